@@ -78,7 +78,6 @@ var (
 // }
 
 type Peer struct {
-	Clients        *utils.Clients
 	Conn           *websocket.Conn
 	PeerConnection *webrtc.PeerConnection
 }
@@ -157,6 +156,10 @@ func main() {
 	configPath := "./app.env"
 	config, _ := initializers.LoadConfig(configPath)
 
+	// Websocket sessions of all nodes are routed through Redis
+	utils.WSHub = utils.NewHub(initializers.RedisClient)
+	go utils.WSHub.Run(context.Background())
+
 	// url := "https://api.development.push.apple.com/3/device/5334f3e850f3e06f5e3714344e4f6c5358751829290a64e65ed3afdeec085d1c"
 
 	// payload := `{"uuid":"a582647b-7bf5-4bb4-a5da-98e6ef08eb5a", "action": "coming_call", "handle": "Arsen Beketov", "sdp":[{"type":"offer","sdp":"..."}]}`
@@ -505,28 +508,9 @@ func main() {
 			return
 		}
 
-		err = c.WriteMessage(websocket.TextMessage, jsonData)
-		if err != nil {
-			fmt.Println("error writing message to client", idStr, ":", err)
-			return
-		}
-
-		// Initialize Redis client
-		configPath := "./app.env"
-		config, err := initializers.LoadConfig(configPath)
-		if err != nil {
-			fmt.Println("error loading config:", err)
-			return
-		}
-
-		// Connect to Redis
-		redisClient := initializers.ConnectRedis(&config)
-		defer redisClient.Close()
-
-		// Add client to clients map
-		Lock.Lock()
-		utils.ClientsInstance[idStr] = c
-		Lock.Unlock()
+		// Register the session in the hub, all writes go through its queue
+		session := utils.WSHub.Register(idStr, c)
+		session.Send(jsonData)
 
 		//CHECK USER LOGIN OR NOT
 		authToken := c.Cookies("access_token")
@@ -552,19 +536,11 @@ func main() {
 			if tokenClaims != nil {
 				UserID := tokenClaims.UserID
 				// Continue processing with UserID
-				var user models.User
-				if err := initializers.DB.Model(&user).Where("id = ?", UserID).First(&user).Error; err != nil {
-					_ = err
-
-					// Handle the error (e.g., user not found)
-					// You might return an error response or take appropriate action.
-				}
-				userName := user.Name
-				lastTimeStr := user.LastOnline.Format("2006-01-02 15:04:05")
-
 				if UserID != "" {
-					initializers.DB.Model(&user).Where("id = ?", UserID).Updates(map[string]interface{}{"online": true, "session": idStr})
-					utils.UserActivity("userOnline", userName, lastTimeStr)
+					if err := utils.WSHub.BindUser(idStr, UserID); err != nil {
+						log.Println("Failed to bind websocket session:", err)
+					}
+					controllers.PresenceConnected(UserID, idStr)
 
 				} else {
 					fmt.Println("User is not logged in")
@@ -581,8 +557,8 @@ func main() {
 		defer func() {
 			Lock.Lock()
 			delete(peers, idStr)
-			delete(utils.ClientsInstance, idStr)
 			Lock.Unlock()
+			boundUserID := utils.WSHub.Unregister(session)
 			bufferPool.Free()
 			byteQueue.Clear()
 
@@ -592,13 +568,6 @@ func main() {
 			durationComponents := GetDurationComponents(elapsedTime)
 			log.Printf("Client %s disconnected after %s", idStr, elapsedTime)
 
-			///var clientsMap map[string]*websocket.Conn
-
-			// Remove client from clients map
-			delete(utils.ClientsInstance, idStr)
-
-			fmt.Println("client deleted from Redis:", idStr)
-
 			// Close WebSocket connection
 			err = c.Close()
 			if err != nil {
@@ -609,8 +578,6 @@ func main() {
 			// var user models.User
 			// initializers.DB.Where("session = ?", idStr).First(&user)
 
-			now := time.Now()
-
 			//CHECK USER IS LOGIN OR NOT
 			authToken := c.Cookies("access_token")
 			// fmt.Println("authToken", authToken)
@@ -665,19 +632,17 @@ func main() {
 				formattedTime := string(jsonBytes)
 
 				initializers.DB.Model(&user).Updates(map[string]interface{}{
-					"online":       false,
-					"last_online":  now,
 					"online_hours": formattedTime,
-					"session":      nil,
 				})
-
-				userName := user.Name
-				lastTimeStr := user.LastOnline.Format("2006-01-02 15:04:05")
-				utils.UserActivity("userOffline", userName, lastTimeStr)
 			} else {
 				fmt.Println("Пользователь не залогинен")
 			}
 
+			// The user goes offline with the last of their sessions
+			if boundUserID != "" {
+				controllers.PresenceDisconnected(boundUserID, idStr)
+			}
+
 			if authToken != "" {
 
 				xconfig, _ := initializers.LoadConfig(".")
@@ -748,16 +713,10 @@ func main() {
 					formattedTime := string(jsonBytes)
 
 					// Lost connection
-					initializers.DB.Model(&user).Updates(map[string]interface{}{"online": false, "last_online": now, "online_hours": formattedTime, "session": nil})
+					initializers.DB.Model(&user).Updates(map[string]interface{}{"online_hours": formattedTime})
 
 					// Access the user's ID with `user.ID`
 					// userID := user.ID.String()
-					userName := user.Name
-					lastTimeStr := user.LastOnline.Format("2006-01-02 15:04:05")
-					//CHECK USER LOGIN OR NOT
-					// authToken := c.Cookies("access_token")
-
-					utils.UserActivity("userOffline", userName, lastTimeStr)
 					// initializers.DB.Model(&user).Where("ID = ?", UserID).Updates(map[string]interface{}{"online": true})
 				} else {
 					fmt.Println("User is not logged in")
@@ -765,17 +724,6 @@ func main() {
 
 			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-			defer cancel()
-
-			// Set the clients JSON string as the value in a Redis hash
-			redisKey := "connected_clients"
-			err := redisClient.HDel(ctx, redisKey, idStr).Err()
-			if err != nil {
-				fmt.Println("error deleting client info from Redis:", err)
-				return
-			}
-
 			fmt.Println("WebSocket client disconnected:", idStr)
 
 		}()
@@ -787,31 +735,6 @@ func main() {
 		// 	return
 		// }
 
-		// Create a context with a timeout of 10 seconds
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-
-		// Set the clients JSON string as the value in a Redis hash
-		redisKey := "connected_clients"
-		clientInfo, err := json.Marshal(utils.ClientsInstance)
-		err = redisClient.HSet(ctx, redisKey, idStr, clientInfo).Err()
-		if err != nil {
-			fmt.Println("error setting client info in Redis:", err)
-			return
-		}
-
-		go func() {
-			ticker := time.NewTicker(10 * time.Second)
-			defer ticker.Stop()
-
-			for range ticker.C {
-				if err := c.WriteMessage(websocket.PingMessage, nil); err != nil {
-					fmt.Println("Ошибка при отправке ping сообщения:", err)
-					return
-				}
-			}
-		}()
-
 		c.SetPingHandler(func(appData string) error {
 			fmt.Println("Получено ping сообщение")
 			if err := c.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second)); err != nil {
@@ -840,6 +763,20 @@ func main() {
 				fmt.Println("error unmarshalling JSON:", err)
 				continue
 			}
+			if Message.MessageType == "presence" {
+				// The client reports the session idle or active again
+				userID := utils.WSHub.SessionUser(session)
+				if userID == "" || len(Message.Data) == 0 {
+					continue
+				}
+				switch Message.Data[0]["state"] {
+				case utils.PresenceAway:
+					controllers.PresenceAway(userID, idStr, true)
+				case utils.PresenceOnline:
+					controllers.PresenceAway(userID, idStr, false)
+				}
+				continue
+			}
 			if Message.MessageType == "UserIsTyping" {
 				if len(Message.Data) > 0 {
 					// Assuming there is at least one item in Data and it contains access_token
@@ -1113,7 +1050,7 @@ func main() {
 
 		// Show log of all clients currently connected
 		fmt.Println("Currently connected clients:")
-		for id := range utils.ClientsInstance {
+		for _, id := range utils.WSHub.LocalSessionIDs() {
 			fmt.Println(id)
 		}
 	}))
@@ -1203,6 +1140,78 @@ func main() {
 		}
 	}()
 
+	// Reconcile stale payments with the acquirer
+	reconcileTicker := time.NewTicker(time.Hour)
+	defer reconcileTicker.Stop()
+	go func() {
+		for range reconcileTicker.C {
+			if _, err := utils.ReconcilePayments(&config, time.Hour); err != nil {
+				log.Println("Payment reconciliation failed:", err)
+			}
+		}
+	}()
+
+	// Complete orders the buyer has not confirmed in time
+	orderTicker := time.NewTicker(time.Hour)
+	defer orderTicker.Stop()
+	go func() {
+		for range orderTicker.C {
+			if _, err := utils.AutoConfirmOrders(&config); err != nil {
+				log.Println("Order auto-confirmation failed:", err)
+			}
+		}
+	}()
+
+	// Email new invoices and receipts
+	documentTicker := time.NewTicker(time.Minute)
+	defer documentTicker.Stop()
+	go func() {
+		for range documentTicker.C {
+			if _, err := utils.SendPendingDocumentEmails(); err != nil {
+				log.Println("Document emails failed:", err)
+			}
+		}
+	}()
+
+	// Purge chat messages past the room retention
+	chatRetentionTicker := time.NewTicker(time.Minute)
+	defer chatRetentionTicker.Stop()
+	go func() {
+		for range chatRetentionTicker.C {
+			if _, err := controllers.SweepExpiredChatMessages(); err != nil {
+				log.Println("Chat retention sweep failed:", err)
+			}
+			if _, err := utils.SweepUnattachedChatAttachments(config); err != nil {
+				log.Println("Unattached chat attachment sweep failed:", err)
+			}
+		}
+	}()
+
+	// Take offline the users whose sessions stopped sending heartbeats
+	presenceTicker := time.NewTicker(time.Minute)
+	defer presenceTicker.Stop()
+	go func() {
+		for range presenceTicker.C {
+			if _, err := controllers.SweepStalePresence(); err != nil {
+				log.Println("Presence sweep failed:", err)
+			}
+		}
+	}()
+
+	// Build queued chat exports and delete the expired ones
+	chatExportTicker := time.NewTicker(time.Minute)
+	defer chatExportTicker.Stop()
+	go func() {
+		for range chatExportTicker.C {
+			if _, err := utils.ExpireChatExports(config); err != nil {
+				log.Println("Chat export expiry failed:", err)
+			}
+			if _, err := utils.ProcessChatExports(config); err != nil {
+				log.Println("Chat exports failed:", err)
+			}
+		}
+	}()
+
 	// Create a channel to receive messages that contain the desired words.
 
 	// Define the words to filter for.
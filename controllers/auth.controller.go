@@ -126,20 +126,7 @@ func SignUpUser(c *fiber.Ctx) error {
 		emailData.Subject = "MYRUONLINE account activation"
 	}
 
-	billing := models.Billing{
-		UserID: newUser.ID,
-		Amount: 100,
-	}
 
-	transaction := models.Transaction{
-		UserID:      newUser.ID,
-		Total:       `0`,
-		Amount:      100,
-		Description: `Бонус за регистрацию`,
-		Module:      `Registration`,
-		Type:        `profit`,
-		Status:      `CLOSED_1`,
-	}
 
 	// Create and save the OnlineStorage object to the database
 	onlineStorage := models.OnlineStorage{
@@ -149,8 +136,9 @@ func SignUpUser(c *fiber.Ctx) error {
 	}
 
 	initializers.DB.Create(&onlineStorage)
-	initializers.DB.Create(&transaction)
-	initializers.DB.Create(&billing)
+	if err := creditRegistrationBonus(newUser.ID); err != nil {
+		log.Println("Failed to credit registration bonus:", err)
+	}
 
 	utils.SendEmail(&newUser, &emailData, "verificationCode", language)
 
@@ -234,20 +222,7 @@ func SignUpBot(c *fiber.Ctx) error {
 	newUser.TelegramToken = TokenCode
 	initializers.DB.Save(newUser)
 
-	billing := models.Billing{
-		UserID: newUser.ID,
-		Amount: 100,
-	}
 
-	transaction := models.Transaction{
-		UserID:      newUser.ID,
-		Total:       `0`,
-		Amount:      100,
-		Description: `Бонус за регистрацию`,
-		Module:      `Registration`,
-		Type:        `profit`,
-		Status:      `CLOSED_1`,
-	}
 
 	// Create and save the OnlineStorage object to the database
 	onlineStorage := models.OnlineStorage{
@@ -261,8 +236,9 @@ func SignUpBot(c *fiber.Ctx) error {
 	}
 
 	initializers.DB.Create(&onlineStorage)
-	initializers.DB.Create(&transaction)
-	initializers.DB.Create(&billing)
+	if err := creditRegistrationBonus(newUser.ID); err != nil {
+		log.Println("Failed to credit registration bonus:", err)
+	}
 	initializers.DB.Create(&profile)
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "data": fiber.Map{"user": models.FilterUserRecord(&newUser, language), "profile": profile}})
@@ -345,9 +321,18 @@ func SignInUser(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"status": "fail", "message": "Failed to create refresh token"})
 	}
 
-	// Update user session and status
-	user.Session = payload.Session
-	user.Online = true
+	// The websocket of the client counts towards the presence of the user. A
+	// session of another user is never taken over.
+	userID := user.ID.String()
+	bindErr := utils.WSHub.BindUser(payload.Session, userID)
+	if bindErr == utils.ErrSessionBound {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "fail", "message": bindErr.Error()})
+	}
+	if bindErr != nil {
+		log.Println("Failed to bind websocket session:", bindErr)
+	} else {
+		user.Session = payload.Session
+	}
 
 	// Save updated user information to the database
 	if err := initializers.DB.Save(&user).Error; err != nil {
@@ -357,9 +342,12 @@ func SignInUser(c *fiber.Ctx) error {
 	// Set user data in the context
 	c.Locals("user", &user)
 
-	userID := user.ID.String()
-	var addintinal = ""
-	utils.UserActivity("userOnline", userID, addintinal)
+	// Keep the cart collected before signing in
+	mergeGuestCartOnLogin(c, user)
+
+	if bindErr == nil {
+		PresenceConnected(userID, payload.Session)
+	}
 	// Send a personal message to the client
 	if err := utils.SendPersonalMessageToClient(payload.Session, "Hello Client"); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to send message to client"})
@@ -671,12 +659,24 @@ func LogoutUser(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "fail", "message": "User not found in the database"})
 	}
 
-	userRecord.Session = ""
+	// The client sends its own websocket session, like on sign in. Only that
+	// session is signed out, the other devices of the user stay bound.
+	if session := c.Query("session"); session != "" {
+		userID := userRecord.ID.String()
+		err = utils.WSHub.UnbindUser(session, userID)
+		if err == utils.ErrSessionBound {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to sign out the session"})
+		}
+		PresenceDisconnected(userID, session)
 
-	// Сохраните изменения и проверьте запрос
-	err = initializers.DB.Save(&userRecord).Error
-	if err != nil {
-		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+		// Сохраните изменения и проверьте запрос
+		err = initializers.DB.Model(&models.User{}).Where("id = ? AND session = ?", userRecord.ID, session).Update("session", "").Error
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+		}
 	}
 
 	c.Cookie(&fiber.Cookie{
@@ -741,3 +741,16 @@ func GetUserDetails(c *fiber.Ctx) error {
 		"data":   res,
 	})
 }
+
+// creditRegistrationBonus opens the wallet of a new user with the
+// registration bonus paid from the platform promo account.
+func creditRegistrationBonus(userID uuid.UUID) error {
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		entry := models.LedgerEntry{
+			Kind:        utils.LedgerKindBonus,
+			Description: `Бонус за регистрацию`,
+			Module:      `Registration`,
+		}
+		return utils.CreditUserWallet(tx, userID, utils.LedgerAccountPromo, utils.MoneyToMinor(100), &entry)
+	})
+}
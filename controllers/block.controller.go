@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// GetBlockedUsers lists the users blocked by the requestor, newest first.
+func GetBlockedUsers(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var blocks []models.UserBlock
+	if err := initializers.DB.Where("blocker_id = ?", user.ID).Order("created_at DESC").Find(&blocks).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to retrieve blocked users"})
+	}
+
+	ids := make([]uuid.UUID, 0, len(blocks))
+	for _, block := range blocks {
+		ids = append(ids, block.BlockedID)
+	}
+	var users []models.User
+	if len(ids) > 0 {
+		if err := initializers.DB.Where("id IN ?", ids).Find(&users).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to retrieve blocked users"})
+		}
+	}
+	byID := make(map[uuid.UUID]models.User, len(users))
+	for _, u := range users {
+		byID[u.ID] = u
+	}
+
+	data := make([]map[string]interface{}, 0, len(blocks))
+	for _, block := range blocks {
+		blocked, ok := byID[block.BlockedID]
+		if !ok {
+			continue
+		}
+		data = append(data, map[string]interface{}{
+			"user":       utils.SerializeUser(blocked),
+			"blocked_at": block.CreatedAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "data": data})
+}
+
+// BlockUser blocks the user from :userId. The blocked user can no longer
+// message, follow, comment on posts of or view the profile of the requestor.
+func BlockUser(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	blockedID, err := uuid.FromString(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid user ID"})
+	}
+	var blocked models.User
+	if err := initializers.DB.Select("id").First(&blocked, "id = ?", blockedID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "User not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Database error"})
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		return utils.BlockUser(tx, user.ID, blockedID)
+	})
+	if errors.Is(err, utils.ErrBlockSelf) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to block user", "error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "User blocked"})
+}
+
+// UnblockUser lifts the block of the user from :userId. Follows dropped by
+// the block are not restored.
+func UnblockUser(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	blockedID, err := uuid.FromString(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid user ID"})
+	}
+
+	result := initializers.DB.Where("blocker_id = ? AND blocked_id = ?", user.ID, blockedID).Delete(&models.UserBlock{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to unblock user"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "User is not blocked"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "User unblocked"})
+}
+
+// SetMessagePrivacy sets who may start a conversation with the requestor:
+// everyone, followers or nobody.
+func SetMessagePrivacy(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		MessagePrivacy string `json:"messagePrivacy"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body"})
+	}
+	if !utils.IsMessagePrivacy(payload.MessagePrivacy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": utils.ErrMessagePrivacyValue.Error()})
+	}
+
+	if err := initializers.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("message_privacy", payload.MessagePrivacy).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update message privacy"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"messagePrivacy": payload.MessagePrivacy}})
+}
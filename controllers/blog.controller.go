@@ -88,6 +88,8 @@ type userResponse struct {
 	TelegramName      string           `json:"telegramname"`
 	TelegramActivated bool             `json:"telegramactivated"`
 	IsBot             bool             `json:"is_bot"`
+	Rating            float64          `json:"rating"`
+	ReviewsCount      int              `json:"reviewsCount"`
 }
 
 type CategoryJSON struct {
@@ -132,6 +134,8 @@ type blogResponse struct {
 	Hashtags         []string              `json:"hashtags"`
 	UserProfile      UserProfileJSON       `json:"userProfile"`
 	IsFavorite 		 bool                  `json:"isfavorite"`
+	Rating           float64               `json:"rating"`
+	ReviewsCount     int                   `json:"reviewsCount"`
 }
 
 
@@ -985,9 +989,8 @@ func AddBlogTime(c *fiber.Ctx) error {
 	var blog models.Blog
 
 	type PostData struct {
-		ID    int    `json:"id"`
-		Days  string `json:"days"`
-		Price string `json:"price"`
+		ID   int    `json:"id"`
+		Days string `json:"days"`
 	}
 
 	// Parse the POST request body into the struct
@@ -999,18 +1002,9 @@ func AddBlogTime(c *fiber.Ctx) error {
 
 	// Access the parsed data
 	days := postData.Days
-	price := postData.Price
 	id := postData.ID
-	// Convert the string price to a float64
-	priceFloat, err := strconv.ParseFloat(price, 64)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Invalid price value",
-		})
-	}
 
-	err = initializers.DB.Where("id = ?", id).First(&blog).Error
+	err := initializers.DB.Where("id = ?", id).First(&blog).Error
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"status":  "error",
@@ -1052,51 +1046,13 @@ func AddBlogTime(c *fiber.Ctx) error {
 		})
 	}
 
-	// Fetch the current balance
-	var billing models.Billing
-	err = initializers.DB.Where("user_id = ?", userObj.ID).First(&billing).Error
+	// The price of the extension is computed here, the client price is ignored
+	config, _ := initializers.LoadConfig(".")
+	price, err := utils.BlogExtensionPrice(config, daysInt)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to fetch balance",
-		})
-	}
-
-	// Check if the balance is sufficient
-	if billing.Amount < priceFloat {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Insufficient balance",
-		})
-	}
-	// Update the amount field in the balance table
-	err = initializers.DB.Model(&models.Billing{}).
-		Where("user_id = ?", userObj.ID).
-		Updates(map[string]interface{}{
-			"amount": gorm.Expr("amount - ?", priceFloat),
-		}).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to update balance",
-		})
-	}
-
-	transaction := models.Transaction{
-		UserID:      userObj.ID,
-		Total:       "0", // Update with the appropriate value
-		Amount:      priceFloat,
-		Description: "Оплата за продление размещения",
-		Module:      "addTimeBlog",
-		Type:        "deduction",
-		Status:      "CLOSED_1",
-	}
-
-	err = initializers.DB.Create(&transaction).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to create transaction",
+			"message": err.Error(),
 		})
 	}
 
@@ -1106,25 +1062,36 @@ func AddBlogTime(c *fiber.Ctx) error {
 		newExpiredAt := time.Now()
 		newExpiredAt = newExpiredAt.AddDate(0, 0, daysInt)
 		blog.ExpiredAt = &newExpiredAt
-		// Update the expired_at column in the database
-		err := initializers.DB.Model(&blog).Update("expired_at", newExpiredAt).Error
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Failed to update expired_at value",
-			})
-		}
 	}
 
 	// Add the specified number of days to the existing expired_at value
 	newExpiredAt := blog.ExpiredAt.AddDate(0, 0, daysInt)
 
-	// Update the expired_at, days, and status columns in the database
-	err = initializers.DB.Model(&blog).Updates(map[string]interface{}{
-		"expired_at": newExpiredAt,
-		"days":       daysInt,
-		"status":     "ACTIVE",
-	}).Error
+	// Charge the extension and prolong the blog in one transaction
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		entry := models.LedgerEntry{
+			Kind:        utils.LedgerKindPublicationFee,
+			Description: "Оплата за продление размещения",
+			Module:      "addTimeBlog",
+			ElementId:   blog.ID,
+		}
+		if err := utils.ChargePublicationFee(tx, userObj.ID, price, &entry); err != nil {
+			return err
+		}
+
+		// Update the expired_at, days, and status columns in the database
+		return tx.Model(&blog).Updates(map[string]interface{}{
+			"expired_at": newExpiredAt,
+			"days":       daysInt,
+			"status":     "ACTIVE",
+		}).Error
+	})
+	if errors.Is(err, utils.ErrInsufficientBalance) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Insufficient balance",
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
@@ -1566,6 +1533,8 @@ func GetBlogById(c *fiber.Ctx) error {
 			UpdatedAt:  b.UpdatedAt,
 			Catygory:   categories,
 			Sticker:    b.Sticker,
+			Rating:       b.Rating,
+			ReviewsCount: b.ReviewsCount,
 			UserProfile: UserProfileJSON{
 				MultilangDescr: userProfile.MultilangDescr,
 				Streaming:      userProfile.Streaming,
@@ -1582,6 +1551,8 @@ func GetBlogById(c *fiber.Ctx) error {
 				TelegramName:      telegramNameVal,
 				TelegramActivated: b.User.TelegramActivated,
 				IsBot:             b.User.IsBot,
+				Rating:            b.User.Rating,
+				ReviewsCount:      b.User.ReviewsCount,
 			},
 			Hashtags: hashtags,
 		}
@@ -1982,6 +1953,8 @@ func GetAll(c *fiber.Ctx) error {
             Catygory:       categories,
             UniqId:         b.UniqId,
             Sticker:        b.Sticker,
+            Rating:       b.Rating,
+            ReviewsCount: b.ReviewsCount,
             User: userResponse{
                 TId:               b.User.Tid,
                 Online:            b.User.Online,
@@ -1992,6 +1965,8 @@ func GetAll(c *fiber.Ctx) error {
                 TelegramName:      telegramNameVal,
                 TelegramActivated: b.User.TelegramActivated,
                 IsBot:             b.User.IsBot,
+                Rating:            b.User.Rating,
+                ReviewsCount:      b.User.ReviewsCount,
             },
             Hashtags:  hashtags,
             IsFavorite: isFavorite,  // добавляем это поле в ответ
@@ -2150,6 +2125,8 @@ func EditBlogGetId(c *fiber.Ctx) error {
 			UpdatedAt:  b.UpdatedAt,
 			Catygory:   categories,
 			Sticker:    b.Sticker,
+			Rating:       b.Rating,
+			ReviewsCount: b.ReviewsCount,
 			User: userResponse{
 				TId:              b.User.Tid,
 				Online:           b.User.Online,
@@ -2159,6 +2136,8 @@ func EditBlogGetId(c *fiber.Ctx) error {
 				TotalOnlineHours: userTotalOnlineHours,
 				TotalRestBlogs:   b.User.TotalRestBlogs,
 				IsBot:            b.User.IsBot,
+				Rating:           b.User.Rating,
+				ReviewsCount:     b.User.ReviewsCount,
 			},
 			Hashtags: hashtags,
 		}
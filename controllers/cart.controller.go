@@ -0,0 +1,378 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Гостевая корзина передается заголовком или cookie
+const (
+	cartTokenHeader = "X-Cart-Token"
+	cartTokenCookie = "cart_token"
+)
+
+func cartToken(c *fiber.Ctx) string {
+	if token := c.Get(cartTokenHeader); token != "" {
+		return token
+	}
+	return c.Cookies(cartTokenCookie)
+}
+
+// resolveCart возвращает корзину пользователя или гостя. Для гостя без
+// корзины она создается, если create, а токен отдается в cookie.
+func resolveCart(c *fiber.Ctx, tx *gorm.DB, create bool) (models.Cart, error) {
+	if user, ok := c.Locals("user").(models.UserResponse); ok {
+		return utils.UserCart(tx, user.ID)
+	}
+
+	if token := cartToken(c); token != "" {
+		cart, err := utils.GuestCart(tx, token)
+		if !errors.Is(err, utils.ErrCartNotFound) || !create {
+			return cart, err
+		}
+	}
+	if !create {
+		return models.Cart{}, utils.ErrCartNotFound
+	}
+
+	cart, err := utils.NewGuestCart(tx)
+	if err != nil {
+		return cart, err
+	}
+	c.Set(cartTokenHeader, *cart.GuestToken)
+	c.Cookie(&fiber.Cookie{
+		Name:     cartTokenCookie,
+		Value:    *cart.GuestToken,
+		Path:     "/",
+		SameSite: "Lax",
+		MaxAge:   60 * 60 * 24 * 30,
+		Secure:   true,
+	})
+	return cart, nil
+}
+
+// respondCart отвечает проверенным содержимым корзины
+func respondCart(c *fiber.Ctx, cart models.Cart) error {
+	view, err := utils.ValidateCart(initializers.DB, cart.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось получить корзину",
+		})
+	}
+
+	response := fiber.Map{
+		"status": "success",
+		"data":   view,
+	}
+	if cart.GuestToken != nil {
+		response["token"] = *cart.GuestToken
+	}
+	return c.JSON(response)
+}
+
+func GetCart(c *fiber.Ctx) error {
+	cart, err := resolveCart(c, initializers.DB, false)
+	if errors.Is(err, utils.ErrCartNotFound) {
+		return c.JSON(fiber.Map{
+			"status": "success",
+			"data":   utils.CartView{Lines: []utils.CartLine{}},
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось получить корзину",
+		})
+	}
+	return respondCart(c, cart)
+}
+
+// SetCartItem добавляет товар в корзину или меняет его количество.
+// POST добавляет к уже лежащему количеству, PUT задает его.
+func SetCartItem(c *fiber.Ctx) error {
+	var req struct {
+		VariantID uint64 `json:"variantId"`
+		Quantity  int    `json:"quantity"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Quantity < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Неверные данные",
+		})
+	}
+	if id := c.Params("variantId"); id != "" {
+		variantID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Неверный товар",
+			})
+		}
+		req.VariantID = variantID
+	}
+	if req.VariantID == 0 || (c.Method() == fiber.MethodPost && req.Quantity == 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Укажите товар и количество",
+		})
+	}
+
+	var cart models.Cart
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if cart, err = resolveCart(c, tx, true); err != nil {
+			return err
+		}
+
+		quantity := req.Quantity
+		if c.Method() == fiber.MethodPost {
+			var item models.CartItem
+			if err := tx.Where("cart_id = ? AND variant_id = ?", cart.ID, req.VariantID).Limit(1).Find(&item).Error; err != nil {
+				return err
+			}
+			quantity += item.Quantity
+		}
+		return utils.SetCartItem(tx, cart, req.VariantID, quantity)
+	})
+	if err == nil {
+		utils.InvalidateCartCache(cart.ID)
+	}
+	if errors.Is(err, utils.ErrProductUnavailable) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Товар недоступен",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось обновить корзину",
+		})
+	}
+
+	return respondCart(c, cart)
+}
+
+func RemoveCartItem(c *fiber.Ctx) error {
+	variantID, err := strconv.ParseUint(c.Params("variantId"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Неверный товар",
+		})
+	}
+
+	cart, err := resolveCart(c, initializers.DB, false)
+	if errors.Is(err, utils.ErrCartNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Корзина не найдена",
+		})
+	}
+	if err == nil {
+		err = utils.SetCartItem(initializers.DB, cart, variantID, 0)
+	}
+	if err == nil {
+		utils.InvalidateCartCache(cart.ID)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось обновить корзину",
+		})
+	}
+
+	return respondCart(c, cart)
+}
+
+// MergeCart переносит гостевую корзину в корзину вошедшего пользователя.
+// Токен берется из тела запроса, заголовка или cookie.
+func MergeCart(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	c.BodyParser(&req)
+	if req.Token == "" {
+		req.Token = cartToken(c)
+	}
+
+	var cart models.Cart
+	var guestID uuid.UUID
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if req.Token == "" {
+			cart, err = utils.UserCart(tx, user.ID)
+		} else {
+			cart, guestID, err = utils.MergeGuestCart(tx, req.Token, user.ID)
+		}
+		return err
+	})
+	if err == nil {
+		utils.InvalidateCartCache(cart.ID, guestID)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось объединить корзины",
+		})
+	}
+
+	c.ClearCookie(cartTokenCookie)
+	return respondCart(c, cart)
+}
+
+// mergeGuestCartOnLogin переносит гостевую корзину после входа. Ошибка не
+// мешает входу, корзину можно объединить позже через /cart/merge.
+func mergeGuestCartOnLogin(c *fiber.Ctx, user models.User) {
+	token := cartToken(c)
+	if token == "" {
+		return
+	}
+	var cart models.Cart
+	var guestID uuid.UUID
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		cart, guestID, err = utils.MergeGuestCart(tx, token, user.ID)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	utils.InvalidateCartCache(cart.ID, guestID)
+	c.ClearCookie(cartTokenCookie)
+}
+
+// GetCartShipping возвращает способы и стоимость доставки по продавцам
+// корзины для адреса addressId
+func GetCartShipping(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var deliveryAddress models.DeliveryAddress
+	if err := initializers.DB.Where("id = ? AND user_id = ?", c.Query("addressId"), user.ID).First(&deliveryAddress).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Адрес доставки не найден",
+		})
+	}
+	if err := utils.ValidateDeliveryAddress(initializers.DB, &deliveryAddress); err != nil {
+		return deliveryAddressValidationError(c, err)
+	}
+
+	cart, err := utils.UserCart(initializers.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось загрузить корзину",
+		})
+	}
+	view, err := utils.ValidateCart(initializers.DB, cart.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось загрузить корзину",
+		})
+	}
+	quotes, err := utils.QuoteCartShipping(initializers.DB, view, deliveryAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось рассчитать доставку",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   quotes,
+	})
+}
+
+// CheckoutCart оформляет корзину: по заказу на каждого продавца в одной
+// транзакции, после чего корзина очищается.
+func CheckoutCart(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req struct {
+		AddressId       string            `json:"addressId"`
+		ShippingMethod  string            `json:"shippingMethod"`
+		ShippingMethods map[string]uint64 `json:"shippingMethods"` // ID продавца -> способ доставки
+		PaymentMethod   string            `json:"paymentMethod"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.AddressId == "" || req.PaymentMethod == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Укажите адрес и способ оплаты",
+		})
+	}
+	shippingMethods, err := parseShippingMethods(req.ShippingMethods)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	// Получаем данные адреса доставки по ID
+	var deliveryAddress models.DeliveryAddress
+	if err := initializers.DB.Where("id = ? AND user_id = ?", req.AddressId, user.ID).First(&deliveryAddress).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Адрес доставки не найден",
+		})
+	}
+
+	config, _ := initializers.LoadConfig(".")
+
+	var orders []models.Order
+	var cart models.Cart
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if cart, err = utils.UserCart(tx, user.ID); err != nil {
+			return err
+		}
+
+		var stored []models.CartItem
+		if err := tx.Where("cart_id = ?", cart.ID).Find(&stored).Error; err != nil {
+			return err
+		}
+		items := make([]utils.CartItem, 0, len(stored))
+		for _, item := range stored {
+			items = append(items, utils.CartItem{VariantID: item.VariantID, Quantity: item.Quantity})
+		}
+
+		orders, err = utils.PlaceOrders(tx, utils.OrderPlacement{
+			BuyerID:           user.ID,
+			Items:             items,
+			Address:           deliveryAddress,
+			ShippingMethod:    req.ShippingMethod,
+			ShippingMethods:   shippingMethods,
+			PaymentMethod:     req.PaymentMethod,
+			CommissionPercent: config.MarketplaceCommissionPercent,
+		})
+		if err != nil {
+			return err
+		}
+		return utils.ClearCart(tx, cart.ID)
+	})
+	if err != nil {
+		return orderPlacementError(c, err)
+	}
+	utils.InvalidateCartCache(cart.ID)
+
+	notifyOrdersPlaced(user.ID, orders)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Заказы успешно созданы",
+		"data":    orders,
+	})
+}
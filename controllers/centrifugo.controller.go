@@ -4,14 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"hyperpage/initializers"
 	"hyperpage/models"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
 )
 
 func GetCentrifugoConnectionToken(c *fiber.Ctx) error {
@@ -59,8 +62,15 @@ func GetCentrifugoSubscriptionToken(c *fiber.Ctx) error {
 }
 
 func GetRoomMemberChannels(roomID uint64) ([]string, error) {
+	return GetRoomMemberChannelsTx(initializers.DB, roomID)
+}
+
+// GetRoomMemberChannelsTx returns the personal channels of the room members
+// as seen by tx, so members added or removed in the transaction are
+// accounted for.
+func GetRoomMemberChannelsTx(tx *gorm.DB, roomID uint64) ([]string, error) {
 	var members []models.ChatRoomMember
-	if err := initializers.DB.Where("room_id = ?", roomID).Find(&members).Error; err != nil {
+	if err := tx.Where("room_id = ?", roomID).Find(&members).Error; err != nil {
 		return nil, err
 	}
 
@@ -119,15 +129,133 @@ func CentrifugoBroadcastViaAPI(apiEndpoint string, apiKey string, payload Centri
 	return "Broadcast sent successfully to Centrifugo", nil
 }
 
-func CentrifugoBroadcastRoom(roomID string, broadcastPayload CentrifugoBroadcastPayload) (string, error) {
+// NewRoomBroadcastPayload builds a broadcast of eventType addressed to the
+// personal channels of every member of the room.
+func NewRoomBroadcastPayload(roomID uint64, eventType string, body map[string]interface{}, idempotencyKey string) (CentrifugoBroadcastPayload, error) {
+	return NewRoomBroadcastPayloadTx(initializers.DB, roomID, eventType, body, idempotencyKey)
+}
+
+// NewRoomBroadcastPayloadTx is NewRoomBroadcastPayload with the members read
+// through tx.
+func NewRoomBroadcastPayloadTx(tx *gorm.DB, roomID uint64, eventType string, body map[string]interface{}, idempotencyKey string) (CentrifugoBroadcastPayload, error) {
+	var payload CentrifugoBroadcastPayload
+	channels, err := GetRoomMemberChannelsTx(tx, roomID)
+	if err != nil {
+		return payload, err
+	}
+
+	payload.Channels = channels
+	payload.Data.Type = eventType
+	payload.Data.Body = body
+	payload.IdempotencyKey = idempotencyKey
+	return payload, nil
+}
+
+// roomBroadcastTx builds the broadcast of a room event and stores it with
+// tx. In "api" mode nothing is stored and the event is sent after the
+// commit, so the channels are left empty here and resolved by
+// CentrifugoBroadcastRoomAfterCommit: members added in tx are included and
+// a failed lookup only skips the event while the change is kept.
+func roomBroadcastTx(tx *gorm.DB, roomID uint64, eventType string, body map[string]interface{}, idempotencyKey string) (CentrifugoBroadcastPayload, error) {
+	config, _ := initializers.LoadConfig("./app.env")
+	if config.CentrifugoBroadcastMode == "api" {
+		var payload CentrifugoBroadcastPayload
+		payload.Data.Type = eventType
+		payload.Data.Body = body
+		payload.IdempotencyKey = idempotencyKey
+		return payload, nil
+	}
+
+	payload, err := NewRoomBroadcastPayloadTx(tx, roomID, eventType, body, idempotencyKey)
+	if err != nil {
+		return payload, err
+	}
+	return payload, CentrifugoBroadcastRoomTx(tx, fmt.Sprint(roomID), payload)
+}
+
+// centrifugoOutboxPartition maps a room to one of the outbox partitions so
+// that all events of a room are consumed by Centrifugo in order.
+func centrifugoOutboxPartition(roomID string, numPartitions int) int64 {
+	if numPartitions <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(roomID))
+	return int64(h.Sum32() % uint32(numPartitions))
+}
+
+// CentrifugoBroadcastRoomTx stores the broadcast in the outbox or CDC table
+// using tx, so the publication is committed atomically with the changes that
+// caused it. In "api" mode nothing is stored, the broadcast is sent by
+// CentrifugoBroadcastRoomAfterCommit once the transaction is committed.
+func CentrifugoBroadcastRoomTx(tx *gorm.DB, roomID string, broadcastPayload CentrifugoBroadcastPayload) error {
+	configPath := "./app.env"
+	config, _ := initializers.LoadConfig(configPath)
+
+	if config.CentrifugoBroadcastMode == "api" {
+		return nil
+	}
+
+	payloadBytes, err := json.Marshal(broadcastPayload)
+	if err != nil {
+		return err
+	}
+	partition := centrifugoOutboxPartition(roomID, config.CentrifugoOutboxPartitions)
+
+	switch config.CentrifugoBroadcastMode {
+	case "outbox":
+		return tx.Create(&models.ChatOutbox{
+			Method:    "broadcast",
+			Payload:   payloadBytes,
+			Partition: partition,
+		}).Error
+	case "cdc", "api_cdc":
+		return tx.Create(&models.ChatCDC{
+			Method:    "broadcast",
+			Payload:   payloadBytes,
+			Partition: partition,
+		}).Error
+	default:
+		log.Printf("Broadcast mode '%s' is not implemented", config.CentrifugoBroadcastMode)
+		return fmt.Errorf("broadcast mode '%s' is not implemented", config.CentrifugoBroadcastMode)
+	}
+}
+
+// CentrifugoBroadcastRoomAfterCommit sends the broadcast over the Centrifugo
+// HTTP API in the modes that use it. It must be called only after the
+// transaction passed to CentrifugoBroadcastRoomTx has been committed. A
+// payload without channels is sent to the members of the room as they are
+// after the commit.
+func CentrifugoBroadcastRoomAfterCommit(roomID string, broadcastPayload CentrifugoBroadcastPayload) (string, error) {
 	configPath := "./app.env"
 	config, _ := initializers.LoadConfig(configPath)
 
 	switch config.CentrifugoBroadcastMode {
-	case "api":
+	case "api", "api_cdc":
+		if len(broadcastPayload.Channels) == 0 && broadcastPayload.Data.Type != "" {
+			id, err := strconv.ParseUint(roomID, 10, 64)
+			if err != nil {
+				return "", err
+			}
+			if broadcastPayload.Channels, err = GetRoomMemberChannels(id); err != nil {
+				return "", err
+			}
+		}
+		if len(broadcastPayload.Channels) == 0 {
+			return "No channels to broadcast to", nil
+		}
 		return CentrifugoBroadcastViaAPI(config.CentrifugoHttpApiEndpoint, config.CentrifugoHttpApiKey, broadcastPayload)
+	case "outbox", "cdc":
+		return "Broadcast stored for Centrifugo consumer", nil
 	default:
 		log.Printf("Broadcast mode '%s' is not implemented", config.CentrifugoBroadcastMode)
 		return "", fmt.Errorf("broadcast mode '%s' is not implemented", config.CentrifugoBroadcastMode)
 	}
 }
+
+func CentrifugoBroadcastRoom(roomID string, broadcastPayload CentrifugoBroadcastPayload) (string, error) {
+	if err := CentrifugoBroadcastRoomTx(initializers.DB, roomID, broadcastPayload); err != nil {
+		return "", err
+	}
+	return CentrifugoBroadcastRoomAfterCommit(roomID, broadcastPayload)
+}
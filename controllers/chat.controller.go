@@ -21,10 +21,11 @@ type CreateRoomRequest struct {
 }
 
 type SendMessageRequest struct {
-	Content         string `json:"content"`
-	ParentMessageID string `json:"parentMessageId,omitempty"` // Use omitempty for an optional field
-	MsgType         string `json:"msgType,omitempty"`
-	JsonData        string `json:"jsonData,omitempty"` // this is msg field for system, backend only validates this as json
+	Content         string   `json:"content"`
+	ParentMessageID string   `json:"parentMessageId,omitempty"` // Use omitempty for an optional field
+	MsgType         string   `json:"msgType,omitempty"`
+	JsonData        string   `json:"jsonData,omitempty"`      // this is msg field for system, backend only validates this as json
+	AttachmentIds   []uint64 `json:"attachmentIds,omitempty"` // uploaded through POST /chat/attachment/:roomId
 }
 
 type EditMessageRequest struct {
@@ -69,63 +70,63 @@ func CreateChatRoomForDM(c *fiber.Ctx) error {
 		Model(&models.ChatRoom{}).
 		Joins("JOIN chat_room_members as rm1 ON rm1.room_id = chat_rooms.id AND rm1.user_id = ?", requestorUser.ID).
 		Joins("JOIN chat_room_members as rm2 ON rm2.room_id = chat_rooms.id AND rm2.user_id = ?", acceptorUser.ID).
+		Where("chat_rooms.is_group = ?", false).
 		Where("chat_rooms.id IN (SELECT room_id FROM chat_room_members GROUP BY room_id HAVING COUNT(DISTINCT user_id) >= 2)").
 		Preload("Members", func(db *gorm.DB) *gorm.DB {
 			return db.Joins("User")
 		}).
 		Preload("LastMessage").
+		Preload("LastMessage.Attachments", utils.OrderChatAttachments).
 		First(&room)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		// Room does not exist, so proceed with creation
-		newRoom := models.ChatRoom{Name: requestorUser.Name + " & " + acceptorUser.Name}
-		if err := initializers.DB.Create(&newRoom).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to create room"})
+		// The acceptor may block the requestor or accept messages only from followers
+		if err := utils.CanStartConversation(initializers.DB, requestorUser.ID, acceptorUser.ID); err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "fail", "message": err.Error()})
 		}
 
-		roomMembers := []models.ChatRoomMember{
-			{RoomID: newRoom.ID, UserID: requestorUser.ID, IsSubscribed: true},
-			{RoomID: newRoom.ID, UserID: acceptorUser.ID, IsNew: true},
-		}
-
-		if err := initializers.DB.CreateInBatches(roomMembers, len(roomMembers)).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to add members to room"})
-		}
-		// Create initial message
+		// Room does not exist, so proceed with creation. The room, its members,
+		// the initial message and the broadcast are stored in one transaction.
+		newRoom := models.ChatRoom{Name: requestorUser.Name + " & " + acceptorUser.Name}
 		initialMessage := models.ChatMessage{
-			RoomID:  newRoom.ID,
 			UserID:  requestorUser.ID,
 			Content: payload.InitialMessage,
 		}
-		if err := initializers.DB.Create(&initialMessage).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to send initial message"})
-		}
+		var broadcastPayload CentrifugoBroadcastPayload
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newRoom).Error; err != nil {
+				return err
+			}
 
-		// Update the room's LastMessageId with the ID of the initial message
-		if err := initializers.DB.Model(&newRoom).Update("last_message_id", initialMessage.ID).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update room's last message"})
-		}
+			roomMembers := []models.ChatRoomMember{
+				{RoomID: newRoom.ID, UserID: requestorUser.ID, IsSubscribed: true},
+				{RoomID: newRoom.ID, UserID: acceptorUser.ID, IsNew: true},
+			}
+			if err := tx.CreateInBatches(roomMembers, len(roomMembers)).Error; err != nil {
+				return err
+			}
 
-		serializedRoom := utils.SerializeChatRoom(newRoom.ID)
-		channels, err := GetRoomMemberChannels(newRoom.ID)
-		if err != nil {
-			log.Printf("Failed to get room member channels: %s", err)
-		} else {
-			broadcastPayload := CentrifugoBroadcastPayload{
-				Channels: channels,
-				Data: struct {
-					Type string                 `json:"type"`
-					Body map[string]interface{} `json:"body"`
-				}{
-					Type: "new_room",
-					Body: serializedRoom,
-				},
-				IdempotencyKey: fmt.Sprintf("create_room_%d", newRoom.ID),
+			// Create initial message
+			initialMessage.RoomID = newRoom.ID
+			if err := tx.Create(&initialMessage).Error; err != nil {
+				return err
 			}
 
-			if _, err := CentrifugoBroadcastRoom(fmt.Sprint(newRoom.ID), broadcastPayload); err != nil {
-				log.Printf("Failed to broadcast room creation: %s", err)
+			// Update the room's LastMessageId with the ID of the initial message
+			if err := tx.Model(&newRoom).Update("last_message_id", initialMessage.ID).Error; err != nil {
+				return err
 			}
+
+			var err error
+			broadcastPayload, err = roomBroadcastTx(tx, newRoom.ID, "new_room", utils.SerializeChatRoomTx(tx, newRoom.ID), fmt.Sprintf("create_room_%d", newRoom.ID))
+			return err
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to create room"})
+		}
+
+		if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(newRoom.ID), broadcastPayload); err != nil {
+			log.Printf("Failed to broadcast room creation: %s", err)
 		}
 
 		roomIDStr := strconv.FormatUint(newRoom.ID, 10)
@@ -179,6 +180,7 @@ func GetRoomDetailsForDM(c *fiber.Ctx) error {
 				})
 		}).
 		Preload("LastMessage").
+		Preload("LastMessage.Attachments", utils.OrderChatAttachments).
 		First(&room).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Room not found or access denied", "error": err.Error()})
@@ -217,39 +219,22 @@ func GetSubscribedRoomsForDM(c *fiber.Ctx) error {
 				})
 		}).
 		Preload("LastMessage").
+		Preload("LastMessage.Attachments", utils.OrderChatAttachments).
 		Find(&rooms)
 
 	var responseRooms []ChatRoomResponse
-	// Now, for each room, calculate the unread message count
 	for _, room := range rooms {
-		var count int64
-		err := initializers.DB.
-			Model(&models.ChatMessage{}).
-			Where(`
-            user_id != ? AND room_id = ? AND 
-            id > COALESCE(
-                (
-                    SELECT last_read_message_id
-                    FROM chat_room_members 
-                    WHERE room_id = ? AND user_id = ?  AND is_subscribed = ?
-                ), 
-                0
-            )
-        `, user.ID, room.ID, room.ID, user.ID, true).
-			Count(&count).Error
-
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": fmt.Sprintf("Could not calculate unread Msg Count, room id is %d", room.ID),
-				"error":   err.Error(),
-			})
+		var unreadCount int
+		for _, member := range room.Members {
+			if member.UserID == user.ID {
+				unreadCount = member.UnreadCount
+			}
 		}
 
 		// Append the enriched room data to the response slice
 		responseRooms = append(responseRooms, ChatRoomResponse{
 			ChatRoom:       room,
-			UnreadMessages: strconv.FormatInt(count, 10),
+			UnreadMessages: strconv.Itoa(unreadCount),
 		})
 	}
 
@@ -281,6 +266,8 @@ func GetNewUnsubscribedRoomsForDM(c *fiber.Ctx) error {
 		Model(&models.ChatRoom{}).
 		Joins("JOIN chat_room_members ON chat_rooms.id = chat_room_members.room_id").
 		Where("chat_room_members.user_id = ? AND chat_room_members.is_subscribed = ? AND chat_room_members.is_new = ?", user.ID, false, true).
+		// Requests from blocked users are not shown
+		Where("NOT EXISTS (SELECT 1 FROM chat_room_members other JOIN user_blocks ON user_blocks.blocked_id = other.user_id AND user_blocks.blocker_id = ? WHERE other.room_id = chat_rooms.id)", user.ID).
 		Preload("Members", func(db *gorm.DB) *gorm.DB {
 			return db.Joins("JOIN users ON chat_room_members.user_id = users.id").
 				Preload("User.Profile", func(db *gorm.DB) *gorm.DB {
@@ -298,6 +285,7 @@ func GetNewUnsubscribedRoomsForDM(c *fiber.Ctx) error {
 				})
 		}).
 		Preload("LastMessage").
+		Preload("LastMessage.Attachments", utils.OrderChatAttachments).
 		Find(&rooms)
 
 	if result.Error != nil {
@@ -340,6 +328,7 @@ func GetUnsubscribedNotNewRoomsForDM(c *fiber.Ctx) error {
 				})
 		}).
 		Preload("LastMessage").
+		Preload("LastMessage.Attachments", utils.OrderChatAttachments).
 		Order("created_at DESC"). // You may wish to order the rooms
 		Find(&rooms)
 
@@ -408,34 +397,25 @@ func SubscribeNewRoomForDM(c *fiber.Ctx) error {
 	// User is a member but not subscribed, so proceed to update the subscription and IsNew to false.
 	roomMember.IsNew = false
 	roomMember.IsSubscribed = true
-	if err := initializers.DB.Save(&roomMember).Error; err != nil {
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&roomMember).Error; err != nil {
+			return err
+		}
+
+		var err error
+		broadcastPayload, err = roomBroadcastTx(tx, room.ID, "subscribe_room", utils.SerializeChatRoomTx(tx, room.ID), fmt.Sprintf("subscribe_room_%d", room.ID))
+		return err
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Failed to update subscription",
-			"error":   err.Error(),
 		})
 	}
 
-	serializedRoom := utils.SerializeChatRoom(room.ID)
-	channels, err := GetRoomMemberChannels(room.ID)
-	if err != nil {
-		log.Printf("Failed to get room member channels for broadcasting: %s", err)
-	} else {
-		broadcastPayload := CentrifugoBroadcastPayload{
-			Channels: channels,
-			Data: struct {
-				Type string                 `json:"type"`
-				Body map[string]interface{} `json:"body"`
-			}{
-				Type: "subscribe_room",
-				Body: serializedRoom,
-			},
-			IdempotencyKey: fmt.Sprintf("subscribe_room_%d", room.ID),
-		}
-
-		if _, err := CentrifugoBroadcastRoom(fmt.Sprint(room.ID), broadcastPayload); err != nil {
-			log.Printf("Failed to broadcast room subscription: %s", err)
-		}
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(room.ID), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast room subscription: %s", err)
 	}
 
 	return c.JSON(fiber.Map{
@@ -496,34 +476,25 @@ func UnsubscribeRoomForDM(c *fiber.Ctx) error {
 	// User is subscribed, proceed to update the subscription to false.
 	roomMember.IsSubscribed = false
 	roomMember.IsNew = false
-	if err := initializers.DB.Save(&roomMember).Error; err != nil {
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&roomMember).Error; err != nil {
+			return err
+		}
+
+		var err error
+		broadcastPayload, err = roomBroadcastTx(tx, room.ID, "unsubscribe_room", utils.SerializeChatRoomTx(tx, room.ID), fmt.Sprintf("unsubscribe_room_%d", room.ID))
+		return err
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Failed to update subscription status",
-			"error":   err.Error(),
 		})
 	}
 
-	serializedRoom := utils.SerializeChatRoom(room.ID)
-	channels, err := GetRoomMemberChannels(room.ID)
-	if err != nil {
-		log.Printf("Failed to get room member channels for broadcasting: %s", err)
-	} else {
-		broadcastPayload := CentrifugoBroadcastPayload{
-			Channels: channels,
-			Data: struct {
-				Type string                 `json:"type"`
-				Body map[string]interface{} `json:"body"`
-			}{
-				Type: "unsubscribe_room",
-				Body: serializedRoom,
-			},
-			IdempotencyKey: fmt.Sprintf("unsubscribe_room_%d", room.ID),
-		}
-
-		if _, err := CentrifugoBroadcastRoom(fmt.Sprint(room.ID), broadcastPayload); err != nil {
-			log.Printf("Failed to broadcast room unsubscription: %s", err)
-		}
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(room.ID), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast room unsubscription: %s", err)
 	}
 
 	// Successfully updated the subscription to unsubscribed.
@@ -533,6 +504,63 @@ func UnsubscribeRoomForDM(c *fiber.Ctx) error {
 	})
 }
 
+// directRoomPartner returns the other member of a direct room.
+func directRoomPartner(roomID uint64, userID uuid.UUID) (uuid.UUID, bool) {
+	var partner models.ChatRoomMember
+	if err := initializers.DB.Where("room_id = ? AND user_id != ?", roomID, userID).First(&partner).Error; err != nil {
+		return uuid.Nil, false
+	}
+	return partner.UserID, true
+}
+
+// checkRoomPosting checks that the user may post to the room and returns the
+// members to notify. Direct rooms need the other member to be subscribed,
+// group rooms notify every subscribed member except the sender and refuse
+// muted members. Errors are *fiber.Error with the response status.
+func checkRoomPosting(roomID uint64, userID uuid.UUID) (models.ChatRoom, []models.ChatRoomMember, error) {
+	var room models.ChatRoom
+	var recipients []models.ChatRoomMember
+
+	// Check if the user is a subscribed member of the room
+	var member models.ChatRoomMember
+	result := initializers.DB.Model(&models.ChatRoomMember{}).
+		Where("room_id = ? AND user_id = ?", roomID, userID).
+		First(&member)
+	if result.Error != nil || !member.IsSubscribed {
+		return room, nil, fiber.NewError(fiber.StatusForbidden, "User is not subscribed to the room")
+	}
+
+	if err := initializers.DB.First(&room, roomID).Error; err != nil {
+		return room, nil, fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+
+	initializers.DB.Model(&models.ChatRoomMember{}).
+		Where("room_id = ? AND user_id != ? AND is_subscribed = ?", roomID, userID, true).
+		Find(&recipients)
+	if err := checkMemberMayWrite(room, member); err != nil {
+		return room, nil, err
+	}
+	if !room.IsGroup && len(recipients) == 0 {
+		// This means the other member is not subscribed or does not exist
+		return room, nil, fiber.NewError(fiber.StatusBadRequest, "The other member is not subscribed or does not exist")
+	}
+	return room, recipients, nil
+}
+
+// checkMemberMayWrite refuses muted members of group rooms and members of
+// direct rooms where either side blocked the other. The error is a
+// *fiber.Error with the response status.
+func checkMemberMayWrite(room models.ChatRoom, member models.ChatRoomMember) error {
+	if room.IsGroup {
+		if utils.IsChatMemberMuted(member) {
+			return fiber.NewError(fiber.StatusForbidden, "You are muted in this room")
+		}
+	} else if otherID, ok := directRoomPartner(room.ID, member.UserID); ok && utils.IsBlockedEitherWay(initializers.DB, member.UserID, otherID) {
+		return fiber.NewError(fiber.StatusForbidden, utils.ErrUserBlocked.Error())
+	}
+	return nil
+}
+
 func SendMessageForDM(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.UserResponse)
 	roomId := c.Params("roomId")
@@ -551,42 +579,9 @@ func SendMessageForDM(c *fiber.Ctx) error {
 		})
 	}
 
-	// Check if the user is a subscribed member of the room
-	var member models.ChatRoomMember
-	result := initializers.DB.Model(&models.ChatRoomMember{}).
-		Where("room_id = ? AND user_id = ?", u64, user.ID).
-		First(&member)
-	if result.Error != nil || !member.IsSubscribed {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "User is not subscribed to the room"})
-	}
-
-	// Check if there's another subscribed member in this room
-	var recipient models.ChatRoomMember
-	initializers.DB.Model(&models.ChatRoomMember{}).
-		Where("room_id = ? AND user_id != ? AND is_subscribed = ?", u64, user.ID, true).
-		First(&recipient)
-	if recipient.UserID == uuid.Nil {
-		// This means the other member is not subscribed or does not exist
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "The other member is not subscribed or does not exist"})
-	}
-
-	// Check if the user is a member of the room and if the other member is subscribed.
-	var count int64
-	initializers.DB.Model(&models.ChatRoomMember{}).
-		Where("room_id = ? AND user_id = ?", u64, user.ID).
-		Count(&count)
-	// Check if the user is indeed a member of the room
-	if count == 0 {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "User is not a member of the room"})
-	}
-
-	// check if there's another subscribed member in this room
-	initializers.DB.Model(&models.ChatRoomMember{}).
-		Where("room_id = ? AND user_id != ? AND is_subscribed = ?", u64, user.ID, true).
-		Count(&count)
-	if count == 0 {
-		// This means the other member is not subscribed or does not exist
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "The other member is not subscribed or does not exist"})
+	room, recipients, err := checkRoomPosting(u64, user.ID)
+	if err != nil {
+		return c.Status(err.(*fiber.Error).Code).JSON(fiber.Map{"status": "error", "message": err.Error()})
 	}
 
 	// Initialize the ChatMessage with common fields
@@ -625,6 +620,12 @@ func SendMessageForDM(c *fiber.Ctx) error {
 			})
 		}
 		message.MsgType = uint8(msgType)
+		if message.MsgType == utils.ChatMsgTypeSystem {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "System messages can not be sent by users",
+			})
+		}
 
 		// additionally store json data
 		if payload.JsonData != "" {
@@ -635,42 +636,48 @@ func SendMessageForDM(c *fiber.Ctx) error {
 		fmt.Println("Creating new message with content, default msgType is 0...")
 	}
 
-	if err := initializers.DB.Create(&message).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to send message"})
-	}
+	// The message, the room bump and the broadcast are stored in one transaction,
+	// so a saved message is never lost for the outbox/cdc consumers.
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if err := utils.AttachToMessageTx(tx, &message, payload.AttachmentIds); err != nil {
+			return err
+		}
 
-	// Update the room's LastMessageId after sending a new message
-	if err := initializers.DB.Model(&models.ChatRoom{}).Where("id = ?", message.RoomID).Update("last_message_id", message.ID).Error; err != nil {
-		fmt.Println("Failed to update room's last message: ", err)
-	}
+		// Update the room's LastMessageId after sending a new message
+		if err := tx.Model(&models.ChatRoom{}).Where("id = ?", message.RoomID).Update("last_message_id", message.ID).Error; err != nil {
+			return err
+		}
 
-	serializedMessage := utils.SerializeChatMessage(message)
-	channels, err := GetRoomMemberChannels(message.RoomID)
+		var err error
+		broadcastPayload, err = roomBroadcastTx(tx, message.RoomID, "new_message", utils.SerializeChatMessage(message), fmt.Sprintf("send_message_%d", message.ID))
+		return err
+	})
+	if errors.Is(err, utils.ErrAttachmentNotFound) || errors.Is(err, utils.ErrAttachmentTooMany) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
 	if err != nil {
-		log.Printf("Failed to get room member channels for broadcasting: %s", err)
-	} else {
-		broadcastPayload := CentrifugoBroadcastPayload{
-			Channels: channels,
-			Data: struct {
-				Type string                 `json:"type"`
-				Body map[string]interface{} `json:"body"`
-			}{
-				Type: "new_message",
-				Body: serializedMessage,
-			},
-			IdempotencyKey: fmt.Sprintf("send_message_%d", message.ID),
-		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to send message"})
+	}
 
-		if _, err := CentrifugoBroadcastRoom(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
-			log.Printf("Failed to broadcast new message: %s", err)
-		}
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast new message: %s", err)
 	}
 
 	roomIDStr := strconv.FormatUint(message.RoomID, 10)
 	pageURL := fmt.Sprintf("https://www.myru.online/chat/%s?mode=false", roomIDStr)
 
-	// sendPushNotificationToOwner(recipient.UserID, user.Name, message.Content, pageURL)
-	SendNotificationToOwner(recipient.UserID.String(), user.Name, message.Content, pageURL)
+	title := user.Name
+	if room.IsGroup {
+		title = room.Title + ": " + user.Name
+	}
+	for _, recipient := range recipients {
+		// sendPushNotificationToOwner(recipient.UserID, user.Name, message.Content, pageURL)
+		SendNotificationToOwner(recipient.UserID.String(), title, message.Content, pageURL)
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"message": message}})
 }
@@ -696,7 +703,7 @@ func EditMessageForDM(c *fiber.Ctx) error {
 	}
 
 	var message models.ChatMessage
-	result := initializers.DB.First(&message, "id = ? AND user_id = ?", messageID, userID)
+	result := initializers.DB.First(&message, "id = ? AND user_id = ? AND msg_type != ?", messageID, userID, utils.ChatMsgTypeSystem)
 	if result.Error != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"status":  "error",
@@ -706,7 +713,18 @@ func EditMessageForDM(c *fiber.Ctx) error {
 
 	message.Content = payload.Content
 	message.IsEdited = true
-	if err := initializers.DB.Save(&message).Error; err != nil {
+
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&message).Error; err != nil {
+			return err
+		}
+
+		var err error
+		broadcastPayload, err = roomBroadcastTx(tx, message.RoomID, "edit_message", utils.SerializeChatMessage(message), fmt.Sprintf("edit_message_%d", message.ID))
+		return err
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Failed to update message",
@@ -714,26 +732,8 @@ func EditMessageForDM(c *fiber.Ctx) error {
 		})
 	}
 
-	serializedMessage := utils.SerializeChatMessage(message)
-	channels, err := GetRoomMemberChannels(message.RoomID)
-	if err != nil {
-		log.Printf("Failed to get room member channels for broadcasting: %s", err)
-	} else {
-		broadcastPayload := CentrifugoBroadcastPayload{
-			Channels: channels,
-			Data: struct {
-				Type string                 `json:"type"`
-				Body map[string]interface{} `json:"body"`
-			}{
-				Type: "edit_message",
-				Body: serializedMessage,
-			},
-			IdempotencyKey: fmt.Sprintf("edit_message_%d", message.ID),
-		}
-
-		if _, err := CentrifugoBroadcastRoom(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
-			log.Printf("Failed to broadcast message update: %s", err)
-		}
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast message update: %s", err)
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -757,7 +757,7 @@ func DeleteMessageForDM(c *fiber.Ctx) error {
 	}
 
 	var message models.ChatMessage
-	result := initializers.DB.First(&message, "id = ? AND user_id = ?", messageID, userID)
+	result := initializers.DB.First(&message, "id = ? AND user_id = ? AND msg_type != ?", messageID, userID, utils.ChatMsgTypeSystem)
 	if result.Error != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"status":  "error",
@@ -765,44 +765,18 @@ func DeleteMessageForDM(c *fiber.Ctx) error {
 		})
 	}
 
-	// Perform soft delete by updating IsDeleted to true and setting DeletedAt to the current time
-	now := time.Now()
-	updateResult := initializers.DB.Model(&message).Updates(models.ChatMessage{IsDeleted: true, DeletedAt: &now})
-	if updateResult.Error != nil {
+	// Delete for everyone: the content and attachments are wiped, not only flagged
+	if err := purgeChatMessage(message.ID); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to flag message as deleted",
-			"error":   updateResult.Error.Error(),
+			"message": "Failed to delete message",
+			"error":   err.Error(),
 		})
 	}
 
-	tempMessage := message
-	tempMessage.Content = "This message has been deleted."
-	serializedMessage := utils.SerializeChatMessage(tempMessage)
-	channels, err := GetRoomMemberChannels(message.RoomID)
-	if err != nil {
-		log.Printf("Failed to get room member channels for broadcasting: %s", err)
-	} else {
-		broadcastPayload := CentrifugoBroadcastPayload{
-			Channels: channels,
-			Data: struct {
-				Type string                 `json:"type"`
-				Body map[string]interface{} `json:"body"`
-			}{
-				Type: "delete_message",
-				Body: serializedMessage,
-			},
-			IdempotencyKey: fmt.Sprintf("delete_message_%d", message.ID),
-		}
-
-		if _, err := CentrifugoBroadcastRoom(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
-			log.Printf("Failed to broadcast message deletion notice: %s", err)
-		}
-	}
-
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status":  "success",
-		"message": "Message flagged as deleted successfully",
+		"message": "Message deleted for everyone",
 	})
 }
 
@@ -881,6 +855,7 @@ func GetChatMessagesForDM(c *fiber.Ctx) error {
 				})
 		}).
 		Preload("ParentMessage").
+		Preload("Attachments", utils.OrderChatAttachments).
 		Find(&messages).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -971,9 +946,27 @@ func MarkMessageAsReadForDM(c *fiber.Ctx) error {
 		})
 	}
 
-	member.LastReadMessageID = maxUint64Ptr(member.LastReadMessageID, messageIDParsed)
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.MarkChatRead(tx, &member, messageIDParsed); err != nil {
+			return err
+		}
 
-	if err := initializers.DB.Save(&member).Error; err != nil {
+		// Prepare the 'Body' map
+		bodyMap := map[string]interface{}{}
+
+		bodyMap["lastReadMessageId"] = uint64PtrToString(member.LastReadMessageID)
+		bodyMap["lastDeliveredMessageId"] = uint64PtrToString(member.LastDeliveredMessageID)
+		bodyMap["unreadCount"] = member.UnreadCount
+		bodyMap["ownerId"] = message.UserID.String()
+		bodyMap["readerId"] = userID.String()
+		bodyMap["roomId"] = strconv.FormatUint(message.RoomID, 10)
+
+		var err error
+		broadcastPayload, err = roomBroadcastTx(tx, roomIDParsed, "updated_last_read_msg_id", bodyMap, fmt.Sprintf("updated_last_read_msg_%s_%s", bodyMap["readerId"], bodyMap["lastReadMessageId"]))
+		return err
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Failed to update latest read message",
@@ -981,41 +974,121 @@ func MarkMessageAsReadForDM(c *fiber.Ctx) error {
 		})
 	}
 
-	channels, err := GetRoomMemberChannels(roomIDParsed)
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(roomIDParsed), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast update latest read msg ID: %s", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Message is marked as read",
+		"data": fiber.Map{
+			"updated_latest_read": member.LastReadMessageID,
+			"unread_count":        member.UnreadCount,
+		},
+	})
+}
+
+// MarkMessageAsDeliveredForDM is called by the app when a message reaches
+// the device, the sender sees the delivery in real time.
+func MarkMessageAsDeliveredForDM(c *fiber.Ctx) error {
+	userID := c.Locals("user").(models.UserResponse).ID
+	roomIDParsed, err := strconv.ParseUint(c.Params("roomId"), 10, 64)
 	if err != nil {
-		log.Printf("Failed to get room member channels: %s", err)
-	} else {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid room ID format, must be a positive number",
+		})
+	}
 
-		// Prepare the 'Body' map
-		bodyMap := map[string]interface{}{}
+	payload := new(UserLatestMsgRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+	}
+	messageIDParsed, err := strconv.ParseUint(payload.MessageId, 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid message ID format, must be a positive number",
+		})
+	}
 
-		bodyMap["lastReadMessageId"] = uint64PtrToString(member.LastReadMessageID)
-		bodyMap["ownerId"] = message.UserID.String()
-		bodyMap["readerId"] = userID.String()
-		bodyMap["roomId"] = strconv.FormatUint(message.RoomID, 10)
+	var member models.ChatRoomMember
+	if err := initializers.DB.Where("user_id = ? AND room_id = ?", userID, roomIDParsed).First(&member).Error; err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User is not a member of the room or room does not exist",
+		})
+	}
 
-		broadcastPayload := CentrifugoBroadcastPayload{
-			Channels: channels,
-			Data: struct {
-				Type string                 `json:"type"`
-				Body map[string]interface{} `json:"body"`
-			}{
-				Type: "updated_last_read_msg_id",
-				Body: bodyMap,
-			},
-			IdempotencyKey: fmt.Sprintf("updated_last_read_msg_%s_%s", bodyMap["readerId"], bodyMap["lastReadMessageId"]),
+	var message models.ChatMessage
+	if err := initializers.DB.First(&message, "id = ? AND room_id = ?", messageIDParsed, roomIDParsed).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Message not found in this room",
+		})
+	}
+
+	var moved bool
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if moved, err = utils.MarkChatDelivered(tx, &member, messageIDParsed); err != nil || !moved {
+			return err
+		}
+
+		bodyMap := map[string]interface{}{
+			"lastDeliveredMessageId": uint64PtrToString(member.LastDeliveredMessageID),
+			"ownerId":                message.UserID.String(),
+			"recipientId":            userID.String(),
+			"roomId":                 strconv.FormatUint(roomIDParsed, 10),
 		}
+		broadcastPayload, err = roomBroadcastTx(tx, roomIDParsed, "updated_last_delivered_msg_id", bodyMap, fmt.Sprintf("updated_last_delivered_msg_%s_%s", bodyMap["recipientId"], bodyMap["lastDeliveredMessageId"]))
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update latest delivered message",
+			"error":   err.Error(),
+		})
+	}
 
-		if _, err := CentrifugoBroadcastRoom(fmt.Sprint(roomIDParsed), broadcastPayload); err != nil {
-			log.Printf("Failed to broadcast update latest read msg ID: %s", err)
+	if moved {
+		if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(roomIDParsed), broadcastPayload); err != nil {
+			log.Printf("Failed to broadcast update latest delivered msg ID: %s", err)
 		}
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status":  "success",
-		"message": "Message is marked as read",
+		"message": "Message is marked as delivered",
 		"data": fiber.Map{
-			"updated_latest_read": member.LastReadMessageID,
+			"updated_latest_delivered": member.LastDeliveredMessageID,
+		},
+	})
+}
+
+// GetUnreadTotalForDM returns the unread counters for the app badge.
+func GetUnreadTotalForDM(c *fiber.Ctx) error {
+	userID := c.Locals("user").(models.UserResponse).ID
+
+	messages, rooms, err := utils.ChatUnreadTotal(initializers.DB, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not calculate unread Msg Count",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"unread_messages": messages,
+			"unread_rooms":    rooms,
 		},
 	})
 }
@@ -1108,16 +1181,6 @@ func SendUserTypingToCentrifugo(userID uuid.UUID, roomID string) error {
 	return nil
 }
 
-func maxUint64Ptr(a *uint64, b uint64) *uint64 {
-	if a == nil {
-		return &b // If a is nil, return b
-	}
-	if *a < b {
-		return &b // If b is greater, return b
-	}
-	return a // Otherwise, return a as it's already the maximum
-}
-
 func uint64PtrToString(val *uint64) string {
 	if val == nil {
 		// If the input is nil, you might want to return a default value
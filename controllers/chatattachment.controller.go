@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// UploadChatAttachment stores a file for a message to the room. The form
+// carries "file", "kind" (image, file or voice) and, for voice notes,
+// "duration" in seconds. The returned ID is sent in attachmentIds of the
+// message.
+func UploadChatAttachment(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+	roomID, err := strconv.ParseUint(c.Params("roomId"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Failed to parse roomId"})
+	}
+
+	var member models.ChatRoomMember
+	if err := initializers.DB.Where("room_id = ? AND user_id = ?", roomID, user.ID).First(&member).Error; err != nil || !member.IsSubscribed {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "User is not subscribed to the room"})
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "File is required"})
+	}
+	kind := c.FormValue("kind", utils.ChatAttachmentFile)
+	duration, _ := strconv.Atoi(c.FormValue("duration"))
+
+	config, _ := initializers.LoadConfig(".")
+	attachment, err := utils.SaveChatAttachment(config, roomID, user.ID, kind, file, duration)
+	if errors.Is(err, utils.ErrAttachmentTooLarge) || errors.Is(err, utils.ErrAttachmentType) ||
+		errors.Is(err, utils.ErrAttachmentDuration) || errors.Is(err, utils.ErrAttachmentPixels) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	if err != nil {
+		log.Println("Failed to store chat attachment:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to upload attachment"})
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := utils.CheckChatUploadQuota(tx, user.ID, attachment.Size); err != nil {
+			return err
+		}
+		return tx.Create(&attachment).Error
+	})
+	if err != nil {
+		utils.RemoveChatAttachmentFiles(config, attachment)
+		if errors.Is(err, utils.ErrAttachmentQuota) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"status": "error", "message": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to upload attachment"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   fiber.Map{"attachment": utils.SerializeChatAttachment(attachment)},
+	})
+}
+
+// findChatAttachment loads an attachment the user may download: members of
+// the room see attachments of messages that are not deleted, the uploader
+// also sees the ones not sent yet.
+func findChatAttachment(c *fiber.Ctx) (models.ChatAttachment, error) {
+	user := c.Locals("user").(models.UserResponse)
+
+	var attachment models.ChatAttachment
+	if err := initializers.DB.
+		Joins("JOIN chat_room_members ON chat_room_members.room_id = chat_attachments.room_id AND chat_room_members.user_id = ?", user.ID).
+		Joins("LEFT JOIN chat_messages ON chat_messages.id = chat_attachments.message_id").
+		Where("chat_attachments.id = ?", c.Params("id")).
+		Where("(chat_attachments.message_id IS NOT NULL AND chat_messages.is_deleted = ?) OR (chat_attachments.message_id IS NULL AND chat_attachments.user_id = ?)", false, user.ID).
+		First(&attachment).Error; err != nil {
+		return attachment, err
+	}
+	return attachment, nil
+}
+
+// GetChatAttachment downloads an attachment. Images and voice notes are
+// shown inline, other files are always saved.
+func GetChatAttachment(c *fiber.Ctx) error {
+	attachment, err := findChatAttachment(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Attachment not found or access denied"})
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	path := filepath.Join(utils.ChatStorePath(config), attachment.Path)
+
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	if attachment.Kind == utils.ChatAttachmentFile {
+		return c.Download(path, attachment.FileName)
+	}
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, attachment.MimeType)
+	return nil
+}
+
+// GetChatAttachmentThumbnail downloads the thumbnail of an image attachment.
+func GetChatAttachmentThumbnail(c *fiber.Ctx) error {
+	attachment, err := findChatAttachment(c)
+	if err != nil || attachment.ThumbnailPath == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Attachment not found or access denied"})
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.SendFile(filepath.Join(utils.ChatStorePath(config), attachment.ThumbnailPath))
+}
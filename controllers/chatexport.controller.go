@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func serializeChatExport(export models.ChatExport) fiber.Map {
+	data := fiber.Map{
+		"id":           export.ID,
+		"room_id":      export.RoomID,
+		"report_id":    export.ReportID,
+		"status":       export.Status,
+		"size":         export.Size,
+		"created_at":   export.CreatedAt,
+		"completed_at": export.CompletedAt,
+		"expires_at":   export.ExpiresAt,
+	}
+	if export.Status == utils.ChatExportReady {
+		data["url"] = utils.ChatExportURL(export)
+	}
+	return data
+}
+
+func chatExportRequestError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, utils.ErrChatExportInProgress) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	log.Println("Failed to request chat export:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to request export"})
+}
+
+// RequestChatExport queues an archive of one room of the requestor, or of
+// all their rooms without roomId. The user is notified when it is ready.
+func RequestChatExport(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		RoomId *uint64 `json:"roomId"`
+	}
+	if err := c.BodyParser(&payload); err != nil && len(c.Body()) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body"})
+	}
+	if payload.RoomId != nil {
+		var count int64
+		initializers.DB.Model(&models.ChatRoomMember{}).Where("room_id = ? AND user_id = ?", *payload.RoomId, user.ID).Count(&count)
+		if count == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Room not found"})
+		}
+	}
+
+	export, err := utils.RequestChatExport(initializers.DB, user.ID, payload.RoomId, nil)
+	if err != nil {
+		return chatExportRequestError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "success", "data": serializeChatExport(export)})
+}
+
+// ExportReportedRoom queues an archive of the reported room for the admin
+// handling the report.
+func ExportReportedRoom(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var report models.ChatReport
+	if err := initializers.DB.First(&report, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Report not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Database error"})
+	}
+
+	export, err := utils.RequestChatExport(initializers.DB, user.ID, &report.RoomID, &report.ID)
+	if err != nil {
+		return chatExportRequestError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "success", "data": serializeChatExport(export)})
+}
+
+// GetChatExports lists the exports of the requestor, newest first.
+func GetChatExports(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var exports []models.ChatExport
+	if err := initializers.DB.Where("user_id = ?", user.ID).Order("id DESC").Limit(20).Find(&exports).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to retrieve exports"})
+	}
+
+	data := make([]fiber.Map, 0, len(exports))
+	for _, export := range exports {
+		data = append(data, serializeChatExport(export))
+	}
+	return c.JSON(fiber.Map{"status": "success", "data": data})
+}
+
+// DownloadChatExport sends the archive to the user who requested it until
+// the link expires.
+func DownloadChatExport(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var export models.ChatExport
+	if err := initializers.DB.Where("id = ? AND user_id = ?", c.Params("id"), user.ID).First(&export).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Export not found"})
+	}
+	if export.Status != utils.ChatExportReady || export.ExpiresAt == nil || export.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{"status": "error", "message": "Export is not available"})
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	return c.Download(filepath.Join(utils.ChatStorePath(config), export.Path), fmt.Sprintf("chat-export-%d.zip", export.ID))
+}
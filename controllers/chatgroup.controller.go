@@ -0,0 +1,668 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CreateGroupRoomRequest struct {
+	Title       string   `json:"title"`
+	Avatar      string   `json:"avatar"`
+	MemberIds   []string `json:"memberIds"`
+	MemberLimit int      `json:"memberLimit"`
+}
+
+type UpdateGroupRoomRequest struct {
+	Title  *string `json:"title"`
+	Avatar *string `json:"avatar"`
+}
+
+type GroupMembersRequest struct {
+	UserIds []string `json:"userIds"`
+}
+
+var (
+	errGroupRoomNotFound   = errors.New("group room not found or access denied")
+	errGroupMemberNotFound = errors.New("member not found")
+	errGroupForbidden      = errors.New("not enough rights in the room")
+	errGroupMemberLimit    = errors.New("room member limit reached")
+)
+
+// groupRoomError answers the errors of the group room handlers.
+func groupRoomError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errGroupRoomNotFound), errors.Is(err, errGroupMemberNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	case errors.Is(err, errGroupForbidden), errors.Is(err, utils.ErrUserBlocked), errors.Is(err, utils.ErrMessagesNotAllowed):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	case errors.Is(err, errGroupMemberLimit):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update room", "error": err.Error()})
+}
+
+// parseUserIDs parses and deduplicates user IDs, skipping exclude.
+func parseUserIDs(ids []string, exclude uuid.UUID) ([]uuid.UUID, error) {
+	seen := map[uuid.UUID]bool{exclude: true}
+	var result []uuid.UUID
+	for _, id := range ids {
+		userID, err := uuid.FromString(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q", id)
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			result = append(result, userID)
+		}
+	}
+	return result, nil
+}
+
+// lockGroupRoom locks the group room row, so membership changes of the room
+// are serialized, and loads the member userID of the room.
+func lockGroupRoom(tx *gorm.DB, roomIDParam string, userID uuid.UUID) (models.ChatRoom, models.ChatRoomMember, error) {
+	var room models.ChatRoom
+	var member models.ChatRoomMember
+
+	roomID, err := strconv.ParseUint(roomIDParam, 10, 64)
+	if err != nil {
+		return room, member, errGroupRoomNotFound
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND is_group = ?", roomID, true).First(&room).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return room, member, errGroupRoomNotFound
+		}
+		return room, member, err
+	}
+	if err := tx.Where("room_id = ? AND user_id = ?", room.ID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return room, member, errGroupRoomNotFound
+		}
+		return room, member, err
+	}
+	return room, member, nil
+}
+
+// findGroupMember loads another member of the room by the userId param.
+func findGroupMember(tx *gorm.DB, roomID uint64, userIDParam string) (models.ChatRoomMember, error) {
+	var member models.ChatRoomMember
+	userID, err := uuid.FromString(userIDParam)
+	if err != nil {
+		return member, errGroupMemberNotFound
+	}
+	if err := tx.Where("room_id = ? AND user_id = ?", roomID, userID).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return member, errGroupMemberNotFound
+		}
+		return member, err
+	}
+	return member, nil
+}
+
+// checkGroupMemberLimit fails when adding count members would exceed the
+// room limit. The room row must be locked.
+func checkGroupMemberLimit(tx *gorm.DB, room models.ChatRoom, count int) error {
+	var members int64
+	if err := tx.Model(&models.ChatRoomMember{}).Where("room_id = ?", room.ID).Count(&members).Error; err != nil {
+		return err
+	}
+	if int(members)+count > room.MemberLimit {
+		return errGroupMemberLimit
+	}
+	return nil
+}
+
+// checkUsersExist fails when any of the users does not exist.
+func checkUsersExist(tx *gorm.DB, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.User{}).Where("id IN ?", userIDs).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count) != len(userIDs) {
+		return errGroupMemberNotFound
+	}
+	return nil
+}
+
+// checkCanInvite fails when any of the users blocked the inviter or does not
+// accept messages from them.
+func checkCanInvite(tx *gorm.DB, inviterID uuid.UUID, userIDs []uuid.UUID) error {
+	for _, id := range userIDs {
+		if err := utils.CanStartConversation(tx, inviterID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postGroupSystemMessageTx stores a system message about a group event,
+// makes it the last message of the room and queues its broadcast to the
+// members and to the extra users, e.g. a kicked member. The returned payload
+// must be passed to CentrifugoBroadcastRoomAfterCommit.
+func postGroupSystemMessageTx(tx *gorm.DB, roomID uint64, actorID uuid.UUID, event string, content string, data map[string]interface{}, extraUsers ...uuid.UUID) (CentrifugoBroadcastPayload, error) {
+	var payload CentrifugoBroadcastPayload
+
+	data["event"] = event
+	data["actorId"] = actorID.String()
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return payload, err
+	}
+	jsonDataStr := string(jsonData)
+
+	message := models.ChatMessage{
+		Content:  content,
+		UserID:   actorID,
+		RoomID:   roomID,
+		MsgType:  utils.ChatMsgTypeSystem,
+		JsonData: &jsonDataStr,
+	}
+	if err := tx.Create(&message).Error; err != nil {
+		return payload, err
+	}
+	if err := tx.Model(&models.ChatRoom{}).Where("id = ?", roomID).Updates(map[string]interface{}{"last_message_id": message.ID, "bumped_at": time.Now()}).Error; err != nil {
+		return payload, err
+	}
+
+	payload, err = NewRoomBroadcastPayloadTx(tx, roomID, "new_message", utils.SerializeChatMessage(message), fmt.Sprintf("send_message_%d", message.ID))
+	if err != nil {
+		return payload, err
+	}
+	for _, userID := range extraUsers {
+		payload.Channels = append(payload.Channels, fmt.Sprintf("personal:%s", userID))
+	}
+	return payload, CentrifugoBroadcastRoomTx(tx, fmt.Sprint(roomID), payload)
+}
+
+// broadcastGroupRoom sends the updated room to its members and to the extra
+// users after the change is committed.
+func broadcastGroupRoom(roomID uint64, eventType string, messagePayload CentrifugoBroadcastPayload, extraUsers ...uuid.UUID) {
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(roomID), messagePayload); err != nil {
+		log.Printf("Failed to broadcast group system message: %s", err)
+	}
+
+	channels, err := GetRoomMemberChannels(roomID)
+	if err != nil {
+		log.Printf("Failed to get room member channels for broadcasting: %s", err)
+		return
+	}
+	for _, userID := range extraUsers {
+		channels = append(channels, fmt.Sprintf("personal:%s", userID))
+	}
+
+	var payload CentrifugoBroadcastPayload
+	payload.Channels = channels
+	payload.Data.Type = eventType
+	payload.Data.Body = utils.SerializeChatRoom(roomID)
+	payload.IdempotencyKey = fmt.Sprintf("%s_%d_%d", eventType, roomID, time.Now().UTC().UnixNano())
+	if _, err := CentrifugoBroadcastRoom(fmt.Sprint(roomID), payload); err != nil {
+		log.Printf("Failed to broadcast group room update: %s", err)
+	}
+}
+
+// notifyGroupInvitees sends a push notification about the invitation.
+func notifyGroupInvitees(room models.ChatRoom, inviter string, userIDs []uuid.UUID) {
+	pageURL := fmt.Sprintf("https://www.myru.online/ru/chat/%d?mode=false", room.ID)
+	for _, userID := range userIDs {
+		SendNotificationToOwner(userID.String(), room.Title, inviter+" added you to the group", pageURL)
+	}
+}
+
+// CreateGroupRoom creates a group room owned by the requestor. Invited users
+// see it among new rooms until they subscribe.
+func CreateGroupRoom(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	payload := new(CreateGroupRoomRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body", "error": err.Error()})
+	}
+	payload.Title = strings.TrimSpace(payload.Title)
+	if payload.Title == "" || len([]rune(payload.Title)) > 128 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Title is required and must be at most 128 characters"})
+	}
+	memberIDs, err := parseUserIDs(payload.MemberIds, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	limit := utils.ChatGroupMemberLimit(config)
+	if payload.MemberLimit > 0 && payload.MemberLimit < limit {
+		limit = payload.MemberLimit
+	}
+	if len(memberIDs)+1 > limit {
+		return groupRoomError(c, errGroupMemberLimit)
+	}
+
+	room := models.ChatRoom{
+		Name:        "group_" + uuid.NewV4().String(),
+		IsGroup:     true,
+		Title:       payload.Title,
+		Avatar:      payload.Avatar,
+		OwnerID:     &user.ID,
+		MemberLimit: limit,
+	}
+	var messagePayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkUsersExist(tx, memberIDs); err != nil {
+			return err
+		}
+		if err := checkCanInvite(tx, user.ID, memberIDs); err != nil {
+			return err
+		}
+		if err := tx.Create(&room).Error; err != nil {
+			return err
+		}
+
+		members := []models.ChatRoomMember{{RoomID: room.ID, UserID: user.ID, IsSubscribed: true, Role: utils.ChatRoleOwner}}
+		for _, memberID := range memberIDs {
+			members = append(members, models.ChatRoomMember{RoomID: room.ID, UserID: memberID, IsNew: true, Role: utils.ChatRoleMember})
+		}
+		if err := tx.Create(&members).Error; err != nil {
+			return err
+		}
+
+		var err error
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, utils.ChatEventCreated,
+			user.Name+" created the group", map[string]interface{}{"userIds": memberIDs})
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	broadcastGroupRoom(room.ID, "new_room", messagePayload)
+	notifyGroupInvitees(room, user.Name, memberIDs)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"room": utils.SerializeChatRoom(room.ID),
+		},
+	})
+}
+
+// UpdateGroupRoom changes the title or the avatar of the room. Admins only.
+func UpdateGroupRoom(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	payload := new(UpdateGroupRoomRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body", "error": err.Error()})
+	}
+	updates := map[string]interface{}{}
+	if payload.Title != nil {
+		title := strings.TrimSpace(*payload.Title)
+		if title == "" || len([]rune(title)) > 128 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Title is required and must be at most 128 characters"})
+		}
+		updates["title"] = title
+	}
+	if payload.Avatar != nil {
+		updates["avatar"] = *payload.Avatar
+	}
+	if len(updates) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Nothing to update"})
+	}
+
+	var room models.ChatRoom
+	var messagePayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var actor models.ChatRoomMember
+		var err error
+		room, actor, err = lockGroupRoom(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		if !utils.IsChatAdmin(actor) {
+			return errGroupForbidden
+		}
+		if err := tx.Model(&room).Updates(updates).Error; err != nil {
+			return err
+		}
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, utils.ChatEventUpdated,
+			user.Name+" updated the group", updates)
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	broadcastGroupRoom(room.ID, "room_updated", messagePayload)
+
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"room": utils.SerializeChatRoom(room.ID)}})
+}
+
+// InviteGroupMembers adds users to the room. Admins only.
+func InviteGroupMembers(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	payload := new(GroupMembersRequest)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body", "error": err.Error()})
+	}
+	userIDs, err := parseUserIDs(payload.UserIds, user.ID)
+	if err != nil || len(userIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Specify the users to invite"})
+	}
+
+	var room models.ChatRoom
+	var invited []uuid.UUID
+	var messagePayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var actor models.ChatRoomMember
+		var err error
+		room, actor, err = lockGroupRoom(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		if !utils.IsChatAdmin(actor) {
+			return errGroupForbidden
+		}
+		if err := checkUsersExist(tx, userIDs); err != nil {
+			return err
+		}
+
+		var existing []uuid.UUID
+		if err := tx.Model(&models.ChatRoomMember{}).Where("room_id = ? AND user_id IN ?", room.ID, userIDs).Pluck("user_id", &existing).Error; err != nil {
+			return err
+		}
+		isMember := map[uuid.UUID]bool{}
+		for _, id := range existing {
+			isMember[id] = true
+		}
+		var members []models.ChatRoomMember
+		for _, id := range userIDs {
+			if !isMember[id] {
+				invited = append(invited, id)
+				members = append(members, models.ChatRoomMember{RoomID: room.ID, UserID: id, IsNew: true, Role: utils.ChatRoleMember})
+			}
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		if err := checkCanInvite(tx, user.ID, invited); err != nil {
+			return err
+		}
+		if err := checkGroupMemberLimit(tx, room, len(members)); err != nil {
+			return err
+		}
+		if err := tx.Create(&members).Error; err != nil {
+			return err
+		}
+
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, utils.ChatEventJoined,
+			user.Name+" added new members", map[string]interface{}{"userIds": invited})
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	if len(invited) > 0 {
+		broadcastGroupRoom(room.ID, "room_updated", messagePayload)
+		notifyGroupInvitees(room, user.Name, invited)
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"invited": invited}})
+}
+
+// KickGroupMember removes a member from the room. Admins kick members, the
+// owner kicks anyone.
+func KickGroupMember(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var room models.ChatRoom
+	var target models.ChatRoomMember
+	var messagePayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var actor models.ChatRoomMember
+		var err error
+		room, actor, err = lockGroupRoom(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		if target, err = findGroupMember(tx, room.ID, c.Params("userId")); err != nil {
+			return err
+		}
+		if !utils.CanManageChatMember(actor, target) {
+			return errGroupForbidden
+		}
+		if err := tx.Delete(&target).Error; err != nil {
+			return err
+		}
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, utils.ChatEventKicked,
+			user.Name+" removed a member", map[string]interface{}{"userId": target.UserID}, target.UserID)
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	broadcastGroupRoom(room.ID, "room_updated", messagePayload, target.UserID)
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Member removed"})
+}
+
+// MuteGroupMember forbids a member to send messages for the given number of
+// minutes, 0 unmutes. Same rights as for kicking.
+func MuteGroupMember(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		Minutes int `json:"minutes"`
+	}
+	if err := c.BodyParser(&payload); err != nil || payload.Minutes < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "minutes must be zero or positive"})
+	}
+
+	var room models.ChatRoom
+	var target models.ChatRoomMember
+	var messagePayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var actor models.ChatRoomMember
+		var err error
+		room, actor, err = lockGroupRoom(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		if target, err = findGroupMember(tx, room.ID, c.Params("userId")); err != nil {
+			return err
+		}
+		if !utils.CanManageChatMember(actor, target) {
+			return errGroupForbidden
+		}
+
+		event, content := utils.ChatEventUnmuted, user.Name+" unmuted a member"
+		target.MutedUntil = nil
+		if payload.Minutes > 0 {
+			until := time.Now().Add(time.Duration(payload.Minutes) * time.Minute)
+			target.MutedUntil = &until
+			event, content = utils.ChatEventMuted, user.Name+" muted a member"
+		}
+		if err := tx.Model(&target).Update("muted_until", target.MutedUntil).Error; err != nil {
+			return err
+		}
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, event, content,
+			map[string]interface{}{"userId": target.UserID, "mutedUntil": target.MutedUntil})
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	broadcastGroupRoom(room.ID, "room_updated", messagePayload)
+
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"mutedUntil": target.MutedUntil}})
+}
+
+// SetGroupMemberRole makes a member an admin or back a member. Owner only.
+func SetGroupMemberRole(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&payload); err != nil || (payload.Role != utils.ChatRoleAdmin && payload.Role != utils.ChatRoleMember) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "role must be admin or member"})
+	}
+
+	var room models.ChatRoom
+	var messagePayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var actor, target models.ChatRoomMember
+		var err error
+		room, actor, err = lockGroupRoom(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		if actor.Role != utils.ChatRoleOwner {
+			return errGroupForbidden
+		}
+		if target, err = findGroupMember(tx, room.ID, c.Params("userId")); err != nil {
+			return err
+		}
+		if target.Role == utils.ChatRoleOwner {
+			return errGroupForbidden
+		}
+		if err := tx.Model(&target).Update("role", payload.Role).Error; err != nil {
+			return err
+		}
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, utils.ChatEventRoleChanged,
+			user.Name+" changed a member role", map[string]interface{}{"userId": target.UserID, "role": payload.Role})
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	broadcastGroupRoom(room.ID, "room_updated", messagePayload)
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Role updated"})
+}
+
+// TransferGroupOwnership hands the room over to another subscribed member.
+// The previous owner stays an admin.
+func TransferGroupOwnership(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		UserId string `json:"userId"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body", "error": err.Error()})
+	}
+
+	var room models.ChatRoom
+	var messagePayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var actor, target models.ChatRoomMember
+		var err error
+		room, actor, err = lockGroupRoom(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		if actor.Role != utils.ChatRoleOwner {
+			return errGroupForbidden
+		}
+		if target, err = findGroupMember(tx, room.ID, payload.UserId); err != nil {
+			return err
+		}
+		if target.UserID == actor.UserID || !target.IsSubscribed {
+			return errGroupForbidden
+		}
+		messagePayload, err = transferGroupOwnershipTx(tx, room, actor, target, user.Name+" transferred the ownership")
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	broadcastGroupRoom(room.ID, "room_updated", messagePayload)
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Ownership transferred"})
+}
+
+func transferGroupOwnershipTx(tx *gorm.DB, room models.ChatRoom, owner models.ChatRoomMember, target models.ChatRoomMember, content string) (CentrifugoBroadcastPayload, error) {
+	if owner.ID != 0 {
+		if err := tx.Model(&owner).Update("role", utils.ChatRoleAdmin).Error; err != nil {
+			return CentrifugoBroadcastPayload{}, err
+		}
+	}
+	if err := tx.Model(&target).Updates(map[string]interface{}{"role": utils.ChatRoleOwner, "muted_until": nil}).Error; err != nil {
+		return CentrifugoBroadcastPayload{}, err
+	}
+	if err := tx.Model(&room).Update("owner_id", target.UserID).Error; err != nil {
+		return CentrifugoBroadcastPayload{}, err
+	}
+	return postGroupSystemMessageTx(tx, room.ID, owner.UserID, utils.ChatEventOwnerChange, content,
+		map[string]interface{}{"userId": target.UserID})
+}
+
+// LeaveGroupRoom removes the requestor from the room. When the owner leaves,
+// the ownership passes to the longest-standing admin, or member when there
+// are no admins.
+func LeaveGroupRoom(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var room models.ChatRoom
+	var messagePayload, ownerPayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var member models.ChatRoomMember
+		var err error
+		room, member, err = lockGroupRoom(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&member).Error; err != nil {
+			return err
+		}
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, utils.ChatEventLeft,
+			user.Name+" left the group", map[string]interface{}{"userId": user.ID}, user.ID)
+		if err != nil || member.Role != utils.ChatRoleOwner {
+			return err
+		}
+
+		var successor models.ChatRoomMember
+		err = tx.Where("room_id = ?", room.ID).
+			Order(clause.Expr{SQL: "CASE WHEN role = ? THEN 0 WHEN is_subscribed THEN 1 ELSE 2 END, joined_at, id", Vars: []interface{}{utils.ChatRoleAdmin}}).
+			First(&successor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Model(&room).Update("owner_id", nil).Error
+		}
+		if err != nil {
+			return err
+		}
+		ownerPayload, err = transferGroupOwnershipTx(tx, room, models.ChatRoomMember{UserID: user.ID}, successor, "The group has a new owner")
+		return err
+	})
+	if err != nil {
+		return groupRoomError(c, err)
+	}
+
+	if len(ownerPayload.Channels) > 0 {
+		if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(room.ID), ownerPayload); err != nil {
+			log.Printf("Failed to broadcast group system message: %s", err)
+		}
+	}
+	broadcastGroupRoom(room.ID, "room_updated", messagePayload, user.ID)
+
+	return c.JSON(fiber.Map{"status": "success", "message": "You left the group"})
+}
@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type ForwardMessageRequest struct {
+	RoomID  uint64 `json:"roomId"`
+	Comment string `json:"comment,omitempty"` // sent as a separate message before the forwarded one
+}
+
+// findMemberMessage loads a message the user can see: a message of a room
+// the user is a subscribed member of, not deleted and not a system one.
+func findMemberMessage(messageIDParam string, userID uuid.UUID) (models.ChatMessage, models.ChatRoomMember, error) {
+	var message models.ChatMessage
+	var member models.ChatRoomMember
+
+	messageID, err := strconv.ParseUint(messageIDParam, 10, 64)
+	if err != nil {
+		return message, member, gorm.ErrRecordNotFound
+	}
+	if err := initializers.DB.First(&message, "id = ? AND is_deleted = ? AND msg_type != ?", messageID, false, utils.ChatMsgTypeSystem).Error; err != nil {
+		return message, member, err
+	}
+	if err := initializers.DB.Where("room_id = ? AND user_id = ? AND is_subscribed = ?", message.RoomID, userID, true).First(&member).Error; err != nil {
+		return message, member, err
+	}
+	return message, member, nil
+}
+
+// findWritableMemberMessage is findMemberMessage for changes to the message:
+// like posting, they are refused to muted members of group rooms and in
+// direct rooms where either side blocked the other. Errors are *fiber.Error
+// with the response status.
+func findWritableMemberMessage(messageIDParam string, userID uuid.UUID) (models.ChatMessage, models.ChatRoomMember, models.ChatRoom, error) {
+	var room models.ChatRoom
+	message, member, err := findMemberMessage(messageIDParam, userID)
+	if err != nil {
+		return message, member, room, fiber.NewError(fiber.StatusNotFound, "Message not found or access denied")
+	}
+	if err := initializers.DB.First(&room, message.RoomID).Error; err != nil {
+		return message, member, room, fiber.NewError(fiber.StatusNotFound, "Room not found")
+	}
+	return message, member, room, checkMemberMayWrite(room, member)
+}
+
+// ToggleMessageReaction adds the emoji reaction of the user to the message,
+// or removes it when the user already reacted with it.
+func ToggleMessageReaction(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	payload := new(ReactionRequest)
+	if err := c.BodyParser(payload); err != nil || !utils.IsReactionEmoji(payload.Emoji) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": utils.ErrReactionEmoji.Error()})
+	}
+
+	message, _, _, err := findWritableMemberMessage(c.Params("messageId"), user.ID)
+	if err != nil {
+		return c.Status(err.(*fiber.Error).Code).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+
+	var added bool
+	var reactions []utils.ChatReactionSummary
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if added, err = utils.ToggleChatReaction(tx, message.ID, user.ID, payload.Emoji); err != nil {
+			return err
+		}
+		reactions = utils.ChatMessageReactions(tx, message.ID)
+
+		body := map[string]interface{}{
+			"message_id": message.ID,
+			"room_id":    message.RoomID,
+			"user_id":    user.ID.String(),
+			"emoji":      payload.Emoji,
+			"added":      added,
+			"reactions":  reactions,
+		}
+		broadcastPayload, err = NewRoomBroadcastPayloadTx(tx, message.RoomID, "reaction_message", body, fmt.Sprintf("reaction_message_%d_%d", message.ID, time.Now().UTC().UnixNano()))
+		if err != nil {
+			return err
+		}
+		return CentrifugoBroadcastRoomTx(tx, fmt.Sprint(message.RoomID), broadcastPayload)
+	})
+	if errors.Is(err, utils.ErrReactionTooMany) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update reaction"})
+	}
+
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast reaction: %s", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   fiber.Map{"added": added, "reactions": reactions},
+	})
+}
+
+// setMessagePinned pins or unpins a message. Any member pins in direct
+// rooms, only admins in group rooms.
+func setMessagePinned(c *fiber.Ctx, pin bool) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	message, member, room, err := findWritableMemberMessage(c.Params("messageId"), user.ID)
+	if err != nil {
+		return c.Status(err.(*fiber.Error).Code).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	if room.IsGroup && !utils.IsChatAdmin(member) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Only room admins can pin messages"})
+	}
+	if pin == (message.PinnedAt != nil) {
+		return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"message": utils.SerializeChatMessage(message)}})
+	}
+
+	eventType := "unpin_message"
+	var broadcastPayload CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if pin {
+			var pinned int64
+			if err := tx.Model(&models.ChatMessage{}).Where("room_id = ? AND pinned_at IS NOT NULL", message.RoomID).Count(&pinned).Error; err != nil {
+				return err
+			}
+			if pinned >= utils.ChatPinnedMessagesPerRoom {
+				return utils.ErrPinnedLimit
+			}
+			now := time.Now()
+			message.PinnedAt, message.PinnedByID = &now, &user.ID
+			eventType = "pin_message"
+		} else {
+			message.PinnedAt, message.PinnedByID = nil, nil
+		}
+		if err := tx.Model(&message).Updates(map[string]interface{}{"pinned_at": message.PinnedAt, "pinned_by_id": message.PinnedByID}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		broadcastPayload, err = NewRoomBroadcastPayloadTx(tx, message.RoomID, eventType, utils.SerializeChatMessage(message), fmt.Sprintf("%s_%d_%d", eventType, message.ID, time.Now().UTC().UnixNano()))
+		if err != nil {
+			return err
+		}
+		return CentrifugoBroadcastRoomTx(tx, fmt.Sprint(message.RoomID), broadcastPayload)
+	})
+	if errors.Is(err, utils.ErrPinnedLimit) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update message"})
+	}
+
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast %s: %s", eventType, err)
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"message": utils.SerializeChatMessage(message)}})
+}
+
+func PinMessage(c *fiber.Ctx) error {
+	return setMessagePinned(c, true)
+}
+
+func UnpinMessage(c *fiber.Ctx) error {
+	return setMessagePinned(c, false)
+}
+
+// GetPinnedMessages lists the pinned messages of the room, the latest pinned
+// first.
+func GetPinnedMessages(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+	roomID, err := strconv.ParseUint(c.Params("roomId"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid room ID format, must be a positive number"})
+	}
+
+	// Same rule as findMemberMessage: only subscribed members see the room
+	var count int64
+	initializers.DB.Model(&models.ChatRoomMember{}).Where("room_id = ? AND user_id = ? AND is_subscribed = ?", roomID, user.ID, true).Count(&count)
+	if count == 0 {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "User is not subscribed to the room"})
+	}
+
+	var messages []models.ChatMessage
+	if err := initializers.DB.Preload("Attachments", utils.OrderChatAttachments).
+		Where("room_id = ? AND pinned_at IS NOT NULL AND is_deleted = ?", roomID, false).
+		Order("pinned_at DESC").Find(&messages).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to load pinned messages"})
+	}
+
+	serialized := []map[string]interface{}{}
+	for _, message := range messages {
+		serialized = append(serialized, utils.SerializeChatMessage(message))
+	}
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"messages": serialized}})
+}
+
+// ForwardMessage copies a message with its attachments into another room the
+// user can post to. The copy is attributed to the author of the original.
+func ForwardMessage(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	payload := new(ForwardMessageRequest)
+	if err := c.BodyParser(payload); err != nil || payload.RoomID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "roomId is required"})
+	}
+
+	original, _, err := findMemberMessage(c.Params("messageId"), user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Message not found or access denied"})
+	}
+	room, recipients, err := checkRoomPosting(payload.RoomID, user.ID)
+	if err != nil {
+		return c.Status(err.(*fiber.Error).Code).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+
+	message := models.ChatMessage{
+		Content:             original.Content,
+		UserID:              user.ID,
+		RoomID:              room.ID,
+		MsgType:             original.MsgType,
+		JsonData:            original.JsonData,
+		ForwardedFromID:     &original.ID,
+		ForwardedFromUserID: &original.UserID,
+	}
+	if original.ForwardedFromUserID != nil {
+		message.ForwardedFromID, message.ForwardedFromUserID = original.ForwardedFromID, original.ForwardedFromUserID
+	}
+
+	var payloads []CentrifugoBroadcastPayload
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if payload.Comment != "" {
+			comment := models.ChatMessage{Content: payload.Comment, UserID: user.ID, RoomID: room.ID}
+			if err := tx.Create(&comment).Error; err != nil {
+				return err
+			}
+			commentPayload, err := NewRoomBroadcastPayloadTx(tx, room.ID, "new_message", utils.SerializeChatMessage(comment), fmt.Sprintf("send_message_%d", comment.ID))
+			if err != nil {
+				return err
+			}
+			if err := CentrifugoBroadcastRoomTx(tx, fmt.Sprint(room.ID), commentPayload); err != nil {
+				return err
+			}
+			payloads = append(payloads, commentPayload)
+		}
+
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+
+		// The copies point to the stored files of the original room, access
+		// is checked against the room of the copy.
+		var attachments []models.ChatAttachment
+		if err := tx.Where("message_id = ?", original.ID).Order("id").Find(&attachments).Error; err != nil {
+			return err
+		}
+		for i := range attachments {
+			attachments[i].ID = 0
+			attachments[i].RoomID = room.ID
+			attachments[i].MessageID = &message.ID
+			attachments[i].UserID = user.ID
+			attachments[i].CreatedAt = time.Now()
+		}
+		if len(attachments) > 0 {
+			if err := tx.Create(&attachments).Error; err != nil {
+				return err
+			}
+		}
+		message.Attachments = attachments
+
+		if err := tx.Model(&models.ChatRoom{}).Where("id = ?", room.ID).Update("last_message_id", message.ID).Error; err != nil {
+			return err
+		}
+
+		forwardPayload, err := NewRoomBroadcastPayloadTx(tx, room.ID, "forward_message", utils.SerializeChatMessage(message), fmt.Sprintf("forward_message_%d", message.ID))
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, forwardPayload)
+		return CentrifugoBroadcastRoomTx(tx, fmt.Sprint(room.ID), forwardPayload)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to forward message"})
+	}
+
+	for _, broadcastPayload := range payloads {
+		if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(room.ID), broadcastPayload); err != nil {
+			log.Printf("Failed to broadcast forwarded message: %s", err)
+		}
+	}
+
+	pageURL := fmt.Sprintf("https://www.myru.online/chat/%d?mode=false", room.ID)
+	title := user.Name
+	if room.IsGroup {
+		title = room.Title + ": " + user.Name
+	}
+	for _, recipient := range recipients {
+		SendNotificationToOwner(recipient.UserID.String(), title, message.Content, pageURL)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"message": utils.SerializeChatMessage(message)}})
+}
+
+// SearchChatMessages searches the messages of all rooms of the user. Every
+// hit carries the room and an anchor for GET /chat/message/:roomId with
+// end_msg_id, which loads the room history down to the message. The next
+// page is requested with before_id.
+func SearchChatMessages(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	query := strings.TrimSpace(c.Query("q"))
+	if len([]rune(query)) < 2 || len(query) > 256 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Query must be from 2 to 256 characters"})
+	}
+	language := c.Query("lang")
+	if _, ok := utils.ChatSearchConfigs[language]; language != "" && !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "lang must be one of ru, en, ka, es"})
+	}
+	roomID, _ := strconv.ParseUint(c.Query("room_id"), 10, 64)
+	beforeID, _ := strconv.ParseUint(c.Query("before_id"), 10, 64)
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 || limit > 50 {
+		limit = 20
+	}
+
+	hits, err := utils.SearchChatMessages(initializers.DB, user.ID, query, language, roomID, beforeID, limit+1)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to search messages"})
+	}
+	var nextBeforeID *uint64
+	if len(hits) > limit {
+		hits = hits[:limit]
+		nextBeforeID = &hits[limit-1].ID
+	}
+
+	ids := make([]uint64, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	var messages []models.ChatMessage
+	initializers.DB.Preload("Attachments", utils.OrderChatAttachments).Where("id IN ?", ids).Find(&messages)
+	messageByID := map[uint64]models.ChatMessage{}
+	for _, message := range messages {
+		messageByID[message.ID] = message
+	}
+
+	rooms := map[uint64]map[string]interface{}{}
+	results := []fiber.Map{}
+	for _, hit := range hits {
+		message, ok := messageByID[hit.ID]
+		if !ok {
+			continue
+		}
+		if _, ok := rooms[hit.RoomID]; !ok {
+			rooms[hit.RoomID] = utils.SerializeChatRoom(hit.RoomID)
+		}
+		results = append(results, fiber.Map{
+			"message": utils.SerializeChatMessage(message),
+			"snippet": hit.Snippet,
+			"room":    rooms[hit.RoomID],
+			"anchor":  fiber.Map{"room_id": hit.RoomID, "end_msg_id": hit.ID},
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"results": results,
+		},
+		"limit":          limit,
+		"next_before_id": nextBeforeID,
+	})
+}
+
+// GetMessageReceipts lists the members that got and read the message.
+func GetMessageReceipts(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	message, _, err := findMemberMessage(c.Params("messageId"), user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Message not found or access denied"})
+	}
+
+	delivered, read, err := utils.ChatMessageReceipts(initializers.DB, message)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to load receipts"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"message_id":   message.ID,
+			"delivered_to": delivered,
+			"read_by":      read,
+		},
+	})
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+var errMessageRequestNotFound = errors.New("Message request not found")
+
+// declineMessageRequestTx declines the pending request of the room for the
+// user: the room leaves the requests queue without being subscribed. It
+// returns the room and the user who sent the request, the other member of a
+// direct room or the owner of a group.
+func declineMessageRequestTx(tx *gorm.DB, roomIDParam string, userID uuid.UUID) (models.ChatRoom, uuid.UUID, error) {
+	var room models.ChatRoom
+	roomID, err := strconv.ParseUint(roomIDParam, 10, 64)
+	if err != nil {
+		return room, uuid.Nil, errMessageRequestNotFound
+	}
+
+	result := tx.Model(&models.ChatRoomMember{}).
+		Where("room_id = ? AND user_id = ? AND is_new = ? AND is_subscribed = ?", roomID, userID, true, false).
+		Updates(map[string]interface{}{"is_new": false, "is_subscribed": false})
+	if result.Error != nil {
+		return room, uuid.Nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return room, uuid.Nil, errMessageRequestNotFound
+	}
+
+	if err := tx.First(&room, roomID).Error; err != nil {
+		return room, uuid.Nil, err
+	}
+	if room.IsGroup && room.OwnerID != nil {
+		return room, *room.OwnerID, nil
+	}
+	var sender models.ChatRoomMember
+	if err := tx.Where("room_id = ? AND user_id != ?", room.ID, userID).First(&sender).Error; err != nil {
+		return room, uuid.Nil, err
+	}
+	return room, sender.UserID, nil
+}
+
+func messageRequestError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errMessageRequestNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update message request", "error": err.Error()})
+}
+
+// DeclineMessageRequest removes the room from the requests queue. The sender
+// is not notified.
+func DeclineMessageRequest(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		_, _, err := declineMessageRequestTx(tx, c.Params("roomId"), user.ID)
+		return err
+	})
+	if err != nil {
+		return messageRequestError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Message request declined"})
+}
+
+// ReportMessageRequest declines the request and files a report on its sender
+// for the admins. With block set the sender is blocked as well.
+func ReportMessageRequest(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		Reason string `json:"reason"`
+		Block  bool   `json:"block"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body"})
+	}
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if len([]rune(payload.Reason)) > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Reason must be at most 1000 characters"})
+	}
+
+	var report models.ChatReport
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		room, senderID, err := declineMessageRequestTx(tx, c.Params("roomId"), user.ID)
+		if err != nil {
+			return err
+		}
+		report = models.ChatReport{
+			RoomID:     room.ID,
+			ReporterID: user.ID,
+			ReportedID: senderID,
+			Reason:     payload.Reason,
+			Status:     "open",
+		}
+		if err := tx.Create(&report).Error; err != nil {
+			return err
+		}
+		if payload.Block {
+			return utils.BlockUser(tx, user.ID, senderID)
+		}
+		return nil
+	})
+	if err != nil {
+		return messageRequestError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "data": report})
+}
+
+// GetChatReports lists the reports for the admins, newest first. The status
+// query filters by open or resolved.
+func GetChatReports(c *fiber.Ctx) error {
+	var reports []models.ChatReport
+	db := initializers.DB.Order("created_at DESC")
+	if status := c.Query("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	return utils.Paginate(c, db, &reports)
+}
+
+// ResolveChatReport marks the report as resolved by the admin.
+func ResolveChatReport(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var report models.ChatReport
+	if err := initializers.DB.First(&report, "id = ?", c.Params("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Report not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Database error"})
+	}
+	if report.Status == "resolved" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "error", "message": "Report is already resolved"})
+	}
+
+	now := time.Now()
+	report.Status = "resolved"
+	report.ResolvedBy = &user.ID
+	report.ResolvedAt = &now
+	if err := initializers.DB.Save(&report).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to resolve report"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "data": report})
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// purgeChatMessage wipes the message for everyone and tells the members of
+// the room. Already purged messages are skipped.
+func purgeChatMessage(messageID uint64) error {
+	var message models.ChatMessage
+	var unused []models.ChatAttachment
+	var broadcastPayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND purged_at IS NULL", messageID).First(&message).Error; err != nil {
+			return err
+		}
+		var err error
+		if unused, err = utils.PurgeChatMessageTx(tx, &message); err != nil {
+			return err
+		}
+
+		tempMessage := message
+		tempMessage.Content = "This message has been deleted."
+		broadcastPayload, err = NewRoomBroadcastPayloadTx(tx, message.RoomID, "delete_message", utils.SerializeChatMessage(tempMessage), fmt.Sprintf("delete_message_%d", message.ID))
+		if err != nil {
+			return err
+		}
+		return CentrifugoBroadcastRoomTx(tx, fmt.Sprint(message.RoomID), broadcastPayload)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	for _, attachment := range unused {
+		utils.RemoveChatAttachmentFiles(config, attachment)
+	}
+	if _, err := CentrifugoBroadcastRoomAfterCommit(fmt.Sprint(message.RoomID), broadcastPayload); err != nil {
+		log.Printf("Failed to broadcast message deletion notice: %s", err)
+	}
+	return nil
+}
+
+// SweepExpiredChatMessages purges messages past the retention of their room
+// and the content left by soft deletes. One node sweeps at a time.
+func SweepExpiredChatMessages() (int, error) {
+	purged := 0
+	_, err := utils.WithRedisLock("chat_retention_sweep", 10*time.Minute, func() error {
+		ids, err := utils.ExpiredChatMessageIDs(initializers.DB, 500)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := purgeChatMessage(id); err != nil {
+				log.Println("Failed to purge chat message", id, err)
+				continue
+			}
+			purged++
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// SetRoomRetention sets how long the messages of the room are kept. Any
+// member of a direct room and admins of a group may change it.
+func SetRoomRetention(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		Retention string `json:"retention"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid request body"})
+	}
+	if !utils.IsChatRetention(payload.Retention) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": utils.ErrChatRetentionValue.Error()})
+	}
+
+	var room models.ChatRoom
+	var member models.ChatRoomMember
+	if err := initializers.DB.First(&room, "id = ?", c.Params("roomId")).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Room not found"})
+	}
+	if err := initializers.DB.Where("room_id = ? AND user_id = ?", room.ID, user.ID).First(&member).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Room not found"})
+	}
+	if room.IsGroup && !utils.IsChatAdmin(member) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": errGroupForbidden.Error()})
+	}
+	if room.Retention == payload.Retention {
+		return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"room": utils.SerializeChatRoom(room.ID)}})
+	}
+
+	var messagePayload CentrifugoBroadcastPayload
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&room).Update("retention", payload.Retention).Error; err != nil {
+			return err
+		}
+		content := user.Name + " set messages to disappear after " + payload.Retention
+		if payload.Retention == utils.ChatRetentionOff {
+			content = user.Name + " turned off disappearing messages"
+		}
+		var err error
+		messagePayload, err = postGroupSystemMessageTx(tx, room.ID, user.ID, utils.ChatEventRetention,
+			content, map[string]interface{}{"retention": payload.Retention})
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update room", "error": err.Error()})
+	}
+
+	broadcastGroupRoom(room.ID, "room_updated", messagePayload)
+
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"room": utils.SerializeChatRoom(room.ID)}})
+}
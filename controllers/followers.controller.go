@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"hyperpage/initializers"
 	"hyperpage/models"
+	"hyperpage/utils"
 	"log"
 
 	uuid "github.com/satori/go.uuid"
@@ -59,6 +60,12 @@ func Scribe(c *fiber.Ctx) error {
 		})
 	}
 
+	if utils.IsBlockedEitherWay(initializers.DB, requestBody.UserID, requestBody.FollowerID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "User is blocked",
+		})
+	}
+
 	var follower, user models.User
 
 	// Fetch the follower and user based on the provided IDs
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetInvoices lists the invoices and receipts the user paid or issued.
+func GetInvoices(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	query := initializers.DB.Where("user_id = ? OR issuer_id = ?", user.ID, user.ID)
+	if kind := c.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	var documents []models.Document
+	return utils.Paginate(c, query.Order("created_at DESC"), &documents)
+}
+
+// GetInvoicePDF renders an invoice or receipt as PDF for its customer, its
+// issuer or an admin.
+func GetInvoicePDF(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid document ID",
+		})
+	}
+
+	var document models.Document
+	if err := initializers.DB.First(&document, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Document not found",
+		})
+	}
+
+	isIssuer := document.IssuerID != nil && *document.IssuerID == user.ID
+	if document.UserID != user.ID && !isIssuer && user.Role != "admin" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Access denied",
+		})
+	}
+
+	language := c.Query("language")
+	if language == "" {
+		language = utils.DocumentUserLanguage(user.ID)
+	}
+
+	pdf, err := utils.RenderDocumentPDF(document, language)
+	if err != nil {
+		log.Println("Failed to render document:", document.ID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to render document",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+document.Code+`.pdf"`)
+	return c.Send(pdf)
+}
@@ -141,8 +141,8 @@ func SendNotificationToOwner(userID, title, text, pageURL string) error {
 		return err
 	}
 
-	if user.Session != "" {
-		utils.SendPersonalMessageToClient(user.Session, "new_notification")
+	if state, err := utils.PresenceState(userID); err == nil && state != utils.PresenceOffline {
+		utils.SendPersonalMessageToUser(userID, "new_notification")
 	} else {
 		parsedUserID, err := uuid.FromString(userID)
 		if err != nil {
@@ -1,16 +1,64 @@
 package controllers
 
 import (
-	"fmt"
+	"encoding/csv"
+	"errors"
 	"hyperpage/initializers"
 	"hyperpage/models"
 	"hyperpage/utils"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// Статусы заказов, которые не входят в выручку
+var orderStatusesWithoutRevenue = []string{utils.OrderStatusCanceled, utils.OrderStatusRefunded}
+
+// filterOrders применяет фильтры из запроса: status (через запятую),
+// from и to (YYYY-MM-DD, включительно) и, для продавца, buyer (ID или имя
+// покупателя).
+func filterOrders(c *fiber.Ctx, query *gorm.DB, byBuyer bool) (*gorm.DB, error) {
+	if status := c.Query("status"); status != "" {
+		statuses := strings.Split(status, ",")
+		for _, s := range statuses {
+			if !utils.IsOrderStatus(s) {
+				return nil, errors.New("Некорректный статус: " + s)
+			}
+		}
+		query = query.Where("orders.status IN ?", statuses)
+	}
+
+	if from := c.Query("from"); from != "" {
+		date, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return nil, errors.New("Некорректная дата from")
+		}
+		query = query.Where("orders.created_at >= ?", date)
+	}
+	if to := c.Query("to"); to != "" {
+		date, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return nil, errors.New("Некорректная дата to")
+		}
+		query = query.Where("orders.created_at < ?", date.AddDate(0, 0, 1))
+	}
+
+	if buyer := c.Query("buyer"); buyer != "" && byBuyer {
+		if buyerID, err := uuid.FromString(buyer); err == nil {
+			query = query.Where("orders.user_id = ?", buyerID)
+		} else {
+			query = query.Where("orders.user_id IN (?)",
+				initializers.DB.Model(&models.User{}).Select("id").Where("name ILIKE ?", "%"+buyer+"%"))
+		}
+	}
+	return query, nil
+}
+
 func GetOrdersForSeller(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.UserResponse)
 	
@@ -22,79 +70,234 @@ func GetOrdersForSeller(c *fiber.Ctx) error {
 		})
 	}
 
+	query, err := filterOrders(c, initializers.DB.Where("seller_id = ?", user.ID), true)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
 	var orders []models.Order
 
 	// Получаем заказы вместе с товарами и адресами доставки для продавца
-	err := utils.Paginate(c, initializers.DB.
+	return utils.Paginate(c, query.
 		Preload("OrderItems").        // Загружаем товары в заказе
 		Preload("DeliveryAddress").   // Загружаем адрес доставки
-		Where("seller_id = ?", user.ID).
 		Order("created_at DESC"), &orders)
-	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Заказы не найдены",
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"status": "success",
-		"data":   orders,
-	})
 }
 
 func GetOrdersForBuyer(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.UserResponse)
 
-	// Проверяем, является ли пользователь покупателем
-	// if user.Seller {
-	// 	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-	// 		"status":  "error",
-	// 		"message": "Вы являетесь продавцом, доступ запрещен",
-	// 	})
-	// }
+	query, err := filterOrders(c, initializers.DB.Where("user_id = ?", user.ID), false)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
 
 	var orders []models.Order
 
 	// Получаем заказы вместе с товарами и адресами доставки для покупателя
-	err := utils.Paginate(c, initializers.DB.
+	return utils.Paginate(c, query.
 		Preload("OrderItems").        // Загружаем товары в заказе
 		Preload("DeliveryAddress").   // Загружаем адрес доставки
-		Where("user_id = ?", user.ID).  // Фильтруем заказы по покупателю
 		Order("created_at DESC"), &orders)
+}
+
+// GetSellerOrderStats возвращает выручку, число заказов и средний чек
+// продавца за весь период и по дням, неделям или месяцам (period).
+// Без фильтра по статусу отмененные и возвращенные заказы не учитываются.
+func GetSellerOrderStats(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	if !user.Seller {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Вы не являетесь продавцом",
+		})
+	}
+
+	period := c.Query("period", "day")
+	if period != "day" && period != "week" && period != "month" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "period должен быть day, week или month",
+		})
+	}
+
+	query, err := filterOrders(c, initializers.DB.Model(&models.Order{}).Where("seller_id = ?", user.ID), true)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Заказы не найдены",
+			"message": err.Error(),
+		})
+	}
+	if c.Query("status") == "" {
+		query = query.Where("orders.status NOT IN ?", orderStatusesWithoutRevenue)
+	}
+
+	type orderStats struct {
+		Period  *time.Time `json:"period,omitempty"`
+		Revenue float64    `json:"revenue"`
+		Orders  int64      `json:"orders"`
+		Average float64    `json:"average"`
+	}
+
+	var summary orderStats
+	if err := query.Session(&gorm.Session{}).
+		Select("COALESCE(SUM(total_amount), 0) AS revenue, COUNT(*) AS orders, COALESCE(AVG(total_amount), 0) AS average").
+		Scan(&summary).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при подсчете статистики",
+		})
+	}
+
+	var periods []orderStats
+	if err := query.Session(&gorm.Session{}).
+		Select("date_trunc(?, orders.created_at) AS period, SUM(total_amount) AS revenue, COUNT(*) AS orders, AVG(total_amount) AS average", period).
+		Group("period").
+		Order("period").
+		Scan(&periods).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при подсчете статистики",
 		})
 	}
 
 	return c.JSON(fiber.Map{
 		"status": "success",
-		"data":   orders,
+		"data": fiber.Map{
+			"summary": summary,
+			"periods": periods,
+		},
 	})
 }
 
-func CreateOrder(c *fiber.Ctx) error {
+// ExportSellerOrders выгружает отфильтрованные заказы продавца с товарами и
+// адресами доставки в CSV или XLSX (format), по строке на товар.
+func ExportSellerOrders(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.UserResponse)
 
-	// Структура для получения данных из запроса
-	type OrderItemRequest struct {
-		ID       string  `json:"id"`
-		Title    string  `json:"title"`
-		Price    float64 `json:"price"`
-		Quantity int     `json:"quantity"`
-		Image    string  `json:"image"`
-		Seller   string  `json:"seller"`  // Продавец передается как строка (имя пользователя)
+	if !user.Seller {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Вы не являетесь продавцом",
+		})
+	}
+
+	format := c.Query("format", "csv")
+	if format != "csv" && format != "xlsx" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "format должен быть csv или xlsx",
+		})
+	}
+
+	query, err := filterOrders(c, initializers.DB.Where("seller_id = ?", user.ID), true)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	var orders []models.Order
+	if err := query.
+		Preload("OrderItems").
+		Preload("DeliveryAddress").
+		Order("created_at DESC").
+		Find(&orders).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при получении заказов",
+		})
+	}
+
+	buyerIDs := make([]uuid.UUID, 0, len(orders))
+	for _, order := range orders {
+		buyerIDs = append(buyerIDs, order.UserID)
+	}
+	var buyers []models.User
+	if len(buyerIDs) > 0 {
+		initializers.DB.Select("id", "name").Where("id IN ?", buyerIDs).Find(&buyers)
+	}
+	buyerNames := map[uuid.UUID]string{}
+	for _, buyer := range buyers {
+		buyerNames[buyer.ID] = buyer.Name
+	}
+
+	rows := [][]string{{
+		"Заказ", "Дата", "Статус", "Покупатель", "Товар", "Артикул", "Количество", "Цена",
+		"Сумма заказа", "Доставка", "Оплата", "Город", "Адрес", "Индекс", "Телефон",
+	}}
+	for _, order := range orders {
+		address := order.DeliveryAddress
+		street := strings.TrimSpace(address.Street + " " + address.Building)
+		if address.Apartment != "" {
+			street += ", кв. " + address.Apartment
+		}
+		for _, item := range order.OrderItems {
+			rows = append(rows, []string{
+				order.ID.String(),
+				order.CreatedAt.Format("2006-01-02 15:04"),
+				order.Status,
+				buyerNames[order.UserID],
+				item.Product,
+				item.SKU,
+				strconv.Itoa(item.Quantity),
+				strconv.FormatFloat(item.Price, 'f', 2, 64),
+				strconv.FormatFloat(order.TotalAmount, 'f', 2, 64),
+				order.ShippingMethod,
+				order.PaymentMethod,
+				address.City,
+				street,
+				address.PostalCode,
+				address.PhoneNumber,
+			})
+		}
+	}
+
+	// Имена, адреса и телефоны вводят покупатели, формулы из них не выполняем
+	for _, row := range rows[1:] {
+		for i, value := range row {
+			row[i] = utils.SpreadsheetCell(value)
+		}
+	}
+
+	filename := "orders-" + time.Now().Format("2006-01-02") + "." + format
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+	if format == "xlsx" {
+		c.Set(fiber.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		return utils.WriteXLSX(c.Response().BodyWriter(), "Orders", rows)
 	}
 
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	body := c.Response().BodyWriter()
+	// BOM, чтобы Excel открыл кириллицу в UTF-8
+	body.Write([]byte("\xEF\xBB\xBF"))
+	writer := csv.NewWriter(body)
+	writer.WriteAll(rows)
+	return writer.Error()
+}
+
+func CreateOrder(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	// Структура для получения данных из запроса. Цены и продавцы берутся
+	// из каталога, клиент передает только вариант товара и количество.
 	type OrderRequest struct {
-		CartItems      []OrderItemRequest `json:"cartItems" validate:"required"`
+		CartItems      []utils.CartItem `json:"cartItems" validate:"required"`
 		CustomerDetails struct {
 			AddressId string `json:"addressId" validate:"required"`
 		} `json:"customerDetails" validate:"required"`
-		ShippingMethod string `json:"shippingMethod" validate:"required"`
-		PaymentMethod  string `json:"paymentMethod" validate:"required"`
+		ShippingMethod  string            `json:"shippingMethod"`
+		ShippingMethods map[string]uint64 `json:"shippingMethods"` // ID продавца -> способ доставки
+		PaymentMethod   string            `json:"paymentMethod" validate:"required"`
 	}
 
 	var orderReq OrderRequest
@@ -107,80 +310,169 @@ func CreateOrder(c *fiber.Ctx) error {
 
 	// Получаем данные адреса доставки по ID
 	var deliveryAddress models.DeliveryAddress
-	if err := initializers.DB.Where("id = ?", orderReq.CustomerDetails.AddressId).First(&deliveryAddress).Error; err != nil {
+	if err := initializers.DB.Where("id = ? AND user_id = ?", orderReq.CustomerDetails.AddressId, user.ID).First(&deliveryAddress).Error; err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Адрес доставки не найден",
 		})
 	}
 
-	fmt.Println(orderReq)
+	shippingMethods, err := parseShippingMethods(orderReq.ShippingMethods)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	config, _ := initializers.LoadConfig(".")
 
-	// Перебираем товары в заказе
-	for _, item := range orderReq.CartItems {
-		// Получаем ID продавца по его имени (seller)
-		var seller models.User
-		err := initializers.DB.Where("name = ?", item.Seller).First(&seller).Error
+	// Все заказы корзины создаются и оплачиваются одной транзакцией
+	var orders []models.Order
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		orders, err = utils.PlaceOrders(tx, utils.OrderPlacement{
+			BuyerID:           user.ID,
+			Items:             orderReq.CartItems,
+			Address:           deliveryAddress,
+			ShippingMethod:    orderReq.ShippingMethod,
+			ShippingMethods:   shippingMethods,
+			PaymentMethod:     orderReq.PaymentMethod,
+			CommissionPercent: config.MarketplaceCommissionPercent,
+		})
+		return err
+	})
+	if err != nil {
+		return orderPlacementError(c, err)
+	}
+
+	notifyOrdersPlaced(user.ID, orders)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Заказы успешно созданы",
+		"data":    orders,
+	})
+}
+
+// parseShippingMethods разбирает выбранные покупателем способы доставки
+func parseShippingMethods(methods map[string]uint64) (map[uuid.UUID]uint64, error) {
+	result := make(map[uuid.UUID]uint64, len(methods))
+	for sellerID, methodID := range methods {
+		id, err := uuid.FromString(sellerID)
 		if err != nil {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Продавец не найден: " + item.Seller,
-			})
+			return nil, errors.New("Некорректный продавец в способах доставки: " + sellerID)
 		}
+		result[id] = methodID
+	}
+	return result, nil
+}
 
-		// Создаем новый заказ для текущего продавца
-		order := models.Order{
-			ID:          uuid.NewV4(),
-			UserID:      user.ID,
-			SellerID:    seller.ID, // Используем ID продавца из базы данных
-			TotalAmount: item.Price * float64(item.Quantity),
-			Status:      "pending",
-			// Добавляем адрес доставки
-			DeliveryAddress: deliveryAddress, 
-		}
+// Ответы на ошибки utils.ValidateDeliveryAddress
+var deliveryAddressErrors = map[error]string{
+	utils.ErrAddressIncomplete:  "Укажите город, улицу и дом",
+	utils.ErrAddressCountry:     "Некорректный код страны",
+	utils.ErrAddressCity:        "Город не найден в указанной стране",
+	utils.ErrAddressPostalCode:  "Неверный формат почтового индекса для страны",
+	utils.ErrAddressPhoneNumber: "Неверный формат номера телефона",
+}
 
-		// Сохраняем заказ в базе данных
-		if err := initializers.DB.Create(&order).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Не удалось создать заказ",
-			})
+func deliveryAddressError(err error) (string, bool) {
+	for addressErr, message := range deliveryAddressErrors {
+		if errors.Is(err, addressErr) {
+			return message, true
 		}
+	}
+	return "", false
+}
 
-		// Добавляем товары к заказу
-		orderItem := models.OrderItem{
-			ID:       uuid.NewV4(),
-			OrderID:  order.ID,
-			Product:  item.Title,
-			Price:    item.Price,
-			Quantity: item.Quantity,
-		}
+// orderPlacementError отвечает клиенту на ошибку utils.PlaceOrders
+func orderPlacementError(c *fiber.Ctx, err error) error {
+	if message, ok := deliveryAddressError(err); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Адрес доставки: " + message,
+		})
+	}
+	if errors.Is(err, utils.ErrShippingUnavailable) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Продавец не доставляет по этому адресу",
+		})
+	}
+	if errors.Is(err, utils.ErrShippingMethodNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Выбранный способ доставки недоступен для этого адреса",
+		})
+	}
+	if errors.Is(err, utils.ErrInvalidCartItem) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Корзина пуста или содержит некорректные товары",
+		})
+	}
+	if errors.Is(err, utils.ErrProductUnavailable) || errors.Is(err, utils.ErrProductOutOfStock) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	if errors.Is(err, utils.ErrWalletCurrencyMismatch) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "С баланса можно оплатить только товары в рублях",
+		})
+	}
+	if errors.Is(err, utils.ErrInsufficientBalance) {
+		return c.Status(fiber.StatusPaymentRequired).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Недостаточно средств на балансе",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Не удалось создать заказ",
+	})
+}
 
-		// Сохраняем товар в базе данных
-		if err := initializers.DB.Create(&orderItem).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Ошибка при добавлении товаров в заказ",
-			})
+// notifyOrdersPlaced уведомляет продавцов о новых заказах
+func notifyOrdersPlaced(buyerID uuid.UUID, orders []models.Order) {
+	paidFromBalance := false
+	for _, order := range orders {
+		if order.EscrowStatus == utils.EscrowStatusHeld {
+			paidFromBalance = true
 		}
 
 		// Отправляем уведомление продавцу
 		SendNotificationToOwner(
-			seller.ID.String(), 
-			"У вас новая продажа", 
-			"На сумму " + strconv.FormatFloat(item.Price * float64(item.Quantity), 'f', 2, 64) + " руб.", 
+			order.SellerID.String(),
+			"У вас новая продажа",
+			"На сумму " + strconv.FormatFloat(order.TotalAmount, 'f', 2, 64) + " руб.",
 			"https://www.myru.online/profile/posts?tabs=sales",
 		)
 	}
-
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"status":  "success",
-		"message": "Заказы успешно созданы",
-	})
+	if paidFromBalance {
+		notifyBalanceChanged(buyerID)
+	}
 }
 
 
 
+// deliveryAddressValidationError отвечает клиенту на ошибку проверки адреса
+func deliveryAddressValidationError(c *fiber.Ctx, err error) error {
+	if message, ok := deliveryAddressError(err); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": message,
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Ошибка при проверке адреса",
+	})
+}
+
 func AddDeliveryAddress(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.UserResponse)
 
@@ -198,6 +490,10 @@ func AddDeliveryAddress(c *fiber.Ctx) error {
 	// Генерируем UUID для нового адреса
 	newAddress.ID = uuid.NewV4()
 
+	if err := utils.ValidateDeliveryAddress(initializers.DB, &newAddress); err != nil {
+		return deliveryAddressValidationError(c, err)
+	}
+
 	// Сохраняем адрес в базу данных
 	if err := initializers.DB.Create(&newAddress).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -227,13 +523,20 @@ func UpdateDeliveryAddress(c *fiber.Ctx) error {
 		})
 	}
 
-	// Обновляем адрес с новыми данными
+	// Обновляем адрес с новыми данными, ID и владельца из тела запроса не принимаем
+	id := address.ID
 	if err := c.BodyParser(&address); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Неверные данные",
 		})
 	}
+	address.ID = id
+	address.UserID = user.ID
+
+	if err := utils.ValidateDeliveryAddress(initializers.DB, &address); err != nil {
+		return deliveryAddressValidationError(c, err)
+	}
 
 	// Сохраняем обновления
 	if err := initializers.DB.Save(&address).Error; err != nil {
@@ -298,6 +601,42 @@ func GetDeliveryAddresses(c *fiber.Ctx) error {
 	})
 }
 
+var orderStatusTitles = map[string]string{
+	utils.OrderStatusCreated:         "создан",
+	utils.OrderStatusPaid:            "оплачен",
+	utils.OrderStatusAccepted:        "принят продавцом",
+	utils.OrderStatusShipped:         "отправлен",
+	utils.OrderStatusDelivered:       "доставлен",
+	utils.OrderStatusCompleted:       "завершен",
+	utils.OrderStatusCanceled:        "отменен",
+	utils.OrderStatusRefundRequested: "запрошен возврат",
+	utils.OrderStatusRefunded:        "возвращен",
+	utils.OrderStatusDisputed:        "открыт спор",
+}
+
+// notifyOrderStatus уведомляет покупателя и продавца об изменении статуса,
+// кроме того, кто его изменил.
+func notifyOrderStatus(order models.Order, actorID uuid.UUID) {
+	text := "Заказ на сумму " + strconv.FormatFloat(order.TotalAmount, 'f', 2, 64) + " руб.: " + orderStatusTitles[order.Status]
+
+	if order.UserID != actorID {
+		SendNotificationToOwner(
+			order.UserID.String(),
+			"Статус заказа изменен",
+			text,
+			"https://www.myru.online/profile/posts?tabs=purchases",
+		)
+	}
+	if order.SellerID != actorID && order.SellerID != order.UserID {
+		SendNotificationToOwner(
+			order.SellerID.String(),
+			"Статус заказа изменен",
+			text,
+			"https://www.myru.online/profile/posts?tabs=sales",
+		)
+	}
+}
+
 func UpdateOrderStatus(c *fiber.Ctx) error {
 	// Получаем ID заказа из параметров маршрута
 	orderID := c.Params("id")
@@ -307,7 +646,8 @@ func UpdateOrderStatus(c *fiber.Ctx) error {
 
 	// Структура для данных запроса
 	type UpdateStatusRequest struct {
-		Status string `json:"status" validate:"required"` // Статус, который будет обновлен
+		Status  string `json:"status" validate:"required"` // Статус, который будет обновлен
+		Comment string `json:"comment"`                    // Комментарий к изменению
 	}
 
 	var req UpdateStatusRequest
@@ -321,48 +661,61 @@ func UpdateOrderStatus(c *fiber.Ctx) error {
 	}
 
 	// Проверяем, является ли переданный статус корректным
-	validStatuses := []string{"pending", "completed", "canceled"}
-	isValidStatus := false
-	for _, status := range validStatuses {
-		if req.Status == status {
-			isValidStatus = true
-			break
-		}
-	}
-
-	if !isValidStatus {
+	if !utils.IsOrderStatus(req.Status) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Некорректный статус",
 		})
 	}
 
-	// Ищем заказ по ID
 	var order models.Order
-	if err := initializers.DB.Where("id = ?", orderID).First(&order).Error; err != nil {
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		// Ищем заказ по ID и блокируем его до конца транзакции
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", orderID).First(&order).Error; err != nil {
+			return err
+		}
+
+		roles := utils.OrderActorRoles(order, user.ID, user.Role)
+		if len(roles) == 0 {
+			return utils.ErrOrderTransitionForbidden
+		}
+
+		_, err := utils.TransitionOrder(tx, &order, req.Status, user.ID, roles, req.Comment)
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Заказ не найден",
 		})
 	}
-
-	// Проверяем, является ли текущий пользователь продавцом этого заказа
-	if order.SellerID != user.ID {
+	if errors.Is(err, utils.ErrOrderTransitionForbidden) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Вы не имеете прав для изменения статуса этого заказа",
 		})
 	}
-
-	// Обновляем статус заказа
-	order.Status = req.Status
-	if err := initializers.DB.Save(&order).Error; err != nil {
+	if errors.Is(err, utils.ErrOrderTransitionNotAllowed) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Заказ нельзя перевести из статуса " + order.Status + " в " + req.Status,
+		})
+	}
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Ошибка при обновлении статуса",
 		})
 	}
 
+	notifyOrderStatus(order, user.ID)
+	switch order.EscrowStatus {
+	case utils.EscrowStatusReleased:
+		notifyBalanceChanged(order.SellerID)
+	case utils.EscrowStatusReturned:
+		notifyBalanceChanged(order.UserID)
+	}
+
 	// Возвращаем успешный ответ
 	return c.JSON(fiber.Map{
 		"status":  "success",
@@ -370,3 +723,42 @@ func UpdateOrderStatus(c *fiber.Ctx) error {
 		"data":    order,
 	})
 }
+
+// GetOrderHistory возвращает историю статусов заказа и статусы, в которые
+// текущий пользователь может его перевести.
+func GetOrderHistory(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var order models.Order
+	if err := initializers.DB.Where("id = ?", c.Params("id")).First(&order).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Заказ не найден",
+		})
+	}
+
+	roles := utils.OrderActorRoles(order, user.ID, user.Role)
+	if len(roles) == 0 {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Доступ запрещен",
+		})
+	}
+
+	var history []models.OrderHistory
+	if err := initializers.DB.Where("order_id = ?", order.ID).Order("created_at").Find(&history).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при получении истории заказа",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"status":  order.Status,
+			"history": history,
+			"next":    utils.NextOrderStatuses(order, roles),
+		},
+	})
+}
@@ -7,17 +7,17 @@ import (
 	"hyperpage/initializers"
 	"hyperpage/models"
 	"hyperpage/utils"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
-	"github.com/nikita-vanyasin/tinkoff"
+	uuid "github.com/satori/go.uuid"
 	"gorm.io/gorm"
 )
 
@@ -31,202 +31,146 @@ func handlePanic(c *fiber.Ctx) {
 	}
 }
 
+// Pending handles payment notifications of the provider named in the path,
+// Tinkoff when it is omitted. The signature is verified by the provider and
+// each transition is applied once, providers keep resending a notification
+// until it is acknowledged.
 func Pending(c *fiber.Ctx) error {
 
 	defer handlePanic(c)
 
-	var requestBody map[string]interface{}
+	config, _ := initializers.LoadConfig(".")
+	provider, err := utils.PaymentProviderByName(&config, c.Params("provider", utils.PaymentProviderTinkoff))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Unknown payment provider",
+		})
+	}
 
-	if err := c.BodyParser(&requestBody); err != nil {
+	notification, err := provider.ParseNotification(c.Body())
+	if err != nil {
+		log.Println("Rejected payment notification:", err)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": fmt.Sprintf("Failed to parse request body: %v", err),
+			"message": "Invalid payment notification",
 		})
 	}
 
-	var response = requestBody
-
-	// Access the value of the PaymentId field
-	// paymentIDFloat := response["PaymentId"].(float64)
-
-	// Convert the PaymentId to an integer
-	var paymentID int64
-
-	switch v := requestBody["PaymentId"].(type) {
-	case float64:
-		paymentID = int64(v)
-	case string:
-		// Преобразование строки в int64
-		id, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Invalid PaymentId format",
-			})
-		}
-		paymentID = id
-	default:
+	var payment models.Payments
+	var balanceChanged bool
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var applyErr error
+		payment, balanceChanged, applyErr = utils.ApplyPaymentStatus(tx, provider.Name(), notification.PaymentID, notification.Status, notification.Amount)
+		return applyErr
+	})
+	if errors.Is(err, utils.ErrPaymentNotificationProcessed) {
+		return c.SendString(provider.NotificationResponse())
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Payment not found",
+		})
+	}
+	if errors.Is(err, utils.ErrPaymentAmountMismatch) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "PaymentId is of an unexpected type",
+			"message": "Payment amount does not match",
+		})
+	}
+	if errors.Is(err, utils.ErrWalletCurrencyMismatch) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Payment currency does not match the wallet",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update balance",
 		})
 	}
-	
-
-	if response["Status"] == "CONFIRMED" { // Use == for comparison
-		var payment models.Payments
-		if err := initializers.DB.Where("payment_id = ?  AND status = ?", fmt.Sprintf("%d", paymentID), "NEW").First(&payment).Error; err != nil {
-			// Handle error if the record is not found or other issues
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Payment not found or status is not 'NEW'",
-			})
-		}
-		// Update the status to "applied"
-		payment.Status = "applied"
-		if err := initializers.DB.Save(&payment).Error; err != nil {
-			// Handle error if the update fails
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Failed to update payment status",
-			})
-		}
-
-		decimalAmount := float64(payment.Amount) / 100.0
-
-		// Update the amount field in the balance table
-		updateBalanceErr := initializers.DB.Model(&models.Billing{}).
-			Where("user_id = ?", payment.UserID).
-			Updates(map[string]interface{}{
-				"amount": gorm.Expr("amount + ?", decimalAmount),
-			}).Error
-		if updateBalanceErr != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Failed to update balance",
-			})
-		}
-
-		var user models.User
-		if err := initializers.DB.Model(&models.User{}).Where("id = ?", payment.UserID).First(&user).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Failed to find user",
-			})
-		}
-
-		userSession := user.Session
 
-		transaction := models.Transaction{
-			UserID:      payment.UserID,
-			Total:       `0`,
-			Amount:      decimalAmount,
-			Description: `Пополнение баланса c карты банка`,
-			Module:      `Payment`,
-			Type:        `profit`,
-			Status:      `CLOSED_1`,
-		}
+	if balanceChanged {
+		notifyBalanceChanged(payment.UserID)
+	}
 
-		createTransactionErr := initializers.DB.Create(&transaction).Error
-		if createTransactionErr != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status":  "error",
-				"message": "Failed to create transaction",
-			})
+	if balanceChanged && notification.Status == utils.AcquirerStatusConfirmed {
+		if err := addFiatConversionBlock(payment); err != nil {
+			log.Println("Failed to add fiat conversion block:", err)
 		}
-		
+	}
 
-		
+	return c.SendString(provider.NotificationResponse())
+}
 
-		var err = utils.SendPersonalMessageToClient(userSession, "BalanceAdded")
-		if err != nil {
-			// handle error
-			_ = err
-		}
+// notifyBalanceChanged tells the user's websocket client to reload the balance.
+func notifyBalanceChanged(userID uuid.UUID) {
+	if err := utils.SendPersonalMessageToUser(userID.String(), "BalanceAdded"); err != nil {
+		log.Println("Failed to notify user about balance:", err)
+	}
+}
 
-		err = godotenv.Load("app.env")
-		if err != nil {
-			log.Fatalf("Error loading .env file")
-		}
+// addFiatConversionBlock records a confirmed top-up in the blockchain service.
+func addFiatConversionBlock(payment models.Payments) error {
+	decimalAmount := float64(payment.Amount) / 100.0
 
-		// Формирование URL с учетом userID
-		blockchainAPI := os.Getenv("BLOCKCHAIN_API")
-		api := fmt.Sprintf("%s/api/add_block", blockchainAPI)
-		// payment.UserID
-
-		data := url.Values{}
-		data.Set("transaction_type", "fiat_conversion")
-		data.Set("from_currency", "₽")
-		data.Set("to_currency", "RUDT")
-		data.Set("amount", fmt.Sprintf("%.2f", decimalAmount)) // Преобразуем float64 в строку
-		data.Set("conversion_rate", "1")
-		data.Set("data", "Конвертация Рубля в криптовалюту RUDT")
-		data.Set("user_id", payment.UserID.String()) // Преобразуем UUID в строку
-	
-
-		// Получение токена для авторизации
-		blockchainToken := os.Getenv("BLOCKCHAIN_TOKEN")
-
-		if blockchainToken == "" {
-			return errors.New("blockchain token is missing in environment variables")
-		}
-		
+	err := godotenv.Load("app.env")
+	if err != nil {
+		return errors.New("error loading .env file")
+	}
 
-		// Формирование запроса
-		req, err := http.NewRequest("POST", api, bytes.NewBufferString(data.Encode()))
-		if err != nil {
-			return err
-		}
+	// Формирование URL с учетом userID
+	blockchainAPI := os.Getenv("BLOCKCHAIN_API")
+	api := fmt.Sprintf("%s/api/add_block", blockchainAPI)
 
-		req.Header.Set("Authorization", "Bearer "+blockchainToken)
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	data := url.Values{}
+	data.Set("transaction_type", "fiat_conversion")
+	data.Set("from_currency", "₽")
+	data.Set("to_currency", "RUDT")
+	data.Set("amount", fmt.Sprintf("%.2f", decimalAmount)) // Преобразуем float64 в строку
+	data.Set("conversion_rate", "1")
+	data.Set("data", "Конвертация Рубля в криптовалюту RUDT")
+	data.Set("user_id", payment.UserID.String()) // Преобразуем UUID в строку
 
-		// Выполнение запроса
-		client := &http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Do(req)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
+	// Получение токена для авторизации
+	blockchainToken := os.Getenv("BLOCKCHAIN_TOKEN")
 
-		// Обработка ответа
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("ошибка при отправке запроса, статус код: %d", resp.StatusCode)
-		}
+	if blockchainToken == "" {
+		return errors.New("blockchain token is missing in environment variables")
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-	
-		fmt.Println("Response body:", string(body))
-	
+	// Формирование запроса
+	req, err := http.NewRequest("POST", api, bytes.NewBufferString(data.Encode()))
+	if err != nil {
+		return err
+	}
 
-		// var balanceResp BalanceResponse
-		// if err := json.Unmarshal(body, &balanceResp); err != nil {
-		// 	return err
-		// }
+	req.Header.Set("Authorization", "Bearer "+blockchainToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	// Выполнение запроса
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-		}
+	// Обработка ответа
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ошибка при отправке запроса, статус код: %d", resp.StatusCode)
+	}
 
-	// return the city names as a JSON response
-	return c.JSON(fiber.Map{
-		"status": "success",
-		"data":   "initRes",
-	})
+	return nil
 }
 
+// CreateInvoice creates a top-up invoice with the provider chosen by the
+// user's country. Invoices are in the wallet currency, a currency header
+// naming another one is refused.
 func CreateInvoice(c *fiber.Ctx) error {
 
-	// var terminalKey = "1718186727633DEMO"
-	// var terminalPassword = "lkSI$mq4FqxMBrwf"
-
-	var terminalKey = "1669511559870"
-	var terminalPassword = "vfo6kjw9gntp2sxz"
-
-	client := tinkoff.NewClient(terminalKey, terminalPassword)
-
 	orderID := strconv.FormatInt(time.Now().UnixNano(), 10)
 
 	user := c.Locals("user")
@@ -240,62 +184,172 @@ func CreateInvoice(c *fiber.Ctx) error {
 
 	userResp := user.(models.UserResponse)
 
-	initReq := &tinkoff.InitRequest{
-		Amount:      amount,
+	currency := strings.ToUpper(c.Get("currency", "RUB"))
+	country := c.Get("country")
+	if country == "" {
+		country = utils.CountryByLanguage(c.Query("language"))
+	}
+
+	// The wallet is credited with the amount as is, other currencies are not converted
+	if err := utils.CheckWalletCurrency(initializers.DB, userResp.ID, currency); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Top-ups are not accepted in " + currency,
+		})
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	provider, err := utils.SelectPaymentProvider(&config, country)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No payment provider for " + country,
+		})
+	}
+
+	invoice, err := provider.CreateInvoice(utils.InvoiceRequest{
 		OrderID:     orderID,
+		Amount:      amount,
+		Currency:    currency,
 		CustomerKey: userResp.Name,
 		Description: "Пополнение баланса в профиле " + userResp.Name + " на платформе моя Россия онлайн",
-		// PayType:         tinkoff.PayTypeOneStep,
-		RedirectDueDate: tinkoff.Time(time.Now().Add(4 * time.Hour * 24)), // ссылка истечет через 4 дня
-		Receipt: &tinkoff.Receipt{
-			Email: userResp.Email,
-			Items: []*tinkoff.ReceiptItem{
-				{
-					Price:         amount,
-					Quantity:      "1",
-					Amount:        amount,
-					Name:          "Баланс на сумму " + strconv.FormatUint(amount, 10),
-					Tax:           tinkoff.VATNone,
-					PaymentMethod: tinkoff.PaymentMethodFullPayment,
-					PaymentObject: tinkoff.PaymentObjectIntellectualActivity,
-				},
-			},
-			Taxation: tinkoff.TaxationUSNIncome,
-			Payments: &tinkoff.ReceiptPayments{
-				Electronic: amount,
-			},
-		},
-
-		//custom fields for tinkoff
-		Data: map[string]string{},
+		Email:       userResp.Email,
+	})
+	if err != nil {
+		log.Println("Could not create invoice:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Payment provider is unavailable",
+		})
 	}
 
-	initRes, err := client.Init(initReq)
-	if err != nil {
-		// Handle the error here, if needed
-		fmt.Println("Error:", err)
-	} else {
-
-		payments := models.Payments{
-			UserID:    userResp.ID,
-			Amount:    float64(amount),
-			Status:    "NEW",
-			PaymentId: initRes.PaymentID, // Store as a string directly
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
+	payments := models.Payments{
+		UserID:    userResp.ID,
+		Amount:    float64(amount),
+		Currency:  currency,
+		Provider:  provider.Name(),
+		Status:    utils.PaymentStatusNew,
+		PaymentId: invoice.PaymentID, // Store as a string directly
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
 
-		// Create the database record
-		if err := initializers.DB.Create(&payments).Error; err != nil {
-			log.Println("Could not create payment:", err)
-		} else {
-			fmt.Println("Payment record created successfully")
-		}
+	// Create the database record
+	if err := initializers.DB.Create(&payments).Error; err != nil {
+		log.Println("Could not create payment:", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create payment",
+		})
 	}
 
 	// return the city names as a JSON response
 	return c.JSON(fiber.Map{
 		"status": "success",
-		"data":   initRes,
+		"data":   invoice.Response,
+	})
+}
+
+// RefundPayment returns a confirmed top-up to the card, fully or by the
+// given amount in kopecks, and takes it back from the user's wallet. The
+// provider query parameter defaults to Tinkoff.
+func RefundPayment(c *fiber.Ctx) error {
+	paymentID := c.Params("paymentId")
+
+	var payload struct {
+		Amount uint64 `json:"amount"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid request body",
+			})
+		}
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	provider, err := utils.PaymentProviderByName(&config, c.Query("provider", utils.PaymentProviderTinkoff))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Payment not found",
+		})
+	}
+
+	payment, err := utils.RefundTopUp(provider, paymentID, payload.Amount)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Payment not found",
+		})
+	case errors.Is(err, utils.ErrPaymentNotRefundable):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Payment can not be refunded in status " + payment.Status,
+		})
+	case errors.Is(err, utils.ErrRefundExceedsPayment):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Refund amount exceeds the payment",
+		})
+	case errors.Is(err, utils.ErrRefundPending):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "A refund of this payment is in progress",
+		})
+	case errors.Is(err, utils.ErrInsufficientBalance):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Insufficient balance",
+		})
+	case errors.Is(err, utils.ErrAcquirerRefusedRefund):
+		log.Println("Refund failed:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Acquirer refused the refund",
+		})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update balance",
+		})
+	}
+
+	notifyBalanceChanged(payment.UserID)
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   payment,
 	})
 }
+
+// ReconcilePayments runs the payment reconciliation job right away.
+func ReconcilePayments(c *fiber.Ctx) error {
+	config, _ := initializers.LoadConfig(".")
+	discrepancies, err := utils.ReconcilePayments(&config, time.Hour)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to reconcile payments",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   discrepancies,
+	})
+}
+
+// GetPaymentDiscrepancies lists the differences found by the reconciliation job.
+func GetPaymentDiscrepancies(c *fiber.Ctx) error {
+	var discrepancies []models.PaymentDiscrepancy
+
+	query := initializers.DB.Order("created_at DESC")
+	if resolved := c.Query("resolved"); resolved != "" {
+		query = query.Where("resolved = ?", resolved == "true")
+	}
+
+	return utils.Paginate(c, query, &discrepancies)
+}
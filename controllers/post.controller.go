@@ -444,6 +444,19 @@ func AddComment(c *fiber.Ctx) error {
 		})
 	}
 
+	// Автор поста мог заблокировать комментатора
+	var post models.Post
+	if err := initializers.DB.Select("id", "user_id").First(&post, "id = ?", uuid.FromStringOrNil(postID)).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post not found",
+		})
+	}
+	if utils.IsBlockedBy(initializers.DB, post.UserID, userResponse.ID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You cannot comment on this post",
+		})
+	}
+
 	// Создание нового комментария
 	comment := models.CommentPost{
 		ID:        uuid.NewV4(),
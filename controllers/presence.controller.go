@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+)
+
+const maxPresenceIDs = 100
+
+// presenceAudience returns the personal channels of the users who see the
+// presence of the user: followers and partners of direct rooms, without
+// users blocked either way.
+func presenceAudience(userID uuid.UUID) ([]string, error) {
+	var ids []uuid.UUID
+	err := initializers.DB.Raw(`
+		SELECT following_id FROM user_relation WHERE user_id = ?
+		UNION
+		SELECT other.user_id FROM chat_room_members AS own
+		JOIN chat_rooms ON chat_rooms.id = own.room_id AND chat_rooms.is_group = false
+		JOIN chat_room_members AS other ON other.room_id = own.room_id AND other.user_id <> own.user_id
+		WHERE own.user_id = ?`, userID, userID).Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var blocked []uuid.UUID
+	initializers.DB.Model(&models.UserBlock{}).Where("blocker_id = ?", userID).Pluck("blocked_id", &blocked)
+	var blockers []uuid.UUID
+	initializers.DB.Model(&models.UserBlock{}).Where("blocked_id = ?", userID).Pluck("blocker_id", &blockers)
+	isBlocked := make(map[uuid.UUID]bool, len(blocked)+len(blockers))
+	for _, id := range append(blocked, blockers...) {
+		isBlocked[id] = true
+	}
+
+	channels := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != userID && !isBlocked[id] {
+			channels = append(channels, fmt.Sprintf("personal:%s", id))
+		}
+	}
+	return channels, nil
+}
+
+// presenceBody is the presence of the user as others see it. The last seen
+// time is only given for users who are not online and do not hide it.
+func presenceBody(user models.User, state string) map[string]interface{} {
+	var lastSeen interface{}
+	if state == utils.PresenceOffline && !user.HideLastSeen && !user.LastOnline.IsZero() {
+		lastSeen = user.LastOnline
+	}
+	return map[string]interface{}{
+		"user_id":   user.ID,
+		"state":     state,
+		"last_seen": lastSeen,
+	}
+}
+
+// presenceEventKey is the idempotency key of a presence change made now.
+func presenceEventKey(user models.User, state string) string {
+	return fmt.Sprintf("presence_%s_%s_%d", user.ID, state, time.Now().UTC().UnixNano())
+}
+
+// PublishPresence tells the followers and the chat partners of the user
+// about the state of the user.
+func PublishPresence(user models.User, state string, idempotencyKey string) {
+	channels, err := presenceAudience(user.ID)
+	if err != nil {
+		log.Printf("Failed to get presence audience: %s", err)
+		return
+	}
+	if len(channels) == 0 {
+		return
+	}
+
+	var payload CentrifugoBroadcastPayload
+	payload.Channels = channels
+	payload.Data.Type = "presence"
+	payload.Data.Body = presenceBody(user, state)
+	payload.IdempotencyKey = idempotencyKey
+	if _, err := CentrifugoBroadcastRoom("presence_"+user.ID.String(), payload); err != nil {
+		log.Printf("Failed to broadcast presence: %s", err)
+	}
+}
+
+// PresenceConnected adds a signed in session of the user. Followers and chat
+// partners are told when the user comes online. Sessions of a user are kept
+// by the hub, personal messages reach all of them by user ID.
+func PresenceConnected(userID string, sessionID string) {
+	before, after, err := utils.PresenceConnect(userID, sessionID)
+	if err != nil {
+		log.Printf("Failed to track presence of user %s: %s", userID, err)
+		return
+	}
+
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return
+	}
+	initializers.DB.Model(&user).Update("online", true)
+	if before == after {
+		return
+	}
+	PublishPresence(user, after, presenceEventKey(user, after))
+}
+
+// PresenceDisconnected removes a session of the user. The user goes offline
+// with the last session.
+func PresenceDisconnected(userID string, sessionID string) {
+	before, after, err := utils.PresenceDisconnect(userID, sessionID)
+	if err != nil {
+		log.Printf("Failed to track presence of user %s: %s", userID, err)
+		return
+	}
+
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return
+	}
+	if after == utils.PresenceOffline {
+		now := time.Now()
+		initializers.DB.Model(&user).Updates(map[string]interface{}{"online": false, "last_online": now})
+		user.LastOnline = now
+		// A session that connected meanwhile wrote online before us
+		if state, err := utils.PresenceState(userID); err == nil && state != utils.PresenceOffline {
+			initializers.DB.Model(&user).Update("online", true)
+		}
+	}
+	if before != after {
+		PublishPresence(user, after, presenceEventKey(user, after))
+	}
+}
+
+// PresenceAway marks a session of the user idle or active again, as the
+// client reports it.
+func PresenceAway(userID string, sessionID string, away bool) {
+	before, after, err := utils.PresenceSetAway(userID, sessionID, away)
+	if err != nil {
+		log.Printf("Failed to track presence of user %s: %s", userID, err)
+		return
+	}
+	if before == after {
+		return
+	}
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", userID).Error; err != nil {
+		return
+	}
+	PublishPresence(user, after, presenceEventKey(user, after))
+}
+
+// SweepStalePresence repairs the online flag of users where it disagrees
+// with Redis. Users whose sessions stopped sending heartbeats without
+// disconnecting, e.g. after a node crashed, go offline as last seen at their
+// last heartbeat; users with live sessions marked offline by racing
+// disconnects come back online. One node sweeps at a time.
+func SweepStalePresence() (int, error) {
+	swept := 0
+	_, err := utils.WithRedisLock("presence_sweep", time.Minute, func() error {
+		var users []models.User
+		if err := initializers.DB.Select("id", "hide_last_seen", "last_online").Where("online = ?", true).Find(&users).Error; err != nil {
+			return err
+		}
+		for _, user := range users {
+			state, err := utils.PresenceState(user.ID.String())
+			if err != nil {
+				return err
+			}
+			if state != utils.PresenceOffline {
+				continue
+			}
+			lastSeen, ok := utils.PresenceLastHeartbeat(user.ID.String())
+			if !ok {
+				lastSeen = time.Now()
+			}
+			result := initializers.DB.Model(&models.User{}).Where("id = ? AND online = ?", user.ID, true).
+				Updates(map[string]interface{}{"online": false, "last_online": lastSeen})
+			if result.Error != nil || result.RowsAffected == 0 {
+				continue
+			}
+			user.LastOnline = lastSeen
+			// The same stale session always gives the same event
+			PublishPresence(user, utils.PresenceOffline, fmt.Sprintf("presence_%s_offline_%d", user.ID, lastSeen.Unix()))
+			swept++
+		}
+
+		userIDs, err := utils.PresenceUsers()
+		if err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			state, err := utils.PresenceState(userID)
+			if err != nil {
+				return err
+			}
+			if state == utils.PresenceOffline {
+				continue
+			}
+			result := initializers.DB.Model(&models.User{}).Where("id = ? AND online = ?", userID, false).Update("online", true)
+			if result.Error == nil && result.RowsAffected > 0 {
+				swept++
+			}
+		}
+		return nil
+	})
+	return swept, err
+}
+
+// GetPresence returns the presence of the users given by ids. Users who
+// blocked the requestor or were blocked by them look offline.
+func GetPresence(c *fiber.Ctx) error {
+	viewer := c.Locals("user").(models.UserResponse)
+
+	var ids []uuid.UUID
+	for _, value := range strings.Split(c.Query("ids"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		id, err := uuid.FromString(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid user ID"})
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || len(ids) > maxPresenceIDs {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": fmt.Sprintf("ids must list 1 to %d users", maxPresenceIDs)})
+	}
+
+	var users []models.User
+	if err := initializers.DB.Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to retrieve users"})
+	}
+
+	data := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		if user.ID != viewer.ID && utils.IsBlockedEitherWay(initializers.DB, user.ID, viewer.ID) {
+			data = append(data, map[string]interface{}{"user_id": user.ID, "state": utils.PresenceOffline, "last_seen": nil})
+			continue
+		}
+		state, err := utils.PresenceState(user.ID.String())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to retrieve presence"})
+		}
+		data = append(data, presenceBody(user, state))
+	}
+	return c.JSON(fiber.Map{"status": "success", "data": data})
+}
+
+// SetLastSeenPrivacy hides or shows the last seen time of the requestor.
+func SetLastSeenPrivacy(c *fiber.Ctx) error {
+	viewer := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		HideLastSeen *bool `json:"hideLastSeen"`
+	}
+	if err := c.BodyParser(&payload); err != nil || payload.HideLastSeen == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "hideLastSeen is required"})
+	}
+
+	var user models.User
+	if err := initializers.DB.First(&user, "id = ?", viewer.ID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "User not found"})
+	}
+	if err := initializers.DB.Model(&user).Update("hide_last_seen", *payload.HideLastSeen).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update user"})
+	}
+	user.HideLastSeen = *payload.HideLastSeen
+
+	if state, err := utils.PresenceState(user.ID.String()); err == nil && state == utils.PresenceOffline {
+		PublishPresence(user, state, presenceEventKey(user, state))
+	}
+	return c.JSON(fiber.Map{"status": "success", "data": fiber.Map{"hideLastSeen": user.HideLastSeen}})
+}
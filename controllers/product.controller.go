@@ -0,0 +1,446 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgtype"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+type productFile struct {
+	Path string `json:"path"`
+}
+
+type productVariantRequest struct {
+	SKU    string `json:"sku"`
+	Name   string `json:"name"`
+	Price  *int64 `json:"price"` // копейки
+	Stock  *int   `json:"stock"`
+	Weight *int   `json:"weight"` // граммы
+	Active *bool  `json:"active"`
+}
+
+func (r productVariantRequest) validate() error {
+	if r.Price != nil && *r.Price <= 0 {
+		return errors.New("Цена должна быть больше нуля")
+	}
+	if r.Stock != nil && *r.Stock < 0 {
+		return errors.New("Остаток не может быть отрицательным")
+	}
+	if r.Weight != nil && *r.Weight < 0 {
+		return errors.New("Вес не может быть отрицательным")
+	}
+	return nil
+}
+
+// findSellerProduct загружает товар текущего продавца
+func findSellerProduct(c *fiber.Ctx, productID string) (models.Product, error) {
+	user := c.Locals("user").(models.UserResponse)
+
+	var product models.Product
+	err := initializers.DB.Where("id = ? AND seller_id = ?", productID, user.ID).First(&product).Error
+	return product, err
+}
+
+// checkProductBlog проверяет, что объявление принадлежит продавцу
+func checkProductBlog(blogID *uint64, sellerID uuid.UUID) bool {
+	if blogID == nil {
+		return true
+	}
+	var count int64
+	initializers.DB.Model(&models.Blog{}).Where("id = ? AND user_id = ?", *blogID, sellerID).Count(&count)
+	return count > 0
+}
+
+func productFilesJSON(files []productFile) (pgtype.JSONB, error) {
+	filesJSON := pgtype.JSONB{}
+	for _, file := range files {
+		if file.Path == "" {
+			return filesJSON, errors.New("empty file path")
+		}
+	}
+	err := filesJSON.Set(files)
+	return filesJSON, err
+}
+
+func GetProducts(c *fiber.Ctx) error {
+	query := initializers.DB.
+		Preload("Variants", "active = ?", true).
+		Preload("Photos").
+		Where("active = ?", true)
+
+	if seller := c.Query("seller"); seller != "" {
+		query = query.Where("seller_id = ?", seller)
+	}
+	if blogID := c.Query("blogId"); blogID != "" {
+		query = query.Where("blog_id = ?", blogID)
+	}
+	if search := c.Query("search"); search != "" {
+		query = query.Where("title ILIKE ?", "%"+search+"%")
+	}
+
+	var products []models.Product
+	return utils.Paginate(c, query.Order("created_at DESC"), &products)
+}
+
+func GetProduct(c *fiber.Ctx) error {
+	var product models.Product
+	if err := initializers.DB.
+		Preload("Variants", "active = ?", true).
+		Preload("Photos").
+		Where("id = ? AND active = ?", c.Params("id"), true).
+		First(&product).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Товар не найден",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   product,
+	})
+}
+
+func GetMyProducts(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var products []models.Product
+	return utils.Paginate(c, initializers.DB.
+		Preload("Variants").
+		Preload("Photos").
+		Where("seller_id = ?", user.ID).
+		Order("created_at DESC"), &products)
+}
+
+func CreateProduct(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	if !user.Seller {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Вы не являетесь продавцом",
+		})
+	}
+
+	var req struct {
+		Title    string                  `json:"title"`
+		Descr    string                  `json:"descr"`
+		Currency string                  `json:"currency"`
+		BlogID   *uint64                 `json:"blogId"`
+		Variants []productVariantRequest `json:"variants"`
+		Photos   []productFile           `json:"photos"`
+	}
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Title) == "" || len(req.Variants) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Укажите название и хотя бы один вариант товара",
+		})
+	}
+
+	if !checkProductBlog(req.BlogID, user.ID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Объявление не найдено",
+		})
+	}
+
+	product := models.Product{
+		SellerID: user.ID,
+		BlogID:   req.BlogID,
+		Title:    strings.TrimSpace(req.Title),
+		Descr:    req.Descr,
+		Currency: strings.ToUpper(req.Currency),
+		Active:   true,
+	}
+	if product.Currency == "" {
+		product.Currency = "RUB"
+	}
+
+	for _, v := range req.Variants {
+		if err := v.validate(); err != nil || v.SKU == "" || v.Price == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "У каждого варианта должны быть артикул и цена",
+			})
+		}
+		variant := models.ProductVariant{SKU: v.SKU, Name: v.Name, Price: *v.Price, Active: true}
+		if v.Stock != nil {
+			variant.Stock = *v.Stock
+		}
+		if v.Weight != nil {
+			variant.Weight = *v.Weight
+		}
+		product.Variants = append(product.Variants, variant)
+	}
+
+	if len(req.Photos) > 0 {
+		files, err := productFilesJSON(req.Photos)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Error converting files to JSON",
+			})
+		}
+		product.Photos = []models.ProductPhoto{{Files: files}}
+	}
+
+	if err := initializers.DB.Create(&product).Error; err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось создать товар, возможно артикул уже занят",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   product,
+	})
+}
+
+func UpdateProduct(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	product, err := findSellerProduct(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Товар не найден или доступ запрещен",
+		})
+	}
+
+	var req struct {
+		Title  *string `json:"title"`
+		Descr  *string `json:"descr"`
+		BlogID *uint64 `json:"blogId"`
+		Active *bool   `json:"active"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Неверные данные",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if req.Title != nil && strings.TrimSpace(*req.Title) != "" {
+		updates["title"] = strings.TrimSpace(*req.Title)
+	}
+	if req.Descr != nil {
+		updates["descr"] = *req.Descr
+	}
+	if req.BlogID != nil {
+		if !checkProductBlog(req.BlogID, user.ID) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Объявление не найдено",
+			})
+		}
+		updates["blog_id"] = *req.BlogID
+	}
+	if req.Active != nil {
+		updates["active"] = *req.Active
+	}
+
+	if len(updates) > 0 {
+		if err := initializers.DB.Model(&product).Updates(updates).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Ошибка при обновлении товара",
+			})
+		}
+	}
+
+	initializers.DB.Preload("Variants").Preload("Photos").First(&product, product.ID)
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   product,
+	})
+}
+
+func AddProductVariant(c *fiber.Ctx) error {
+	product, err := findSellerProduct(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Товар не найден или доступ запрещен",
+		})
+	}
+
+	var req productVariantRequest
+	if err := c.BodyParser(&req); err != nil || req.SKU == "" || req.Price == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Укажите артикул и цену",
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	variant := models.ProductVariant{ProductID: product.ID, SKU: req.SKU, Name: req.Name, Price: *req.Price, Active: true}
+	if req.Stock != nil {
+		variant.Stock = *req.Stock
+	}
+	if req.Weight != nil {
+		variant.Weight = *req.Weight
+	}
+	if err := initializers.DB.Create(&variant).Error; err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Артикул уже занят",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   variant,
+	})
+}
+
+// UpdateProductVariant меняет цену, остаток, вес или доступность варианта.
+// Остаток задается абсолютным значением.
+func UpdateProductVariant(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req productVariantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Неверные данные",
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	var variant models.ProductVariant
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Joins("JOIN products ON products.id = product_variants.product_id").
+			Where("product_variants.id = ? AND products.seller_id = ?", c.Params("variantId"), user.ID).
+			First(&variant).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if req.Name != "" {
+			updates["name"] = req.Name
+		}
+		if req.Price != nil {
+			updates["price"] = *req.Price
+		}
+		if req.Stock != nil {
+			updates["stock"] = *req.Stock
+		}
+		if req.Weight != nil {
+			updates["weight"] = *req.Weight
+		}
+		if req.Active != nil {
+			updates["active"] = *req.Active
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&variant).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.First(&variant, variant.ID).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Вариант не найден или доступ запрещен",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при обновлении варианта",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   variant,
+	})
+}
+
+func AddProductPhotos(c *fiber.Ctx) error {
+	product, err := findSellerProduct(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Товар не найден или доступ запрещен",
+		})
+	}
+
+	var req struct {
+		Files []productFile `json:"files"`
+	}
+	if err := c.BodyParser(&req); err != nil || len(req.Files) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Error parsing request body",
+		})
+	}
+
+	files, err := productFilesJSON(req.Files)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Error converting files to JSON",
+		})
+	}
+
+	photo := models.ProductPhoto{ProductID: product.ID, Files: files}
+	if err := initializers.DB.Create(&photo).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Error saving to database",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   photo,
+	})
+}
+
+func DeleteProductPhoto(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	result := initializers.DB.
+		Where("id = ? AND product_id IN (?)", c.Params("photoId"),
+			initializers.DB.Model(&models.Product{}).Select("id").Where("seller_id = ?", user.ID)).
+		Delete(&models.ProductPhoto{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при удалении фото",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Фото не найдено или доступ запрещен",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Фото удалено",
+	})
+}
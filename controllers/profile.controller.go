@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hyperpage/initializers"
 	"hyperpage/models"
@@ -441,6 +442,14 @@ func GetProfileGuest(c *fiber.Ctx) error {
 			})
 		}
 
+		// Для заблокированного пользователя профиль не существует
+		if viewerID, err := uuid.FromString(tokenClaims.UserID); err == nil && utils.IsBlockedBy(initializers.DB, profile.ID, viewerID) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Profile not found",
+			})
+		}
+
 		var highestIsUpBlog models.Blog
 		maxIsUpVotes := 0
 
@@ -1525,8 +1534,8 @@ func SendDonat(c *fiber.Ctx) error {
 		})
 	}
 
-	// Конвертация суммы в float
-	priceFloat, err := strconv.ParseFloat(donatReq.Amount, 64)
+	// Сумма доната в копейках, только положительная
+	amount, err := utils.ParseMoneyAmount(donatReq.Amount)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
@@ -1534,56 +1543,6 @@ func SendDonat(c *fiber.Ctx) error {
 		})
 	}
 
-	// Получение текущего баланса пользователя
-	var billing models.Billing
-	err = initializers.DB.Where("user_id = ?", userResp.ID).First(&billing).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to fetch balance",
-		})
-	}
-
-	// Проверка достаточности баланса
-	if billing.Amount < priceFloat {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Insufficient balance",
-		})
-	}
-
-	// Обновление баланса пользователя
-	err = initializers.DB.Model(&models.Billing{}).
-		Where("user_id = ?", userResp.ID).
-		Updates(map[string]interface{}{
-			"amount": gorm.Expr("amount - ?", priceFloat),
-		}).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to update balance",
-		})
-	}
-
-	// Создание транзакции
-	transaction := models.Transaction{
-		UserID:      userResp.ID,
-		Total:       strconv.FormatFloat(priceFloat, 'f', 2, 64),
-		Amount:      priceFloat,
-		Description: "Донат пользователю " + donatReq.Author,
-		Module:      "donat",
-		Type:        "deduction",
-		Status:      "CLOSED_1",
-	}
-
-	err = initializers.DB.Create(&transaction).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to create transaction",
-		})
-	}
-
 	// Получение ID автора стрима
 	var author models.User
 	err = initializers.DB.Where("name = ?", donatReq.Author).First(&author).Error
@@ -1594,56 +1553,37 @@ func SendDonat(c *fiber.Ctx) error {
 		})
 	}
 
-	// Ensure author.ID is a UUID (string)
-	authorIDStr := author.ID
-
-	// Увеличение баланса автора стрима
-	var authorBilling models.Billing
-	err = initializers.DB.Where("user_id = ?", authorIDStr).First(&authorBilling).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to fetch author balance",
-		})
-	}
-
-	// Увеличение баланса автора стрима
-	err = initializers.DB.Model(&models.Billing{}).
-		Where("user_id = ?", authorIDStr).
-		Updates(map[string]interface{}{
-			"amount": gorm.Expr("amount + ?", priceFloat),
-		}).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	// Перевод доната с кошелька зрителя на кошелёк автора стрима
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		entry := models.LedgerEntry{
+			Kind:        utils.LedgerKindDonation,
+			Description: "Донат от пользователя " + userResp.Name + " пользователю " + author.Name,
+			Module:      "donat",
+		}
+		if err := utils.TransferBetweenUsers(tx, userResp.ID, author.ID, amount, &entry); err != nil {
+			return err
+		}
+		_, err := utils.IssueReceipt(tx, utils.DocumentPurposeDonation, entry, userResp.ID, &author.ID, amount)
+		return err
+	})
+	if errors.Is(err, utils.ErrInsufficientBalance) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to update author balance",
+			"message": "Insufficient balance",
 		})
 	}
-
-	// Создание транзакции для автора стрима
-	transactionReceiver := models.Transaction{
-		UserID:      authorIDStr,
-		Total:       strconv.FormatFloat(priceFloat, 'f', 2, 64),
-		Amount:      priceFloat,
-		Description: "Получение доната от пользователя " + userResp.Name,
-		Module:      "donat",
-		Type:        "addition",
-		Status:      "CLOSED_1",
-	}
-
-	err = initializers.DB.Create(&transactionReceiver).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to create transaction for receiver",
+			"message": "Failed to update balance",
 		})
 	}
 
 	data := []utils.AdditionalData{
-		{Name: userResp.Name, Total: strconv.FormatFloat(priceFloat, 'f', 2, 64), Msg: donatReq.Sms},
+		{Name: userResp.Name, Total: strconv.FormatFloat(utils.MinorToMoney(amount), 'f', 2, 64), Msg: donatReq.Sms},
 	}
 
-	err = utils.SendPersonalMessageToClientWithData(author.Session, "newDonat", data)
+	err = utils.SendPersonalMessageToUserWithData(author.ID.String(), "newDonat", data)
 	if err != nil {
 		// handle error
 		_ = err
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// CreatePromoCampaign creates a promo campaign. Balance is in kopecks.
+func CreatePromoCampaign(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var campaign models.PromoCampaign
+	if err := c.BodyParser(&campaign); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+	campaign.ID = 0
+	campaign.Redemptions = 0
+	campaign.CreatedBy = user.ID
+
+	if err := utils.ValidatePromoCampaign(campaign); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+	if campaign.Kind == utils.PromoKindPlanDays {
+		if _, err := utils.FindSubscriptionPlan(initializers.DB, campaign.PlanCode); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Plan not found",
+			})
+		}
+	}
+
+	if err := initializers.DB.Create(&campaign).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create campaign",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   campaign,
+	})
+}
+
+// GetPromoCampaigns lists the promo campaigns, newest first.
+func GetPromoCampaigns(c *fiber.Ctx) error {
+	var campaigns []models.PromoCampaign
+	return utils.Paginate(c, initializers.DB.Order("created_at DESC"), &campaigns)
+}
+
+// GeneratePromoCodes bulk-generates codes for a campaign.
+func GeneratePromoCodes(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid campaign ID",
+		})
+	}
+
+	var campaign models.PromoCampaign
+	if err := initializers.DB.First(&campaign, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Campaign not found",
+		})
+	}
+
+	var payload struct {
+		Count          int `json:"count"`
+		MaxRedemptions int `json:"maxRedemptions"`
+	}
+	payload.MaxRedemptions = 1
+	if err := c.BodyParser(&payload); err != nil || payload.Count <= 0 || payload.Count > 10000 || payload.MaxRedemptions < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Count must be between 1 and 10000",
+		})
+	}
+
+	balance := "0"
+	if campaign.Kind == utils.PromoKindBalance {
+		balance = strconv.FormatFloat(utils.MinorToMoney(campaign.Balance), 'f', 2, 64)
+	}
+
+	var codes []models.Codes
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = utils.GeneratePromoCodes(tx, models.Codes{
+			Balance:        balance,
+			UserId:         user.ID,
+			CampaignID:     &campaign.ID,
+			MaxRedemptions: payload.MaxRedemptions,
+		}, payload.Count)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to generate codes",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   codes,
+	})
+}
+
+// ExportPromoCodes writes the codes of a campaign as CSV.
+func ExportPromoCodes(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid campaign ID",
+		})
+	}
+
+	var campaign models.PromoCampaign
+	if err := initializers.DB.First(&campaign, "id = ?", id).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Campaign not found",
+		})
+	}
+
+	var codes []models.Codes
+	if err := initializers.DB.Where("campaign_id = ?", campaign.ID).Order("id").Find(&codes).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch codes",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="campaign-`+strconv.FormatUint(campaign.ID, 10)+`.csv"`)
+
+	writer := csv.NewWriter(c.Response().BodyWriter())
+	writer.Write([]string{"code", "kind", "max_redemptions", "redemptions", "activated", "created_at"})
+	for _, code := range codes {
+		writer.Write([]string{
+			code.Code,
+			campaign.Kind,
+			strconv.Itoa(code.MaxRedemptions),
+			strconv.Itoa(code.Redemptions),
+			strconv.FormatBool(code.Activated),
+			code.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
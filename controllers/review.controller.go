@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxReviewPhotos = 10
+
+type reviewAuthor struct {
+	ID    uuid.UUID `json:"id"`
+	Name  string    `json:"name"`
+	Photo string    `json:"photo"`
+}
+
+type reviewResponse struct {
+	models.Review
+	Author reviewAuthor `json:"author"`
+}
+
+// CreateReview оставляет отзыв покупателя по завершённому заказу
+func CreateReview(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req struct {
+		OrderID   string        `json:"orderId"`
+		ProductID *uint64       `json:"productId"`
+		Stars     int           `json:"stars"`
+		Text      string        `json:"text"`
+		Photos    []productFile `json:"photos"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Stars < 1 || req.Stars > 5 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Оценка должна быть от 1 до 5",
+		})
+	}
+	orderID, err := uuid.FromString(req.OrderID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Некорректный идентификатор заказа",
+		})
+	}
+	if len(req.Photos) > maxReviewPhotos {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Можно прикрепить не больше " + strconv.Itoa(maxReviewPhotos) + " фотографий",
+		})
+	}
+
+	review := models.Review{
+		ProductID: req.ProductID,
+		Stars:     req.Stars,
+		Text:      strings.TrimSpace(req.Text),
+	}
+	if len(req.Photos) > 0 {
+		if review.Photos, err = productFilesJSON(req.Photos); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Некорректные фотографии",
+			})
+		}
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", orderID, user.ID).
+			First(&order).Error; err != nil {
+			return err
+		}
+		return utils.CreateReview(tx, order, &review)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Заказ не найден",
+			})
+		case errors.Is(err, utils.ErrReviewOrderNotCompleted):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Отзыв можно оставить только по завершённому заказу",
+			})
+		case errors.Is(err, utils.ErrReviewExists):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Отзыв по этому заказу уже оставлен",
+			})
+		case errors.Is(err, utils.ErrReviewProductNotInOrder):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Товар не входит в заказ",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось сохранить отзыв",
+		})
+	}
+
+	SendNotificationToOwner(
+		review.SellerID.String(),
+		"Новый отзыв",
+		"Покупатель оценил заказ на "+strconv.Itoa(review.Stars)+" из 5",
+		"https://www.myru.online/profile/posts?tabs=reviews",
+	)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   review,
+	})
+}
+
+// ReplyToReview публикует ответ продавца на отзыв. Ответить можно один раз.
+func ReplyToReview(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Text) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Введите текст ответа",
+		})
+	}
+
+	now := time.Now()
+	result := initializers.DB.Model(&models.Review{}).
+		Where("id = ? AND seller_id = ? AND reply = ''", c.Params("id"), user.ID).
+		Updates(map[string]interface{}{"reply": strings.TrimSpace(req.Text), "replied_at": now})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось сохранить ответ",
+		})
+	}
+
+	var review models.Review
+	if err := initializers.DB.Where("id = ? AND seller_id = ?", c.Params("id"), user.ID).First(&review).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Отзыв не найден",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Вы уже ответили на этот отзыв",
+		})
+	}
+
+	SendNotificationToOwner(
+		review.UserID.String(),
+		"Продавец ответил на отзыв",
+		review.Reply,
+		"https://www.myru.online/profile/posts?tabs=purchases",
+	)
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   review,
+	})
+}
+
+// GetReviews возвращает опубликованные отзывы продавца, объявления или товара
+func GetReviews(c *fiber.Ctx) error {
+	query := initializers.DB.Model(&models.Review{}).Where("status = ?", utils.ReviewStatusPublished)
+
+	filtered := false
+	if seller := c.Query("seller"); seller != "" {
+		query = query.Where("seller_id = ?", seller)
+		filtered = true
+	}
+	if blogID := c.Query("blogId"); blogID != "" {
+		query = query.Where("blog_id = ?", blogID)
+		filtered = true
+	}
+	if productID := c.Query("productId"); productID != "" {
+		query = query.Where("product_id = ?", productID)
+		filtered = true
+	}
+	if !filtered {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Укажите продавца, объявление или товар",
+		})
+	}
+	if stars, err := strconv.Atoi(c.Query("stars")); err == nil {
+		query = query.Where("stars = ?", stars)
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit", "10"))
+	if err != nil || limit <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid limit parameter",
+		})
+	}
+	skip, err := strconv.Atoi(c.Query("skip", "0"))
+	if err != nil || skip < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid skip parameter",
+		})
+	}
+
+	var total int64
+	var reviews []models.Review
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not retrieve data",
+		})
+	}
+	if err := query.Order("created_at DESC").Limit(limit).Offset(skip).Find(&reviews).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Could not retrieve data",
+		})
+	}
+
+	authorIDs := make([]uuid.UUID, 0, len(reviews))
+	for _, review := range reviews {
+		authorIDs = append(authorIDs, review.UserID)
+	}
+	var authors []models.User
+	if len(authorIDs) > 0 {
+		initializers.DB.Select("id", "name", "photo").Where("id IN ?", authorIDs).Find(&authors)
+	}
+	authorsByID := map[uuid.UUID]reviewAuthor{}
+	for _, author := range authors {
+		authorsByID[author.ID] = reviewAuthor{ID: author.ID, Name: author.Name, Photo: author.Photo}
+	}
+
+	data := make([]reviewResponse, 0, len(reviews))
+	for _, review := range reviews {
+		data = append(data, reviewResponse{Review: review, Author: authorsByID[review.UserID]})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   data,
+		"meta": fiber.Map{
+			"total": total,
+			"limit": limit,
+			"skip":  skip,
+		},
+	})
+}
+
+// GetReviewsForModeration возвращает отзывы для модерации, включая скрытые
+func GetReviewsForModeration(c *fiber.Ctx) error {
+	query := initializers.DB.Order("created_at DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if seller := c.Query("seller"); seller != "" {
+		query = query.Where("seller_id = ?", seller)
+	}
+
+	var reviews []models.Review
+	return utils.Paginate(c, query, &reviews)
+}
+
+// ModerateReview скрывает или снова публикует отзыв и пересчитывает рейтинги.
+// removeReply удаляет ответ продавца.
+func ModerateReview(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req struct {
+		Status      string `json:"status"`
+		Reason      string `json:"reason"`
+		RemoveReply bool   `json:"removeReply"`
+	}
+	if err := c.BodyParser(&req); err != nil ||
+		(req.Status != utils.ReviewStatusPublished && req.Status != utils.ReviewStatusHidden) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Status must be published or hidden",
+		})
+	}
+
+	var review models.Review
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&review, "id = ?", c.Params("id")).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":            req.Status,
+			"moderator_id":      user.ID,
+			"moderation_reason": req.Reason,
+			"moderated_at":      now,
+		}
+		if req.RemoveReply {
+			updates["reply"] = ""
+			updates["replied_at"] = nil
+		}
+		if err := tx.Model(&review).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := utils.UpdateReviewRatings(tx, review); err != nil {
+			return err
+		}
+		return tx.First(&review, review.ID).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Review not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to moderate review",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   review,
+	})
+}
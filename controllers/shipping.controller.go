@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type shippingZoneRequest struct {
+	Name      string `json:"name"`
+	Locations []struct {
+		CountryCode string `json:"countryCode"`
+		Region      string `json:"region"`
+		CityID      *uint  `json:"cityId"`
+	} `json:"locations"`
+}
+
+// locations проверяет страны, регионы и города зоны
+func (r shippingZoneRequest) locations() ([]models.ShippingZoneLocation, error) {
+	if strings.TrimSpace(r.Name) == "" || len(r.Locations) == 0 {
+		return nil, errors.New("Укажите название зоны и хотя бы одну страну или город")
+	}
+
+	locations := make([]models.ShippingZoneLocation, 0, len(r.Locations))
+	for _, l := range r.Locations {
+		location := models.ShippingZoneLocation{
+			CountryCode: strings.ToUpper(strings.TrimSpace(l.CountryCode)),
+			Region:      strings.TrimSpace(l.Region),
+			CityID:      l.CityID,
+		}
+		if !utils.CountryCodePattern.MatchString(location.CountryCode) {
+			return nil, errors.New("Некорректный код страны: " + l.CountryCode)
+		}
+		if location.CityID != nil {
+			var count int64
+			initializers.DB.Model(&models.City{}).Where("id = ? AND UPPER(country_code) = ?", *location.CityID, location.CountryCode).Count(&count)
+			if count == 0 {
+				return nil, errors.New("Город не найден в стране " + location.CountryCode)
+			}
+		}
+		locations = append(locations, location)
+	}
+	return locations, nil
+}
+
+type shippingMethodRequest struct {
+	Name          *string `json:"name"`
+	RateType      *string `json:"rateType"`
+	Price         *int64  `json:"price"`      // копейки
+	PricePerKg    *int64  `json:"pricePerKg"` // копейки
+	FreeFrom      *int64  `json:"freeFrom"`   // копейки, 0 отключает
+	EstimatedDays *int    `json:"estimatedDays"`
+	Active        *bool   `json:"active"`
+}
+
+func (r shippingMethodRequest) validate() error {
+	if r.Name != nil && strings.TrimSpace(*r.Name) == "" {
+		return errors.New("Укажите название способа доставки")
+	}
+	if r.RateType != nil && !utils.IsShippingRateType(*r.RateType) {
+		return errors.New("Тариф должен быть flat или weight")
+	}
+	if (r.Price != nil && *r.Price < 0) || (r.PricePerKg != nil && *r.PricePerKg < 0) || (r.FreeFrom != nil && *r.FreeFrom < 0) {
+		return errors.New("Стоимость не может быть отрицательной")
+	}
+	if r.EstimatedDays != nil && *r.EstimatedDays < 0 {
+		return errors.New("Срок доставки не может быть отрицательным")
+	}
+	return nil
+}
+
+// findSellerShippingZone загружает зону доставки текущего продавца
+func findSellerShippingZone(c *fiber.Ctx, zoneID string) (models.ShippingZone, error) {
+	user := c.Locals("user").(models.UserResponse)
+
+	var zone models.ShippingZone
+	err := initializers.DB.Where("id = ? AND seller_id = ?", zoneID, user.ID).First(&zone).Error
+	return zone, err
+}
+
+func GetShippingZones(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var zones []models.ShippingZone
+	if err := initializers.DB.Preload("Locations").Preload("Methods").
+		Where("seller_id = ?", user.ID).Order("id").Find(&zones).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось загрузить зоны доставки",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   zones,
+	})
+}
+
+func CreateShippingZone(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req shippingZoneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Неверные данные",
+		})
+	}
+	locations, err := req.locations()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	zone := models.ShippingZone{SellerID: user.ID, Name: strings.TrimSpace(req.Name), Locations: locations}
+	if err := initializers.DB.Create(&zone).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось создать зону доставки",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   zone,
+	})
+}
+
+// UpdateShippingZone меняет название зоны и заменяет список стран и городов
+func UpdateShippingZone(c *fiber.Ctx) error {
+	zone, err := findSellerShippingZone(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Зона доставки не найдена или доступ запрещен",
+		})
+	}
+
+	var req shippingZoneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Неверные данные",
+		})
+	}
+	locations, err := req.locations()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&zone).Update("name", strings.TrimSpace(req.Name)).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("zone_id = ?", zone.ID).Delete(&models.ShippingZoneLocation{}).Error; err != nil {
+			return err
+		}
+		for i := range locations {
+			locations[i].ZoneID = zone.ID
+		}
+		return tx.Create(&locations).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при обновлении зоны доставки",
+		})
+	}
+
+	initializers.DB.Preload("Locations").Preload("Methods").First(&zone, zone.ID)
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   zone,
+	})
+}
+
+func DeleteShippingZone(c *fiber.Ctx) error {
+	zone, err := findSellerShippingZone(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Зона доставки не найдена или доступ запрещен",
+		})
+	}
+
+	if err := initializers.DB.Select("Locations", "Methods").Delete(&zone).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при удалении зоны доставки",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Зона доставки удалена",
+	})
+}
+
+func AddShippingMethod(c *fiber.Ctx) error {
+	zone, err := findSellerShippingZone(c, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Зона доставки не найдена или доступ запрещен",
+		})
+	}
+
+	var req shippingMethodRequest
+	if err := c.BodyParser(&req); err != nil || req.Name == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Укажите название способа доставки",
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	active := true
+	method := models.ShippingMethod{ZoneID: zone.ID, Name: strings.TrimSpace(*req.Name), RateType: utils.ShippingRateFlat, Active: &active}
+	if req.RateType != nil {
+		method.RateType = *req.RateType
+	}
+	if req.Price != nil {
+		method.Price = *req.Price
+	}
+	if req.PricePerKg != nil {
+		method.PricePerKg = *req.PricePerKg
+	}
+	if req.FreeFrom != nil && *req.FreeFrom > 0 {
+		method.FreeFrom = req.FreeFrom
+	}
+	if req.EstimatedDays != nil {
+		method.EstimatedDays = *req.EstimatedDays
+	}
+	if req.Active != nil {
+		method.Active = req.Active
+	}
+
+	if err := initializers.DB.Create(&method).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Не удалось добавить способ доставки",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data":   method,
+	})
+}
+
+func UpdateShippingMethod(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var req shippingMethodRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Неверные данные",
+		})
+	}
+	if err := req.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+		})
+	}
+
+	var method models.ShippingMethod
+	if err := initializers.DB.
+		Joins("JOIN shipping_zones ON shipping_zones.id = shipping_methods.zone_id").
+		Where("shipping_methods.id = ? AND shipping_zones.seller_id = ?", c.Params("methodId"), user.ID).
+		First(&method).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Способ доставки не найден или доступ запрещен",
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.RateType != nil {
+		updates["rate_type"] = *req.RateType
+	}
+	if req.Price != nil {
+		updates["price"] = *req.Price
+	}
+	if req.PricePerKg != nil {
+		updates["price_per_kg"] = *req.PricePerKg
+	}
+	if req.FreeFrom != nil {
+		if *req.FreeFrom > 0 {
+			updates["free_from"] = *req.FreeFrom
+		} else {
+			updates["free_from"] = nil
+		}
+	}
+	if req.EstimatedDays != nil {
+		updates["estimated_days"] = *req.EstimatedDays
+	}
+	if req.Active != nil {
+		updates["active"] = *req.Active
+	}
+
+	if len(updates) > 0 {
+		if err := initializers.DB.Model(&method).Updates(updates).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Ошибка при обновлении способа доставки",
+			})
+		}
+	}
+
+	initializers.DB.First(&method, method.ID)
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   method,
+	})
+}
+
+func DeleteShippingMethod(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	result := initializers.DB.
+		Where("id = ? AND zone_id IN (?)", c.Params("methodId"),
+			initializers.DB.Model(&models.ShippingZone{}).Select("id").Where("seller_id = ?", user.ID)).
+		Delete(&models.ShippingMethod{})
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Ошибка при удалении способа доставки",
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Способ доставки не найден или доступ запрещен",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Способ доставки удален",
+	})
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"hyperpage/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// GetPlans lists the plans on sale with their prices.
+func GetPlans(c *fiber.Ctx) error {
+	var plans []models.SubscriptionPlan
+	if err := initializers.DB.Preload("Prices").
+		Where("active = ?", true).
+		Order("sort_order").
+		Find(&plans).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch plans",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   plans,
+	})
+}
+
+// GetSubscriptions lists the subscription history of the user.
+func GetSubscriptions(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var subscriptions []models.Subscription
+	if err := initializers.DB.Preload("Plan").
+		Where("user_id = ?", user.ID).
+		Order("created_at DESC").
+		Find(&subscriptions).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch subscriptions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   subscriptions,
+	})
+}
+
+// SetAutoRenew switches auto-renewal of the current subscription.
+func SetAutoRenew(c *fiber.Ctx) error {
+	user := c.Locals("user").(models.UserResponse)
+
+	var payload struct {
+		AutoRenew bool `json:"autoRenew"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+		})
+	}
+
+	var subscription models.Subscription
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		subscription, err = utils.SetSubscriptionAutoRenew(tx, user.ID, payload.AutoRenew)
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No active subscription",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update subscription",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   subscription,
+	})
+}
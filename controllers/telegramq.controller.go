@@ -1,8 +1,6 @@
 package controllers
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"hyperpage/initializers"
 	"hyperpage/models"
@@ -19,18 +17,6 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-func generateRandomString(length int) (string, error) {
-	randomBytes := make([]byte, length)
-
-	_, err := rand.Read(randomBytes)
-	if err != nil {
-		return "", err
-	}
-
-	randomString := base64.URLEncoding.EncodeToString(randomBytes)[:length]
-	return randomString, nil
-}
-
 func ProfileActivity(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
 	//time.Sleep(1 * time.Second)
 	var user models.User
@@ -127,7 +113,7 @@ func TryActivated(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, afterSpace string
 			// user.Photo = fileURL
 			user.Photo = user.Storage + `/` + fileName
 
-			err = utils.SendPersonalMessageToClient(user.Session, "Activated")
+			err = utils.SendPersonalMessageToUser(user.ID.String(), "Activated")
 			if err != nil {
 				// handle error
 				_ = err
@@ -180,7 +166,7 @@ func TryActivated(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, afterSpace string
 			// user.Photo = fileURL
 			user.Photo = user.Storage + `/` + fileName
 
-			err = utils.SendPersonalMessageToClient(user.Session, "Activated")
+			err = utils.SendPersonalMessageToUser(user.ID.String(), "Activated")
 			if err != nil {
 				// handle error
 				_ = err
@@ -236,30 +222,17 @@ func MakeCodes(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, afterSpace string) {
 		return
 	}
 
-	for i := 0; i < numOfCodes; i++ {
-		// Generate a random unique string for the code
-		randomString, err := generateRandomString(10)
-		if err != nil {
-			fmt.Println("Error generating random string:", err)
-			continue
-		}
-
-		// Create a new code with the specified balance
-		code := models.Codes{
-			Code:      randomString,
-			Balance:   strconv.Itoa(amountPerCode), // Convert amountPerCode to a string
-			Activated: false,
-			UserId:    user.ID,
-			Used:      0,
-		}
-
-		// Save the code to the database
-		err = initializers.DB.Create(&code).Error
-		if err != nil {
-			fmt.Println("Error creating code:", err)
-			continue
-		}
+	codes, err := utils.GeneratePromoCodes(initializers.DB, models.Codes{
+		Balance:        strconv.Itoa(amountPerCode), // Convert amountPerCode to a string
+		UserId:         user.ID,
+		MaxRedemptions: 1,
+	}, numOfCodes)
+	if err != nil {
+		fmt.Println("Error creating code:", err)
+		return
+	}
 
+	for _, code := range codes {
 		fmt.Println("Code created:", code.Code)
 
 		// Send the code creation status back to the user
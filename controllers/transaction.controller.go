@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"github.com/gofiber/fiber/v2"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
 
 	"hyperpage/initializers"
 	"hyperpage/models"
@@ -28,3 +30,65 @@ func GetTransactions(c *fiber.Ctx) error {
         "data":   transaction,
     })
 }
+
+// GetLedgerReconciliation compares the cached wallet balance of a user with
+// the sum of the wallet postings, so finance can reconcile it to the kopeck.
+func GetLedgerReconciliation(c *fiber.Ctx) error {
+	userID, err := uuid.FromString(c.Params("userId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid user ID",
+		})
+	}
+
+	var wallet models.LedgerAccount
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		wallet, err = utils.UserWalletAccount(tx, userID)
+		return err
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch wallet",
+		})
+	}
+
+	var postedSum int64
+	if err := initializers.DB.Model(&models.LedgerPosting{}).
+		Where("account_id = ?", wallet.ID).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&postedSum).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to sum postings",
+		})
+	}
+
+	var billing models.Billing
+	initializers.DB.Where("user_id = ?", userID).First(&billing)
+
+	var entries []models.LedgerEntry
+	if err := initializers.DB.
+		Joins("JOIN ledger_postings ON ledger_postings.entry_id = ledger_entries.id").
+		Where("ledger_postings.account_id = ?", wallet.ID).
+		Preload("Postings").
+		Order("ledger_entries.id DESC").
+		Find(&entries).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch ledger entries",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"account":        wallet,
+			"postedBalance":  postedSum,
+			"billingBalance": billing.Balance,
+			"consistent":     postedSum == wallet.Balance && billing.Balance == wallet.Balance,
+			"entries":        entries,
+		},
+	})
+}
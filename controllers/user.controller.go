@@ -10,7 +10,6 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -237,7 +236,7 @@ func GetMeH(id string, userName string, fileURL string, tId int64) (*models.User
 	user.TelegramActivated = true
 	user.Photo = user.Storage + `/` + fileName
 
-	err = utils.SendPersonalMessageToClient(user.Session, "Activated")
+	err = utils.SendPersonalMessageToUser(user.ID.String(), "Activated")
 	if err != nil {
 		// handle error
 		_ = err
@@ -290,95 +289,54 @@ func calculateDirSize(dirPath string) (float64, error) {
 	return sizeInMB, nil
 }
 
+// Plan buys, renews or switches the subscription plan of the user from the
+// wallet. name is the plan code or its name in any language.
 func Plan(c *fiber.Ctx) error {
 	userId := c.Locals("user")
 	userResp := userId.(models.UserResponse)
-	userObj := models.User{
-		ID: userResp.ID,
-	}
 
-	user := new(models.User)
-	if err := c.BodyParser(user); err != nil {
+	var payload struct {
+		Name      string `json:"name"`
+		Currency  string `json:"currency"`
+		AutoRenew bool   `json:"autoRenew"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Invalid request body",
 		})
 	}
-
-	var amount float64
-	var limitstorage int
-
-	// Add an amount variable to store the amount for the blog post
-	switch user.Name {
-	case "Начальный":
-		amount = 150
-		limitstorage = 300
-	case "Бизнесс":
-		amount = 500
-		limitstorage = 600
-	case "Расширенный":
-		amount = 1000
-		limitstorage = 900
+	if payload.Currency == "" {
+		payload.Currency = "RUB"
 	}
 
-	// Fetch the current balance
-	var billing models.Billing
-	err := initializers.DB.Where("user_id = ?", userObj.ID).First(&billing).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	var subscription models.Subscription
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		plan, err := utils.FindSubscriptionPlan(tx, payload.Name)
+		if err != nil {
+			return err
+		}
+		subscription, err = utils.PurchaseSubscription(tx, userResp.ID, plan, payload.Currency, payload.AutoRenew)
+		return err
+	})
+	if errors.Is(err, utils.ErrPlanNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to fetch balance",
+			"message": "Plan not found",
 		})
 	}
-
-	// Check if the balance is sufficient
-	if billing.Amount < amount {
+	if errors.Is(err, utils.ErrPlanPriceNotFound) || errors.Is(err, utils.ErrWalletCurrencyMismatch) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Insufficient balance",
+			"message": "Plan is not sold in " + payload.Currency,
 		})
 	}
-	// Update the amount field in the balance table
-	err = initializers.DB.Model(&models.Billing{}).
-		Where("user_id = ?", userObj.ID).
-		Updates(map[string]interface{}{
-			"amount": gorm.Expr("amount - ?", amount),
-		}).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to update balance",
-		})
-	}
-
-	transaction := models.Transaction{
-		UserID:      userObj.ID,
-		Total:       "0", // Update with the appropriate value
-		Amount:      amount,
-		Description: "Оплата за тариф на месяц",
-		Module:      "CodeUsed",
-		Type:        "deduction",
-		Status:      "CLOSED_1",
-	}
-
-	err = initializers.DB.Create(&transaction).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	if errors.Is(err, utils.ErrInsufficientBalance) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to create transaction",
+			"message": "Insufficient balance",
 		})
 	}
-
-	// Update the user's plan, signed, and expired_plan_at columns
-	err = initializers.DB.Model(&models.User{}).
-		Where("id = ?", userObj.ID).
-		Updates(map[string]interface{}{
-			"plan":            user.Name,
-			"signed":          true,
-			"limit_storage":   limitstorage,
-			"expired_plan_at": time.Now().AddDate(0, 0, 31),
-		}).
-		Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
@@ -387,21 +345,23 @@ func Plan(c *fiber.Ctx) error {
 	}
 
 	return c.JSON(fiber.Map{
-		"status": "success",
-		"data":   "GOOD",
+		"status":       "success",
+		"data":         "GOOD",
+		"subscription": subscription,
 	})
 }
 
+// AddBalance redeems a promo code. Depending on the code it credits the
+// wallet, grants plan days or a discount on the next publication fee.
 func AddBalance(c *fiber.Ctx) error {
 
 	userId := c.Locals("user")
 	userResp := userId.(models.UserResponse)
-	userObj := models.User{
-		ID: userResp.ID,
-	}
 
-	code := new(models.Codes)
-	if err := c.BodyParser(code); err != nil {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
 
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
@@ -409,67 +369,42 @@ func AddBalance(c *fiber.Ctx) error {
 		})
 	}
 
-	//Try find code
-	err := initializers.DB.Table("codes").Where("code = ?", code.Code).First(code).Error
-	if err != nil {
+	var redemption models.PromoRedemption
+	var code models.Codes
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		redemption, code, err = utils.RedeemPromoCode(tx, userResp.ID, payload.Code)
+		return err
+	})
+	if errors.Is(err, utils.ErrPromoCodeNotFound) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Invalid code ID",
 		})
 	}
-
-	// Check if code is already activated
-	if code.Activated {
+	if errors.Is(err, utils.ErrPromoCodeUsed) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Code is already activated",
 		})
 	}
-
-	// Update the amount field in the balance table
-	err = initializers.DB.Model(&models.Billing{}).
-		Where("user_id = ?", userObj.ID).
-		Updates(map[string]interface{}{
-			"amount": gorm.Expr("amount + ?", code.Balance),
-		}).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	if errors.Is(err, utils.ErrPromoCampaignInactive) || errors.Is(err, utils.ErrPromoUserLimit) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to update balance",
+			"message": err.Error(),
 		})
 	}
-
-	// Set columns: activated, user, and used
-	code.Activated = true
-	code.UserId = userObj.ID
-	code.Used = uint64(time.Now().Unix())
-
-	// Update the code record in the database
-	err = initializers.DB.Table("codes").Where("code = ?", code.Code).Updates(code).Error
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to update code",
+			"message": "Failed to update balance",
 		})
 	}
 
-	balanceStr := code.Balance
-	balance, _ := strconv.ParseFloat(balanceStr, 64)
-	transaction := models.Transaction{
-		UserID:      userObj.ID,
-		Total:       `0`,
-		Amount:      balance,
-		Description: `Пополнение баланса`,
-		Module:      `CodeUsed`,
-		Type:        `profit`,
-		Status:      `CLOSED_1`,
-	}
-
-	initializers.DB.Create(&transaction)
-
 	return c.JSON(fiber.Map{
-		"status": "success",
-		"data":   code.Balance,
+		"status":     "success",
+		"data":       code.Balance,
+		"redemption": redemption,
 	})
 }
 
@@ -615,7 +550,6 @@ func DeleteUserWithRelations(c *fiber.Ctx) error {
 		"profile_photos",
 		"billings",
 		"online_storages",
-		"transactions",
 		"blogs",
 		"user_relation",
 		"votes",
@@ -712,7 +646,6 @@ func DeleteAllBotUsersWithRelations(c *fiber.Ctx) error {
 			"profile_photos",
 			"billings",
 			"online_storages",
-			"transactions",
 			"blogs",
 			"user_relation",
 			"votes",
@@ -783,77 +716,13 @@ func GetMeFirst(c *fiber.Ctx) error {
 
 func SetVipUser(c *fiber.Ctx) error {
 	user := c.Locals("user").(models.UserResponse)
-	amount := c.Get("Amount")
-
-	priceFloat, err := strconv.ParseFloat(amount, 64)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Invalid price value",
-		})
-	}
 
-	// Fetch the current balance
-	var billing models.Billing
-	err = initializers.DB.Where("user_id = ?", user.ID).First(&billing).Error
+	// The price comes from the plan, the client only picks it
+	plan, err := utils.FindSitePlan(c.Get("Plan"), c.Get("Amount"))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to fetch balance",
-		})
-	}
-
-	// Check if the balance is sufficient
-	if billing.Amount < priceFloat {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Insufficient balance",
-		})
-	}
-	// Update the amount field in the balance table
-	err = initializers.DB.Model(&models.Billing{}).
-		Where("user_id = ?", user.ID).
-		Updates(map[string]interface{}{
-			"amount": gorm.Expr("amount - ?", priceFloat),
-		}).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to update balance",
-		})
-	}
-
-	transaction := models.Transaction{
-		UserID:      user.ID,
-		Total:       "0", // Update with the appropriate value
-		Amount:      priceFloat,
-		Description: "Оплата за активацию сайта",
-		Module:      "site",
-		Type:        "deduction",
-		Status:      "CLOSED_1",
-	}
-
-	err = initializers.DB.Create(&transaction).Error
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to create transaction",
-		})
-	}
-
-	// Update the user's role to "VIP"
-	result := initializers.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("role", "vip")
-	if result.Error != nil {
-		// Handle the error
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to update user role",
-		})
-	}
-
-	if result.RowsAffected == 0 {
-		// User not found
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "User not found",
+			"message": "Invalid plan",
 		})
 	}
 
@@ -872,30 +741,27 @@ func SetVipUser(c *fiber.Ctx) error {
 		})
 	}
 
-	if priceFloat == 2500 {
-		newExpiredAt := time.Now().AddDate(0, 0, 30)
+	expiredAt := plan.ExpiresAt(time.Now())
 
-		// Create a new domain record
-		domain := models.Domain{
-			UserID:    user.ID,
-			Username:  user.Name,
-			Name:      strings.ToLower(user.Name) + ".myru.online",
-			Settings:  settings,
-			ExpiredAt: &newExpiredAt, // Assign a pointer to the newExpiredAt value
-			Status:    "activated",
+	// Charge the site activation, switch the role and create the domain in one transaction
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		entry := models.LedgerEntry{
+			Kind:        utils.LedgerKindSiteActivation,
+			Description: "Оплата за активацию сайта",
+			Module:      "site",
 		}
-
-		if err := initializers.DB.Create(&domain).Error; err != nil {
-			fmt.Println("Error creating domain:", err)
-			// Handle the error
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create domain",
-			})
+		if err := utils.ChargeUserWallet(tx, user.ID, plan.Price, &entry); err != nil {
+			return err
 		}
 
-		// Use newExpiredAt in further processing as needed
-	} else if priceFloat == 20000 {
-		newExpiredAt := time.Now().AddDate(1, 0, 0)
+		// Update the user's role to "VIP"
+		result := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("role", "vip")
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
 
 		// Create a new domain record
 		domain := models.Domain{
@@ -903,18 +769,31 @@ func SetVipUser(c *fiber.Ctx) error {
 			Username:  user.Name,
 			Name:      strings.ToLower(user.Name) + ".myru.online",
 			Settings:  settings,
-			ExpiredAt: &newExpiredAt, // Assign a pointer to the newExpiredAt value
+			ExpiredAt: &expiredAt,
 			Status:    "activated",
 		}
-
-		if err := initializers.DB.Create(&domain).Error; err != nil {
+		if err := tx.Create(&domain).Error; err != nil {
 			fmt.Println("Error creating domain:", err)
-			// Handle the error
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create domain",
-			})
+			return err
 		}
-		// Use newExpiredAt in further processing as needed
+		return nil
+	})
+	if errors.Is(err, utils.ErrInsufficientBalance) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Insufficient balance",
+		})
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// User not found
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to activate site",
+		})
 	}
 
 	// Respond with a success message
@@ -15,6 +15,7 @@ require (
 	github.com/gofiber/template/html/v2 v2.0.5
 	github.com/golang-jwt/jwt/v4 v4.5.0
 	github.com/jackc/pgtype v1.14.0
+	github.com/joho/godotenv v1.5.1
 	github.com/k3a/html2text v1.2.1
 	github.com/nikita-vanyasin/tinkoff v1.0.5
 	github.com/pion/webrtc/v3 v3.2.23
@@ -32,7 +33,6 @@ require (
 
 require (
 	github.com/go-sql-driver/mysql v1.7.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	gorm.io/driver/mysql v1.4.7 // indirect
 )
 
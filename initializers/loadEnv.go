@@ -44,6 +44,22 @@ type Config struct {
 	CentrifugoHttpApiKey       string `mapstructure:"CENTRIFUGO_HTTP_API_KEY"`
 	CentrifugoBroadcastMode    string `mapstructure:"CENTRIFUGO_BROADCAST_MODE"`
 	CentrifugoOutboxPartitions int    `mapstructure:"CENTRIFUGO_OUTBOX_PARTITIONS"`
+
+	ChatGroupMemberLimit int    `mapstructure:"CHAT_GROUP_MEMBER_LIMIT"`
+	ChatStorePath        string `mapstructure:"CHAT_STORE_PATH"`
+
+	PaymentProvider          string `mapstructure:"PAYMENT_PROVIDER"`
+	PaymentProviderByCountry string `mapstructure:"PAYMENT_PROVIDER_BY_COUNTRY"`
+	TinkoffTerminalKey       string `mapstructure:"TINKOFF_TERMINAL_KEY"`
+	TinkoffTerminalPassword  string `mapstructure:"TINKOFF_TERMINAL_PASSWORD"`
+	PaymentFakeEnabled       bool   `mapstructure:"PAYMENT_FAKE_ENABLED"`
+
+	MarketplaceCommissionPercent float64 `mapstructure:"MARKETPLACE_COMMISSION_PERCENT"`
+	OrderAutoConfirmDays         int     `mapstructure:"ORDER_AUTO_CONFIRM_DAYS"`
+	BlogDayPrice                 float64 `mapstructure:"BLOG_DAY_PRICE"`
+
+	PdfRendererBin       string `mapstructure:"PDF_RENDERER_BIN"`
+	PdfRendererNoSandbox bool   `mapstructure:"PDF_RENDERER_NO_SANDBOX"`
 }
 
 func LoadConfig(path string) (config Config, err error) {
@@ -57,3 +57,12 @@ func DeserializeUser(c *fiber.Ctx) error {
 
 	return c.Next()
 }
+
+// DeserializeOptionalUser authenticates the request like DeserializeUser
+// when it carries an access token and lets guests through otherwise.
+func DeserializeOptionalUser(c *fiber.Ctx) error {
+	if !strings.HasPrefix(c.Get("Authorization"), "Bearer ") && c.Cookies("access_token") == "" {
+		return c.Next()
+	}
+	return DeserializeUser(c)
+}
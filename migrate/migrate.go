@@ -56,12 +56,100 @@ func main() {
 	if err := initializers.DB.AutoMigrate(&models.Notification{}); err != nil {
 		panic(err)
 	}
-	if err := initializers.DB.AutoMigrate(&models.Transaction{}); err != nil {
+	if err := initializers.DB.AutoMigrate(&models.LedgerAccount{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.LedgerEntry{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.LedgerPosting{}); err != nil {
 		panic(err)
 	}
 	if err := initializers.DB.AutoMigrate(&models.Blog{}); err != nil {
 		panic(err)
 	}
+	// Transactions used to be a table written next to every balance update.
+	// They are now a view over the wallet postings of the ledger joined with
+	// the old rows, which are kept as they were in legacy_transactions.
+	// Opening balances are left out: the legacy rows already explain them.
+	// Ledger ids are shifted past the last legacy id so both halves keep
+	// unique ids. A publication fee stays OPENED while its blog is live and
+	// becomes CLOSED_0 once the blog expires, as the table used to record.
+	if err := initializers.DB.Exec(`
+		DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'transactions' AND table_type = 'BASE TABLE') THEN
+				ALTER TABLE transactions RENAME TO legacy_transactions;
+			END IF;
+		END
+		$$;
+
+		CREATE TABLE IF NOT EXISTS legacy_transactions (
+			id bigserial PRIMARY KEY,
+			user_id uuid NOT NULL,
+			element_id bigint NOT NULL,
+			module text NOT NULL,
+			amount double precision NOT NULL,
+			description text NOT NULL,
+			type text NOT NULL,
+			status text,
+			total text,
+			created_at timestamptz NOT NULL DEFAULT now(),
+			updated_at timestamptz NOT NULL DEFAULT now(),
+			deleted_at timestamptz
+		);
+
+		DROP VIEW IF EXISTS transactions;
+		CREATE VIEW transactions AS
+		SELECT
+			l.id AS id,
+			l.user_id AS user_id,
+			l.element_id AS element_id,
+			l.module AS module,
+			l.amount AS amount,
+			l.description AS description,
+			l.type AS type,
+			l.status AS status,
+			l.total AS total,
+			''::text AS kind,
+			0::bigint AS entry_id,
+			l.created_at AS created_at,
+			l.updated_at AS updated_at,
+			l.deleted_at AS deleted_at
+		FROM legacy_transactions l
+		UNION ALL
+		SELECT
+			(SELECT COALESCE(MAX(id), 0) FROM legacy_transactions) + p.id AS id,
+			a.user_id AS user_id,
+			e.element_id AS element_id,
+			e.module AS module,
+			ABS(p.amount)::double precision / 100 AS amount,
+			e.description AS description,
+			CASE WHEN p.amount >= 0 THEN 'profit' ELSE 'deduction' END AS type,
+			CASE
+				WHEN e.reversal_of_id IS NOT NULL OR e.kind = 'refund' THEN 'REFUND'
+				WHEN e.kind = 'publication_fee' AND e.module = 'blog' THEN
+					CASE WHEN EXISTS (
+						SELECT 1 FROM blogs b
+						WHERE b.id = e.element_id
+							AND b.deleted_at IS NULL
+							AND (b.expired_at IS NULL OR b.expired_at >= now())
+					) THEN 'OPENED' ELSE 'CLOSED_0' END
+				ELSE 'CLOSED_1'
+			END AS status,
+			to_char(ABS(p.amount)::numeric / 100, 'FM999999999990.00') AS total,
+			e.kind AS kind,
+			e.id AS entry_id,
+			p.created_at AS created_at,
+			p.created_at AS updated_at,
+			NULL::timestamptz AS deleted_at
+		FROM ledger_postings p
+		JOIN ledger_entries e ON e.id = p.entry_id
+		JOIN ledger_accounts a ON a.id = p.account_id
+		WHERE a.user_id IS NOT NULL AND e.kind <> 'opening_balance';
+	`).Error; err != nil {
+		panic(err)
+	}
 	if err := initializers.DB.AutoMigrate(&models.Favorite{}); err != nil {
 		panic(err)
 	}
@@ -77,6 +165,12 @@ func main() {
 	if err := initializers.DB.AutoMigrate(&models.Payments{}); err != nil {
 		panic(err)
 	}
+	if err := initializers.DB.AutoMigrate(&models.PaymentNotification{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.PaymentDiscrepancy{}); err != nil {
+		panic(err)
+	}
 	if err := initializers.DB.AutoMigrate(&models.Guilds{}); err != nil {
 		panic(err)
 	}
@@ -86,9 +180,21 @@ func main() {
 	if err := initializers.DB.AutoMigrate(&models.OnlineStorage{}); err != nil {
 		panic(err)
 	}
+	// The plain index on codes.code is replaced by a unique one
+	if initializers.DB.Migrator().HasIndex(&models.Codes{}, "idx_codes_code") {
+		if err := initializers.DB.Migrator().DropIndex(&models.Codes{}, "idx_codes_code"); err != nil {
+			panic(err)
+		}
+	}
 	if err := initializers.DB.AutoMigrate(&models.Codes{}); err != nil {
 		panic(err)
 	}
+	if err := initializers.DB.AutoMigrate(&models.PromoCampaign{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.PromoRedemption{}); err != nil {
+		panic(err)
+	}
 	if err := initializers.DB.AutoMigrate(&models.Hashtags{}); err != nil {
 		panic(err)
 	}
@@ -119,15 +225,137 @@ func main() {
 	if err := initializers.DB.AutoMigrate(&models.ChatRoom{}); err != nil {
 		panic(err)
 	}
+	hasUnreadCount := initializers.DB.Migrator().HasColumn(&models.ChatRoomMember{}, "UnreadCount")
 	if err := initializers.DB.AutoMigrate(&models.ChatRoomMember{}); err != nil {
 		panic(err)
 	}
+	// Unread counters used to be counted on every room list request
+	if !hasUnreadCount {
+		if err := initializers.DB.Exec(`
+			UPDATE chat_room_members mem
+			SET unread_count = (
+				SELECT COUNT(*) FROM chat_messages m
+				WHERE m.room_id = mem.room_id AND m.user_id != mem.user_id
+					AND m.id > COALESCE(mem.last_read_message_id, 0)
+					AND NOT m.is_deleted AND m.msg_type != 3
+			),
+			last_delivered_message_id = mem.last_read_message_id
+		`).Error; err != nil {
+			panic(err)
+		}
+	}
+	if err := initializers.DB.AutoMigrate(&models.ChatAttachment{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ChatMessageReaction{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.UserBlock{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ChatReport{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ChatExport{}); err != nil {
+		panic(err)
+	}
 	if err := initializers.DB.AutoMigrate(&models.ChatCDC{}); err != nil {
 		panic(err)
 	}
 	if err := initializers.DB.AutoMigrate(&models.ChatOutbox{}); err != nil {
 		panic(err)
 	}
+	// Wake up the Centrifugo PostgreSQL consumer as soon as an outbox row is
+	// committed instead of waiting for the next partition poll.
+	if err := initializers.DB.Exec(`
+		CREATE OR REPLACE FUNCTION centrifugo_notify_partition_change()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			PERFORM pg_notify('centrifugo_partition_change', NEW.partition::text);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE TRIGGER centrifugo_notify_partition_trigger
+		AFTER INSERT ON chat_outboxes
+		FOR EACH ROW
+		EXECUTE FUNCTION centrifugo_notify_partition_change();
+	`).Error; err != nil {
+		panic(err)
+	}
+	// Full-text index of chat messages. The text search config is chosen by
+	// the script of the message: Georgian has no stemmer and uses simple.
+	// The trigger reindexes edited messages and drops deleted and system ones
+	// from the index.
+	if err := initializers.DB.Exec(`
+		ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS search_config regconfig;
+		ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS search_vector tsvector;
+		CREATE INDEX IF NOT EXISTS idx_chat_messages_search_vector ON chat_messages USING GIN (search_vector);
+
+		CREATE OR REPLACE FUNCTION chat_search_config(content text)
+		RETURNS regconfig AS $$
+			SELECT CASE
+				WHEN content ~ '[ა-ჿ]' THEN 'simple'
+				WHEN content ~* '[а-яё]' THEN 'russian'
+				WHEN content ~* '[ñáéíóú¿¡]' THEN 'spanish'
+				ELSE 'english'
+			END::regconfig
+		$$ LANGUAGE sql IMMUTABLE;
+
+		CREATE OR REPLACE FUNCTION chat_messages_search_update()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			IF NEW.is_deleted OR NEW.msg_type = 3 THEN
+				NEW.search_config := NULL;
+				NEW.search_vector := NULL;
+			ELSE
+				NEW.search_config := chat_search_config(NEW.content);
+				NEW.search_vector := to_tsvector(NEW.search_config, NEW.content);
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE TRIGGER chat_messages_search_trigger
+		BEFORE INSERT OR UPDATE OF content, is_deleted, msg_type ON chat_messages
+		FOR EACH ROW
+		EXECUTE FUNCTION chat_messages_search_update();
+
+		UPDATE chat_messages
+		SET search_config = chat_search_config(content),
+			search_vector = to_tsvector(chat_search_config(content), content)
+		WHERE search_vector IS NULL AND NOT is_deleted AND msg_type != 3;
+	`).Error; err != nil {
+		panic(err)
+	}
+	// Unread counters of room members: a new message is unread for everyone
+	// but its author until read, a deleted one stops being unread.
+	if err := initializers.DB.Exec(`
+		CREATE OR REPLACE FUNCTION chat_messages_unread_update()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			IF NEW.msg_type = 3 THEN
+				RETURN NEW;
+			END IF;
+			IF TG_OP = 'INSERT' THEN
+				UPDATE chat_room_members SET unread_count = unread_count + 1
+				WHERE room_id = NEW.room_id AND user_id != NEW.user_id;
+			ELSIF NEW.is_deleted AND NOT OLD.is_deleted THEN
+				UPDATE chat_room_members SET unread_count = GREATEST(unread_count - 1, 0)
+				WHERE room_id = NEW.room_id AND user_id != NEW.user_id
+					AND COALESCE(last_read_message_id, 0) < NEW.id;
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		CREATE OR REPLACE TRIGGER chat_messages_unread_trigger
+		AFTER INSERT OR UPDATE OF is_deleted ON chat_messages
+		FOR EACH ROW
+		EXECUTE FUNCTION chat_messages_unread_update();
+	`).Error; err != nil {
+		panic(err)
+	}
 	if err := initializers.DB.AutoMigrate(&models.Presavedfilters{}); err != nil {
 		panic(err)
 	}
@@ -143,12 +371,108 @@ func main() {
 	if err := initializers.DB.AutoMigrate(&models.OrderItem{}); err != nil {
 		panic(err)
 	}
+	if err := initializers.DB.AutoMigrate(&models.OrderHistory{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.Product{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ProductVariant{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ProductPhoto{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.Document{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.DocumentCounter{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ShippingZone{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ShippingZoneLocation{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.ShippingMethod{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.Review{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.Cart{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.CartItem{}); err != nil {
+		panic(err)
+	}
+	// Orders created before the order lifecycle were "pending".
+	if err := initializers.DB.Exec(`UPDATE orders SET status = 'created' WHERE status = 'pending'`).Error; err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.Exec(`UPDATE orders SET status_changed_at = updated_at WHERE status_changed_at IS NULL`).Error; err != nil {
+		panic(err)
+	}
+	// Orders created before shipping rates had no shipping cost.
+	if err := initializers.DB.Exec(`UPDATE orders SET items_amount = total_amount WHERE items_amount = 0 AND shipping_amount = 0`).Error; err != nil {
+		panic(err)
+	}
 	if err := initializers.DB.AutoMigrate(&models.PostTag{}); err != nil {
 		panic(err)
 	}
 	if err := initializers.DB.AutoMigrate(&models.DeliveryAddress{}); err != nil {
 		panic(err)
 	}
+	if err := initializers.DB.AutoMigrate(&models.SubscriptionPlan{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.SubscriptionPlanPrice{}); err != nil {
+		panic(err)
+	}
+	if err := initializers.DB.AutoMigrate(&models.Subscription{}); err != nil {
+		panic(err)
+	}
+
+	// Seed the plans that used to be hard-coded in controllers.Plan
+	plans := []models.SubscriptionPlan{
+		{
+			Code:         "basic",
+			Name:         models.MultilangTitle{Ru: "Начальный", En: "Basic", Ka: "საწყისი", Es: "Básico"},
+			LimitStorage: 300,
+			PeriodDays:   31,
+			GraceDays:    3,
+			SortOrder:    1,
+			Prices:       []models.SubscriptionPlanPrice{{Currency: "RUB", Amount: 15000}},
+		},
+		{
+			Code:         "business",
+			Name:         models.MultilangTitle{Ru: "Бизнесс", En: "Business", Ka: "ბიზნესი", Es: "Negocio"},
+			LimitStorage: 600,
+			PeriodDays:   31,
+			GraceDays:    3,
+			SortOrder:    2,
+			Prices:       []models.SubscriptionPlanPrice{{Currency: "RUB", Amount: 50000}},
+		},
+		{
+			Code:         "extended",
+			Name:         models.MultilangTitle{Ru: "Расширенный", En: "Extended", Ka: "გაფართოებული", Es: "Ampliado"},
+			LimitStorage: 900,
+			PeriodDays:   31,
+			GraceDays:    3,
+			SortOrder:    3,
+			Prices:       []models.SubscriptionPlanPrice{{Currency: "RUB", Amount: 100000}},
+		},
+	}
+	for _, plan := range plans {
+		var count int64
+		initializers.DB.Model(&models.SubscriptionPlan{}).Where("code = ?", plan.Code).Count(&count)
+		if count == 0 {
+			if err := initializers.DB.Create(&plan).Error; err != nil {
+				panic(err)
+			}
+		}
+	}
 
 
 	// Check if there are any users in the database
@@ -222,6 +546,9 @@ func main() {
 			Amount: 5000,
 		}
 		initializers.DB.Create(&billing)
+		if _, err := utils.UserWalletAccount(initializers.DB, admin.ID); err != nil {
+			log.Fatal("Could not open admin wallet:", err)
+		}
 
 		// Create a profile record for the first user
 		profile := models.Profile{
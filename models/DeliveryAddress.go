@@ -10,6 +10,9 @@ type DeliveryAddress struct {
 	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
 	UserID      uuid.UUID  `gorm:"type:uuid;not null;index"`  // Связь с пользователем
 	AddressName string     `gorm:"type:varchar(255);not null"`  // Название адреса (например, "Дом" или "Офис")
+	CountryCode string     `gorm:"type:varchar(2);not null;default:RU"` // Код страны ISO 3166-1 alpha-2
+	Region      string     `gorm:"type:varchar(100)"`          // Регион, область или штат
+	CityID      *uint      `gorm:"index"`                       // Город из справочника City, если выбран
 	City        string     `gorm:"type:varchar(100);not null"`  // Город
 	Street      string     `gorm:"type:varchar(255);not null"`  // Улица
 	Building    string     `gorm:"type:varchar(50);not null"`   // Номер дома
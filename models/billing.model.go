@@ -9,7 +9,8 @@ import (
 type Billing struct {
     ID        uint64         `gorm:"primaryKey"`
     UserID    uuid.UUID  	 `gorm:"type:uuid;not null"`
-    Amount    float64        `gorm:"not null"`
+    Amount    float64        `gorm:"not null"`            // derived from Balance, kept for API responses
+    Balance   int64          `gorm:"not null;default:0"`  // wallet ledger balance in kopecks
     CreatedAt time.Time      `gorm:"autoCreateTime"`
     UpdatedAt time.Time      `gorm:"autoUpdateTime"`
     DeletedAt *time.Time `gorm:"index"`
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Блокировка пользователя. Заблокированный не может писать блокирующему,
+// подписываться на него, комментировать его посты и видеть его профиль.
+type UserBlock struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	BlockerID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_block" json:"blocker_id"`
+	BlockedID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_block;index" json:"blocked_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Жалоба на запрос переписки, разбирается администратором.
+type ChatReport struct {
+	ID         uint64     `gorm:"primaryKey" json:"id"`
+	RoomID     uint64     `gorm:"not null;index" json:"room_id"`
+	ReporterID uuid.UUID  `gorm:"type:uuid;not null" json:"reporter_id"`
+	ReportedID uuid.UUID  `gorm:"type:uuid;not null;index" json:"reported_id"`
+	Reason     string     `gorm:"type:text" json:"reason"`
+	Status     string     `gorm:"type:varchar(20);not null;default:open;index" json:"status"` // open или resolved
+	ResolvedBy *uuid.UUID `gorm:"type:uuid" json:"resolved_by"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
@@ -39,6 +39,8 @@ type Blog struct {
 	DeletedAt        *time.Time     `gorm:"index"`
 	ExpiredAt        *time.Time     `gorm:"index"`
 	Hashtags         []Hashtags     `gorm:"many2many:blog_hashtags;"`
+	Rating           float64        `gorm:"type:numeric(3,2);not null;default:0"` // Средняя оценка в отзывах о товарах объявления
+	ReviewsCount     int            `gorm:"not null;default:0"`
 }
 
 type BlogResponse struct {
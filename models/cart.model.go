@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Корзина покупателя. У гостя вместо UserID есть GuestToken, после входа
+// гостевая корзина объединяется с корзиной пользователя.
+type Cart struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	UserID     *uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"user_id"`
+	GuestToken *string    `gorm:"type:varchar(64);uniqueIndex" json:"-"`
+	Items      []CartItem `gorm:"foreignKey:CartID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"items"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Товар в корзине. Price запоминает цену на момент добавления, чтобы
+// показать покупателю, что она изменилась.
+type CartItem struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	CartID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_cart_variant" json:"cart_id"`
+	VariantID uint64    `gorm:"not null;uniqueIndex:idx_cart_variant" json:"variant_id"`
+	Quantity  int       `gorm:"not null" json:"quantity"`
+	Price     int64     `gorm:"not null" json:"price"` // копейки
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -10,14 +10,22 @@ import (
 type ChatRoomMember struct {
 	ID                uint64 `gorm:"primaryKey"`
 	RoomID            uint64
-	UserID            uuid.UUID
+	UserID            uuid.UUID `gorm:"index"`
 	Room              ChatRoom  `gorm:"foreignKey:RoomID"`
 	User              User      `gorm:"foreignKey:UserID"`
 	IsSubscribed      bool      `gorm:"not null;default:false"`
 	IsNew             bool      `gorm:"not null;default:false"`
 	JoinedAt          time.Time `gorm:"not null;default:now()"`
 	LastReadMessageID *uint64
-	IsUnread          bool `gorm:"not null;default:false"`
+	IsUnread          bool       `gorm:"not null;default:false"`
+	Role              string     `gorm:"size:16;not null;default:member"` // group rooms: owner, admin or member
+	MutedUntil        *time.Time // muted members can not send messages until then
+	// Receipts are watermarks: every message up to the ID is delivered to or
+	// read by the member. Reading implies delivery.
+	LastDeliveredMessageID *uint64
+	LastDeliveredAt        *time.Time
+	LastReadAt             *time.Time
+	UnreadCount            int `gorm:"not null;default:0"` // maintained by the chat_messages triggers
 }
 
 type ChatRoom struct {
@@ -29,31 +37,76 @@ type ChatRoom struct {
 	BumpedAt      time.Time        `gorm:"not null;default:now()"`
 	LastMessageID *uint64
 	LastMessage   *ChatMessage `gorm:"foreignKey:LastMessageID"`
+	IsGroup       bool         `gorm:"not null;default:false"`
+	Title         string       `gorm:"size:128"` // group rooms only, Name stays a unique technical key
+	Avatar        string
+	OwnerID       *uuid.UUID `gorm:"type:uuid;index"`
+	MemberLimit   int        `gorm:"not null;default:0"`          // 0 for direct rooms
+	Retention     string     `gorm:"size:8;not null;default:off"` // off, 24h, 7d or 90d, older messages are purged
 }
 
 type ChatMessage struct {
 	ID      uint64 `gorm:"primaryKey"`
 	Content string `gorm:"not null"`
 	UserID  uuid.UUID
-	RoomID  uint64
-	User    User `gorm:"foreignKey:UserID"`
+	RoomID  uint64 `gorm:"index:idx_chat_messages_room_created"`
+	User    User   `gorm:"foreignKey:UserID"`
 	// Room      ChatRoom   `gorm:"foreignKey:RoomID"`
 	IsEdited  bool       `gorm:"not null;default:false"`
 	IsDeleted bool       `gorm:"not null;default:false"`
-	CreatedAt time.Time  `gorm:"not null;default:now()"`
+	CreatedAt time.Time  `gorm:"not null;default:now();index:idx_chat_messages_room_created"`
 	DeletedAt *time.Time `gorm:"index"`
-	MsgType   uint8      `gorm:"not null;default:0"` // 0: common, 1: conference, 2: attached post link
+	MsgType   uint8      `gorm:"not null;default:0"` // 0: common, 1: conference, 2: attached post link, 3: system (group events)
 	JsonData  *string    `gorm:"type:jsonb"`
 	// IsRead    bool       `gorm:"not null;default:false"`
 	ParentMessageID *uint64
-	ParentMessage   *ChatMessage `gorm:"foreignKey:ParentMessageID"`
+	ParentMessage   *ChatMessage     `gorm:"foreignKey:ParentMessageID"`
+	Attachments     []ChatAttachment `gorm:"foreignKey:MessageID"`
+	PinnedAt        *time.Time       `gorm:"index"`
+	PinnedByID      *uuid.UUID       `gorm:"type:uuid"`
+	// Forwarded messages keep the original message and its author, also when
+	// a forwarded message is forwarded again.
+	ForwardedFromID     *uint64
+	ForwardedFromUserID *uuid.UUID `gorm:"type:uuid"`
+	// Deleted and expired messages keep the row for replies and receipts, the
+	// content, attachments and reactions are wiped.
+	PurgedAt *time.Time `gorm:"index"`
+}
+
+// ChatMessageReaction is an emoji reaction of a user to a message. A user
+// toggles every emoji separately.
+type ChatMessageReaction struct {
+	ID        uint64    `gorm:"primaryKey"`
+	MessageID uint64    `gorm:"not null;uniqueIndex:idx_chat_reaction"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_chat_reaction"`
+	Emoji     string    `gorm:"size:32;not null;uniqueIndex:idx_chat_reaction"`
+	CreatedAt time.Time `gorm:"not null;default:now()"`
+}
+
+// ChatAttachment is a file uploaded to a room. It stays unattached until the
+// uploader sends a message with it.
+type ChatAttachment struct {
+	ID            uint64    `gorm:"primaryKey"`
+	RoomID        uint64    `gorm:"not null;index"`
+	MessageID     *uint64   `gorm:"index"`
+	UserID        uuid.UUID `gorm:"type:uuid;not null"`
+	Kind          string    `gorm:"size:16;not null"` // image, file or voice
+	FileName      string    `gorm:"not null"`
+	MimeType      string    `gorm:"size:128;not null"`
+	Size          int64     `gorm:"not null"`
+	Path          string    `gorm:"not null"` // relative to CHAT_STORE_PATH
+	ThumbnailPath string
+	Width         int
+	Height        int
+	Duration      int       // seconds, voice notes only
+	CreatedAt     time.Time `gorm:"not null;default:now()"`
 }
 
 type ChatOutbox struct {
 	ID        uint64 `gorm:"primaryKey"`
 	Method    string `gorm:"type:text;default:publish"`
 	Payload   datatypes.JSON
-	Partition int64 `gorm:"default:0"`
+	Partition int64 `gorm:"default:0;index"`
 	CreatedAt time.Time
 }
 
@@ -64,3 +117,20 @@ type ChatCDC struct {
 	Partition int64 `gorm:"default:0"`
 	CreatedAt time.Time
 }
+
+// ChatExport is an archive of the chat history of a user, one room or all
+// of their rooms, built in the background. Admins export a reported room.
+type ChatExport struct {
+	ID          uint64    `gorm:"primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;index"` // who requested and downloads the archive
+	RoomID      *uint64   // nil for all rooms of the user
+	ReportID    *uint64   // admin exports of a reported room
+	Status      string    `gorm:"size:16;not null;default:pending;index"` // pending, processing, ready, failed or expired
+	Path        string    // relative to the chat store
+	Size        int64     `gorm:"not null;default:0"`
+	Error       string
+	CreatedAt   time.Time `gorm:"not null;default:now()"`
+	StartedAt   *time.Time
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time `gorm:"index"`
+}
@@ -8,7 +8,7 @@ import (
 
 type Codes struct {
     ID        uint       `gorm:"primary_key"`
-    Code      string	 `gorm:"not null"`
+    Code      string	 `gorm:"not null;uniqueIndex:idx_codes_code_unique"`
     Balance   string 	 `gorm:"not null"`
     UserId    uuid.UUID  `gorm:"not null"`
 	Activated bool		 `gorm:"not null"`
@@ -16,4 +16,42 @@ type Codes struct {
     Used 	  uint64  	 `gorm:"null"`
     UpdatedAt time.Time  `gorm:"not null"`
     DeletedAt *time.Time `gorm:"index"`
-}
\ No newline at end of file
+
+    CampaignID     *uint64 `gorm:"index"`
+    MaxRedemptions int     `gorm:"not null;default:1"` // 0 means unlimited
+    Redemptions    int     `gorm:"not null;default:0"`
+}
+
+// PromoCampaign groups codes that grant the same reward. Kind is one of
+// "balance" (Balance kopecks), "plan_days" (PlanDays of PlanCode) and
+// "fee_discount" (DiscountPercent off the next publication fee).
+type PromoCampaign struct {
+	ID              uint64     `gorm:"primaryKey" json:"id"`
+	Name            string     `gorm:"not null" json:"name"`
+	Kind            string     `gorm:"size:32;not null" json:"kind"`
+	Balance         int64      `gorm:"not null;default:0" json:"balance"`
+	PlanCode        string     `gorm:"size:64" json:"plan_code"`
+	PlanDays        int        `gorm:"not null;default:0" json:"plan_days"`
+	DiscountPercent int        `gorm:"not null;default:0" json:"discount_percent"`
+	StartsAt        *time.Time `json:"starts_at"`
+	EndsAt          *time.Time `json:"ends_at"`
+	MaxRedemptions  int        `gorm:"not null;default:0" json:"max_redemptions"` // 0 means unlimited
+	PerUserLimit    int        `gorm:"not null;default:1" json:"per_user_limit"`  // 0 means unlimited
+	Redemptions     int        `gorm:"not null;default:0" json:"redemptions"`
+	CreatedBy       uuid.UUID  `gorm:"type:uuid" json:"created_by"`
+	CreatedAt       time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// PromoRedemption is one use of a code by a user. Fee discounts stay
+// unconsumed until the next publication fee is charged.
+type PromoRedemption struct {
+	ID              uint64     `gorm:"primaryKey" json:"id"`
+	CodeID          uint       `gorm:"not null;uniqueIndex:idx_promo_redemption_code_user" json:"code_id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_promo_redemption_code_user;index:idx_promo_redemption_user_kind" json:"user_id"`
+	CampaignID      *uint64    `gorm:"index" json:"campaign_id"`
+	Kind            string     `gorm:"size:32;not null;index:idx_promo_redemption_user_kind" json:"kind"`
+	DiscountPercent int        `gorm:"not null;default:0" json:"discount_percent"`
+	ConsumedAt      *time.Time `json:"consumed_at"`
+	CreatedAt       time.Time  `gorm:"not null;default:now()" json:"created_at"`
+}
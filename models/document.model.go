@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Document is an issued invoice or receipt. Its number is sequential within
+// Series, which is the issuing seller or the platform. The PDF is rendered
+// from the referenced order or ledger entry on every download.
+type Document struct {
+	ID             uint64     `gorm:"primaryKey" json:"id"`
+	Kind           string     `gorm:"size:16;not null" json:"kind"`    // invoice, receipt
+	Purpose        string     `gorm:"size:32;not null" json:"purpose"` // order, topup, plan_purchase, donation
+	Series         string     `gorm:"size:64;not null;uniqueIndex:idx_document_number" json:"series"`
+	Number         int        `gorm:"not null;uniqueIndex:idx_document_number" json:"number"`
+	Code           string     `gorm:"size:64;not null" json:"code"`
+	IssuerID       *uuid.UUID `gorm:"type:uuid;index" json:"issuer_id"` // nil for the platform
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	OrderID        *uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"order_id"`
+	LedgerEntryID  *uint64    `gorm:"uniqueIndex" json:"ledger_entry_id"`
+	Title          string     `gorm:"not null" json:"title"`
+	Amount         int64      `gorm:"not null" json:"amount"` // minor units
+	Currency       string     `gorm:"size:3;not null;default:RUB" json:"currency"`
+	EmailedAt      *time.Time `gorm:"index" json:"emailed_at"`
+	EmailClaimedAt *time.Time `json:"-"` // taken for emailing by a node
+	CreatedAt      time.Time  `gorm:"not null;default:now()" json:"created_at"`
+}
+
+// DocumentCounter keeps the last document number of a series.
+type DocumentCounter struct {
+	Series string `gorm:"size:64;primaryKey"`
+	Last   int    `gorm:"not null;default:0"`
+}
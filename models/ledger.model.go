@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// LedgerAccount holds money in integer minor units (kopecks). User wallets
+// have UserID set, platform and external accounts are addressed by Code.
+type LedgerAccount struct {
+	ID            uint64     `gorm:"primaryKey" json:"id"`
+	Code          string     `gorm:"size:128;uniqueIndex;not null" json:"code"`
+	Type          string     `gorm:"size:32;not null" json:"type"` // wallet, revenue, expense, external, equity
+	UserID        *uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	Currency      string     `gorm:"size:3;not null;default:RUB" json:"currency"`
+	Balance       int64      `gorm:"not null;default:0" json:"balance"`
+	AllowNegative bool       `gorm:"not null;default:false" json:"allow_negative"`
+	CreatedAt     time.Time  `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// LedgerEntry is one journal entry. Its postings always sum to zero.
+type LedgerEntry struct {
+	ID           uint64          `gorm:"primaryKey" json:"id"`
+	Kind         string          `gorm:"size:32;not null;index" json:"kind"`
+	Description  string          `gorm:"not null" json:"description"`
+	Module       string          `gorm:"size:64;not null;default:''" json:"module"`
+	ElementId    uint64          `gorm:"not null;default:0" json:"element_id"`
+	Reference    *string         `gorm:"size:128;uniqueIndex" json:"reference"`
+	ReversalOfID *uint64         `gorm:"index" json:"reversal_of_id"`
+	CreatedAt    time.Time       `gorm:"not null;default:now()" json:"created_at"`
+	Postings     []LedgerPosting `gorm:"foreignKey:EntryID" json:"postings"`
+}
+
+// LedgerPosting moves Amount minor units into (positive) or out of
+// (negative) an account.
+type LedgerPosting struct {
+	ID        uint64        `gorm:"primaryKey" json:"id"`
+	EntryID   uint64        `gorm:"not null;index" json:"entry_id"`
+	AccountID uint64        `gorm:"not null;index" json:"account_id"`
+	Account   LedgerAccount `gorm:"foreignKey:AccountID" json:"-"`
+	Amount    int64         `gorm:"not null" json:"amount"`
+	CreatedAt time.Time     `gorm:"not null;default:now()" json:"created_at"`
+}
@@ -11,13 +11,24 @@ type Order struct {
 	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
 	UserID      uuid.UUID  `gorm:"type:uuid;null;index"`  // Заказчик
 	SellerID    uuid.UUID  `gorm:"type:uuid;not null;index"`  // Продавец
-	TotalAmount float64    `gorm:"not null"`                  // Общая сумма заказа
-	Status      string     `gorm:"type:varchar(50);default:'pending'"` // Статус заказа (pending, completed, canceled)
+	TotalAmount float64    `gorm:"not null"`                  // Общая сумма заказа, товары и доставка
+	ItemsAmount float64    `gorm:"not null;default:0"`        // Сумма товаров
+	ShippingAmount float64 `gorm:"not null;default:0"`        // Стоимость доставки
+	ShippingMethodID *uint64 `gorm:"index"`                   // Способ доставки продавца
+	Status      string     `gorm:"type:varchar(50);default:'created'"` // Статус заказа, см. utils.OrderStatus*
+	ShippingMethod string  `gorm:"type:varchar(100)"`                 // Способ доставки
+	PaymentMethod  string  `gorm:"type:varchar(100)"`                 // Способ оплаты
+	StatusChangedAt *time.Time `gorm:"index"`                         // Время последнего изменения статуса
+	EscrowStatus   string  `gorm:"type:varchar(20)"`                  // held, released или returned, пустой без эскроу
+	EscrowAmount   int64   `gorm:"not null;default:0"`                // Удержано с покупателя, копейки
+	Commission     int64   `gorm:"not null;default:0"`                // Комиссия площадки, копейки
+	EscrowEntryID  *uint64                                            // Проводка удержания средств
 	CreatedAt   time.Time  `gorm:"autoCreateTime"`            // Время создания
 	UpdatedAt   time.Time  `gorm:"autoUpdateTime"`            // Время обновления
 	OrderItems  []OrderItem `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"` // Товары в заказе
 	DeliveryAddressID uuid.UUID `gorm:"type:uuid;not null"`        // Ссылка на адрес доставки
 	DeliveryAddress  DeliveryAddress `gorm:"foreignKey:DeliveryAddressID"`    // Связь с моделью адреса доставки
+	History     []OrderHistory `gorm:"foreignKey:OrderID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:",omitempty"` // История статусов
 
 }
 
@@ -25,8 +36,23 @@ type Order struct {
 type OrderItem struct {
 	ID       uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
 	OrderID  uuid.UUID `gorm:"type:uuid;not null;index"`       // Ссылка на заказ
+	ProductID *uint64  `gorm:"index"`                         // Товар из каталога продавца
+	VariantID *uint64  `gorm:"index"`                         // Вариант товара (SKU)
+	SKU      string    `gorm:"type:varchar(64)"`              // Артикул на момент заказа
 	Product  string    `gorm:"type:varchar(255);not null"`     // Название товара
 	Price    float64   `gorm:"not null"`                      // Цена товара
 	Quantity int       `gorm:"not null"`                      // Количество товара
 	CreatedAt time.Time `gorm:"autoCreateTime"`                // Время добавления товара
 }
+
+// История изменения статуса заказа
+type OrderHistory struct {
+	ID         uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	OrderID    uuid.UUID `gorm:"type:uuid;not null;index"`   // Ссылка на заказ
+	FromStatus string    `gorm:"type:varchar(50)"`           // Предыдущий статус, пустой при создании
+	ToStatus   string    `gorm:"type:varchar(50);not null"`  // Новый статус
+	ActorID    uuid.UUID `gorm:"type:uuid;not null"`         // Кто изменил статус
+	ActorRole  string    `gorm:"type:varchar(20);not null"`  // buyer, seller или admin
+	Comment    string    `gorm:"type:text"`                  // Комментарий к изменению
+	CreatedAt  time.Time `gorm:"autoCreateTime"`             // Время изменения
+}
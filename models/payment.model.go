@@ -7,12 +7,48 @@ import (
 )
 
 type Payments struct {
-	ID        uint64     `gorm:"primaryKey"`
-	UserID    uuid.UUID  `gorm:"type:uuid;not null"`
-	Amount    float64    `gorm:"not null"`
-	PaymentId string     `gorm:"not null"`
-	Status    string     `gorm:"not null"`
-	CreatedAt time.Time  `gorm:"autoCreateTime"`
-	UpdatedAt time.Time  `gorm:"autoUpdateTime"`
-	DeletedAt *time.Time `gorm:"index"`
+	ID             uint64     `gorm:"primaryKey"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null"`
+	Amount         float64    `gorm:"not null"`
+	RefundedAmount float64    `gorm:"not null;default:0"`
+	Currency       string     `gorm:"size:3;not null;default:RUB"`
+	Provider       string     `gorm:"not null;default:tinkoff"`
+	PaymentId      string     `gorm:"not null"`
+	Status         string     `gorm:"not null"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime"`
+	DeletedAt      *time.Time `gorm:"index"`
+
+	// A refund sent to the acquirer and not yet answered: the sum already
+	// taken from the wallet, its ledger entry and when it was requested.
+	PendingRefundAmount  float64 `gorm:"not null;default:0;index"`
+	PendingRefundEntryID *uint64
+	RefundRequestedAt    *time.Time
+}
+
+// PaymentNotification records every acquirer notification that was applied,
+// so a replayed or concurrent callback for the same transition is a no-op.
+type PaymentNotification struct {
+	ID        uint64    `gorm:"primaryKey"`
+	Provider  string    `gorm:"not null;default:tinkoff;uniqueIndex:idx_payment_notification"`
+	PaymentId string    `gorm:"not null;uniqueIndex:idx_payment_notification"`
+	Status    string    `gorm:"not null;uniqueIndex:idx_payment_notification"`
+	Amount    uint64    `gorm:"not null;uniqueIndex:idx_payment_notification"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// PaymentDiscrepancy is a difference found by the payment reconciliation
+// job, either between the local and the acquirer status of a payment or
+// between a cached wallet balance and its postings.
+type PaymentDiscrepancy struct {
+	ID           uint64     `gorm:"primaryKey" json:"id"`
+	Kind         string     `gorm:"size:32;not null;index" json:"kind"` // status, refund, balance
+	PaymentId    string     `gorm:"index" json:"payment_id"`
+	UserID       *uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	LocalStatus  string     `json:"local_status"`
+	RemoteStatus string     `json:"remote_status"`
+	LocalAmount  int64      `json:"local_amount"`  // kopecks
+	RemoteAmount int64      `json:"remote_amount"` // kopecks
+	Resolved     bool       `gorm:"not null;default:false" json:"resolved"`
+	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
 }
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jackc/pgtype"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Товар продавца. Цены и остатки хранятся в вариантах (SKU).
+type Product struct {
+	ID        uint64           `gorm:"primaryKey" json:"id"`
+	SellerID  uuid.UUID        `gorm:"type:uuid;not null;index" json:"seller_id"` // Продавец
+	BlogID    *uint64          `gorm:"index" json:"blog_id"`                      // Объявление, к которому привязан товар
+	Title     string           `gorm:"type:varchar(255);not null" json:"title"`
+	Descr     string           `gorm:"type:text" json:"descr"`
+	Currency  string           `gorm:"size:3;not null;default:RUB" json:"currency"`
+	Active    bool             `gorm:"not null;default:true" json:"active"`
+	Variants  []ProductVariant `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"variants"`
+	Photos    []ProductPhoto   `gorm:"foreignKey:ProductID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"photos"`
+	CreatedAt time.Time        `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time        `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Вариант товара (SKU) со своей ценой и остатком
+type ProductVariant struct {
+	ID        uint64    `gorm:"primaryKey" json:"id"`
+	ProductID uint64    `gorm:"not null;index" json:"product_id"`
+	SKU       string    `gorm:"type:varchar(64);not null;uniqueIndex" json:"sku"`
+	Name      string    `gorm:"type:varchar(255)" json:"name"` // Например, размер или цвет
+	Price     int64     `gorm:"not null" json:"price"`         // Цена в копейках
+	Stock     int       `gorm:"not null;default:0" json:"stock"`
+	Weight    int       `gorm:"not null;default:0" json:"weight"` // Вес единицы товара в граммах
+	Active    bool      `gorm:"not null;default:true" json:"active"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Фотографии товара. Files хранит пути к загруженным файлам в том же
+// формате, что и BlogPhoto.
+type ProductPhoto struct {
+	ID        uint64       `gorm:"primaryKey" json:"id"`
+	ProductID uint64       `gorm:"not null;index" json:"product_id"`
+	Files     pgtype.JSONB `json:"files" gorm:"type:jsonb"`
+	CreatedAt time.Time    `gorm:"not null" json:"created_at"`
+	UpdatedAt time.Time    `gorm:"not null" json:"updated_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jackc/pgtype"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Отзыв покупателя о продавце и объявлении по завершённому заказу.
+// На один заказ можно оставить один отзыв.
+type Review struct {
+	ID               uint64       `gorm:"primaryKey" json:"id"`
+	OrderID          uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex" json:"order_id"` // Заказ, по которому оставлен отзыв
+	UserID           uuid.UUID    `gorm:"type:uuid;not null;index" json:"user_id"`        // Покупатель
+	SellerID         uuid.UUID    `gorm:"type:uuid;not null;index" json:"seller_id"`      // Продавец
+	ProductID        *uint64      `gorm:"index" json:"product_id"`                        // Товар из заказа
+	BlogID           *uint64      `gorm:"index" json:"blog_id"`                           // Объявление товара
+	Stars            int          `gorm:"not null" json:"stars"`                          // Оценка от 1 до 5
+	Text             string       `gorm:"type:text" json:"text"`
+	Photos           pgtype.JSONB `gorm:"type:jsonb" json:"photos"` // Пути к файлам, как в ProductPhoto
+	Reply            string       `gorm:"type:text" json:"reply"`   // Публичный ответ продавца
+	RepliedAt        *time.Time   `json:"replied_at"`
+	Status           string       `gorm:"type:varchar(20);not null;default:published;index" json:"status"` // published или hidden
+	ModeratorID      *uuid.UUID   `gorm:"type:uuid" json:"moderator_id"`
+	ModerationReason string       `gorm:"type:text" json:"moderation_reason"`
+	ModeratedAt      *time.Time   `json:"moderated_at"`
+	CreatedAt        time.Time    `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time    `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Зона доставки продавца: набор стран и городов, куда он доставляет
+type ShippingZone struct {
+	ID        uint64                 `gorm:"primaryKey" json:"id"`
+	SellerID  uuid.UUID              `gorm:"type:uuid;not null;index" json:"seller_id"`
+	Name      string                 `gorm:"type:varchar(255);not null" json:"name"`
+	Locations []ShippingZoneLocation `gorm:"foreignKey:ZoneID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"locations"`
+	Methods   []ShippingMethod       `gorm:"foreignKey:ZoneID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"methods"`
+	CreatedAt time.Time              `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time              `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Страна, регион или город зоны доставки. Без CityID и Region зона
+// покрывает всю страну.
+type ShippingZoneLocation struct {
+	ID          uint64 `gorm:"primaryKey" json:"id"`
+	ZoneID      uint64 `gorm:"not null;index" json:"zone_id"`
+	CountryCode string `gorm:"type:varchar(2);not null" json:"country_code"` // ISO 3166-1 alpha-2
+	Region      string `gorm:"type:varchar(100)" json:"region"`              // Регион адреса доставки
+	CityID      *uint  `gorm:"index" json:"city_id"`
+}
+
+// Способ доставки в зоне и его тариф. Суммы в копейках.
+type ShippingMethod struct {
+	ID            uint64    `gorm:"primaryKey" json:"id"`
+	ZoneID        uint64    `gorm:"not null;index" json:"zone_id"`
+	Name          string    `gorm:"type:varchar(100);not null" json:"name"`
+	RateType      string    `gorm:"type:varchar(20);not null;default:flat" json:"rate_type"` // flat или weight, см. utils.ShippingRate*
+	Price         int64     `gorm:"not null;default:0" json:"price"`                         // Фиксированная часть тарифа
+	PricePerKg    int64     `gorm:"not null;default:0" json:"price_per_kg"`                  // За каждый начатый килограмм, для weight
+	FreeFrom      *int64    `json:"free_from"`                                               // Бесплатно от этой суммы товаров
+	EstimatedDays int       `gorm:"not null;default:0" json:"estimated_days"`
+	Active        *bool     `gorm:"not null;default:true" json:"active"` // Указатель, чтобы false не заменялся значением по умолчанию
+	CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
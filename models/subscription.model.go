@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/datatypes"
+)
+
+// SubscriptionPlan is a tariff a user can subscribe to. Features holds the
+// feature flags of the plan as a JSON object.
+type SubscriptionPlan struct {
+	ID           uint64                  `gorm:"primaryKey" json:"id"`
+	Code         string                  `gorm:"size:64;uniqueIndex;not null" json:"code"`
+	Name         MultilangTitle          `gorm:"embedded;embeddedPrefix:name_" json:"name"`
+	LimitStorage int                     `gorm:"not null;default:20" json:"limit_storage"`
+	PeriodDays   int                     `gorm:"not null;default:31" json:"period_days"`
+	GraceDays    int                     `gorm:"not null;default:0" json:"grace_days"`
+	Features     datatypes.JSON          `json:"features"`
+	Active       bool                    `gorm:"not null;default:true" json:"active"`
+	SortOrder    int                     `gorm:"not null;default:0" json:"sort_order"`
+	Prices       []SubscriptionPlanPrice `gorm:"foreignKey:PlanID" json:"prices"`
+	CreatedAt    time.Time               `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt    time.Time               `gorm:"not null;default:now()" json:"updated_at"`
+}
+
+// SubscriptionPlanPrice is the price of one plan period in minor units of
+// Currency.
+type SubscriptionPlanPrice struct {
+	ID       uint64 `gorm:"primaryKey" json:"id"`
+	PlanID   uint64 `gorm:"not null;uniqueIndex:idx_plan_currency" json:"plan_id"`
+	Currency string `gorm:"size:3;not null;uniqueIndex:idx_plan_currency" json:"currency"`
+	Amount   int64  `gorm:"not null" json:"amount"`
+}
+
+// Subscription is one paid period of a plan. Every purchase, renewal and
+// upgrade creates a new record and replaces the previous one.
+type Subscription struct {
+	ID            uint64           `gorm:"primaryKey" json:"id"`
+	UserID        uuid.UUID        `gorm:"type:uuid;not null;index" json:"user_id"`
+	PlanID        uint64           `gorm:"not null" json:"plan_id"`
+	Plan          SubscriptionPlan `gorm:"foreignKey:PlanID" json:"plan"`
+	Kind          string           `gorm:"size:32;not null" json:"kind"`                                        // purchase, renewal, upgrade, auto_renewal, promo
+	Status        string           `gorm:"size:32;not null;index:idx_subscription_status_expiry" json:"status"` // active, grace, replaced, expired, canceled
+	Currency      string           `gorm:"size:3;not null;default:RUB" json:"currency"`
+	Price         int64            `gorm:"not null" json:"price"`   // full period price, minor units
+	Charged       int64            `gorm:"not null" json:"charged"` // charged after proration, minor units
+	AutoRenew     bool             `gorm:"not null;default:false" json:"auto_renew"`
+	StartedAt     time.Time        `gorm:"not null" json:"started_at"`
+	ExpiresAt     time.Time        `gorm:"not null;index:idx_subscription_status_expiry" json:"expires_at"`
+	GraceUntil    *time.Time       `gorm:"index" json:"grace_until"`
+	ReplacesID    *uint64          `json:"replaces_id"`
+	LedgerEntryID *uint64          `json:"ledger_entry_id"`
+	CreatedAt     time.Time        `gorm:"not null;default:now()" json:"created_at"`
+	UpdatedAt     time.Time        `gorm:"not null;default:now()" json:"updated_at"`
+}
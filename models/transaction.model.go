@@ -6,6 +6,10 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
+// Transaction is a read-only row of the "transactions" view over the wallet
+// postings of the ledger and the rows kept in legacy_transactions. Legacy
+// rows have an empty Kind and a zero EntryID. Money is moved with
+// utils.PostLedgerEntry.
 type Transaction struct {
 	ID        	uint64        `gorm:"primaryKey"`
 	UserID   	uuid.UUID     `gorm:"type:uuid;not null"`
@@ -16,6 +20,8 @@ type Transaction struct {
 	Type        string        `gorm:"not null"`    
 	Status       string        `gorm:"null"`    
 	Total       string        `gorm:"null"`    
+	Kind        string        `gorm:"->"`
+	EntryID     uint64        `gorm:"->"`
 
 	CreatedAt time.Time `gorm:"not null;default:now()"`
 	UpdatedAt time.Time `gorm:"not null;default:now()"`
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
 )
 
 type TimeEntry struct {
@@ -93,9 +94,13 @@ type User struct {
 	OfflineHours              int              `gorm:"not null;default:0"`
 	TotalRestBlogs            int              `gorm:"not null;default:0"`
 	TotalBlogs                int              `gorm:"not null;default:0"`
-	Rating                    int              `gorm:"not null;default:0"`
+	Rating                    float64          `gorm:"type:numeric(3,2);not null;default:0"` // Средняя оценка в опубликованных отзывах
+	ReviewsCount              int              `gorm:"not null;default:0"`
+	MessagePrivacy            string           `gorm:"type:varchar(16);not null;default:everyone"` // Кто может писать в личные сообщения: everyone, followers или nobody
+	HideLastSeen              bool             `gorm:"not null;default:false"`                     // Скрывать время последнего визита
 	LimitStorage              int              `gorm:"not null;default:20"`
-	LastOnline                time.Time        `json:"last_online"`
+	LastOnline                time.Time        `json:"-"`
+	LastSeen                  *time.Time       `gorm:"-" json:"last_online"` // Время последнего визита для ответов API, пусто если скрыто
 	Online                    bool             `json:"online"`
 	Domains                   []Domain         `json:"domains"`
 	Followings                []*User          `gorm:"many2many:user_relation;joinForeignKey:user_Id;JoinReferences:following_id;"`
@@ -103,6 +108,17 @@ type User struct {
 	IsBot                     bool             `gorm:"default:false"`
 }
 
+// AfterFind заполняет LastSeen, если пользователь не скрыл время последнего
+// визита. Так оно скрыто во всех ответах, где сериализуется User.
+func (u *User) AfterFind(tx *gorm.DB) error {
+	u.LastSeen = nil
+	if !u.HideLastSeen && !u.LastOnline.IsZero() {
+		lastOnline := u.LastOnline
+		u.LastSeen = &lastOnline
+	}
+	return nil
+}
+
 type Role string
 
 const (
@@ -170,6 +186,10 @@ type UserResponse struct {
 	Followings        []*User           `json:"followings"`
 	Followers         []*User           `json:"followers"`
 	TotalFollowers    int64             `json:"totalfollowers"`
+	Rating            float64           `json:"rating"`
+	ReviewsCount      int               `json:"reviewsCount"`
+	MessagePrivacy    string            `json:"messagePrivacy"`
+	HideLastSeen      bool              `json:"hideLastSeen"`
 }
 
 func FilterUserRecord(user *User, language string) UserResponse {
@@ -258,6 +278,10 @@ func FilterUserRecord(user *User, language string) UserResponse {
 		Followings:       user.Followings,
 		Followers:        user.Followers,
 		TotalFollowers:   user.TotalFollowers,
+		Rating:           user.Rating,
+		ReviewsCount:     user.ReviewsCount,
+		MessagePrivacy:   user.MessagePrivacy,
+		HideLastSeen:     user.HideLastSeen,
 	}
 }
 
@@ -20,15 +20,63 @@ func Register(micro *fiber.App) {
 		router.Post("/newOrder", middleware.DeserializeUser, controllers.CreateOrder)
 		router.Get("/getOrders",  middleware.DeserializeUser, controllers.GetOrdersForSeller)
 		router.Get("/getOrdersBuyers",  middleware.DeserializeUser, controllers.GetOrdersForBuyer)
+		router.Get("/stats", middleware.DeserializeUser, controllers.GetSellerOrderStats)
+		router.Get("/export", middleware.DeserializeUser, controllers.ExportSellerOrders)
 				
 		router.Post("/addAddr",  middleware.DeserializeUser, controllers.AddDeliveryAddress)
 		router.Delete("/delAddr/:id", middleware.DeserializeUser, controllers.DeleteDeliveryAddress) 
 		router.Put("/editAddr/:id", middleware.DeserializeUser, controllers.UpdateDeliveryAddress)  
         router.Get("/getAddresses", middleware.DeserializeUser, controllers.GetDeliveryAddresses)    
 		router.Patch("/:id/status", middleware.DeserializeUser, controllers.UpdateOrderStatus)
+		router.Get("/:id/history", middleware.DeserializeUser, controllers.GetOrderHistory)
 
 	})
 
+	micro.Route("/invoices", func(router fiber.Router) {
+		router.Get("/", middleware.DeserializeUser, controllers.GetInvoices)
+		router.Get("/:id/pdf", middleware.DeserializeUser, controllers.GetInvoicePDF)
+	})
+
+	micro.Route("/reviews", func(router fiber.Router) {
+		router.Get("/", controllers.GetReviews)
+		router.Post("/", middleware.DeserializeUser, controllers.CreateReview)
+		router.Post("/:id/reply", middleware.DeserializeUser, controllers.ReplyToReview)
+		router.Get("/moderation", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.GetReviewsForModeration)
+		router.Patch("/:id/moderate", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.ModerateReview)
+	})
+
+	micro.Route("/cart", func(router fiber.Router) {
+		router.Get("/", middleware.DeserializeOptionalUser, controllers.GetCart)
+		router.Post("/items", middleware.DeserializeOptionalUser, controllers.SetCartItem)
+		router.Put("/items/:variantId", middleware.DeserializeOptionalUser, controllers.SetCartItem)
+		router.Delete("/items/:variantId", middleware.DeserializeOptionalUser, controllers.RemoveCartItem)
+		router.Post("/merge", middleware.DeserializeUser, controllers.MergeCart)
+		router.Get("/shipping", middleware.DeserializeUser, controllers.GetCartShipping)
+		router.Post("/checkout", middleware.DeserializeUser, controllers.CheckoutCart)
+	})
+
+	micro.Route("/shipping", func(router fiber.Router) {
+		router.Get("/zones", middleware.DeserializeUser, controllers.GetShippingZones)
+		router.Post("/zones", middleware.DeserializeUser, controllers.CreateShippingZone)
+		router.Put("/zones/:id", middleware.DeserializeUser, controllers.UpdateShippingZone)
+		router.Delete("/zones/:id", middleware.DeserializeUser, controllers.DeleteShippingZone)
+		router.Post("/zones/:id/methods", middleware.DeserializeUser, controllers.AddShippingMethod)
+		router.Patch("/methods/:methodId", middleware.DeserializeUser, controllers.UpdateShippingMethod)
+		router.Delete("/methods/:methodId", middleware.DeserializeUser, controllers.DeleteShippingMethod)
+	})
+
+	micro.Route("/products", func(router fiber.Router) {
+		router.Get("/", controllers.GetProducts)
+		router.Get("/my", middleware.DeserializeUser, controllers.GetMyProducts)
+		router.Get("/:id", controllers.GetProduct)
+		router.Post("/", middleware.DeserializeUser, controllers.CreateProduct)
+		router.Patch("/:id", middleware.DeserializeUser, controllers.UpdateProduct)
+		router.Post("/:id/variants", middleware.DeserializeUser, controllers.AddProductVariant)
+		router.Patch("/variants/:variantId", middleware.DeserializeUser, controllers.UpdateProductVariant)
+		router.Post("/:id/photos", middleware.DeserializeUser, controllers.AddProductPhotos)
+		router.Delete("/photos/:photoId", middleware.DeserializeUser, controllers.DeleteProductPhoto)
+	})
+
 	micro.Route("/post", func(router fiber.Router) {
 		router.Get("/get/:id", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetPostByID)
 		router.Get("/feed", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetUserAndFollowingsPosts)
@@ -122,6 +170,12 @@ func Register(micro *fiber.App) {
 		router.Patch("/notifications/:id/read", middleware.DeserializeUser, controllers.MarkNotificationAsRead)
 		router.Delete("/notifications/:id", middleware.DeserializeUser, controllers.DeleteNotification)
 		router.Put("/changePhoto", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.ChangePhoto)
+		router.Get("/blocks", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetBlockedUsers)
+		router.Post("/blocks/:userId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.BlockUser)
+		router.Delete("/blocks/:userId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.UnblockUser)
+		router.Patch("/messagePrivacy", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.SetMessagePrivacy)
+		router.Patch("/lastSeenPrivacy", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.SetLastSeenPrivacy)
+		router.Get("/presence", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetPresence)
 
 
 		router.Post("/sendrequestcall", controllers.SendBotCallRequest)
@@ -141,10 +195,22 @@ func Register(micro *fiber.App) {
 		router.Get("/getmefirst", middleware.DeserializeUser, controllers.GetMeFirst)
 		router.Post("/addbalance", middleware.DeserializeUser, controllers.AddBalance)
 		router.Post("/plan", middleware.DeserializeUser, controllers.Plan)
+		router.Get("/plans", controllers.GetPlans)
+		router.Get("/subscriptions", middleware.DeserializeUser, controllers.GetSubscriptions)
+		router.Patch("/subscription/autorenew", middleware.DeserializeUser, controllers.SetAutoRenew)
+	})
+
+	micro.Route("/promo", func(router fiber.Router) {
+		router.Post("/redeem", middleware.DeserializeUser, controllers.AddBalance)
+		router.Get("/campaigns", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.GetPromoCampaigns)
+		router.Post("/campaigns", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.CreatePromoCampaign)
+		router.Post("/campaigns/:id/codes", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.GeneratePromoCodes)
+		router.Get("/campaigns/:id/codes.csv", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.ExportPromoCodes)
 	})
 
 	micro.Route("/billing", func(router fiber.Router) {
 		router.Get("/transactions", middleware.DeserializeUser, controllers.GetTransactions)
+		router.Get("/ledger/:userId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.GetLedgerReconciliation)
 	})
 
 	micro.Route("/calls", func(router fiber.Router) {
@@ -208,6 +274,10 @@ func Register(micro *fiber.App) {
 	micro.Route("/payment", func(router fiber.Router) {
 		router.Post("/invoice", middleware.DeserializeUser, controllers.CreateInvoice)
 		router.Post("/pending", controllers.Pending)
+		router.Post("/pending/:provider", controllers.Pending)
+		router.Post("/refund/:paymentId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.RefundPayment)
+		router.Post("/reconcile", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.ReconcilePayments)
+		router.Get("/discrepancies", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.GetPaymentDiscrepancies)
 	})
 
 	micro.Route("/profilehashtags", func(router fiber.Router) {
@@ -250,6 +320,7 @@ func Register(micro *fiber.App) {
 
 	micro.Route("/chat", func(router fiber.Router) {
 		router.Get("/room/:roomId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetRoomDetailsForDM)
+		router.Patch("/room/:roomId/retention", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.SetRoomRetention)
 		router.Get("/rooms", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetSubscribedRoomsForDM)
 		router.Get("/newRooms", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetNewUnsubscribedRoomsForDM)
 		router.Get("/archivedRooms", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetUnsubscribedNotNewRoomsForDM)
@@ -261,9 +332,45 @@ func Register(micro *fiber.App) {
 		router.Post("/message/:roomId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.SendMessageForDM)
 		router.Patch("/message/:messageId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.EditMessageForDM)
 		router.Delete("/message/:messageId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.DeleteMessageForDM)
+		router.Post("/message/:messageId/reaction", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.ToggleMessageReaction)
+		router.Patch("/message/:messageId/pin", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.PinMessage)
+		router.Patch("/message/:messageId/unpin", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.UnpinMessage)
+		router.Post("/message/:messageId/forward", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.ForwardMessage)
+		router.Get("/search", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.SearchChatMessages)
+		router.Get("/pinned/:roomId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetPinnedMessages)
+		router.Post("/attachment/:roomId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.UploadChatAttachment)
+		router.Get("/attachment/:id", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetChatAttachment)
+		router.Get("/attachment/:id/thumbnail", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetChatAttachmentThumbnail)
 		// Marks a message as read by the recipient
 		router.Patch("/read/:roomId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.MarkMessageAsReadForDM)
+		router.Patch("/delivered/:roomId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.MarkMessageAsDeliveredForDM)
+		router.Get("/unread", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetUnreadTotalForDM)
+		router.Get("/message/:messageId/receipts", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetMessageReceipts)
 		router.Patch("/unread/:roomId/:status", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.MarkMessageAsUnReadForDM)
+
+		// Group rooms
+		router.Post("/group", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.CreateGroupRoom)
+		router.Patch("/group/:roomId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.UpdateGroupRoom)
+		router.Post("/group/:roomId/members", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.InviteGroupMembers)
+		router.Delete("/group/:roomId/members/:userId", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.KickGroupMember)
+		router.Patch("/group/:roomId/members/:userId/mute", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.MuteGroupMember)
+		router.Patch("/group/:roomId/members/:userId/role", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.SetGroupMemberRole)
+		router.Post("/group/:roomId/transfer", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.TransferGroupOwnership)
+		router.Post("/group/:roomId/leave", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.LeaveGroupRoom)
+
+		// Message requests, the new rooms not subscribed yet
+		router.Get("/requests", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetNewUnsubscribedRoomsForDM)
+		router.Post("/requests/:roomId/accept", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.SubscribeNewRoomForDM)
+		router.Post("/requests/:roomId/decline", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.DeclineMessageRequest)
+		router.Post("/requests/:roomId/report", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.ReportMessageRequest)
+		router.Get("/reports", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.GetChatReports)
+		router.Patch("/reports/:id/resolve", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.ResolveChatReport)
+		router.Post("/reports/:id/export", middleware.DeserializeUser, middleware.CheckRole([]string{"admin"}), controllers.ExportReportedRoom)
+
+		// History export
+		router.Post("/export", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.RequestChatExport)
+		router.Get("/exports", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.GetChatExports)
+		router.Get("/export/:id/download", middleware.DeserializeUser, middleware.CheckRole([]string{"admin", "user", "vip"}), controllers.DownloadChatExport)
 	})
 
 	micro.Route("/contrifugoToken", func(router fiber.Router) {
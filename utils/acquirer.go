@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"hyperpage/initializers"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nikita-vanyasin/tinkoff"
+)
+
+// Names of the payment providers.
+const (
+	PaymentProviderTinkoff = "tinkoff"
+	PaymentProviderFake    = "fake"
+)
+
+var ErrUnknownPaymentProvider = errors.New("unknown payment provider")
+
+// InvoiceRequest describes a top-up invoice. Amount is in minor units of
+// Currency.
+type InvoiceRequest struct {
+	OrderID     string
+	Amount      uint64
+	Currency    string
+	Description string
+	CustomerKey string
+	Email       string
+}
+
+// Invoice is a created invoice. Response is the provider's own reply and is
+// handed to the client as is.
+type Invoice struct {
+	PaymentID  string
+	PaymentURL string
+	Response   interface{}
+}
+
+// PaymentState is the state of a payment as reported by the acquirer.
+// Amount is in minor units; for refunds it is the sum still held after the
+// operation, zero when the acquirer does not report it.
+type PaymentState struct {
+	PaymentID string
+	Status    string
+	Amount    uint64
+}
+
+// PaymentProvider is an acquiring service. Statuses are reported with the
+// AcquirerStatus* values.
+type PaymentProvider interface {
+	// Name is stored on models.Payments to route later calls back here.
+	Name() string
+	// CreateInvoice registers a payment and returns where to pay it.
+	CreateInvoice(request InvoiceRequest) (Invoice, error)
+	// ParseNotification verifies the signature of a webhook body.
+	ParseNotification(body []byte) (PaymentState, error)
+	// NotificationResponse is the body that acknowledges a webhook.
+	NotificationResponse() string
+	// Refund returns amount minor units of a confirmed payment to the card.
+	Refund(paymentID string, amount uint64) (PaymentState, error)
+	// GetState returns the current status of the payment.
+	GetState(paymentID string) (PaymentState, error)
+}
+
+var fakePaymentProvider = NewFakePaymentProvider()
+
+// PaymentProviderByName returns the provider with the given name, an empty
+// name selects Tinkoff for payments created before providers were recorded.
+// The fake provider exists only when PAYMENT_FAKE_ENABLED is set.
+func PaymentProviderByName(config *initializers.Config, name string) (PaymentProvider, error) {
+	switch name {
+	case PaymentProviderTinkoff, "":
+		return &TinkoffPaymentProvider{
+			client: tinkoff.NewClient(config.TinkoffTerminalKey, config.TinkoffTerminalPassword),
+		}, nil
+	case PaymentProviderFake:
+		if config.PaymentFakeEnabled {
+			return fakePaymentProvider, nil
+		}
+	}
+	return nil, ErrUnknownPaymentProvider
+}
+
+// SelectPaymentProvider picks the provider for a new invoice by the rules of
+// PAYMENT_PROVIDER_BY_COUNTRY, a list like "RU:tinkoff,GE:fake".
+// PAYMENT_PROVIDER is the fallback. Invoices are always in the wallet
+// currency, so there is no routing by currency.
+func SelectPaymentProvider(config *initializers.Config, country string) (PaymentProvider, error) {
+	name := config.PaymentProvider
+	if byCountry, ok := parseProviderRules(config.PaymentProviderByCountry)[strings.ToUpper(country)]; ok {
+		name = byCountry
+	}
+	return PaymentProviderByName(config, name)
+}
+
+func parseProviderRules(rules string) map[string]string {
+	result := map[string]string{}
+	for _, rule := range strings.Split(rules, ",") {
+		key, name, ok := strings.Cut(strings.TrimSpace(rule), ":")
+		if ok {
+			result[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(name)
+		}
+	}
+	return result
+}
+
+var countriesByLanguage = map[string]string{
+	"ru": "RU",
+	"ka": "GE",
+	"es": "ES",
+}
+
+// CountryByLanguage guesses the country of a user from the interface
+// language when the client does not send one.
+func CountryByLanguage(language string) string {
+	return countriesByLanguage[strings.ToLower(language)]
+}
+
+// TinkoffPaymentProvider talks to the Tinkoff acquiring API.
+type TinkoffPaymentProvider struct {
+	client *tinkoff.Client
+}
+
+func (p *TinkoffPaymentProvider) Name() string {
+	return PaymentProviderTinkoff
+}
+
+func (p *TinkoffPaymentProvider) CreateInvoice(request InvoiceRequest) (Invoice, error) {
+	res, err := p.client.Init(&tinkoff.InitRequest{
+		Amount:          request.Amount,
+		OrderID:         request.OrderID,
+		CustomerKey:     request.CustomerKey,
+		Description:     request.Description,
+		RedirectDueDate: tinkoff.Time(time.Now().Add(4 * time.Hour * 24)), // ссылка истечет через 4 дня
+		Receipt: &tinkoff.Receipt{
+			Email: request.Email,
+			Items: []*tinkoff.ReceiptItem{
+				{
+					Price:         request.Amount,
+					Quantity:      "1",
+					Amount:        request.Amount,
+					Name:          "Баланс на сумму " + strconv.FormatUint(request.Amount, 10),
+					Tax:           tinkoff.VATNone,
+					PaymentMethod: tinkoff.PaymentMethodFullPayment,
+					PaymentObject: tinkoff.PaymentObjectIntellectualActivity,
+				},
+			},
+			Taxation: tinkoff.TaxationUSNIncome,
+			Payments: &tinkoff.ReceiptPayments{
+				Electronic: request.Amount,
+			},
+		},
+		Data: map[string]string{},
+	})
+	if err != nil {
+		return Invoice{Response: res}, err
+	}
+	return Invoice{PaymentID: res.PaymentID, PaymentURL: res.PaymentURL, Response: res}, nil
+}
+
+func (p *TinkoffPaymentProvider) ParseNotification(body []byte) (PaymentState, error) {
+	notification, err := p.client.ParseNotification(bytes.NewReader(body))
+	if err != nil {
+		return PaymentState{}, err
+	}
+	return PaymentState{
+		PaymentID: strconv.FormatUint(notification.PaymentID, 10),
+		Status:    notification.Status,
+		Amount:    notification.Amount,
+	}, nil
+}
+
+func (p *TinkoffPaymentProvider) NotificationResponse() string {
+	return p.client.GetNotificationSuccessResponse()
+}
+
+func (p *TinkoffPaymentProvider) Refund(paymentID string, amount uint64) (PaymentState, error) {
+	res, err := p.client.Cancel(&tinkoff.CancelRequest{
+		PaymentID: paymentID,
+		Amount:    amount,
+	})
+	if err != nil {
+		return PaymentState{}, err
+	}
+	return PaymentState{PaymentID: paymentID, Status: res.Status, Amount: res.NewAmount}, nil
+}
+
+// GetState asks for the state with the amount still held, which the
+// client library does not decode.
+func (p *TinkoffPaymentProvider) GetState(paymentID string) (PaymentState, error) {
+	response, err := p.client.PostRequest("/GetState", &tinkoff.GetStateRequest{PaymentID: paymentID})
+	if err != nil {
+		return PaymentState{}, err
+	}
+	defer response.Body.Close()
+
+	var res struct {
+		tinkoff.BaseResponse
+		Status string `json:"Status"`
+		Amount uint64 `json:"Amount"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&res); err != nil {
+		return PaymentState{}, err
+	}
+	if err := res.Error(); err != nil {
+		return PaymentState{}, err
+	}
+	return PaymentState{PaymentID: paymentID, Status: res.Status, Amount: res.Amount}, nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// FakePaymentProvider keeps payments in memory. Payment IDs are issued in
+// order ("fake-1", "fake-2", ...), so local runs and tests are
+// deterministic. Notifications are JSON with PaymentId, Status and Amount,
+// for refunds the sum still held, and are accepted only for payments the
+// provider has issued.
+type FakePaymentProvider struct {
+	mu       sync.Mutex
+	next     int
+	payments map[string]PaymentState
+}
+
+func NewFakePaymentProvider() *FakePaymentProvider {
+	return &FakePaymentProvider{payments: map[string]PaymentState{}}
+}
+
+// SetState seeds or overrides the state of a payment.
+func (p *FakePaymentProvider) SetState(paymentID string, status string, amount uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.payments[paymentID] = PaymentState{PaymentID: paymentID, Status: status, Amount: amount}
+}
+
+func (p *FakePaymentProvider) Name() string {
+	return PaymentProviderFake
+}
+
+func (p *FakePaymentProvider) CreateInvoice(request InvoiceRequest) (Invoice, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if request.Amount == 0 {
+		return Invoice{}, errors.New("invalid invoice amount")
+	}
+
+	p.next++
+	paymentID := fmt.Sprintf("fake-%d", p.next)
+	state := PaymentState{PaymentID: paymentID, Status: PaymentStatusNew, Amount: request.Amount}
+	p.payments[paymentID] = state
+
+	return Invoice{
+		PaymentID:  paymentID,
+		PaymentURL: "https://pay.fake/" + paymentID,
+		Response:   state,
+	}, nil
+}
+
+func (p *FakePaymentProvider) ParseNotification(body []byte) (PaymentState, error) {
+	var notification struct {
+		PaymentId string
+		Status    string
+		Amount    uint64
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return PaymentState{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.payments[notification.PaymentId]
+	if !ok {
+		return PaymentState{}, errors.New("payment not found")
+	}
+	state.Status = notification.Status
+	p.payments[notification.PaymentId] = state
+
+	return PaymentState{PaymentID: notification.PaymentId, Status: notification.Status, Amount: notification.Amount}, nil
+}
+
+func (p *FakePaymentProvider) NotificationResponse() string {
+	return "OK"
+}
+
+func (p *FakePaymentProvider) Refund(paymentID string, amount uint64) (PaymentState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.payments[paymentID]
+	if !ok {
+		return PaymentState{}, errors.New("payment not found")
+	}
+	if state.Status != AcquirerStatusConfirmed && state.Status != AcquirerStatusPartialRefunded {
+		return PaymentState{}, errors.New("payment can not be refunded in status " + state.Status)
+	}
+	if amount == 0 || amount > state.Amount {
+		return PaymentState{}, errors.New("invalid refund amount")
+	}
+
+	state.Amount -= amount
+	state.Status = AcquirerStatusPartialRefunded
+	if state.Amount == 0 {
+		state.Status = AcquirerStatusRefunded
+	}
+	p.payments[paymentID] = state
+	return state, nil
+}
+
+func (p *FakePaymentProvider) GetState(paymentID string) (PaymentState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state, ok := p.payments[paymentID]
+	if !ok {
+		return PaymentState{}, errors.New("payment not found")
+	}
+	return state, nil
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAddressIncomplete  = errors.New("city, street and building are required")
+	ErrAddressCountry     = errors.New("invalid country code")
+	ErrAddressCity        = errors.New("city does not belong to the country")
+	ErrAddressPostalCode  = errors.New("invalid postal code")
+	ErrAddressPhoneNumber = errors.New("invalid phone number")
+)
+
+// addressFormat describes postal codes and phone numbers of a country.
+// Phone numbers are checked in E.164 form: calling code and national number.
+type addressFormat struct {
+	postalCode  *regexp.Regexp // nil when the country has no postal codes
+	callingCode string
+	phone       *regexp.Regexp // national number without the calling code
+}
+
+var addressFormats = map[string]addressFormat{
+	"RU": {regexp.MustCompile(`^\d{6}$`), "7", regexp.MustCompile(`^\d{10}$`)},
+	"KZ": {regexp.MustCompile(`^(\d{6}|[A-Z]\d{2}[A-Z]\d[A-Z]\d)$`), "7", regexp.MustCompile(`^\d{10}$`)},
+	"BY": {regexp.MustCompile(`^\d{6}$`), "375", regexp.MustCompile(`^\d{9}$`)},
+	"UA": {regexp.MustCompile(`^\d{5}$`), "380", regexp.MustCompile(`^\d{9}$`)},
+	"UZ": {regexp.MustCompile(`^\d{6}$`), "998", regexp.MustCompile(`^\d{9}$`)},
+	"KG": {regexp.MustCompile(`^\d{6}$`), "996", regexp.MustCompile(`^\d{9}$`)},
+	"AM": {regexp.MustCompile(`^\d{4}$`), "374", regexp.MustCompile(`^\d{8}$`)},
+	"GE": {regexp.MustCompile(`^\d{4}$`), "995", regexp.MustCompile(`^\d{9}$`)},
+	"TR": {regexp.MustCompile(`^\d{5}$`), "90", regexp.MustCompile(`^\d{10}$`)},
+	"RS": {regexp.MustCompile(`^\d{5}$`), "381", regexp.MustCompile(`^\d{8,9}$`)},
+	"ME": {regexp.MustCompile(`^\d{5}$`), "382", regexp.MustCompile(`^\d{8}$`)},
+	"CY": {regexp.MustCompile(`^\d{4}$`), "357", regexp.MustCompile(`^\d{8}$`)},
+	"IL": {regexp.MustCompile(`^\d{7}$`), "972", regexp.MustCompile(`^\d{8,9}$`)},
+	"AE": {nil, "971", regexp.MustCompile(`^\d{8,9}$`)},
+	"TH": {regexp.MustCompile(`^\d{5}$`), "66", regexp.MustCompile(`^\d{8,9}$`)},
+	"VN": {regexp.MustCompile(`^\d{6}$`), "84", regexp.MustCompile(`^\d{9,10}$`)},
+	"ID": {regexp.MustCompile(`^\d{5}$`), "62", regexp.MustCompile(`^\d{8,12}$`)},
+	"DE": {regexp.MustCompile(`^\d{5}$`), "49", regexp.MustCompile(`^\d{6,13}$`)},
+	"FR": {regexp.MustCompile(`^\d{5}$`), "33", regexp.MustCompile(`^\d{9}$`)},
+	"ES": {regexp.MustCompile(`^\d{5}$`), "34", regexp.MustCompile(`^\d{9}$`)},
+	"IT": {regexp.MustCompile(`^\d{5}$`), "39", regexp.MustCompile(`^\d{6,11}$`)},
+	"PL": {regexp.MustCompile(`^\d{2}-\d{3}$`), "48", regexp.MustCompile(`^\d{9}$`)},
+	"US": {regexp.MustCompile(`^\d{5}(-\d{4})?$`), "1", regexp.MustCompile(`^\d{10}$`)},
+}
+
+// CountryCodePattern matches an upper-case ISO 3166-1 alpha-2 code.
+var CountryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+var (
+	e164Pattern       = regexp.MustCompile(`^\+\d{7,15}$`)
+	postalCodePattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,9}$`)
+	phoneSeparators   = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+)
+
+// ValidateDeliveryAddress checks the address and normalizes it in place:
+// the country code is upper-cased, the postal code is trimmed and the phone
+// number is stored in E.164 form. A CityID must refer to a city of the
+// country; its name is not overwritten. Countries without a known format
+// get a generic check.
+func ValidateDeliveryAddress(tx *gorm.DB, address *models.DeliveryAddress) error {
+	address.CountryCode = strings.ToUpper(strings.TrimSpace(address.CountryCode))
+	if address.CountryCode == "" {
+		address.CountryCode = "RU"
+	}
+	if !CountryCodePattern.MatchString(address.CountryCode) {
+		return ErrAddressCountry
+	}
+
+	address.Region = strings.TrimSpace(address.Region)
+	address.City = strings.TrimSpace(address.City)
+	address.Street = strings.TrimSpace(address.Street)
+	address.Building = strings.TrimSpace(address.Building)
+	if address.City == "" || address.Street == "" || address.Building == "" {
+		return ErrAddressIncomplete
+	}
+
+	if address.CityID != nil {
+		var count int64
+		if err := tx.Model(&models.City{}).
+			Where("id = ? AND UPPER(country_code) = ?", *address.CityID, address.CountryCode).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrAddressCity
+		}
+	}
+
+	format, known := addressFormats[address.CountryCode]
+
+	address.PostalCode = strings.ToUpper(strings.TrimSpace(address.PostalCode))
+	switch {
+	case known && format.postalCode == nil:
+		// no postal codes in the country, the field is optional
+	case known:
+		if !format.postalCode.MatchString(address.PostalCode) {
+			return ErrAddressPostalCode
+		}
+	default:
+		if !postalCodePattern.MatchString(address.PostalCode) {
+			return ErrAddressPostalCode
+		}
+	}
+
+	phone, err := normalizePhoneNumber(address.PhoneNumber, address.CountryCode)
+	if err != nil {
+		return err
+	}
+	address.PhoneNumber = phone
+	return nil
+}
+
+// normalizePhoneNumber returns the number in E.164 form. Numbers without the
+// "+" are read as national ones; for RU and KZ the trunk prefix 8 is
+// accepted as well.
+func normalizePhoneNumber(phone string, countryCode string) (string, error) {
+	phone = phoneSeparators.Replace(strings.TrimSpace(phone))
+	format, known := addressFormats[countryCode]
+
+	if !strings.HasPrefix(phone, "+") {
+		if !known {
+			return "", ErrAddressPhoneNumber
+		}
+		if format.callingCode == "7" && len(phone) == 11 && (phone[0] == '8' || phone[0] == '7') {
+			phone = phone[1:]
+		}
+		phone = "+" + format.callingCode + strings.TrimPrefix(phone, "0")
+	}
+
+	if !e164Pattern.MatchString(phone) {
+		return "", ErrAddressPhoneNumber
+	}
+	if known && strings.HasPrefix(phone, "+"+format.callingCode) {
+		if !format.phone.MatchString(strings.TrimPrefix(phone, "+"+format.callingCode)) {
+			return "", ErrAddressPhoneNumber
+		}
+	}
+	return phone, nil
+}
@@ -2,10 +2,9 @@ package utils
 
 import (
 	"errors"
-	"fmt"
 	"hyperpage/initializers"
 	"hyperpage/models"
-	"strconv"
+	"time"
 
 	uuid "github.com/satori/go.uuid"
 
@@ -13,56 +12,63 @@ import (
 )
 
 func DeductAmountFromUserBalance(userID uuid.UUID, amount float64, total float64, module string, elementId uint64) error {
+	return initializers.DB.Transaction(func(tx *gorm.DB) error {
+		entry := models.LedgerEntry{
+			Kind:        LedgerKindPublicationFee,
+			Description: `Списание за публикацию объявления`,
+			Module:      module,
+			ElementId:   elementId,
+		}
+		return ChargePublicationFee(tx, userID, MoneyToMinor(amount), &entry)
+	})
+}
 
-	// Calculate 5% of the amount
-	fee := amount
+// SitePlan is a paid period of a VIP site.
+type SitePlan struct {
+	Price  int64 // kopecks
+	Months int
+	Years  int
+}
 
-	// Retrieve user's balance from database
-	balance := new(models.Billing)
-	if err := initializers.DB.Where("user_id = ?", userID).First(balance).Error; err != nil {
-		fmt.Println(err)
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// No balance record found for the user, create a new one
-			balance = &models.Billing{
-				UserID: userID,
-				Amount: 0,
-			}
-			if err := initializers.DB.Create(balance).Error; err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	}
+// ExpiresAt returns the end of the plan bought at from.
+func (p SitePlan) ExpiresAt(from time.Time) time.Time {
+	return from.AddDate(p.Years, p.Months, 0)
+}
 
-	// Check if user has sufficient balance
-	if balance.Amount < amount {
-		return errors.New("insufficient balance")
-	}
+var sitePlans = map[string]SitePlan{
+	"month": {Price: 250000, Months: 1},
+	"year":  {Price: 2000000, Years: 1},
+}
 
+// blogPlacementDays are the placement periods a blog can be extended by.
+var blogPlacementDays = map[int]bool{10: true, 30: true, 60: true, 90: true}
 
-	// Deduct amount from user's balance and save to database
-	balance.Amount -= fee
-	if err := initializers.DB.Save(balance).Error; err != nil {
-		return err
-	}
+var (
+	ErrSitePlanNotFound      = errors.New("unknown site plan")
+	ErrBlogPlacementDuration = errors.New("days must be 10, 30, 60 or 90")
+)
 
-	description := `Списание за публикацию объявления`
-	// Create transaction log for the deduction
-	transaction := &models.Transaction{
-		UserID:      userID,
-		Amount:      fee,
-		Status: 	 `OPENED`,
-		Module: 	module,
-		ElementId: elementId,
-    	Total: 		 strconv.FormatFloat(total, 'f', 2, 64),
-		Description: description,
-		Type:        "deduction",
+// FindSitePlan returns the site plan by name. Older clients send the price
+// of the plan instead, it only selects the plan and is never charged as is.
+func FindSitePlan(name string, legacyAmount string) (SitePlan, error) {
+	if plan, ok := sitePlans[name]; ok {
+		return plan, nil
 	}
-	if err := initializers.DB.Create(transaction).Error; err != nil {
-		return err
+	if amount, err := ParseMoneyAmount(legacyAmount); err == nil {
+		for _, plan := range sitePlans {
+			if plan.Price == amount {
+				return plan, nil
+			}
+		}
 	}
+	return SitePlan{}, ErrSitePlanNotFound
+}
 
-
-	return nil
+// BlogExtensionPrice returns the price in kopecks of extending a blog
+// placement by the given days.
+func BlogExtensionPrice(config initializers.Config, days int) (int64, error) {
+	if !blogPlacementDays[days] {
+		return 0, ErrBlogPlacementDuration
+	}
+	return MoneyToMinor(config.BlogDayPrice) * int64(days), nil
 }
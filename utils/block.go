@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Values of models.User.MessagePrivacy.
+const (
+	MessagePrivacyEveryone  = "everyone"
+	MessagePrivacyFollowers = "followers" // only users following the recipient
+	MessagePrivacyNobody    = "nobody"
+)
+
+var (
+	ErrUserBlocked         = errors.New("user is blocked")
+	ErrMessagesNotAllowed  = errors.New("user does not accept messages from you")
+	ErrBlockSelf           = errors.New("you cannot block yourself")
+	ErrMessagePrivacyValue = errors.New("messagePrivacy must be everyone, followers or nobody")
+)
+
+// IsMessagePrivacy reports whether value is a known privacy setting.
+func IsMessagePrivacy(value string) bool {
+	return value == MessagePrivacyEveryone || value == MessagePrivacyFollowers || value == MessagePrivacyNobody
+}
+
+// IsBlockedBy reports whether blockerID blocked userID.
+func IsBlockedBy(db *gorm.DB, blockerID uuid.UUID, userID uuid.UUID) bool {
+	var count int64
+	db.Model(&models.UserBlock{}).Where("blocker_id = ? AND blocked_id = ?", blockerID, userID).Count(&count)
+	return count > 0
+}
+
+// IsBlockedEitherWay reports whether one of the users blocked the other.
+// Direct messages stop both ways after a block.
+func IsBlockedEitherWay(db *gorm.DB, a uuid.UUID, b uuid.UUID) bool {
+	var count int64
+	db.Model(&models.UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", a, b, b, a).
+		Count(&count)
+	return count > 0
+}
+
+// IsFollowing reports whether followerID follows userID.
+func IsFollowing(db *gorm.DB, followerID uuid.UUID, userID uuid.UUID) bool {
+	var count int64
+	db.Table("user_relation").Where("user_id = ? AND following_id = ?", userID, followerID).Count(&count)
+	return count > 0
+}
+
+// CanStartConversation checks whether the sender may open a conversation
+// with the recipient: a new direct room or an invitation to a group.
+func CanStartConversation(db *gorm.DB, senderID uuid.UUID, recipientID uuid.UUID) error {
+	if IsBlockedEitherWay(db, senderID, recipientID) {
+		return ErrUserBlocked
+	}
+
+	var recipient models.User
+	if err := db.Select("id", "message_privacy").First(&recipient, "id = ?", recipientID).Error; err != nil {
+		return err
+	}
+	following := recipient.MessagePrivacy == MessagePrivacyFollowers && IsFollowing(db, senderID, recipientID)
+	return checkMessagePrivacy(recipient.MessagePrivacy, following)
+}
+
+// checkMessagePrivacy applies the privacy setting of the recipient to a
+// sender, following tells whether the sender follows the recipient.
+func checkMessagePrivacy(privacy string, following bool) error {
+	switch privacy {
+	case MessagePrivacyNobody:
+		return ErrMessagesNotAllowed
+	case MessagePrivacyFollowers:
+		if !following {
+			return ErrMessagesNotAllowed
+		}
+	}
+	return nil
+}
+
+// BlockUser blocks the user and drops the follow of the blocked user, the
+// blocker stops following the blocked one as well.
+func BlockUser(tx *gorm.DB, blockerID uuid.UUID, blockedID uuid.UUID) error {
+	if blockerID == blockedID {
+		return ErrBlockSelf
+	}
+	if err := tx.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).
+		FirstOrCreate(&models.UserBlock{BlockerID: blockerID, BlockedID: blockedID}).Error; err != nil {
+		return err
+	}
+
+	for _, pair := range [][2]uuid.UUID{{blockerID, blockedID}, {blockedID, blockerID}} {
+		result := tx.Exec("DELETE FROM user_relation WHERE user_id = ? AND following_id = ?", pair[0], pair[1])
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if err := tx.Model(&models.User{}).Where("id = ? AND total_followers > 0", pair[0]).
+				Update("total_followers", gorm.Expr("total_followers - 1")).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestIsMessagePrivacy(t *testing.T) {
+	tests := map[string]bool{
+		MessagePrivacyEveryone:  true,
+		MessagePrivacyFollowers: true,
+		MessagePrivacyNobody:    true,
+		"":                      false,
+		"Everyone":              false,
+		"friends":               false,
+	}
+	for value, want := range tests {
+		if got := IsMessagePrivacy(value); got != want {
+			t.Fatalf("IsMessagePrivacy(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestCheckMessagePrivacy(t *testing.T) {
+	tests := []struct {
+		name      string
+		privacy   string
+		following bool
+		wantErr   error
+	}{
+		{name: "everyone", privacy: MessagePrivacyEveryone},
+		{name: "everyone and a follower", privacy: MessagePrivacyEveryone, following: true},
+		{name: "followers and a follower", privacy: MessagePrivacyFollowers, following: true},
+		{name: "followers and a stranger", privacy: MessagePrivacyFollowers, wantErr: ErrMessagesNotAllowed},
+		{name: "nobody and a stranger", privacy: MessagePrivacyNobody, wantErr: ErrMessagesNotAllowed},
+		{name: "nobody and a follower", privacy: MessagePrivacyNobody, following: true, wantErr: ErrMessagesNotAllowed},
+		{name: "setting of an old account", privacy: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkMessagePrivacy(tt.privacy, tt.following); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkMessagePrivacy(%q, %v) = %v, want %v", tt.privacy, tt.following, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlockUserRefusesSelf(t *testing.T) {
+	id := uuid.NewV4()
+	if err := BlockUser(nil, id, id); !errors.Is(err, ErrBlockSelf) {
+		t.Fatalf("BlockUser(self) = %v, want %v", err, ErrBlockSelf)
+	}
+}
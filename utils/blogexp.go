@@ -11,7 +11,6 @@ import (
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	uuid "github.com/satori/go.uuid"
 )
 
 func MoveToArch(bot *tgbotapi.BotAPI) {
@@ -90,8 +89,6 @@ func CheckExpiration(bot *tgbotapi.BotAPI) {
 
 	var blogs []models.Blog
 
-	var transaction []models.Transaction
-
 	initializers.DB.Where("expired_At < ?", time.Now()).Where("status = ?", "ACTIVE").Find(&blogs)
 
 	var blogIDs []uint
@@ -132,7 +129,6 @@ func CheckExpiration(bot *tgbotapi.BotAPI) {
 	}
 	// Delete the blogs records
 
-	initializers.DB.Model(&transaction).Where("element_id IN (?)", initializers.DB.Table("blogs").Select("id").Where("expired_At < ? AND status = ?", time.Now(), "ACTIVE")).Update("status", "CLOSED_0")
 
 	for _, blog := range blogs {
 
@@ -145,35 +141,9 @@ func CheckExpiration(bot *tgbotapi.BotAPI) {
 }
 
 func CheckPlan(bot *tgbotapi.BotAPI) {
-	var users []models.User
-
-	// Retrieve all users
-	initializers.DB.Find(&users)
-
-	var userIds []uuid.UUID
-	for _, user := range users {
-		// Check if expired_plan_at is not null and less than the current time
-		if user.ExpiredPlanAt != nil && user.ExpiredPlanAt.Before(time.Now()) {
-			userIds = append(userIds, user.ID)
-
-			// Update plan, signed, expired_plan_at, and limit_storage columns
-			err := initializers.DB.Model(&models.User{}).
-				Where("id = ?", user.ID).
-				Updates(map[string]interface{}{
-					"plan":            "standart",
-					"signed":          false,
-					"expired_plan_at": nil,
-					"limit_storage":   20,
-				}).Error
-			if err != nil {
-				// Handle error if necessary
-				// ...
-			}
-		}
+	if err := ExpireSubscriptions(); err != nil {
+		log.Println("Could not expire subscriptions:", err)
 	}
-
-	// Further processing or notifications
-	// ...
 }
 
 func CheckSite(bot *tgbotapi.BotAPI) {
@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"log"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of a cart line, re-evaluated on every read.
+const (
+	CartLineOK                = "ok"
+	CartLineUnavailable       = "unavailable"
+	CartLineOutOfStock        = "out_of_stock"
+	CartLineInsufficientStock = "insufficient_stock"
+)
+
+const cartCacheTTL = 30 * time.Minute
+
+var ErrCartNotFound = errors.New("cart not found")
+
+// CartLine is a cart item checked against the catalog.
+type CartLine struct {
+	ItemID       uint64    `json:"id"`
+	VariantID    uint64    `json:"variantId"`
+	ProductID    uint64    `json:"productId"`
+	SellerID     uuid.UUID `json:"sellerId"`
+	Title        string    `json:"title"`
+	VariantName  string    `json:"variantName"`
+	SKU          string    `json:"sku"`
+	Currency     string    `json:"currency"`
+	Quantity     int       `json:"quantity"`
+	Price        int64     `json:"price"`      // current price, minor units
+	AddedPrice   int64     `json:"addedPrice"` // price when the item was added
+	PriceChanged bool      `json:"priceChanged"`
+	Stock        int       `json:"stock"`
+	Weight       int       `json:"weight"` // grams per unit
+	Status       string    `json:"status"`
+}
+
+// CartView is the validated cart. Valid is false when any line can not be
+// ordered as is.
+type CartView struct {
+	ID    uuid.UUID  `json:"id"`
+	Lines []CartLine `json:"lines"`
+	Total int64      `json:"total"` // minor units, lines with status ok only
+	Valid bool       `json:"valid"`
+}
+
+// UserCart returns the cart of the user, creating it on first use.
+func UserCart(tx *gorm.DB, userID uuid.UUID) (models.Cart, error) {
+	cart := models.Cart{ID: uuid.NewV4(), UserID: &userID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&cart).Error; err != nil {
+		return cart, err
+	}
+	err := tx.Where("user_id = ?", userID).First(&cart).Error
+	return cart, err
+}
+
+// GuestCart returns the cart of a guest by its token.
+func GuestCart(tx *gorm.DB, token string) (models.Cart, error) {
+	var cart models.Cart
+	err := tx.Where("guest_token = ? AND user_id IS NULL", token).First(&cart).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cart, ErrCartNotFound
+	}
+	return cart, err
+}
+
+// NewGuestCart creates an empty guest cart with a fresh token.
+func NewGuestCart(tx *gorm.DB) (models.Cart, error) {
+	token := uuid.NewV4().String()
+	cart := models.Cart{ID: uuid.NewV4(), GuestToken: &token}
+	err := tx.Create(&cart).Error
+	return cart, err
+}
+
+// SetCartItem sets the quantity of a variant in the cart, zero removes it.
+// The variant must exist and be on sale, stock is checked only on read and
+// at checkout. The caller drops the cached cart with InvalidateCartCache
+// once the transaction is committed.
+func SetCartItem(tx *gorm.DB, cart models.Cart, variantID uint64, quantity int) error {
+	if quantity <= 0 {
+		return tx.Where("cart_id = ? AND variant_id = ?", cart.ID, variantID).Delete(&models.CartItem{}).Error
+	}
+
+	var variant models.ProductVariant
+	if err := tx.
+		Joins("JOIN products ON products.id = product_variants.product_id").
+		Where("product_variants.id = ? AND product_variants.active AND products.active", variantID).
+		First(&variant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProductUnavailable
+		}
+		return err
+	}
+
+	item := models.CartItem{CartID: cart.ID, VariantID: variantID, Quantity: quantity, Price: variant.Price}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cart_id"}, {Name: "variant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"quantity", "price", "updated_at"}),
+	}).Create(&item).Error
+}
+
+// ClearCart removes all items from the cart. The caller drops the cached
+// cart with InvalidateCartCache once the transaction is committed.
+func ClearCart(tx *gorm.DB, cartID uuid.UUID) error {
+	return tx.Where("cart_id = ?", cartID).Delete(&models.CartItem{}).Error
+}
+
+// MergeGuestCart moves the items of a guest cart into the user's cart,
+// adding up quantities of the same variant, and deletes the guest cart.
+// Unknown tokens are ignored. It also returns the ID of the merged guest
+// cart, uuid.Nil if there was none; the caller drops both cached carts with
+// InvalidateCartCache once the transaction is committed.
+func MergeGuestCart(tx *gorm.DB, token string, userID uuid.UUID) (models.Cart, uuid.UUID, error) {
+	cart, err := UserCart(tx, userID)
+	if err != nil {
+		return cart, uuid.Nil, err
+	}
+
+	guest, err := GuestCart(tx, token)
+	if errors.Is(err, ErrCartNotFound) {
+		return cart, uuid.Nil, nil
+	}
+	if err != nil {
+		return cart, uuid.Nil, err
+	}
+
+	var items []models.CartItem
+	if err := tx.Where("cart_id = ?", guest.ID).Find(&items).Error; err != nil {
+		return cart, uuid.Nil, err
+	}
+	for _, item := range items {
+		merged := models.CartItem{CartID: cart.ID, VariantID: item.VariantID, Quantity: item.Quantity, Price: item.Price}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "cart_id"}, {Name: "variant_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"quantity":   gorm.Expr("cart_items.quantity + EXCLUDED.quantity"),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&merged).Error; err != nil {
+			return cart, uuid.Nil, err
+		}
+	}
+
+	if err := tx.Delete(&guest).Error; err != nil {
+		return cart, uuid.Nil, err
+	}
+	return cart, guest.ID, nil
+}
+
+// CartItems returns the items of the cart. Redis caches the stored cart,
+// prices and stock always come from Postgres.
+func CartItems(tx *gorm.DB, cartID uuid.UUID) ([]models.CartItem, error) {
+	var items []models.CartItem
+
+	if initializers.RedisClient != nil {
+		cached, err := initializers.RedisClient.Get(context.Background(), cartCacheKey(cartID)).Bytes()
+		if err == nil && json.Unmarshal(cached, &items) == nil {
+			return items, nil
+		}
+	}
+
+	if err := tx.Where("cart_id = ?", cartID).Order("id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	if initializers.RedisClient != nil {
+		if data, err := json.Marshal(items); err == nil {
+			if err := initializers.RedisClient.Set(context.Background(), cartCacheKey(cartID), data, cartCacheTTL).Err(); err != nil {
+				log.Println("Failed to cache cart:", err)
+			}
+		}
+	}
+	return items, nil
+}
+
+// ValidateCart checks every cart item against the current catalog.
+func ValidateCart(tx *gorm.DB, cartID uuid.UUID) (CartView, error) {
+	view := CartView{ID: cartID, Lines: []CartLine{}, Valid: true}
+
+	items, err := CartItems(tx, cartID)
+	if err != nil {
+		return view, err
+	}
+	if len(items) == 0 {
+		view.Valid = false
+		return view, nil
+	}
+
+	ids := make([]uint64, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.VariantID)
+	}
+	var variants []models.ProductVariant
+	if err := tx.Where("id IN ?", ids).Find(&variants).Error; err != nil {
+		return view, err
+	}
+	variantsByID := map[uint64]models.ProductVariant{}
+	productIDs := []uint64{}
+	for _, variant := range variants {
+		variantsByID[variant.ID] = variant
+		productIDs = append(productIDs, variant.ProductID)
+	}
+	var products []models.Product
+	if len(productIDs) > 0 {
+		if err := tx.Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+			return view, err
+		}
+	}
+	productsByID := map[uint64]models.Product{}
+	for _, product := range products {
+		productsByID[product.ID] = product
+	}
+
+	for _, item := range items {
+		variant := variantsByID[item.VariantID]
+		line := checkCartLine(item, variant, productsByID[variant.ProductID])
+
+		if line.Status == CartLineOK {
+			view.Total += line.Price * int64(line.Quantity)
+		} else {
+			view.Valid = false
+		}
+		view.Lines = append(view.Lines, line)
+	}
+	return view, nil
+}
+
+// checkCartLine compares a cart item with its catalog variant and product.
+// A zero variant or product means it no longer exists.
+func checkCartLine(item models.CartItem, variant models.ProductVariant, product models.Product) CartLine {
+	line := CartLine{
+		ItemID:     item.ID,
+		VariantID:  item.VariantID,
+		Quantity:   item.Quantity,
+		AddedPrice: item.Price,
+		Status:     CartLineOK,
+	}
+
+	if variant.ID == 0 || product.ID == 0 || !variant.Active || !product.Active {
+		line.Status = CartLineUnavailable
+		return line
+	}
+
+	line.ProductID = product.ID
+	line.SellerID = product.SellerID
+	line.Title = product.Title
+	line.VariantName = variant.Name
+	line.SKU = variant.SKU
+	line.Currency = product.Currency
+	line.Price = variant.Price
+	line.PriceChanged = variant.Price != item.Price
+	line.Stock = variant.Stock
+	line.Weight = variant.Weight
+	if variant.Stock == 0 {
+		line.Status = CartLineOutOfStock
+	} else if variant.Stock < item.Quantity {
+		line.Status = CartLineInsufficientStock
+	}
+	return line
+}
+
+func cartCacheKey(cartID uuid.UUID) string {
+	return "cart:" + cartID.String()
+}
+
+// InvalidateCartCache drops the cached carts. Call it after the change is
+// committed, otherwise a concurrent read may cache the old items again.
+func InvalidateCartCache(cartIDs ...uuid.UUID) {
+	if initializers.RedisClient == nil {
+		return
+	}
+	keys := make([]string, 0, len(cartIDs))
+	for _, cartID := range cartIDs {
+		if cartID != uuid.Nil {
+			keys = append(keys, cartCacheKey(cartID))
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := initializers.RedisClient.Del(context.Background(), keys...).Err(); err != nil {
+		log.Println("Failed to invalidate cart cache:", err)
+	}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"testing"
+)
+
+func TestCheckCartLine(t *testing.T) {
+	product := models.Product{ID: 1, Active: true, Title: "Чайник"}
+	variant := models.ProductVariant{ID: 10, ProductID: 1, Active: true, Price: 5000, Stock: 3}
+	item := models.CartItem{VariantID: 10, Quantity: 2, Price: 5000}
+
+	tests := []struct {
+		name         string
+		item         models.CartItem
+		variant      models.ProductVariant
+		product      models.Product
+		status       string
+		priceChanged bool
+	}{
+		{name: "in stock", item: item, variant: variant, product: product, status: CartLineOK},
+		{name: "variant removed", item: item, product: product, status: CartLineUnavailable},
+		{name: "product removed", item: item, variant: variant, status: CartLineUnavailable},
+		{
+			name:    "variant switched off",
+			item:    item,
+			variant: models.ProductVariant{ID: 10, ProductID: 1, Price: 5000, Stock: 3},
+			product: product,
+			status:  CartLineUnavailable,
+		},
+		{
+			name:    "sold out",
+			item:    item,
+			variant: models.ProductVariant{ID: 10, ProductID: 1, Active: true, Price: 5000},
+			product: product,
+			status:  CartLineOutOfStock,
+		},
+		{
+			name:    "not enough left",
+			item:    models.CartItem{VariantID: 10, Quantity: 5, Price: 5000},
+			variant: variant,
+			product: product,
+			status:  CartLineInsufficientStock,
+		},
+		{
+			name:         "price went up since it was added",
+			item:         models.CartItem{VariantID: 10, Quantity: 1, Price: 4500},
+			variant:      variant,
+			product:      product,
+			status:       CartLineOK,
+			priceChanged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := checkCartLine(tt.item, tt.variant, tt.product)
+			if line.Status != tt.status || line.PriceChanged != tt.priceChanged {
+				t.Fatalf("checkCartLine() = %s, price changed %v; want %s, %v", line.Status, line.PriceChanged, tt.status, tt.priceChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of models.ChatAttachment.
+const (
+	ChatAttachmentImage = "image"
+	ChatAttachmentFile  = "file"
+	ChatAttachmentVoice = "voice"
+)
+
+const (
+	ChatAttachmentsPerMessage = 10
+	chatAttachmentMaxSize     = 20 * 1024 * 1024 // the request body limit of the API
+	chatVoiceMaxDuration      = 10 * 60          // seconds
+	chatThumbnailSize         = 320
+	chatImageMaxSide          = 10000
+	chatImageMaxPixels        = 40 * 1000 * 1000 // decoded, about 160 MB of RGBA
+	chatUploadDailyQuota      = 500 * 1024 * 1024
+	chatUnattachedTTL         = 24 * time.Hour
+)
+
+var (
+	ErrAttachmentTooLarge = errors.New("attachment exceeds the size limit of 20 MB")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+	ErrAttachmentDuration = errors.New("voice note duration must be between 1 and 600 seconds")
+	ErrAttachmentNotFound = errors.New("attachment not found or already attached")
+	ErrAttachmentTooMany  = errors.New("too many attachments in one message")
+	ErrAttachmentPixels   = errors.New("image dimensions exceed the limit of 40 megapixels")
+	ErrAttachmentQuota    = errors.New("daily upload quota of 500 MB is used up")
+)
+
+var chatImageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// Voice notes are recorded by browsers and phones in different containers,
+// most of which http.DetectContentType does not know, so the container is
+// recognized by its signature.
+var chatVoiceTypes = map[string]string{
+	"audio/ogg":  ".ogg",
+	"audio/webm": ".webm",
+	"audio/mpeg": ".mp3",
+	"audio/mp4":  ".m4a",
+	"audio/aac":  ".aac",
+	"audio/wav":  ".wav",
+}
+
+// sniffVoiceType returns the MIME type of the audio container in contents.
+func sniffVoiceType(contents []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(contents, []byte("OggS")):
+		return "audio/ogg", true
+	case bytes.HasPrefix(contents, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "audio/webm", true
+	case bytes.HasPrefix(contents, []byte("ID3")):
+		return "audio/mpeg", true
+	case len(contents) >= 12 && string(contents[:4]) == "RIFF" && string(contents[8:12]) == "WAVE":
+		return "audio/wav", true
+	case len(contents) >= 8 && string(contents[4:8]) == "ftyp":
+		return "audio/mp4", true
+	case len(contents) >= 2 && contents[0] == 0xFF && contents[1]&0xF6 == 0xF0:
+		return "audio/aac", true // ADTS frame
+	case len(contents) >= 2 && contents[0] == 0xFF && contents[1]&0xE0 == 0xE0 && contents[1]&0x06 != 0:
+		return "audio/mpeg", true // MPEG audio frame without a tag
+	}
+	return "", false
+}
+
+// ChatStorePath returns the directory of chat attachments. It is not served
+// statically, attachments are downloaded through the API by room members.
+func ChatStorePath(config initializers.Config) string {
+	if config.ChatStorePath != "" {
+		return config.ChatStorePath
+	}
+	return "chat-store"
+}
+
+// SaveChatAttachment stores an uploaded file under the room directory and
+// returns the not yet attached record. Images get a thumbnail and their
+// dimensions, voice notes keep the duration reported by the client. Images
+// are decoded only after their header shows acceptable dimensions.
+func SaveChatAttachment(config initializers.Config, roomID uint64, userID uuid.UUID, kind string, file *multipart.FileHeader, duration int) (models.ChatAttachment, error) {
+	attachment := models.ChatAttachment{
+		RoomID:   roomID,
+		UserID:   userID,
+		Kind:     kind,
+		FileName: filepath.Base(file.Filename),
+		Size:     file.Size,
+	}
+	if file.Size > chatAttachmentMaxSize {
+		return attachment, ErrAttachmentTooLarge
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return attachment, err
+	}
+	defer src.Close()
+	contents, err := io.ReadAll(src)
+	if err != nil {
+		return attachment, err
+	}
+
+	fileExt := strings.ToLower(filepath.Ext(file.Filename))
+	detected := http.DetectContentType(contents)
+	switch kind {
+	case ChatAttachmentImage:
+		ext, ok := chatImageTypes[detected]
+		if !ok {
+			return attachment, ErrAttachmentType
+		}
+		imageConfig, _, err := image.DecodeConfig(bytes.NewReader(contents))
+		if err != nil {
+			return attachment, ErrAttachmentType
+		}
+		if imageConfig.Width > chatImageMaxSide || imageConfig.Height > chatImageMaxSide ||
+			imageConfig.Width*imageConfig.Height > chatImageMaxPixels {
+			return attachment, ErrAttachmentPixels
+		}
+		attachment.MimeType, fileExt = detected, ext
+	case ChatAttachmentVoice:
+		mimeType, ok := sniffVoiceType(contents)
+		if !ok {
+			return attachment, ErrAttachmentType
+		}
+		if duration < 1 || duration > chatVoiceMaxDuration {
+			return attachment, ErrAttachmentDuration
+		}
+		attachment.MimeType, attachment.Duration = mimeType, duration
+		fileExt = chatVoiceTypes[mimeType]
+	case ChatAttachmentFile:
+		attachment.MimeType = detected
+		if len(fileExt) > 10 {
+			fileExt = ""
+		}
+	default:
+		return attachment, ErrAttachmentType
+	}
+
+	name, err := randomFileName()
+	if err != nil {
+		return attachment, err
+	}
+	dir := filepath.Join(ChatStorePath(config), fmt.Sprint(roomID))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return attachment, err
+	}
+	attachment.Path = filepath.Join(fmt.Sprint(roomID), name+fileExt)
+	if err := os.WriteFile(filepath.Join(ChatStorePath(config), attachment.Path), contents, 0o644); err != nil {
+		return attachment, err
+	}
+
+	if kind == ChatAttachmentImage {
+		img, _, err := image.Decode(bytes.NewReader(contents))
+		if err != nil {
+			os.Remove(filepath.Join(ChatStorePath(config), attachment.Path))
+			return attachment, ErrAttachmentType
+		}
+		attachment.Width, attachment.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+		thumbnail := imaging.Fit(img, chatThumbnailSize, chatThumbnailSize, imaging.Lanczos)
+		attachment.ThumbnailPath = filepath.Join(fmt.Sprint(roomID), name+"_thumb.jpg")
+		if err := imaging.Save(thumbnail, filepath.Join(ChatStorePath(config), attachment.ThumbnailPath)); err != nil {
+			return attachment, err
+		}
+	}
+	return attachment, nil
+}
+
+// RemoveChatAttachmentFiles deletes the stored files of the attachment.
+func RemoveChatAttachmentFiles(config initializers.Config, attachment models.ChatAttachment) {
+	os.Remove(filepath.Join(ChatStorePath(config), attachment.Path))
+	if attachment.ThumbnailPath != "" {
+		os.Remove(filepath.Join(ChatStorePath(config), attachment.ThumbnailPath))
+	}
+}
+
+// CheckChatUploadQuota fails when the user uploaded more than the daily
+// quota with this upload. The user row stays locked until the transaction
+// ends, so parallel uploads are counted one after another.
+func CheckChatUploadQuota(tx *gorm.DB, userID uuid.UUID, size int64) error {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	var used int64
+	if err := tx.Model(&models.ChatAttachment{}).
+		Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-24*time.Hour)).
+		Select("COALESCE(SUM(size), 0)").Scan(&used).Error; err != nil {
+		return err
+	}
+	if used+size > chatUploadDailyQuota {
+		return ErrAttachmentQuota
+	}
+	return nil
+}
+
+// SweepUnattachedChatAttachments deletes uploads that were never sent with
+// a message and their files.
+func SweepUnattachedChatAttachments(config initializers.Config) (int, error) {
+	var attachments []models.ChatAttachment
+	if err := initializers.DB.Where("message_id IS NULL AND created_at < ?", time.Now().Add(-chatUnattachedTTL)).
+		Limit(500).Find(&attachments).Error; err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, attachment := range attachments {
+		result := initializers.DB.Where("id = ? AND message_id IS NULL", attachment.ID).Delete(&models.ChatAttachment{})
+		if result.Error != nil {
+			return removed, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue // attached meanwhile
+		}
+		RemoveChatAttachmentFiles(config, attachment)
+		removed++
+	}
+	return removed, nil
+}
+
+// OrderChatAttachments is the preload scope of ChatMessage.Attachments.
+// Lists of messages preload them, so serializing a message does not query
+// its attachments.
+func OrderChatAttachments(db *gorm.DB) *gorm.DB {
+	return db.Order("id")
+}
+
+// AttachToMessageTx binds the attachments uploaded by the message author to
+// the room to the message and loads them into message.Attachments.
+func AttachToMessageTx(tx *gorm.DB, message *models.ChatMessage, attachmentIDs []uint64) error {
+	if len(attachmentIDs) == 0 {
+		return nil
+	}
+	if len(attachmentIDs) > ChatAttachmentsPerMessage {
+		return ErrAttachmentTooMany
+	}
+
+	result := tx.Model(&models.ChatAttachment{}).
+		Where("id IN ? AND room_id = ? AND user_id = ? AND message_id IS NULL", attachmentIDs, message.RoomID, message.UserID).
+		Update("message_id", message.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if int(result.RowsAffected) != len(attachmentIDs) {
+		return ErrAttachmentNotFound
+	}
+	return tx.Where("message_id = ?", message.ID).Order("id").Find(&message.Attachments).Error
+}
+
+func SerializeChatAttachment(attachment models.ChatAttachment) map[string]interface{} {
+	url := fmt.Sprintf("/api/chat/attachment/%d", attachment.ID)
+	thumbnailURL := ""
+	if attachment.ThumbnailPath != "" {
+		thumbnailURL = url + "/thumbnail"
+	}
+	return map[string]interface{}{
+		"id":            attachment.ID,
+		"kind":          attachment.Kind,
+		"file_name":     attachment.FileName,
+		"mime_type":     attachment.MimeType,
+		"size":          attachment.Size,
+		"width":         attachment.Width,
+		"height":        attachment.Height,
+		"duration":      attachment.Duration,
+		"url":           url,
+		"thumbnail_url": thumbnailURL,
+	}
+}
+
+func randomFileName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,27 @@
+package utils
+
+import "testing"
+
+func TestSniffVoiceType(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents []byte
+		want     string
+	}{
+		{"ogg", []byte("OggS\x00\x02"), "audio/ogg"},
+		{"webm", []byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F}, "audio/webm"},
+		{"mp3 with tag", []byte("ID3\x04\x00"), "audio/mpeg"},
+		{"mp3 frame", []byte{0xFF, 0xFB, 0x90, 0x64}, "audio/mpeg"},
+		{"aac", []byte{0xFF, 0xF1, 0x50, 0x80}, "audio/aac"},
+		{"wav", []byte("RIFF\x24\x08\x00\x00WAVEfmt "), "audio/wav"},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A "), "audio/mp4"},
+		{"png renamed to ogg", []byte("\x89PNG\r\n\x1a\n"), ""},
+		{"script", []byte("#!/bin/sh\n"), ""},
+	}
+	for _, tc := range cases {
+		got, ok := sniffVoiceType(tc.contents)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("%s: sniffVoiceType = %q, %v, want %q", tc.name, got, ok, tc.want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Values of models.ChatExport.Status.
+const (
+	ChatExportPending    = "pending"
+	ChatExportProcessing = "processing"
+	ChatExportReady      = "ready"
+	ChatExportFailed     = "failed"
+	ChatExportExpired    = "expired"
+)
+
+const (
+	ChatExportTTL        = 72 * time.Hour
+	chatExportStaleAfter = 30 * time.Minute // a worker died while building
+	chatExportBatch      = 500
+)
+
+var ErrChatExportInProgress = errors.New("a chat export is already in progress")
+
+// RequestChatExport queues an export for the user. A user has at most one
+// export in progress.
+func RequestChatExport(db *gorm.DB, userID uuid.UUID, roomID *uint64, reportID *uint64) (models.ChatExport, error) {
+	export := models.ChatExport{UserID: userID, RoomID: roomID, ReportID: reportID, Status: ChatExportPending}
+
+	var count int64
+	if err := db.Model(&models.ChatExport{}).
+		Where("user_id = ? AND status IN ?", userID, []string{ChatExportPending, ChatExportProcessing}).
+		Count(&count).Error; err != nil {
+		return export, err
+	}
+	if count > 0 {
+		return export, ErrChatExportInProgress
+	}
+	return export, db.Create(&export).Error
+}
+
+// ChatExportURL is the download link of a ready export.
+func ChatExportURL(export models.ChatExport) string {
+	return fmt.Sprintf("/api/chat/export/%d/download", export.ID)
+}
+
+// ProcessChatExports builds the queued exports and notifies their users.
+// Exports left in processing by a stopped worker are built again.
+func ProcessChatExports(config initializers.Config) (int, error) {
+	var ids []uint64
+	if err := initializers.DB.Model(&models.ChatExport{}).
+		Where("status = ? OR (status = ? AND started_at < ?)", ChatExportPending, ChatExportProcessing, time.Now().Add(-chatExportStaleAfter)).
+		Order("id").
+		Limit(10).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	built := 0
+	for _, id := range ids {
+		export, err := claimChatExport(id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Println("Failed to claim chat export", id, err)
+			continue
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{"completed_at": now}
+		path, size, err := buildChatExport(config, export)
+		if err != nil {
+			log.Println("Failed to build chat export", id, err)
+			updates["status"] = ChatExportFailed
+			updates["error"] = err.Error()
+		} else {
+			updates["status"] = ChatExportReady
+			updates["path"] = path
+			updates["size"] = size
+			updates["expires_at"] = now.Add(ChatExportTTL)
+		}
+		// A worker that took over a stale claim owns the export now
+		result := initializers.DB.Model(&export).Where("status = ? AND started_at = ?", ChatExportProcessing, export.StartedAt).Updates(updates)
+		if result.Error != nil {
+			log.Println("Failed to update chat export", id, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			if path, ok := updates["path"].(string); ok {
+				os.Remove(filepath.Join(ChatStorePath(config), path))
+			}
+			continue
+		}
+
+		if updates["status"] == ChatExportReady {
+			built++
+			Notification("Архив переписки готов", "Ссылка действует до "+now.Add(ChatExportTTL).Format("02.01.2006 15:04"), export.UserID.String(), ChatExportURL(export))
+		} else {
+			Notification("Не удалось выгрузить переписку", "Попробуйте запросить архив еще раз", export.UserID.String(), "")
+		}
+	}
+	return built, nil
+}
+
+// claimChatExport takes a pending export, or one left in processing by a
+// stopped worker, in a single conditional update. gorm.ErrRecordNotFound
+// means another worker holds it.
+func claimChatExport(id uint64) (models.ChatExport, error) {
+	var export models.ChatExport
+	now := time.Now()
+	result := initializers.DB.Model(&models.ChatExport{}).
+		Where("id = ? AND (status = ? OR (status = ? AND started_at < ?))",
+			id, ChatExportPending, ChatExportProcessing, now.Add(-chatExportStaleAfter)).
+		Updates(map[string]interface{}{"status": ChatExportProcessing, "started_at": now})
+	if result.Error != nil {
+		return export, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return export, gorm.ErrRecordNotFound
+	}
+	return export, initializers.DB.First(&export, id).Error
+}
+
+// ExpireChatExports deletes the archives of expired exports.
+func ExpireChatExports(config initializers.Config) (int, error) {
+	var exports []models.ChatExport
+	if err := initializers.DB.Where("status = ? AND expires_at < ?", ChatExportReady, time.Now()).Find(&exports).Error; err != nil {
+		return 0, err
+	}
+	for _, export := range exports {
+		os.Remove(filepath.Join(ChatStorePath(config), export.Path))
+		if err := initializers.DB.Model(&export).Updates(map[string]interface{}{"status": ChatExportExpired, "path": ""}).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(exports), nil
+}
+
+// chatTranscript is the data of templates/chatTranscript.html.
+type chatTranscript struct {
+	Title      string
+	ExportedAt string
+	Members    string
+	Lines      []chatTranscriptLine
+}
+
+type chatTranscriptLine struct {
+	ID          uint64
+	Time        string
+	Author      string
+	Content     string
+	ReplyTo     uint64
+	Forwarded   string
+	Edited      bool
+	Deleted     bool
+	System      bool
+	Attachments []chatTranscriptFile
+}
+
+type chatTranscriptFile struct {
+	Name string
+	Path string
+}
+
+// buildChatExport writes the ZIP of the export to the chat store and returns
+// its path and size. Every room gets messages.json with the serialized room
+// and messages, transcript.html and its attachments.
+func buildChatExport(config initializers.Config, export models.ChatExport) (string, int64, error) {
+	var roomIDs []uint64
+	if export.RoomID != nil {
+		roomIDs = []uint64{*export.RoomID}
+	} else if err := initializers.DB.Model(&models.ChatRoomMember{}).Where("user_id = ?", export.UserID).Order("room_id").Pluck("room_id", &roomIDs).Error; err != nil {
+		return "", 0, err
+	}
+
+	templates, err := ParseTemplateDir("templates")
+	if err != nil {
+		return "", 0, err
+	}
+
+	name, err := randomFileName()
+	if err != nil {
+		return "", 0, err
+	}
+	path := filepath.Join("exports", name+".zip")
+	fullPath := filepath.Join(ChatStorePath(config), path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", 0, err
+	}
+	file, err := os.Create(fullPath)
+	if err != nil {
+		return "", 0, err
+	}
+
+	archive := zip.NewWriter(file)
+	err = writeChatExport(config, archive, templates.Lookup("chatTranscript.html"), export, roomIDs)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fullPath)
+		return "", 0, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", 0, err
+	}
+	return path, info.Size(), nil
+}
+
+func writeChatExport(config initializers.Config, archive *zip.Writer, transcript *template.Template, export models.ChatExport, roomIDs []uint64) error {
+	if transcript == nil {
+		return errors.New("template chatTranscript.html not found")
+	}
+	exportedAt := time.Now()
+
+	index := []map[string]interface{}{}
+	for _, roomID := range roomIDs {
+		room := SerializeChatRoom(roomID)
+		if room == nil {
+			continue
+		}
+		dir := fmt.Sprintf("rooms/%d/", roomID)
+
+		messages := []map[string]interface{}{}
+		data := chatTranscript{
+			Title:      chatExportRoomTitle(room, export.UserID),
+			ExportedAt: exportedAt.Format("02.01.2006 15:04"),
+			Members:    chatExportMemberNames(room),
+		}
+
+		var batch []models.ChatMessage
+		err := initializers.DB.Preload("Attachments", OrderChatAttachments).Where("room_id = ?", roomID).FindInBatches(&batch, chatExportBatch, func(tx *gorm.DB, _ int) error {
+			for _, message := range batch {
+				redactDeletedChatMessage(&message)
+				serialized := SerializeChatMessage(message)
+				if serialized == nil {
+					continue
+				}
+				messages = append(messages, serialized)
+
+				line := chatTranscriptLine{
+					ID:      message.ID,
+					Time:    message.CreatedAt.Format("02.01.2006 15:04"),
+					Author:  chatExportUserName(serialized["user"]),
+					Content: message.Content,
+					Edited:  message.IsEdited,
+					Deleted: message.IsDeleted,
+					System:  message.MsgType == ChatMsgTypeSystem,
+				}
+				if message.ParentMessageID != nil {
+					line.ReplyTo = *message.ParentMessageID
+				}
+				if forwarded, ok := serialized["forward_from"].(map[string]interface{}); ok {
+					line.Forwarded = chatExportUserName(forwarded["user"])
+				}
+				if !message.IsDeleted {
+					for _, attachment := range message.Attachments {
+						name := fmt.Sprintf("attachments/%d_%s", attachment.ID, chatExportFileName(attachment.FileName))
+						if err := copyChatExportFile(archive, filepath.Join(ChatStorePath(config), attachment.Path), dir+name); err != nil {
+							log.Println("Failed to export chat attachment", attachment.ID, err)
+							continue
+						}
+						line.Attachments = append(line.Attachments, chatTranscriptFile{Name: attachment.FileName, Path: name})
+					}
+				}
+				data.Lines = append(data.Lines, line)
+			}
+			return nil
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := writeChatExportJSON(archive, dir+"messages.json", map[string]interface{}{"room": room, "messages": messages}); err != nil {
+			return err
+		}
+		var html bytes.Buffer
+		if err := transcript.Execute(&html, data); err != nil {
+			return err
+		}
+		w, err := archive.Create(dir + "transcript.html")
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(html.Bytes()); err != nil {
+			return err
+		}
+
+		index = append(index, map[string]interface{}{"id": roomID, "title": data.Title, "messages": len(messages), "path": dir})
+	}
+
+	return writeChatExportJSON(archive, "index.json", map[string]interface{}{
+		"exported_at": exportedAt,
+		"user_id":     export.UserID,
+		"report_id":   export.ReportID,
+		"rooms":       index,
+	})
+}
+
+func writeChatExportJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func copyChatExportFile(archive *zip.Writer, source string, name string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// redactDeletedChatMessage blanks a deleted message, it keeps its place in
+// the export but not what was said.
+func redactDeletedChatMessage(message *models.ChatMessage) {
+	if !message.IsDeleted {
+		return
+	}
+	message.Content = ""
+	message.JsonData = nil
+	message.Attachments = nil
+}
+
+// chatExportRoomTitle is the group title or the names of the other members
+// of a direct room.
+func chatExportRoomTitle(room map[string]interface{}, userID uuid.UUID) string {
+	if title, _ := room["title"].(string); title != "" {
+		return title
+	}
+	var names []string
+	members, _ := room["members"].([]map[string]interface{})
+	for _, member := range members {
+		user, _ := member["user"].(map[string]interface{})
+		if id, _ := user["id"].(uuid.UUID); id != userID {
+			names = append(names, chatExportUserName(user))
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("Room %v", room["id"])
+	}
+	return strings.Join(names, ", ")
+}
+
+func chatExportMemberNames(room map[string]interface{}) string {
+	var names []string
+	members, _ := room["members"].([]map[string]interface{})
+	for _, member := range members {
+		names = append(names, chatExportUserName(member["user"]))
+	}
+	return strings.Join(names, ", ")
+}
+
+func chatExportUserName(user interface{}) string {
+	serialized, _ := user.(map[string]interface{})
+	if name, _ := serialized["name"].(string); name != "" {
+		return name
+	}
+	return "Unknown user"
+}
+
+// chatExportFileName keeps the uploaded file name safe inside the archive.
+func chatExportFileName(name string) string {
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(filepath.Base(name))
+	if name == "." || name == ".." || name == "" {
+		return "file"
+	}
+	return name
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func chatExportRoom(title string, users ...map[string]interface{}) map[string]interface{} {
+	members := []map[string]interface{}{}
+	for _, user := range users {
+		members = append(members, map[string]interface{}{"user": user})
+	}
+	return map[string]interface{}{"id": uint64(7), "title": title, "members": members}
+}
+
+func TestChatExportRoomTitle(t *testing.T) {
+	me := uuid.NewV4()
+	self := map[string]interface{}{"id": me, "name": "Me"}
+	anna := map[string]interface{}{"id": uuid.NewV4(), "name": "Anna"}
+	boris := map[string]interface{}{"id": uuid.NewV4(), "name": "Boris"}
+	nameless := map[string]interface{}{"id": uuid.NewV4()}
+
+	tests := []struct {
+		name string
+		room map[string]interface{}
+		want string
+	}{
+		{name: "group title", room: chatExportRoom("Team", self, anna), want: "Team"},
+		{name: "direct room", room: chatExportRoom("", self, anna), want: "Anna"},
+		{name: "several other members", room: chatExportRoom("", anna, self, boris), want: "Anna, Boris"},
+		{name: "member without a name", room: chatExportRoom("", self, nameless), want: "Unknown user"},
+		{name: "alone in the room", room: chatExportRoom("", self), want: "Room 7"},
+		{name: "no members", room: map[string]interface{}{"id": uint64(7)}, want: "Room 7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chatExportRoomTitle(tt.room, me); got != tt.want {
+				t.Fatalf("chatExportRoomTitle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatExportMemberNames(t *testing.T) {
+	room := chatExportRoom("", map[string]interface{}{"name": "Anna"}, map[string]interface{}{}, map[string]interface{}{"name": "Boris"})
+	if got, want := chatExportMemberNames(room), "Anna, Unknown user, Boris"; got != want {
+		t.Fatalf("chatExportMemberNames() = %q, want %q", got, want)
+	}
+	if got := chatExportMemberNames(map[string]interface{}{}); got != "" {
+		t.Fatalf("chatExportMemberNames(no members) = %q, want empty", got)
+	}
+}
+
+func TestChatExportUserName(t *testing.T) {
+	tests := []struct {
+		name string
+		user interface{}
+		want string
+	}{
+		{name: "named user", user: map[string]interface{}{"name": "Anna"}, want: "Anna"},
+		{name: "empty name", user: map[string]interface{}{"name": ""}, want: "Unknown user"},
+		{name: "deleted user", user: nil, want: "Unknown user"},
+		{name: "not a serialized user", user: "Anna", want: "Unknown user"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chatExportUserName(tt.user); got != tt.want {
+				t.Fatalf("chatExportUserName(%v) = %q, want %q", tt.user, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatExportFileName(t *testing.T) {
+	tests := map[string]string{
+		"photo.jpg":          "photo.jpg",
+		"../../etc/passwd":   "passwd",
+		"/abs/path/file.txt": "file.txt",
+		"dir\\evil.exe":      "dir_evil.exe",
+		"":                   "file",
+		".":                  "file",
+		"..":                 "file",
+		"/":                  "_",
+	}
+	for name, want := range tests {
+		if got := chatExportFileName(name); got != want {
+			t.Fatalf("chatExportFileName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRedactDeletedChatMessage(t *testing.T) {
+	data := `{"poll":1}`
+	message := func(deleted bool) models.ChatMessage {
+		return models.ChatMessage{
+			Content:     "secret",
+			JsonData:    &data,
+			IsDeleted:   deleted,
+			Attachments: []models.ChatAttachment{{FileName: "a.png"}},
+		}
+	}
+
+	deleted := message(true)
+	redactDeletedChatMessage(&deleted)
+	if deleted.Content != "" || deleted.JsonData != nil || deleted.Attachments != nil {
+		t.Fatalf("redactDeletedChatMessage(deleted) left %q, %v, %v", deleted.Content, deleted.JsonData, deleted.Attachments)
+	}
+
+	kept := message(false)
+	redactDeletedChatMessage(&kept)
+	if kept.Content != "secret" || kept.JsonData == nil || len(kept.Attachments) != 1 {
+		t.Fatalf("redactDeletedChatMessage(live) changed the message: %q, %v, %v", kept.Content, kept.JsonData, kept.Attachments)
+	}
+}
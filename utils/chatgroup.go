@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"time"
+)
+
+// Types of models.ChatMessage.
+const (
+	ChatMsgTypeCommon     uint8 = 0
+	ChatMsgTypeConference uint8 = 1
+	ChatMsgTypePostLink   uint8 = 2
+	ChatMsgTypeSystem     uint8 = 3 // room events, JsonData holds the event
+)
+
+// Events of system messages.
+const (
+	ChatEventCreated     = "created"
+	ChatEventJoined      = "joined"
+	ChatEventLeft        = "left"
+	ChatEventKicked      = "kicked"
+	ChatEventMuted       = "muted"
+	ChatEventUnmuted     = "unmuted"
+	ChatEventRoleChanged = "role_changed"
+	ChatEventOwnerChange = "owner_changed"
+	ChatEventUpdated     = "updated"
+	ChatEventRetention   = "retention_changed"
+)
+
+// Roles of group room members.
+const (
+	ChatRoleOwner  = "owner"
+	ChatRoleAdmin  = "admin"
+	ChatRoleMember = "member"
+)
+
+const defaultChatGroupMemberLimit = 200
+
+var chatRoleRanks = map[string]int{ChatRoleMember: 0, ChatRoleAdmin: 1, ChatRoleOwner: 2}
+
+// ChatGroupMemberLimit returns the largest allowed number of members of a
+// group room.
+func ChatGroupMemberLimit(config initializers.Config) int {
+	if config.ChatGroupMemberLimit > 0 {
+		return config.ChatGroupMemberLimit
+	}
+	return defaultChatGroupMemberLimit
+}
+
+// IsChatAdmin reports whether the member can manage the group room.
+func IsChatAdmin(member models.ChatRoomMember) bool {
+	return chatRoleRanks[member.Role] >= chatRoleRanks[ChatRoleAdmin]
+}
+
+// CanManageChatMember reports whether actor may kick or mute target: admins
+// manage members, the owner manages everyone else.
+func CanManageChatMember(actor models.ChatRoomMember, target models.ChatRoomMember) bool {
+	return IsChatAdmin(actor) && actor.UserID != target.UserID && chatRoleRanks[actor.Role] > chatRoleRanks[target.Role]
+}
+
+// IsChatMemberMuted reports whether the member may not send messages now.
+func IsChatMemberMuted(member models.ChatRoomMember) bool {
+	return member.MutedUntil != nil && member.MutedUntil.After(time.Now())
+}
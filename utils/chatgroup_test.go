@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestIsChatAdmin(t *testing.T) {
+	tests := map[string]bool{ChatRoleOwner: true, ChatRoleAdmin: true, ChatRoleMember: false, "": false}
+	for role, want := range tests {
+		if got := IsChatAdmin(models.ChatRoomMember{Role: role}); got != want {
+			t.Fatalf("IsChatAdmin(%q) = %v, want %v", role, got, want)
+		}
+	}
+}
+
+func TestCanManageChatMember(t *testing.T) {
+	member := func(role string) models.ChatRoomMember {
+		return models.ChatRoomMember{UserID: uuid.NewV4(), Role: role}
+	}
+	owner := member(ChatRoleOwner)
+
+	tests := []struct {
+		name   string
+		actor  models.ChatRoomMember
+		target models.ChatRoomMember
+		want   bool
+	}{
+		{name: "owner manages an admin", actor: owner, target: member(ChatRoleAdmin), want: true},
+		{name: "owner manages a member", actor: owner, target: member(ChatRoleMember), want: true},
+		{name: "admin manages a member", actor: member(ChatRoleAdmin), target: member(ChatRoleMember), want: true},
+		{name: "admin does not manage another admin", actor: member(ChatRoleAdmin), target: member(ChatRoleAdmin)},
+		{name: "admin does not manage the owner", actor: member(ChatRoleAdmin), target: owner},
+		{name: "member manages nobody", actor: member(ChatRoleMember), target: member(ChatRoleMember)},
+		{name: "owner does not manage themselves", actor: owner, target: owner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanManageChatMember(tt.actor, tt.target); got != tt.want {
+				t.Fatalf("CanManageChatMember() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsChatMemberMuted(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	if IsChatMemberMuted(models.ChatRoomMember{}) {
+		t.Fatal("member without a mute is muted")
+	}
+	if IsChatMemberMuted(models.ChatRoomMember{MutedUntil: &past}) {
+		t.Fatal("expired mute still applies")
+	}
+	if !IsChatMemberMuted(models.ChatRoomMember{MutedUntil: &future}) {
+		t.Fatal("active mute does not apply")
+	}
+}
+
+func TestChatGroupMemberLimit(t *testing.T) {
+	if got := ChatGroupMemberLimit(initializers.Config{}); got != defaultChatGroupMemberLimit {
+		t.Fatalf("default limit = %d, want %d", got, defaultChatGroupMemberLimit)
+	}
+	if got := ChatGroupMemberLimit(initializers.Config{ChatGroupMemberLimit: 50}); got != 50 {
+		t.Fatalf("configured limit = %d, want 50", got)
+	}
+}
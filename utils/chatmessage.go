@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"unicode"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	chatReactionMaxLength     = 32 // bytes, an emoji with modifiers
+	ChatReactionsPerUser      = 20 // distinct emojis of a user on one message
+	ChatPinnedMessagesPerRoom = 50
+)
+
+var (
+	ErrReactionEmoji   = errors.New("reaction must be a single emoji")
+	ErrReactionTooMany = errors.New("too many reactions on the message")
+	ErrPinnedLimit     = errors.New("too many pinned messages in the room")
+)
+
+// ChatReactionSummary is the number of users who reacted to a message with
+// the emoji.
+type ChatReactionSummary struct {
+	Emoji   string      `json:"emoji"`
+	Count   int         `json:"count"`
+	UserIDs []uuid.UUID `json:"user_ids"`
+}
+
+// IsReactionEmoji reports whether s looks like one emoji: pictographic
+// symbols with skin tone modifiers, joiners and variation selectors, or a
+// keycap.
+func IsReactionEmoji(s string) bool {
+	if s == "" || len(s) > chatReactionMaxLength {
+		return false
+	}
+	symbols := 0
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.So, r), r == '\u20e3': // keycap
+			symbols++
+		case r == '\u200d', r >= '\ufe00' && r <= '\ufe0f':
+		case unicode.Is(unicode.Sk, r) && r > unicode.MaxASCII:
+		case r >= '0' && r <= '9', r == '#', r == '*':
+		default:
+			return false
+		}
+	}
+	return symbols > 0
+}
+
+// ToggleChatReaction adds the reaction of the user to the message or removes
+// it when it is already there. It reports whether the reaction was added.
+func ToggleChatReaction(tx *gorm.DB, messageID uint64, userID uuid.UUID, emoji string) (bool, error) {
+	result := tx.Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).Delete(&models.ChatMessageReaction{})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return false, result.Error
+	}
+
+	var count int64
+	if err := tx.Model(&models.ChatMessageReaction{}).Where("message_id = ? AND user_id = ?", messageID, userID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count >= ChatReactionsPerUser {
+		return false, ErrReactionTooMany
+	}
+
+	reaction := models.ChatMessageReaction{MessageID: messageID, UserID: userID, Emoji: emoji}
+	return true, tx.Create(&reaction).Error
+}
+
+// ChatMessageReactions aggregates the reactions to the message, the emoji
+// used first goes first.
+func ChatMessageReactions(tx *gorm.DB, messageID uint64) []ChatReactionSummary {
+	var reactions []models.ChatMessageReaction
+	tx.Where("message_id = ?", messageID).Order("created_at, id").Find(&reactions)
+	return summarizeChatReactions(reactions)
+}
+
+func summarizeChatReactions(reactions []models.ChatMessageReaction) []ChatReactionSummary {
+	summaries := []ChatReactionSummary{}
+	index := map[string]int{}
+	for _, reaction := range reactions {
+		i, ok := index[reaction.Emoji]
+		if !ok {
+			i = len(summaries)
+			index[reaction.Emoji] = i
+			summaries = append(summaries, ChatReactionSummary{Emoji: reaction.Emoji, UserIDs: []uuid.UUID{}})
+		}
+		summaries[i].Count++
+		summaries[i].UserIDs = append(summaries[i].UserIDs, reaction.UserID)
+	}
+	return summaries
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestIsReactionEmoji(t *testing.T) {
+	tests := map[string]bool{
+		"👍":         true,
+		"❤️":        true, // with a variation selector
+		"👍🏽":        true, // with a skin tone
+		"👩‍💻":       true, // joined
+		"1️⃣":       true, // keycap
+		"":          false,
+		"a":         false,
+		"1":         false,
+		"ok 👍":      false,
+		"<b>👍":      false,
+		"👍\n":       false,
+		"👍👍👍👍👍👍👍👍👍": false, // longer than one emoji may be
+	}
+	for value, want := range tests {
+		if got := IsReactionEmoji(value); got != want {
+			t.Fatalf("IsReactionEmoji(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestSummarizeChatReactions(t *testing.T) {
+	alice, bob := uuid.NewV4(), uuid.NewV4()
+	summaries := summarizeChatReactions([]models.ChatMessageReaction{
+		{UserID: alice, Emoji: "🔥"},
+		{UserID: bob, Emoji: "👍"},
+		{UserID: bob, Emoji: "🔥"},
+	})
+
+	if len(summaries) != 2 {
+		t.Fatalf("summaries = %+v, want 2", summaries)
+	}
+	if summaries[0].Emoji != "🔥" || summaries[0].Count != 2 || summaries[0].UserIDs[0] != alice || summaries[0].UserIDs[1] != bob {
+		t.Fatalf("first summary = %+v, want 🔥 by both in order", summaries[0])
+	}
+	if summaries[1].Emoji != "👍" || summaries[1].Count != 1 {
+		t.Fatalf("second summary = %+v, want one 👍", summaries[1])
+	}
+	if empty := summarizeChatReactions(nil); empty == nil || len(empty) != 0 {
+		t.Fatalf("no reactions = %#v, want an empty list", empty)
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// unreadCountExpr recounts the unread messages of a member after the read
+// watermark moves to the message in the first argument.
+const unreadCountExpr = `(
+	SELECT COUNT(*) FROM chat_messages m
+	WHERE m.room_id = chat_room_members.room_id AND m.user_id != chat_room_members.user_id
+		AND m.id > GREATEST(COALESCE(chat_room_members.last_read_message_id, 0), ?)
+		AND NOT m.is_deleted AND m.msg_type != 3
+)`
+
+// MarkChatRead moves the read and delivered watermarks of the member up to
+// the message, never back, and recounts its unread messages. The member is
+// reloaded with the stored values.
+func MarkChatRead(tx *gorm.DB, member *models.ChatRoomMember, messageID uint64) error {
+	now := time.Now()
+	return tx.Model(member).Clauses(clause.Returning{}).Updates(map[string]interface{}{
+		"unread_count":              gorm.Expr(unreadCountExpr, messageID),
+		"last_read_message_id":      gorm.Expr("GREATEST(COALESCE(last_read_message_id, 0), ?)", messageID),
+		"last_delivered_message_id": gorm.Expr("GREATEST(COALESCE(last_delivered_message_id, 0), ?)", messageID),
+		"last_read_at":              now,
+		"last_delivered_at":         now,
+	}).Error
+}
+
+// MarkChatDelivered moves the delivered watermark of the member up to the
+// message, never back. It reports whether the watermark moved.
+func MarkChatDelivered(tx *gorm.DB, member *models.ChatRoomMember, messageID uint64) (bool, error) {
+	result := tx.Model(member).Clauses(clause.Returning{}).
+		Where("COALESCE(last_delivered_message_id, 0) < ?", messageID).
+		Updates(map[string]interface{}{
+			"last_delivered_message_id": messageID,
+			"last_delivered_at":         time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ChatUnreadTotal returns the unread messages of the user in subscribed
+// rooms and the number of rooms with unread messages or marked as unread.
+func ChatUnreadTotal(db *gorm.DB, userID uuid.UUID) (int64, int64, error) {
+	var total struct {
+		Messages int64
+		Rooms    int64
+	}
+	err := db.Model(&models.ChatRoomMember{}).
+		Select("COALESCE(SUM(unread_count), 0) AS messages, COUNT(*) FILTER (WHERE unread_count > 0 OR is_unread) AS rooms").
+		Where("user_id = ? AND is_subscribed = ?", userID, true).
+		Scan(&total).Error
+	return total.Messages, total.Rooms, err
+}
+
+// ChatMessageReceipts lists the other members of the room that got and read
+// the message.
+func ChatMessageReceipts(db *gorm.DB, message models.ChatMessage) ([]uuid.UUID, []uuid.UUID, error) {
+	var members []models.ChatRoomMember
+	if err := db.Where("room_id = ? AND user_id != ?", message.RoomID, message.UserID).Find(&members).Error; err != nil {
+		return nil, nil, err
+	}
+	delivered, read := messageReceipts(members, message.ID)
+	return delivered, read, nil
+}
+
+// messageReceipts splits the members by their watermarks into those that got
+// and those that read the message.
+func messageReceipts(members []models.ChatRoomMember, messageID uint64) ([]uuid.UUID, []uuid.UUID) {
+	delivered, read := []uuid.UUID{}, []uuid.UUID{}
+	for _, member := range members {
+		if member.LastDeliveredMessageID != nil && *member.LastDeliveredMessageID >= messageID {
+			delivered = append(delivered, member.UserID)
+		}
+		if member.LastReadMessageID != nil && *member.LastReadMessageID >= messageID {
+			read = append(read, member.UserID)
+		}
+	}
+	return delivered, read
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"reflect"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestMessageReceipts(t *testing.T) {
+	id := func(v uint64) *uint64 { return &v }
+	unseen, got, read, ahead := uuid.NewV4(), uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	members := []models.ChatRoomMember{
+		{UserID: unseen},
+		{UserID: got, LastDeliveredMessageID: id(10), LastReadMessageID: id(9)},
+		{UserID: read, LastDeliveredMessageID: id(10), LastReadMessageID: id(10)},
+		{UserID: ahead, LastDeliveredMessageID: id(15), LastReadMessageID: id(12)},
+	}
+
+	tests := []struct {
+		name          string
+		messageID     uint64
+		wantDelivered []uuid.UUID
+		wantRead      []uuid.UUID
+	}{
+		{name: "earlier message", messageID: 9, wantDelivered: []uuid.UUID{got, read, ahead}, wantRead: []uuid.UUID{got, read, ahead}},
+		{name: "watermark on the message", messageID: 10, wantDelivered: []uuid.UUID{got, read, ahead}, wantRead: []uuid.UUID{read, ahead}},
+		{name: "later message", messageID: 13, wantDelivered: []uuid.UUID{ahead}, wantRead: []uuid.UUID{}},
+		{name: "nobody got it", messageID: 20, wantDelivered: []uuid.UUID{}, wantRead: []uuid.UUID{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delivered, read := messageReceipts(members, tt.messageID)
+			if !reflect.DeepEqual(delivered, tt.wantDelivered) {
+				t.Fatalf("messageReceipts() delivered = %v, want %v", delivered, tt.wantDelivered)
+			}
+			if !reflect.DeepEqual(read, tt.wantRead) {
+				t.Fatalf("messageReceipts() read = %v, want %v", read, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestMessageReceiptsWithoutMembers(t *testing.T) {
+	delivered, read := messageReceipts(nil, 1)
+	if delivered == nil || read == nil || len(delivered) != 0 || len(read) != 0 {
+		t.Fatalf("messageReceipts(nil) = %v, %v, want empty lists", delivered, read)
+	}
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Values of models.ChatRoom.Retention.
+const (
+	ChatRetentionOff     = "off"
+	ChatRetention24Hours = "24h"
+	ChatRetention7Days   = "7d"
+	ChatRetention90Days  = "90d"
+)
+
+var chatRetentionPeriods = map[string]time.Duration{
+	ChatRetention24Hours: 24 * time.Hour,
+	ChatRetention7Days:   7 * 24 * time.Hour,
+	ChatRetention90Days:  90 * 24 * time.Hour,
+}
+
+var ErrChatRetentionValue = errors.New("retention must be off, 24h, 7d or 90d")
+
+// IsChatRetention reports whether value is a known retention policy.
+func IsChatRetention(value string) bool {
+	_, ok := chatRetentionPeriods[value]
+	return ok || value == ChatRetentionOff
+}
+
+// ExpiredChatMessageIDs returns messages to purge, oldest first: messages
+// older than the retention of their room and deleted messages whose content
+// is still stored.
+func ExpiredChatMessageIDs(db *gorm.DB, limit int) ([]uint64, error) {
+	now := time.Now()
+	conditions := db.Where("chat_messages.is_deleted = ?", true)
+	for retention, period := range chatRetentionPeriods {
+		conditions = conditions.Or("chat_rooms.retention = ? AND chat_messages.created_at < ?", retention, now.Add(-period))
+	}
+
+	var ids []uint64
+	err := db.Model(&models.ChatMessage{}).
+		Joins("JOIN chat_rooms ON chat_rooms.id = chat_messages.room_id").
+		Where("chat_messages.purged_at IS NULL").
+		Where(conditions).
+		Order("chat_messages.id").
+		Limit(limit).
+		Pluck("chat_messages.id", &ids).Error
+	return ids, err
+}
+
+// PurgeChatMessageTx deletes the message for everyone: the content, the
+// attachments and the reactions are wiped, the row stays as a deleted
+// message. It returns the attachments whose files are no longer used by
+// forwarded copies; the caller removes them after the commit.
+func PurgeChatMessageTx(tx *gorm.DB, message *models.ChatMessage) ([]models.ChatAttachment, error) {
+	now := time.Now()
+	if err := tx.Model(message).Updates(map[string]interface{}{
+		"content":      "",
+		"json_data":    nil,
+		"is_deleted":   true,
+		"deleted_at":   gorm.Expr("COALESCE(deleted_at, ?)", now),
+		"purged_at":    now,
+		"pinned_at":    nil,
+		"pinned_by_id": nil,
+	}).Error; err != nil {
+		return nil, err
+	}
+	message.Content = ""
+	message.JsonData = nil
+	message.IsDeleted = true
+	if message.DeletedAt == nil {
+		message.DeletedAt = &now
+	}
+	message.PurgedAt = &now
+	message.PinnedAt = nil
+	message.PinnedByID = nil
+	message.Attachments = []models.ChatAttachment{}
+
+	if err := tx.Where("message_id = ?", message.ID).Delete(&models.ChatMessageReaction{}).Error; err != nil {
+		return nil, err
+	}
+
+	var attachments []models.ChatAttachment
+	if err := tx.Where("message_id = ?", message.ID).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	if err := tx.Delete(&attachments).Error; err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(attachments))
+	for _, attachment := range attachments {
+		paths = append(paths, attachment.Path)
+	}
+	var shared []string
+	if err := tx.Model(&models.ChatAttachment{}).Where("path IN ?", paths).Distinct().Pluck("path", &shared).Error; err != nil {
+		return nil, err
+	}
+	return unusedChatAttachments(attachments, shared), nil
+}
+
+// unusedChatAttachments drops the attachments whose path is still shared by
+// another message.
+func unusedChatAttachments(attachments []models.ChatAttachment, shared []string) []models.ChatAttachment {
+	isShared := make(map[string]bool, len(shared))
+	for _, path := range shared {
+		isShared[path] = true
+	}
+	unused := attachments[:0]
+	for _, attachment := range attachments {
+		if !isShared[attachment.Path] {
+			unused = append(unused, attachment)
+		}
+	}
+	return unused
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIsChatRetention(t *testing.T) {
+	tests := map[string]bool{
+		ChatRetentionOff:     true,
+		ChatRetention24Hours: true,
+		ChatRetention7Days:   true,
+		ChatRetention90Days:  true,
+		"":                   false,
+		"30d":                false,
+		"24H":                false,
+	}
+	for value, want := range tests {
+		if got := IsChatRetention(value); got != want {
+			t.Fatalf("IsChatRetention(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestChatRetentionPeriods(t *testing.T) {
+	want := map[string]time.Duration{
+		ChatRetention24Hours: 24 * time.Hour,
+		ChatRetention7Days:   7 * 24 * time.Hour,
+		ChatRetention90Days:  90 * 24 * time.Hour,
+	}
+	if !reflect.DeepEqual(chatRetentionPeriods, want) {
+		t.Fatalf("chatRetentionPeriods = %v, want %v", chatRetentionPeriods, want)
+	}
+	if _, ok := chatRetentionPeriods[ChatRetentionOff]; ok {
+		t.Fatalf("chatRetentionPeriods has a period for %q", ChatRetentionOff)
+	}
+}
+
+func TestUnusedChatAttachments(t *testing.T) {
+	attachments := func() []models.ChatAttachment {
+		return []models.ChatAttachment{{ID: 1, Path: "a"}, {ID: 2, Path: "b"}, {ID: 3, Path: "c"}}
+	}
+
+	tests := []struct {
+		name   string
+		shared []string
+		want   []uint64
+	}{
+		{name: "nothing shared", want: []uint64{1, 2, 3}},
+		{name: "one forwarded copy", shared: []string{"b"}, want: []uint64{1, 3}},
+		{name: "all forwarded", shared: []string{"a", "b", "c"}, want: []uint64{}},
+		{name: "unrelated path", shared: []string{"z"}, want: []uint64{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []uint64{}
+			for _, attachment := range unusedChatAttachments(attachments(), tt.shared) {
+				got = append(got, attachment.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unusedChatAttachments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"html"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// ChatSearchConfigs maps the languages of the search box to Postgres text
+// search configs. Georgian has no stemmer in Postgres.
+var ChatSearchConfigs = map[string]string{
+	"ru": "russian",
+	"en": "english",
+	"es": "spanish",
+	"ka": "simple",
+}
+
+const (
+	chatSearchStartSel = "\x02"
+	chatSearchStopSel  = "\x03"
+	chatSearchOptions  = "StartSel=" + chatSearchStartSel + ", StopSel=" + chatSearchStopSel + ", MaxFragments=2, MinWords=8, MaxWords=24, FragmentDelimiter=\" … \""
+)
+
+// ChatSearchHit is a message matching the search with its highlighted
+// snippet.
+type ChatSearchHit struct {
+	ID      uint64
+	RoomID  uint64
+	Snippet string
+}
+
+// SearchChatMessages finds messages in the rooms of the user, newest first.
+// Without a language the query is parsed with every config, so it matches
+// messages indexed in any of them. beforeID continues a previous page and
+// roomID limits the search to one room when not zero.
+func SearchChatMessages(db *gorm.DB, userID uuid.UUID, query string, language string, roomID uint64, beforeID uint64, limit int) ([]ChatSearchHit, error) {
+	tsQuery := "websearch_to_tsquery(CAST(@config AS regconfig), @query)"
+	if language == "" {
+		tsQuery = "(websearch_to_tsquery('russian', @query) || websearch_to_tsquery('english', @query) || websearch_to_tsquery('spanish', @query) || websearch_to_tsquery('simple', @query))"
+	}
+
+	conditions := ""
+	if roomID != 0 {
+		conditions += " AND m.room_id = @room"
+	}
+	if beforeID != 0 {
+		conditions += " AND m.id < @before"
+	}
+
+	var hits []ChatSearchHit
+	err := db.Raw(`
+		SELECT m.id, m.room_id,
+			ts_headline(m.search_config, translate(m.content, E'\x02\x03', ''), q.query, @options) AS snippet
+		FROM chat_messages m
+		JOIN chat_room_members mem ON mem.room_id = m.room_id AND mem.user_id = @user
+		CROSS JOIN (SELECT `+tsQuery+` AS query) q
+		WHERE m.search_vector @@ q.query`+conditions+`
+		ORDER BY m.id DESC
+		LIMIT @limit
+	`,
+		map[string]interface{}{
+			"config":  ChatSearchConfigs[language],
+			"query":   query,
+			"options": chatSearchOptions,
+			"user":    userID,
+			"room":    roomID,
+			"before":  beforeID,
+			"limit":   limit,
+		}).Scan(&hits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range hits {
+		hits[i].Snippet = highlightSnippet(hits[i].Snippet)
+	}
+	return hits, nil
+}
+
+// highlightSnippet escapes the snippet for HTML and marks the matches.
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, chatSearchStartSel, "<mark>")
+	return strings.ReplaceAll(snippet, chatSearchStopSel, "</mark>")
+}
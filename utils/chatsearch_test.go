@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+		want    string
+	}{
+		{name: "plain text", snippet: "hello world", want: "hello world"},
+		{name: "marks the match", snippet: "say " + chatSearchStartSel + "hello" + chatSearchStopSel + " world", want: "say <mark>hello</mark> world"},
+		{name: "marks every match", snippet: chatSearchStartSel + "a" + chatSearchStopSel + " … " + chatSearchStartSel + "b" + chatSearchStopSel, want: "<mark>a</mark> … <mark>b</mark>"},
+		{name: "escapes html", snippet: "<script>alert('x')</script>", want: "&lt;script&gt;alert(&#39;x&#39;)&lt;/script&gt;"},
+		{name: "escapes html inside a match", snippet: chatSearchStartSel + "<b>" + chatSearchStopSel + " & co", want: "<mark>&lt;b&gt;</mark> &amp; co"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightSnippet(tt.snippet); got != tt.want {
+				t.Fatalf("highlightSnippet(%q) = %q, want %q", tt.snippet, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"fmt"
+	"hyperpage/models"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// OrderPlacement is a checkout request with the cart resolved to catalog
+// variants.
+type OrderPlacement struct {
+	BuyerID           uuid.UUID
+	Items             []CartItem
+	Address           models.DeliveryAddress
+	ShippingMethod    string               // label for sellers without shipping zones
+	ShippingMethods   map[uuid.UUID]uint64 // chosen method per seller, the cheapest when missing
+	PaymentMethod     string
+	CommissionPercent float64
+}
+
+// PlaceOrders takes the items off stock and creates one order per seller
+// with prices from the catalog and shipping cost from the seller's rates.
+// Orders paid from the balance are held in escrow right away. It must be called inside a DB transaction, so a cart
+// is either ordered as a whole or not at all.
+func PlaceOrders(tx *gorm.DB, placement OrderPlacement) ([]models.Order, error) {
+	payFromBalance := placement.PaymentMethod == OrderPaymentBalance
+
+	if err := ValidateDeliveryAddress(tx, &placement.Address); err != nil {
+		return nil, err
+	}
+
+	reserved, err := ReserveCartItems(tx, placement.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	var sellers []uuid.UUID
+	bySeller := map[uuid.UUID][]ReservedItem{}
+	for _, item := range reserved {
+		if item.Product.SellerID == placement.BuyerID {
+			return nil, fmt.Errorf("%w: %s", ErrProductUnavailable, item.Variant.SKU)
+		}
+		if payFromBalance && item.Product.Currency != "RUB" {
+			return nil, ErrWalletCurrencyMismatch
+		}
+		if _, ok := bySeller[item.Product.SellerID]; !ok {
+			sellers = append(sellers, item.Product.SellerID)
+		}
+		bySeller[item.Product.SellerID] = append(bySeller[item.Product.SellerID], item)
+	}
+
+	orders := make([]models.Order, 0, len(sellers))
+	for _, sellerID := range sellers {
+		var itemsAmount int64
+		weight := 0
+		for _, item := range bySeller[sellerID] {
+			itemsAmount += item.Amount()
+			weight += item.Variant.Weight * item.Quantity
+		}
+
+		shipping, err := ChooseShipping(tx, sellerID, placement.Address, itemsAmount, weight, placement.ShippingMethods[sellerID])
+		if err != nil {
+			return nil, err
+		}
+
+		order := models.Order{
+			ID:              uuid.NewV4(),
+			UserID:          placement.BuyerID,
+			SellerID:        sellerID,
+			TotalAmount:     MinorToMoney(itemsAmount),
+			ItemsAmount:     MinorToMoney(itemsAmount),
+			Status:          OrderStatusCreated,
+			ShippingMethod:  placement.ShippingMethod,
+			PaymentMethod:   placement.PaymentMethod,
+			DeliveryAddress: placement.Address,
+		}
+		if shipping != nil {
+			order.ShippingMethodID = &shipping.MethodID
+			order.ShippingMethod = shipping.Name
+			order.ShippingAmount = MinorToMoney(shipping.Cost)
+			order.TotalAmount = MinorToMoney(itemsAmount + shipping.Cost)
+		}
+		if err := tx.Create(&order).Error; err != nil {
+			return nil, err
+		}
+
+		for _, item := range bySeller[sellerID] {
+			orderItem := models.OrderItem{
+				ID:        uuid.NewV4(),
+				OrderID:   order.ID,
+				ProductID: &item.Product.ID,
+				VariantID: &item.Variant.ID,
+				SKU:       item.Variant.SKU,
+				Product:   item.Product.Title,
+				Price:     MinorToMoney(item.Variant.Price),
+				Quantity:  item.Quantity,
+			}
+			if item.Variant.Name != "" {
+				orderItem.Product += " (" + item.Variant.Name + ")"
+			}
+			if err := tx.Create(&orderItem).Error; err != nil {
+				return nil, err
+			}
+			order.OrderItems = append(order.OrderItems, orderItem)
+		}
+
+		if err := tx.Create(&models.OrderHistory{
+			ID:        uuid.NewV4(),
+			OrderID:   order.ID,
+			ToStatus:  OrderStatusCreated,
+			ActorID:   placement.BuyerID,
+			ActorRole: OrderRoleBuyer,
+		}).Error; err != nil {
+			return nil, err
+		}
+
+		if payFromBalance {
+			if err := PayOrderFromBalance(tx, &order, placement.CommissionPercent); err != nil {
+				return nil, err
+			}
+		}
+
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
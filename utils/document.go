@@ -0,0 +1,359 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds and purposes of models.Document.
+const (
+	DocumentKindInvoice = "invoice"
+	DocumentKindReceipt = "receipt"
+
+	DocumentPurposeOrder        = "order"
+	DocumentPurposeTopUp        = "topup"
+	DocumentPurposePlanPurchase = "plan_purchase"
+	DocumentPurposeDonation     = "donation"
+)
+
+const platformDocumentSeries = "platform"
+
+// Documents older than this are not emailed, so enabling SMTP does not
+// flood users with their history.
+const documentEmailWindow = 7 * 24 * time.Hour
+
+// A document claimed for emailing by a node that died is claimed again
+// after this. It covers a whole batch of PDF renders.
+const documentEmailClaimTTL = 30 * time.Minute
+
+// DocumentLine is a row of the document table.
+type DocumentLine struct {
+	Title    string
+	Quantity int
+	Price    string
+	Amount   string
+}
+
+// DocumentData is passed to the invoice_*, receipt_* and documentEmail_*
+// templates.
+type DocumentData struct {
+	Subject       string
+	Kind          string
+	Code          string
+	Date          string
+	IssuerName    string
+	CustomerName  string
+	CustomerEmail string
+	Title         string
+	Lines         []DocumentLine
+	Total         string
+	Currency      string
+	Address       string
+	Shipping      string
+	Payment       string
+}
+
+var documentSubjects = map[string]map[string]string{
+	"ru": {DocumentKindInvoice: "Счёт", DocumentKindReceipt: "Квитанция"},
+	"en": {DocumentKindInvoice: "Invoice", DocumentKindReceipt: "Receipt"},
+}
+
+var documentShippingTitles = map[string]string{"ru": "Доставка", "en": "Shipping"}
+
+// documentLanguage returns a language the document templates exist for.
+func documentLanguage(language string) string {
+	if _, ok := documentSubjects[language]; ok {
+		return language
+	}
+	if language == "" {
+		return "ru"
+	}
+	return "en"
+}
+
+func documentSeries(issuerID *uuid.UUID) string {
+	if issuerID == nil {
+		return platformDocumentSeries
+	}
+	return "user:" + issuerID.String()
+}
+
+func documentCode(kind string, issuerID *uuid.UUID, number int) string {
+	prefix := "R"
+	if kind == DocumentKindInvoice {
+		prefix = "INV"
+	}
+	if issuerID != nil {
+		prefix += "-" + strings.ToUpper(issuerID.String()[:8])
+	}
+	return fmt.Sprintf("%s-%06d", prefix, number)
+}
+
+// issueDocument takes the next number of the issuer's series and stores the
+// document. The counter row stays locked until the transaction ends, so
+// numbers have no gaps and no duplicates.
+func issueDocument(tx *gorm.DB, document *models.Document) error {
+	document.Series = documentSeries(document.IssuerID)
+
+	counter := models.DocumentCounter{Series: document.Series, Last: 1}
+	if err := tx.Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "series"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"last": gorm.Expr("document_counters.last + 1")}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "last"}}},
+	).Create(&counter).Error; err != nil {
+		return err
+	}
+
+	document.Number = counter.Last
+	document.Code = documentCode(document.Kind, document.IssuerID, document.Number)
+	if document.Currency == "" {
+		document.Currency = "RUB"
+	}
+	return tx.Create(document).Error
+}
+
+// IssueOrderInvoice issues the seller's invoice for an order when it is
+// paid. An order has one invoice, a second call returns it. It must be
+// called inside a DB transaction.
+func IssueOrderInvoice(tx *gorm.DB, order models.Order) (models.Document, error) {
+	var issued models.Document
+	if err := tx.Where("order_id = ?", order.ID).Limit(1).Find(&issued).Error; err != nil {
+		return issued, err
+	}
+	if issued.ID != 0 {
+		return issued, nil
+	}
+
+	document := models.Document{
+		Kind:     DocumentKindInvoice,
+		Purpose:  DocumentPurposeOrder,
+		IssuerID: &order.SellerID,
+		UserID:   order.UserID,
+		OrderID:  &order.ID,
+		Title:    "Заказ " + order.ID.String(),
+		Amount:   MoneyToMinor(order.TotalAmount),
+	}
+	return document, issueDocument(tx, &document)
+}
+
+// IssueReceipt issues a receipt for a ledger entry paid by userID. issuerID
+// is the user who received the money, nil when it is the platform. It must
+// be called inside a DB transaction.
+func IssueReceipt(tx *gorm.DB, purpose string, entry models.LedgerEntry, userID uuid.UUID, issuerID *uuid.UUID, amount int64) (models.Document, error) {
+	document := models.Document{
+		Kind:          DocumentKindReceipt,
+		Purpose:       purpose,
+		IssuerID:      issuerID,
+		UserID:        userID,
+		LedgerEntryID: &entry.ID,
+		Title:         entry.Description,
+		Amount:        amount,
+	}
+	return document, issueDocument(tx, &document)
+}
+
+// DocumentUserLanguage returns the interface language of the user.
+func DocumentUserLanguage(userID uuid.UUID) string {
+	var profile models.Profile
+	if err := initializers.DB.Select("lang").Where("user_id = ?", userID).First(&profile).Error; err != nil {
+		return ""
+	}
+	return profile.Lang
+}
+
+// BuildDocumentData collects what the templates show for the document.
+func BuildDocumentData(document models.Document, language string) (DocumentData, error) {
+	language = documentLanguage(language)
+
+	data := DocumentData{
+		Subject:  documentSubjects[language][document.Kind] + " № " + document.Code,
+		Kind:     document.Kind,
+		Code:     document.Code,
+		Date:     document.CreatedAt.Format("02.01.2006"),
+		Title:    document.Title,
+		Total:    formatMinor(document.Amount),
+		Currency: document.Currency,
+	}
+
+	var customer models.User
+	if err := initializers.DB.Select("id", "name", "email").First(&customer, "id = ?", document.UserID).Error; err != nil {
+		return data, err
+	}
+	data.CustomerName = customer.Name
+	data.CustomerEmail = customer.Email
+
+	data.IssuerName = "MYRUONLINE"
+	if document.IssuerID != nil {
+		var issuer models.User
+		if err := initializers.DB.Select("id", "name").First(&issuer, "id = ?", *document.IssuerID).Error; err != nil {
+			return data, err
+		}
+		data.IssuerName = issuer.Name
+	}
+
+	if document.OrderID == nil {
+		data.Lines = []DocumentLine{{Title: document.Title, Quantity: 1, Price: data.Total, Amount: data.Total}}
+		return data, nil
+	}
+
+	var order models.Order
+	if err := initializers.DB.Preload("OrderItems").Preload("DeliveryAddress").First(&order, "id = ?", *document.OrderID).Error; err != nil {
+		return data, err
+	}
+	for _, item := range order.OrderItems {
+		data.Lines = append(data.Lines, DocumentLine{
+			Title:    item.Product,
+			Quantity: item.Quantity,
+			Price:    strconv.FormatFloat(item.Price, 'f', 2, 64),
+			Amount:   strconv.FormatFloat(item.Price*float64(item.Quantity), 'f', 2, 64),
+		})
+	}
+	if order.ShippingAmount > 0 {
+		shipping := strconv.FormatFloat(order.ShippingAmount, 'f', 2, 64)
+		data.Lines = append(data.Lines, DocumentLine{
+			Title:    strings.Join(nonEmpty(documentShippingTitles[language], order.ShippingMethod), ": "),
+			Quantity: 1,
+			Price:    shipping,
+			Amount:   shipping,
+		})
+	}
+	address := order.DeliveryAddress
+	data.Address = strings.Join(nonEmpty(address.PostalCode, address.City, address.Street, address.Building, address.Apartment), ", ")
+	data.Shipping = order.ShippingMethod
+	data.Payment = order.PaymentMethod
+	return data, nil
+}
+
+// RenderDocumentHTML renders the document with the templates in templates/.
+func RenderDocumentHTML(data DocumentData, language string) (string, error) {
+	templates, err := ParseTemplateDir("templates")
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	err = templates.ExecuteTemplate(&body, data.Kind+"_"+documentLanguage(language)+".html", data)
+	return body.String(), err
+}
+
+// RenderDocumentPDF renders the document as PDF.
+func RenderDocumentPDF(document models.Document, language string) ([]byte, error) {
+	data, err := BuildDocumentData(document, language)
+	if err != nil {
+		return nil, err
+	}
+	html, err := RenderDocumentHTML(data, language)
+	if err != nil {
+		return nil, err
+	}
+	return RenderPDF(html)
+}
+
+// SendPendingDocumentEmails emails recent documents that were not sent yet
+// to their customers with the PDF attached. A batch is claimed in a short
+// transaction and rendered and sent outside of it, so no row stays locked
+// while Chromium and SMTP work. Failed documents are retried on the next
+// run.
+func SendPendingDocumentEmails() (int, error) {
+	documents, err := claimDocumentEmails()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, document := range documents {
+		if err := sendDocumentEmail(document); err != nil {
+			log.Println("Failed to email document", document.ID, err)
+			initializers.DB.Model(&models.Document{}).
+				Where("id = ? AND emailed_at IS NULL", document.ID).
+				Update("email_claimed_at", nil)
+			continue
+		}
+		if err := initializers.DB.Model(&models.Document{}).Where("id = ?", document.ID).
+			Updates(map[string]interface{}{"emailed_at": time.Now(), "email_claimed_at": nil}).Error; err != nil {
+			log.Println("Failed to mark document emailed", document.ID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// claimDocumentEmails marks a batch of unsent documents as taken by this
+// node, skipping documents claimed by others.
+func claimDocumentEmails() ([]models.Document, error) {
+	var documents []models.Document
+	now := time.Now()
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("emailed_at IS NULL AND created_at > ?", now.Add(-documentEmailWindow)).
+			Where("email_claimed_at IS NULL OR email_claimed_at < ?", now.Add(-documentEmailClaimTTL)).
+			Order("id").
+			Limit(50).
+			Find(&documents).Error; err != nil {
+			return err
+		}
+		if len(documents) == 0 {
+			return nil
+		}
+		ids := make([]uint64, 0, len(documents))
+		for _, document := range documents {
+			ids = append(ids, document.ID)
+		}
+		return tx.Model(&models.Document{}).Where("id IN ?", ids).Update("email_claimed_at", now).Error
+	})
+	return documents, err
+}
+
+func sendDocumentEmail(document models.Document) error {
+	language := documentLanguage(DocumentUserLanguage(document.UserID))
+
+	data, err := BuildDocumentData(document, language)
+	if err != nil {
+		return err
+	}
+	html, err := RenderDocumentHTML(data, language)
+	if err != nil {
+		return err
+	}
+	pdf, err := RenderPDF(html)
+	if err != nil {
+		return err
+	}
+
+	templates, err := ParseTemplateDir("templates")
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := templates.ExecuteTemplate(&body, "documentEmail_"+language+".html", data); err != nil {
+		return err
+	}
+
+	return SendEmailWithAttachment(data.CustomerEmail, data.Subject, body.String(), document.Code+".pdf", pdf)
+}
+
+func formatMinor(amount int64) string {
+	return strconv.FormatFloat(MinorToMoney(amount), 'f', 2, 64)
+}
+
+func nonEmpty(values ...string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestDocumentCode(t *testing.T) {
+	seller := uuid.FromStringOrNil("3f2a9c1e-8b7d-4e6f-a5c4-1d2e3f4a5b6c")
+
+	tests := []struct {
+		name     string
+		kind     string
+		issuerID *uuid.UUID
+		number   int
+		want     string
+	}{
+		{name: "platform receipt", kind: DocumentKindReceipt, number: 42, want: "R-000042"},
+		{name: "platform invoice", kind: DocumentKindInvoice, number: 7, want: "INV-000007"},
+		{name: "seller invoice", kind: DocumentKindInvoice, issuerID: &seller, number: 1, want: "INV-3F2A9C1E-000001"},
+		{name: "long numbers are kept", kind: DocumentKindReceipt, number: 1234567, want: "R-1234567"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := documentCode(tt.kind, tt.issuerID, tt.number); got != tt.want {
+				t.Fatalf("documentCode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentSeries(t *testing.T) {
+	seller := uuid.NewV4()
+	if got := documentSeries(nil); got != platformDocumentSeries {
+		t.Fatalf("documentSeries(nil) = %q, want %q", got, platformDocumentSeries)
+	}
+	if got := documentSeries(&seller); got != "user:"+seller.String() {
+		t.Fatalf("documentSeries(seller) = %q", got)
+	}
+}
+
+func TestDocumentLanguage(t *testing.T) {
+	tests := map[string]string{"ru": "ru", "en": "en", "": "ru", "ka": "en"}
+	for language, want := range tests {
+		if got := documentLanguage(language); got != want {
+			t.Fatalf("documentLanguage(%q) = %q, want %q", language, got, want)
+		}
+	}
+}
+
+func TestFormatMinor(t *testing.T) {
+	tests := map[int64]string{0: "0.00", 5: "0.05", 123456: "1234.56", -250: "-2.50"}
+	for amount, want := range tests {
+		if got := formatMinor(amount); got != want {
+			t.Fatalf("formatMinor(%d) = %q, want %q", amount, got, want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -79,6 +80,30 @@ func ParseTemplateDir(dir string) (*template.Template, error) {
 	return template.ParseFiles(paths...)
 }
 
+// SendEmailWithAttachment sends an HTML email with one attached file. Unlike
+// SendEmail it reports failures to the caller.
+func SendEmailWithAttachment(to string, subject string, htmlBody string, filename string, attachment []byte) error {
+	config, err := initializers.LoadConfig(".")
+	if err != nil {
+		return err
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", config.EmailFrom)
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", htmlBody)
+	m.AddAlternative("text/plain", html2text.HTML2Text(htmlBody))
+	m.Attach(filename, gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(attachment)
+		return err
+	}))
+
+	d := gomail.NewDialer(config.SMTPHost, config.SMTPPort, config.SMTPUser, config.SMTPPass)
+	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	return d.DialAndSend(m)
+}
+
 func SendEmail(user *models.User, data interface{}, emailTemplatePrefix string, language string) {
 	config, err := initializers.LoadConfig(".")
 
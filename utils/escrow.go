@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrderPaymentBalance is the payment method of orders paid from the buyer's
+// wallet through escrow.
+const OrderPaymentBalance = "balance"
+
+// Escrow states of models.Order.
+const (
+	EscrowStatusHeld     = "held"
+	EscrowStatusReleased = "released"
+	EscrowStatusReturned = "returned"
+)
+
+const defaultOrderAutoConfirmDays = 14
+
+// OrderCommission returns the platform commission on amount kopecks.
+func OrderCommission(amount int64, percent float64) int64 {
+	if percent <= 0 {
+		return 0
+	}
+	if percent >= 100 {
+		return amount
+	}
+	return int64(math.Round(float64(amount) * percent / 100))
+}
+
+// HoldOrderEscrow moves the order total from the buyer's wallet to escrow.
+// The commission is fixed at this point, so a later change of the rate does
+// not affect placed orders. It must be called inside a DB transaction after
+// the order is created; it returns ErrInsufficientBalance when the buyer
+// can not pay.
+func HoldOrderEscrow(tx *gorm.DB, order *models.Order, commissionPercent float64) error {
+	amount := MoneyToMinor(order.TotalAmount)
+	if amount <= 0 {
+		return errors.New("order total must be positive")
+	}
+
+	entry := models.LedgerEntry{
+		Kind:        LedgerKindEscrowHold,
+		Description: "Оплата заказа",
+		Module:      "Order",
+		Reference:   LedgerReference("order:%s:hold", order.ID),
+	}
+	if err := TransferToSystemAccount(tx, order.UserID, LedgerAccountEscrow, amount, &entry); err != nil {
+		return err
+	}
+
+	order.EscrowStatus = EscrowStatusHeld
+	order.EscrowAmount = amount
+	order.Commission = OrderCommission(amount, commissionPercent)
+	order.EscrowEntryID = &entry.ID
+	return tx.Model(order).Updates(map[string]interface{}{
+		"escrow_status":   order.EscrowStatus,
+		"escrow_amount":   order.EscrowAmount,
+		"commission":      order.Commission,
+		"escrow_entry_id": order.EscrowEntryID,
+	}).Error
+}
+
+// PayOrderFromBalance holds the order total in escrow and marks the freshly
+// created order as paid by the buyer.
+func PayOrderFromBalance(tx *gorm.DB, order *models.Order, commissionPercent float64) error {
+	if err := HoldOrderEscrow(tx, order, commissionPercent); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	history := models.OrderHistory{
+		ID:         uuid.NewV4(),
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   OrderStatusPaid,
+		ActorID:    order.UserID,
+		ActorRole:  OrderRoleBuyer,
+		Comment:    "Оплачено с баланса",
+	}
+	if err := tx.Model(order).Updates(map[string]interface{}{"status": OrderStatusPaid, "status_changed_at": now}).Error; err != nil {
+		return err
+	}
+	order.Status = OrderStatusPaid
+	order.StatusChangedAt = &now
+	if err := tx.Create(&history).Error; err != nil {
+		return err
+	}
+	_, err := IssueOrderInvoice(tx, *order)
+	return err
+}
+
+// ReleaseOrderEscrow pays the held funds to the seller and the commission to
+// the platform. Orders without held funds are left alone.
+func ReleaseOrderEscrow(tx *gorm.DB, order *models.Order) error {
+	if order.EscrowStatus != EscrowStatusHeld {
+		return nil
+	}
+
+	escrow, err := SystemLedgerAccount(tx, LedgerAccountEscrow)
+	if err != nil {
+		return err
+	}
+	commission, err := SystemLedgerAccount(tx, LedgerAccountCommission)
+	if err != nil {
+		return err
+	}
+	seller, err := UserWalletAccount(tx, order.SellerID)
+	if err != nil {
+		return err
+	}
+
+	legs := escrowReleaseLegs(*order, escrow.ID, seller.ID, commission.ID)
+	entry := models.LedgerEntry{
+		Kind:        LedgerKindEscrowRelease,
+		Description: "Оплата по заказу",
+		Module:      "Order",
+		Reference:   LedgerReference("order:%s:release", order.ID),
+	}
+	if err := PostLedgerEntry(tx, &entry, legs); err != nil {
+		return err
+	}
+	return setOrderEscrowStatus(tx, order, EscrowStatusReleased)
+}
+
+// escrowReleaseLegs splits the held funds of the order between the seller
+// and the platform commission.
+func escrowReleaseLegs(order models.Order, escrowID uint64, sellerID uint64, commissionID uint64) []LedgerLeg {
+	legs := []LedgerLeg{{AccountID: escrowID, Amount: -order.EscrowAmount}}
+	if payout := order.EscrowAmount - order.Commission; payout > 0 {
+		legs = append(legs, LedgerLeg{AccountID: sellerID, Amount: payout})
+	}
+	if order.Commission > 0 {
+		legs = append(legs, LedgerLeg{AccountID: commissionID, Amount: order.Commission})
+	}
+	return legs
+}
+
+// ReturnOrderEscrow returns the held funds to the buyer. Orders without held
+// funds are left alone.
+func ReturnOrderEscrow(tx *gorm.DB, order *models.Order) error {
+	if order.EscrowStatus != EscrowStatusHeld {
+		return nil
+	}
+
+	entry := models.LedgerEntry{
+		Kind:        LedgerKindEscrowReturn,
+		Description: "Возврат оплаты заказа",
+		Module:      "Order",
+		Reference:   LedgerReference("order:%s:return", order.ID),
+	}
+	if err := CreditUserWallet(tx, order.UserID, LedgerAccountEscrow, order.EscrowAmount, &entry); err != nil {
+		return err
+	}
+	return setOrderEscrowStatus(tx, order, EscrowStatusReturned)
+}
+
+func setOrderEscrowStatus(tx *gorm.DB, order *models.Order, status string) error {
+	order.EscrowStatus = status
+	return tx.Model(order).Update("escrow_status", status).Error
+}
+
+// AutoConfirmOrders completes shipped and delivered orders whose status has
+// not changed for ORDER_AUTO_CONFIRM_DAYS days, which pays out their escrow.
+// It returns the number of completed orders.
+func AutoConfirmOrders(config *initializers.Config) (int, error) {
+	days := config.OrderAutoConfirmDays
+	if days <= 0 {
+		days = defaultOrderAutoConfirmDays
+	}
+	deadline := time.Now().AddDate(0, 0, -days)
+
+	var ids []uuid.UUID
+	if err := initializers.DB.Model(&models.Order{}).
+		Where("status IN ? AND status_changed_at < ?", []string{OrderStatusShipped, OrderStatusDelivered}, deadline).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	for _, id := range ids {
+		var order models.Order
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&order).Error; err != nil {
+				return err
+			}
+			// The order may have moved on since it was selected.
+			if order.StatusChangedAt == nil || order.StatusChangedAt.After(deadline) {
+				return ErrOrderTransitionNotAllowed
+			}
+			_, err := TransitionOrder(tx, &order, OrderStatusCompleted, uuid.Nil, []string{OrderRoleSystem}, "Автоматическое подтверждение получения")
+			return err
+		})
+		if errors.Is(err, ErrOrderTransitionNotAllowed) {
+			continue
+		}
+		if err != nil {
+			log.Println("Order auto-confirmation failed:", id, err)
+			continue
+		}
+		completed++
+
+		text := fmt.Sprintf("Заказ на сумму %s руб. завершен автоматически", strconv.FormatFloat(order.TotalAmount, 'f', 2, 64))
+		Notification("Заказ завершен", text, order.UserID.String(), "https://www.myru.online/profile/posts?tabs=purchases")
+		Notification("Заказ завершен", text, order.SellerID.String(), "https://www.myru.online/profile/posts?tabs=sales")
+	}
+	return completed, nil
+}
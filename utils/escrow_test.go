@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"testing"
+)
+
+func TestOrderCommission(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		percent float64
+		want    int64
+	}{
+		{amount: 10000, percent: 0, want: 0},
+		{amount: 10000, percent: -5, want: 0},
+		{amount: 10000, percent: 5, want: 500},
+		{amount: 999, percent: 2.5, want: 25},
+		{amount: 10000, percent: 100, want: 10000},
+		{amount: 10000, percent: 150, want: 10000},
+	}
+
+	for _, tt := range tests {
+		if got := OrderCommission(tt.amount, tt.percent); got != tt.want {
+			t.Fatalf("OrderCommission(%d, %v) = %d, want %d", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestEscrowReleaseLegs(t *testing.T) {
+	const escrow, seller, commission = 1, 2, 3
+
+	tests := []struct {
+		name  string
+		order models.Order
+		want  map[uint64]int64
+	}{
+		{
+			name:  "seller gets the total without commission",
+			order: models.Order{EscrowAmount: 10000, Commission: 500},
+			want:  map[uint64]int64{escrow: -10000, seller: 9500, commission: 500},
+		},
+		{
+			name:  "no commission",
+			order: models.Order{EscrowAmount: 10000},
+			want:  map[uint64]int64{escrow: -10000, seller: 10000},
+		},
+		{
+			name:  "commission takes everything",
+			order: models.Order{EscrowAmount: 10000, Commission: 10000},
+			want:  map[uint64]int64{escrow: -10000, commission: 10000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deltas, err := ledgerDeltas(escrowReleaseLegs(tt.order, escrow, seller, commission))
+			if err != nil {
+				t.Fatalf("release is not a valid entry: %v", err)
+			}
+			if len(deltas) != len(tt.want) {
+				t.Fatalf("deltas = %v, want %v", deltas, tt.want)
+			}
+			for id, amount := range tt.want {
+				if deltas[id] != amount {
+					t.Fatalf("deltas = %v, want %v", deltas, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSettledEscrowIsLeftAlone(t *testing.T) {
+	// Settled orders return before the database is touched
+	for _, status := range []string{"", EscrowStatusReleased, EscrowStatusReturned} {
+		order := models.Order{EscrowStatus: status, EscrowAmount: 10000}
+		if err := ReleaseOrderEscrow(nil, &order); err != nil || order.EscrowStatus != status {
+			t.Fatalf("release of %q escrow: status %q, error %v", status, order.EscrowStatus, err)
+		}
+		if err := ReturnOrderEscrow(nil, &order); err != nil || order.EscrowStatus != status {
+			t.Fatalf("return of %q escrow: status %q, error %v", status, order.EscrowStatus, err)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"hyperpage/models"
+	"math"
+	"sort"
+	"strconv"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of ledger entries.
+const (
+	LedgerKindOpeningBalance = "opening_balance"
+	LedgerKindTopUp          = "topup"
+	LedgerKindBonus          = "bonus"
+	LedgerKindPromoCode      = "promo_code"
+	LedgerKindPublicationFee = "publication_fee"
+	LedgerKindDonation       = "donation"
+	LedgerKindPlanPurchase   = "plan_purchase"
+	LedgerKindSiteActivation = "site_activation"
+	LedgerKindRefund         = "refund"
+	LedgerKindEscrowHold     = "escrow_hold"
+	LedgerKindEscrowRelease  = "escrow_release"
+	LedgerKindEscrowReturn   = "escrow_return"
+)
+
+// Codes of the platform side accounts.
+const (
+	LedgerAccountAcquirer       = "external:acquirer"
+	LedgerAccountRevenue        = "platform:revenue"
+	LedgerAccountPromo          = "platform:promo"
+	LedgerAccountOpeningBalance = "equity:opening_balance"
+	LedgerAccountEscrow         = "platform:escrow"
+	LedgerAccountCommission     = "platform:commission"
+)
+
+var systemLedgerAccountTypes = map[string]string{
+	LedgerAccountAcquirer:       "external",
+	LedgerAccountRevenue:        "revenue",
+	LedgerAccountPromo:          "expense",
+	LedgerAccountOpeningBalance: "equity",
+	LedgerAccountEscrow:         "liability",
+	LedgerAccountCommission:     "revenue",
+}
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrUnbalancedEntry     = errors.New("ledger entry postings do not sum to zero")
+	ErrInvalidAmount       = errors.New("amount must be a positive number")
+)
+
+// LedgerLeg is one side of a ledger entry before it is posted.
+type LedgerLeg struct {
+	AccountID uint64
+	Amount    int64
+}
+
+// MoneyToMinor converts an amount in roubles to kopecks.
+func MoneyToMinor(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// ParseMoneyAmount parses a positive amount in roubles, as sent by clients,
+// to kopecks.
+func ParseMoneyAmount(value string) (int64, error) {
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) || amount <= 0 {
+		return 0, ErrInvalidAmount
+	}
+	minor := MoneyToMinor(amount)
+	if minor <= 0 {
+		return 0, ErrInvalidAmount
+	}
+	return minor, nil
+}
+
+// MinorToMoney converts kopecks to roubles.
+func MinorToMoney(amount int64) float64 {
+	return float64(amount) / 100
+}
+
+func userWalletCode(userID uuid.UUID) string {
+	return fmt.Sprintf("user:%s:wallet", userID)
+}
+
+// SystemLedgerAccount returns the platform account with the given code,
+// creating it on first use.
+func SystemLedgerAccount(tx *gorm.DB, code string) (models.LedgerAccount, error) {
+	accountType, ok := systemLedgerAccountTypes[code]
+	if !ok {
+		return models.LedgerAccount{}, fmt.Errorf("unknown ledger account %q", code)
+	}
+
+	account := models.LedgerAccount{Code: code, Type: accountType, AllowNegative: true}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return models.LedgerAccount{}, err
+	}
+	err := tx.Where("code = ?", code).First(&account).Error
+	return account, err
+}
+
+// UserWalletAccount returns the wallet account of the user, creating it on
+// first use. A freshly created wallet is opened with the balance that was
+// stored in the user's Billing row before the ledger existed.
+func UserWalletAccount(tx *gorm.DB, userID uuid.UUID) (models.LedgerAccount, error) {
+	code := userWalletCode(userID)
+	account := models.LedgerAccount{Code: code, Type: "wallet", UserID: &userID}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account)
+	if result.Error != nil {
+		return models.LedgerAccount{}, result.Error
+	}
+
+	if result.RowsAffected == 1 {
+		var billing models.Billing
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&billing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.LedgerAccount{}, err
+		}
+
+		if opening := MoneyToMinor(billing.Amount); opening != 0 {
+			equity, err := SystemLedgerAccount(tx, LedgerAccountOpeningBalance)
+			if err != nil {
+				return models.LedgerAccount{}, err
+			}
+			entry := models.LedgerEntry{
+				Kind:        LedgerKindOpeningBalance,
+				Description: "Перенос баланса в учётную книгу",
+				Module:      "ledger",
+			}
+			// The old balance may already be negative, so the opening entry
+			// is the one posting that may overdraw a wallet.
+			legs := openingBalanceLegs(equity.ID, account.ID, opening)
+			if err := postLedgerEntry(tx, &entry, legs, true); err != nil {
+				return models.LedgerAccount{}, err
+			}
+		}
+	}
+
+	err := tx.Where("code = ?", code).First(&account).Error
+	return account, err
+}
+
+// PostLedgerEntry atomically stores the entry with its postings and updates
+// the cached balances. It must be called inside a DB transaction. Touched
+// accounts are locked in ID order, wallets may not go below zero, and the
+// Billing row of every touched wallet is refreshed from the ledger. Empty
+// postings are rejected with ErrInvalidAmount.
+func PostLedgerEntry(tx *gorm.DB, entry *models.LedgerEntry, legs []LedgerLeg) error {
+	return postLedgerEntry(tx, entry, legs, false)
+}
+
+func postLedgerEntry(tx *gorm.DB, entry *models.LedgerEntry, legs []LedgerLeg, allowOverdraft bool) error {
+	deltas, err := ledgerDeltas(legs)
+	if err != nil {
+		return err
+	}
+
+	accountIDs := make([]uint64, 0, len(deltas))
+	for id := range deltas {
+		accountIDs = append(accountIDs, id)
+	}
+	sort.Slice(accountIDs, func(i, j int) bool { return accountIDs[i] < accountIDs[j] })
+
+	var accounts []models.LedgerAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", accountIDs).
+		Order("id").
+		Find(&accounts).Error; err != nil {
+		return err
+	}
+	if len(accounts) != len(accountIDs) {
+		return errors.New("ledger account not found")
+	}
+
+	if err := checkLedgerBalances(accounts, deltas, allowOverdraft); err != nil {
+		return err
+	}
+
+	entry.Postings = nil
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+
+	postings := make([]models.LedgerPosting, 0, len(legs))
+	for _, leg := range legs {
+		postings = append(postings, models.LedgerPosting{
+			EntryID:   entry.ID,
+			AccountID: leg.AccountID,
+			Amount:    leg.Amount,
+		})
+	}
+	if err := tx.Create(&postings).Error; err != nil {
+		return err
+	}
+	entry.Postings = postings
+
+	for _, account := range accounts {
+		balance := account.Balance + deltas[account.ID]
+		if err := tx.Model(&models.LedgerAccount{}).
+			Where("id = ?", account.ID).
+			Updates(map[string]interface{}{"balance": balance, "updated_at": gorm.Expr("now()")}).Error; err != nil {
+			return err
+		}
+
+		if account.UserID != nil {
+			if err := syncBillingBalance(tx, *account.UserID, balance); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// openingBalanceLegs moves the pre-ledger balance of a wallet from the
+// opening balance equity account. A negative balance moves the other way.
+func openingBalanceLegs(equityID uint64, walletID uint64, opening int64) []LedgerLeg {
+	return []LedgerLeg{
+		{AccountID: equityID, Amount: -opening},
+		{AccountID: walletID, Amount: opening},
+	}
+}
+
+// ledgerDeltas sums the legs per account. An entry needs at least two legs,
+// none of them empty, adding up to zero.
+func ledgerDeltas(legs []LedgerLeg) (map[uint64]int64, error) {
+	if len(legs) < 2 {
+		return nil, ErrUnbalancedEntry
+	}
+
+	var sum int64
+	deltas := map[uint64]int64{}
+	for _, leg := range legs {
+		if leg.Amount == 0 {
+			return nil, ErrInvalidAmount
+		}
+		sum += leg.Amount
+		deltas[leg.AccountID] += leg.Amount
+	}
+	if sum != 0 {
+		return nil, ErrUnbalancedEntry
+	}
+	return deltas, nil
+}
+
+// checkLedgerBalances refuses debits that would take an account that may not
+// go negative below zero, unless the entry is allowed to overdraw. Credits
+// are always accepted so an overdrawn wallet can still be topped up.
+func checkLedgerBalances(accounts []models.LedgerAccount, deltas map[uint64]int64, allowOverdraft bool) error {
+	if allowOverdraft {
+		return nil
+	}
+	for _, account := range accounts {
+		delta := deltas[account.ID]
+		if !account.AllowNegative && delta < 0 && account.Balance+delta < 0 {
+			return ErrInsufficientBalance
+		}
+	}
+	return nil
+}
+
+// billingBalanceFields mirrors a wallet balance to the Billing columns the
+// rest of the code still reads: Amount in roubles and Balance in kopecks.
+func billingBalanceFields(balance int64) map[string]interface{} {
+	return map[string]interface{}{"amount": MinorToMoney(balance), "balance": balance}
+}
+
+func syncBillingBalance(tx *gorm.DB, userID uuid.UUID, balance int64) error {
+	result := tx.Model(&models.Billing{}).
+		Where("user_id = ?", userID).
+		Updates(billingBalanceFields(balance))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return tx.Create(&models.Billing{UserID: userID, Amount: MinorToMoney(balance), Balance: balance}).Error
+	}
+	return nil
+}
+
+// ChargeUserWallet moves amount kopecks from the user's wallet to the
+// platform revenue account. Like the other transfers it rejects amounts that
+// are not positive with ErrInvalidAmount.
+func ChargeUserWallet(tx *gorm.DB, userID uuid.UUID, amount int64, entry *models.LedgerEntry) error {
+	return TransferToSystemAccount(tx, userID, LedgerAccountRevenue, amount, entry)
+}
+
+// CreditUserWallet moves amount kopecks from the given platform account to
+// the user's wallet.
+func CreditUserWallet(tx *gorm.DB, userID uuid.UUID, fromCode string, amount int64, entry *models.LedgerEntry) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	from, err := SystemLedgerAccount(tx, fromCode)
+	if err != nil {
+		return err
+	}
+	wallet, err := UserWalletAccount(tx, userID)
+	if err != nil {
+		return err
+	}
+	return PostLedgerEntry(tx, entry, []LedgerLeg{
+		{AccountID: from.ID, Amount: -amount},
+		{AccountID: wallet.ID, Amount: amount},
+	})
+}
+
+// TransferToSystemAccount moves amount kopecks from the user's wallet to the
+// given platform account.
+func TransferToSystemAccount(tx *gorm.DB, userID uuid.UUID, toCode string, amount int64, entry *models.LedgerEntry) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	wallet, err := UserWalletAccount(tx, userID)
+	if err != nil {
+		return err
+	}
+	to, err := SystemLedgerAccount(tx, toCode)
+	if err != nil {
+		return err
+	}
+	return PostLedgerEntry(tx, entry, []LedgerLeg{
+		{AccountID: wallet.ID, Amount: -amount},
+		{AccountID: to.ID, Amount: amount},
+	})
+}
+
+// TransferBetweenUsers moves amount kopecks from one user's wallet to
+// another's.
+func TransferBetweenUsers(tx *gorm.DB, fromUserID uuid.UUID, toUserID uuid.UUID, amount int64, entry *models.LedgerEntry) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	from, err := UserWalletAccount(tx, fromUserID)
+	if err != nil {
+		return err
+	}
+	to, err := UserWalletAccount(tx, toUserID)
+	if err != nil {
+		return err
+	}
+	return PostLedgerEntry(tx, entry, []LedgerLeg{
+		{AccountID: from.ID, Amount: -amount},
+		{AccountID: to.ID, Amount: amount},
+	})
+}
+
+// ReverseLedgerEntry posts a refund entry that mirrors every posting of the
+// original entry. The money has already left through the provider, so the
+// reversal may take a wallet below zero.
+func ReverseLedgerEntry(tx *gorm.DB, entryID uint64, description string, reference *string) (models.LedgerEntry, error) {
+	var original models.LedgerEntry
+	if err := tx.Preload("Postings").First(&original, entryID).Error; err != nil {
+		return models.LedgerEntry{}, err
+	}
+
+	return postReversal(tx, original, reversalLegs(original.Postings), description, reference)
+}
+
+// RefundLedgerEntry posts a refund of amount kopecks against a two-sided
+// entry, such as a top-up. Like ReverseLedgerEntry it may overdraw a wallet.
+func RefundLedgerEntry(tx *gorm.DB, entryID uint64, amount int64, description string, reference *string) (models.LedgerEntry, error) {
+	if amount <= 0 {
+		return models.LedgerEntry{}, ErrInvalidAmount
+	}
+	var original models.LedgerEntry
+	if err := tx.Preload("Postings").First(&original, entryID).Error; err != nil {
+		return models.LedgerEntry{}, err
+	}
+	legs, err := refundLegs(original.Postings, amount)
+	if err != nil {
+		return models.LedgerEntry{}, err
+	}
+	return postReversal(tx, original, legs, description, reference)
+}
+
+// reversalLegs undoes every posting of an entry.
+func reversalLegs(postings []models.LedgerPosting) []LedgerLeg {
+	legs := make([]LedgerLeg, 0, len(postings))
+	for _, posting := range postings {
+		legs = append(legs, LedgerLeg{AccountID: posting.AccountID, Amount: -posting.Amount})
+	}
+	return legs
+}
+
+// refundLegs takes amount kopecks back from the credited side of a
+// two-sided entry.
+func refundLegs(postings []models.LedgerPosting, amount int64) ([]LedgerLeg, error) {
+	if len(postings) != 2 {
+		return nil, errors.New("only two-sided ledger entries can be partially refunded")
+	}
+
+	legs := make([]LedgerLeg, 0, 2)
+	for _, posting := range postings {
+		refund := amount
+		if posting.Amount > 0 {
+			refund = -amount
+		}
+		legs = append(legs, LedgerLeg{AccountID: posting.AccountID, Amount: refund})
+	}
+	return legs, nil
+}
+
+func postReversal(tx *gorm.DB, original models.LedgerEntry, legs []LedgerLeg, description string, reference *string) (models.LedgerEntry, error) {
+	reversal := models.LedgerEntry{
+		Kind:         LedgerKindRefund,
+		Description:  description,
+		Module:       original.Module,
+		ElementId:    original.ElementId,
+		Reference:    reference,
+		ReversalOfID: &original.ID,
+	}
+	err := postLedgerEntry(tx, &reversal, legs, true)
+	return reversal, err
+}
+
+// GetUserBalance returns the user's wallet balance in kopecks.
+func GetUserBalance(tx *gorm.DB, userID uuid.UUID) (int64, error) {
+	wallet, err := UserWalletAccount(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return wallet.Balance, nil
+}
+
+// LedgerReference returns a pointer suitable for LedgerEntry.Reference.
+func LedgerReference(format string, args ...interface{}) *string {
+	reference := fmt.Sprintf(format, args...)
+	return &reference
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"testing"
+)
+
+func TestOpeningBalanceLegs(t *testing.T) {
+	tests := []struct {
+		name    string
+		opening int64
+		wallet  int64
+		equity  int64
+	}{
+		{name: "positive balance is credited to the wallet", opening: 15000, wallet: 15000, equity: -15000},
+		{name: "negative balance is kept as a debt", opening: -2500, wallet: -2500, equity: 2500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := []models.LedgerAccount{
+				{ID: 1, Code: LedgerAccountOpeningBalance, AllowNegative: true},
+				{ID: 2, Code: "user:wallet"},
+			}
+			deltas, err := ledgerDeltas(openingBalanceLegs(1, 2, tt.opening))
+			if err != nil {
+				t.Fatalf("ledgerDeltas() error = %v", err)
+			}
+			if deltas[2] != tt.wallet || deltas[1] != tt.equity {
+				t.Fatalf("deltas = %v, want wallet %d and equity %d", deltas, tt.wallet, tt.equity)
+			}
+			if err := checkLedgerBalances(accounts, deltas, true); err != nil {
+				t.Fatalf("checkLedgerBalances() with overdraft error = %v", err)
+			}
+		})
+	}
+}
+
+func TestOpeningBalanceNeedsOverdraftWhenNegative(t *testing.T) {
+	accounts := []models.LedgerAccount{
+		{ID: 1, Code: LedgerAccountOpeningBalance, AllowNegative: true},
+		{ID: 2, Code: "user:wallet"},
+	}
+	deltas, err := ledgerDeltas(openingBalanceLegs(1, 2, -2500))
+	if err != nil {
+		t.Fatalf("ledgerDeltas() error = %v", err)
+	}
+	if err := checkLedgerBalances(accounts, deltas, false); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("checkLedgerBalances() error = %v, want %v", err, ErrInsufficientBalance)
+	}
+}
+
+func TestLedgerDeltas(t *testing.T) {
+	tests := []struct {
+		name    string
+		legs    []LedgerLeg
+		want    map[uint64]int64
+		wantErr error
+	}{
+		{
+			name: "balanced transfer",
+			legs: []LedgerLeg{{AccountID: 1, Amount: -500}, {AccountID: 2, Amount: 500}},
+			want: map[uint64]int64{1: -500, 2: 500},
+		},
+		{
+			name: "legs of one account are summed",
+			legs: []LedgerLeg{{AccountID: 1, Amount: -500}, {AccountID: 2, Amount: 300}, {AccountID: 2, Amount: 200}},
+			want: map[uint64]int64{1: -500, 2: 500},
+		},
+		{
+			name:    "single leg",
+			legs:    []LedgerLeg{{AccountID: 1, Amount: 500}},
+			wantErr: ErrUnbalancedEntry,
+		},
+		{
+			name:    "postings do not sum to zero",
+			legs:    []LedgerLeg{{AccountID: 1, Amount: -500}, {AccountID: 2, Amount: 400}},
+			wantErr: ErrUnbalancedEntry,
+		},
+		{
+			name:    "empty posting",
+			legs:    []LedgerLeg{{AccountID: 1, Amount: 0}, {AccountID: 2, Amount: 0}},
+			wantErr: ErrInvalidAmount,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ledgerDeltas(tt.legs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ledgerDeltas() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ledgerDeltas() = %v, want %v", got, tt.want)
+			}
+			for id, amount := range tt.want {
+				if got[id] != amount {
+					t.Fatalf("ledgerDeltas() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckLedgerBalances(t *testing.T) {
+	tests := []struct {
+		name           string
+		account        models.LedgerAccount
+		delta          int64
+		allowOverdraft bool
+		wantErr        error
+	}{
+		{name: "wallet keeps a positive balance", account: models.LedgerAccount{ID: 1, Balance: 1000}, delta: -400},
+		{name: "wallet may reach zero", account: models.LedgerAccount{ID: 1, Balance: 1000}, delta: -1000},
+		{name: "wallet may not go below zero", account: models.LedgerAccount{ID: 1, Balance: 1000}, delta: -1001, wantErr: ErrInsufficientBalance},
+		{name: "system account may go below zero", account: models.LedgerAccount{ID: 1, AllowNegative: true}, delta: -1000},
+		{name: "overdraft entry may overdraw a wallet", account: models.LedgerAccount{ID: 1, Balance: 100}, delta: -1000, allowOverdraft: true},
+		{name: "overdrawn wallet may be topped up", account: models.LedgerAccount{ID: 1, Balance: -1000}, delta: 400},
+		{name: "overdrawn wallet may not be charged", account: models.LedgerAccount{ID: 1, Balance: -1000}, delta: -1, wantErr: ErrInsufficientBalance},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deltas := map[uint64]int64{tt.account.ID: tt.delta}
+			err := checkLedgerBalances([]models.LedgerAccount{tt.account}, deltas, tt.allowOverdraft)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkLedgerBalances() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReversalLegs(t *testing.T) {
+	postings := []models.LedgerPosting{
+		{AccountID: 1, Amount: -700},
+		{AccountID: 2, Amount: 500},
+		{AccountID: 3, Amount: 200},
+	}
+	legs := reversalLegs(postings)
+	deltas, err := ledgerDeltas(legs)
+	if err != nil {
+		t.Fatalf("reversal is not a valid entry: %v", err)
+	}
+	want := map[uint64]int64{1: 700, 2: -500, 3: -200}
+	for id, amount := range want {
+		if deltas[id] != amount {
+			t.Fatalf("reversalLegs() = %v, want %v", legs, want)
+		}
+	}
+}
+
+func TestRefundLegs(t *testing.T) {
+	topUp := []models.LedgerPosting{
+		{AccountID: 1, Amount: -10000},
+		{AccountID: 2, Amount: 10000},
+	}
+
+	tests := []struct {
+		name     string
+		postings []models.LedgerPosting
+		amount   int64
+		want     map[uint64]int64
+		wantErr  bool
+	}{
+		{name: "full refund", postings: topUp, amount: 10000, want: map[uint64]int64{1: 10000, 2: -10000}},
+		{name: "partial refund", postings: topUp, amount: 2500, want: map[uint64]int64{1: 2500, 2: -2500}},
+		{
+			name:     "entries with more sides can not be refunded in part",
+			postings: append(append([]models.LedgerPosting{}, topUp...), models.LedgerPosting{AccountID: 3, Amount: 1}),
+			amount:   2500,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legs, err := refundLegs(tt.postings, tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("refundLegs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			deltas, err := ledgerDeltas(legs)
+			if err != nil {
+				t.Fatalf("refund is not a valid entry: %v", err)
+			}
+			for id, amount := range tt.want {
+				if deltas[id] != amount {
+					t.Fatalf("refundLegs() = %v, want %v", legs, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBillingBalanceFields(t *testing.T) {
+	tests := []struct {
+		balance int64
+		amount  float64
+	}{
+		{balance: 0, amount: 0},
+		{balance: 12345, amount: 123.45},
+		{balance: -250, amount: -2.5},
+	}
+
+	for _, tt := range tests {
+		fields := billingBalanceFields(tt.balance)
+		if fields["balance"] != tt.balance || fields["amount"] != tt.amount {
+			t.Fatalf("billingBalanceFields(%d) = %v, want amount %v", tt.balance, fields, tt.amount)
+		}
+	}
+}
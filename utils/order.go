@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Statuses of models.Order.
+const (
+	OrderStatusCreated         = "created"
+	OrderStatusPaid            = "paid"
+	OrderStatusAccepted        = "accepted"
+	OrderStatusShipped         = "shipped"
+	OrderStatusDelivered       = "delivered"
+	OrderStatusCompleted       = "completed"
+	OrderStatusCanceled        = "canceled"
+	OrderStatusRefundRequested = "refund_requested"
+	OrderStatusRefunded        = "refunded"
+	OrderStatusDisputed        = "disputed"
+)
+
+// Roles a user can act in on an order.
+const (
+	OrderRoleBuyer  = "buyer"
+	OrderRoleSeller = "seller"
+	OrderRoleAdmin  = "admin"
+	OrderRoleSystem = "system" // scheduled jobs, such as auto-confirmation
+)
+
+var (
+	ErrOrderTransitionNotAllowed = errors.New("order status transition is not allowed")
+	ErrOrderTransitionForbidden  = errors.New("user may not make this order status transition")
+)
+
+// orderTransitions lists for every status the statuses it can move to and
+// the roles allowed to make that move.
+var orderTransitions = map[string]map[string][]string{
+	OrderStatusCreated: {
+		OrderStatusPaid:     {OrderRoleSeller, OrderRoleAdmin},
+		OrderStatusCanceled: {OrderRoleBuyer, OrderRoleSeller, OrderRoleAdmin},
+	},
+	OrderStatusPaid: {
+		OrderStatusAccepted:        {OrderRoleSeller, OrderRoleAdmin},
+		OrderStatusCanceled:        {OrderRoleSeller, OrderRoleAdmin},
+		OrderStatusRefundRequested: {OrderRoleBuyer},
+	},
+	OrderStatusAccepted: {
+		OrderStatusShipped:         {OrderRoleSeller, OrderRoleAdmin},
+		OrderStatusCanceled:        {OrderRoleSeller, OrderRoleAdmin},
+		OrderStatusRefundRequested: {OrderRoleBuyer},
+	},
+	OrderStatusShipped: {
+		OrderStatusDelivered: {OrderRoleBuyer, OrderRoleSeller, OrderRoleAdmin},
+		OrderStatusCompleted: {OrderRoleBuyer, OrderRoleSystem},
+		OrderStatusDisputed:  {OrderRoleBuyer},
+	},
+	OrderStatusDelivered: {
+		OrderStatusCompleted:       {OrderRoleBuyer, OrderRoleAdmin, OrderRoleSystem},
+		OrderStatusRefundRequested: {OrderRoleBuyer},
+		OrderStatusDisputed:        {OrderRoleBuyer},
+	},
+	OrderStatusRefundRequested: {
+		OrderStatusRefunded: {OrderRoleSeller, OrderRoleAdmin},
+		OrderStatusDisputed: {OrderRoleBuyer, OrderRoleSeller},
+	},
+	OrderStatusDisputed: {
+		OrderStatusRefunded:  {OrderRoleAdmin},
+		OrderStatusCompleted: {OrderRoleAdmin},
+	},
+}
+
+// IsOrderStatus reports whether status is a known order status.
+func IsOrderStatus(status string) bool {
+	if _, ok := orderTransitions[status]; ok {
+		return true
+	}
+	return status == OrderStatusCompleted || status == OrderStatusCanceled || status == OrderStatusRefunded
+}
+
+// OrderActorRoles returns the roles the user holds on the order.
+func OrderActorRoles(order models.Order, userID uuid.UUID, userRole string) []string {
+	var roles []string
+	if order.UserID == userID {
+		roles = append(roles, OrderRoleBuyer)
+	}
+	if order.SellerID == userID {
+		roles = append(roles, OrderRoleSeller)
+	}
+	if userRole == OrderRoleAdmin {
+		roles = append(roles, OrderRoleAdmin)
+	}
+	return roles
+}
+
+// NextOrderStatuses returns the statuses the holder of roles can move the
+// order to.
+func NextOrderStatuses(order models.Order, roles []string) []string {
+	next := []string{}
+	for status, allowed := range orderTransitions[order.Status] {
+		if orderRoleAllowed(allowed, roles) != "" {
+			next = append(next, status)
+		}
+	}
+	return next
+}
+
+func orderRoleAllowed(allowed []string, roles []string) string {
+	for _, role := range roles {
+		for _, a := range allowed {
+			if role == a {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+// TransitionOrder moves the order to status on behalf of a user holding
+// roles and records the change in the order history. The invoice is issued
+// when the order is paid. Funds held in escrow
+// are paid to the seller when the order is completed and returned to the
+// buyer when it is canceled or refunded; canceled catalog items go back on
+// stock. It must be called inside a DB
+// transaction with the order row locked.
+func TransitionOrder(tx *gorm.DB, order *models.Order, status string, actorID uuid.UUID, roles []string, comment string) (models.OrderHistory, error) {
+	var history models.OrderHistory
+
+	allowed, ok := orderTransitions[order.Status][status]
+	if !ok {
+		return history, ErrOrderTransitionNotAllowed
+	}
+	role := orderRoleAllowed(allowed, roles)
+	if role == "" {
+		return history, ErrOrderTransitionForbidden
+	}
+
+	history = models.OrderHistory{
+		ID:         uuid.NewV4(),
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   status,
+		ActorID:    actorID,
+		ActorRole:  role,
+		Comment:    comment,
+	}
+	now := time.Now()
+	if err := tx.Model(order).Updates(map[string]interface{}{"status": status, "status_changed_at": now}).Error; err != nil {
+		return history, err
+	}
+	order.Status = status
+	order.StatusChangedAt = &now
+	if err := tx.Create(&history).Error; err != nil {
+		return history, err
+	}
+
+	switch status {
+	case OrderStatusPaid:
+		_, err := IssueOrderInvoice(tx, *order)
+		return history, err
+	case OrderStatusCompleted:
+		return history, ReleaseOrderEscrow(tx, order)
+	case OrderStatusCanceled:
+		if err := RestockOrderItems(tx, order.ID); err != nil {
+			return history, err
+		}
+		return history, ReturnOrderEscrow(tx, order)
+	case OrderStatusRefunded:
+		return history, ReturnOrderEscrow(tx, order)
+	}
+	return history, nil
+}
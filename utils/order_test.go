@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"sort"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestNextOrderStatuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		roles  []string
+		want   []string
+	}{
+		{name: "buyer of a new order may only cancel", status: OrderStatusCreated, roles: []string{OrderRoleBuyer}, want: []string{OrderStatusCanceled}},
+		{name: "seller of a new order", status: OrderStatusCreated, roles: []string{OrderRoleSeller}, want: []string{OrderStatusCanceled, OrderStatusPaid}},
+		{name: "buyer of a paid order asks for a refund", status: OrderStatusPaid, roles: []string{OrderRoleBuyer}, want: []string{OrderStatusRefundRequested}},
+		{name: "seller ships an accepted order", status: OrderStatusAccepted, roles: []string{OrderRoleSeller}, want: []string{OrderStatusCanceled, OrderStatusShipped}},
+		{name: "buyer of a shipped order", status: OrderStatusShipped, roles: []string{OrderRoleBuyer}, want: []string{OrderStatusCompleted, OrderStatusDelivered, OrderStatusDisputed}},
+		{name: "auto-confirmation completes a delivered order", status: OrderStatusDelivered, roles: []string{OrderRoleSystem}, want: []string{OrderStatusCompleted}},
+		{name: "seller can not settle a dispute", status: OrderStatusDisputed, roles: []string{OrderRoleSeller}, want: []string{}},
+		{name: "admin settles a dispute", status: OrderStatusDisputed, roles: []string{OrderRoleAdmin}, want: []string{OrderStatusCompleted, OrderStatusRefunded}},
+		{name: "completed order is final", status: OrderStatusCompleted, roles: []string{OrderRoleAdmin}, want: []string{}},
+		{name: "stranger", status: OrderStatusPaid, roles: nil, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NextOrderStatuses(models.Order{Status: tt.status}, tt.roles)
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("NextOrderStatuses() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("NextOrderStatuses() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderTransitionsLeadToKnownStatuses(t *testing.T) {
+	for from, next := range orderTransitions {
+		for to, roles := range next {
+			if !IsOrderStatus(to) {
+				t.Fatalf("%s -> %s leads to an unknown status", from, to)
+			}
+			if len(roles) == 0 {
+				t.Fatalf("%s -> %s can not be made by anyone", from, to)
+			}
+		}
+	}
+	if IsOrderStatus("lost") {
+		t.Fatal("IsOrderStatus accepts an unknown status")
+	}
+}
+
+func TestOrderActorRoles(t *testing.T) {
+	buyer, seller := uuid.NewV4(), uuid.NewV4()
+	order := models.Order{UserID: buyer, SellerID: seller}
+
+	if roles := OrderActorRoles(order, buyer, "user"); len(roles) != 1 || roles[0] != OrderRoleBuyer {
+		t.Fatalf("buyer roles = %v", roles)
+	}
+	if roles := OrderActorRoles(order, seller, "user"); len(roles) != 1 || roles[0] != OrderRoleSeller {
+		t.Fatalf("seller roles = %v", roles)
+	}
+	if roles := OrderActorRoles(order, uuid.NewV4(), OrderRoleAdmin); len(roles) != 1 || roles[0] != OrderRoleAdmin {
+		t.Fatalf("admin roles = %v", roles)
+	}
+	if roles := OrderActorRoles(order, uuid.NewV4(), "user"); len(roles) != 0 {
+		t.Fatalf("stranger roles = %v", roles)
+	}
+}
@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"log"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of models.Payments. A confirmed payment is stored as "applied".
+const (
+	PaymentStatusNew             = "NEW"
+	PaymentStatusAuthorized      = "AUTHORIZED"
+	PaymentStatusApplied         = "applied"
+	PaymentStatusRejected        = "REJECTED"
+	PaymentStatusCanceled        = "CANCELED"
+	PaymentStatusDeadlineExpired = "DEADLINE_EXPIRED"
+	PaymentStatusPartialRefunded = "PARTIAL_REFUNDED"
+	PaymentStatusRefunded        = "REFUNDED"
+)
+
+// Statuses reported by the acquirer.
+const (
+	AcquirerStatusAuthorized      = "AUTHORIZED"
+	AcquirerStatusConfirmed       = "CONFIRMED"
+	AcquirerStatusRejected        = "REJECTED"
+	AcquirerStatusCanceled        = "CANCELED"
+	AcquirerStatusDeadlineExpired = "DEADLINE_EXPIRED"
+	AcquirerStatusPartialRefunded = "PARTIAL_REFUNDED"
+	AcquirerStatusRefunded        = "REFUNDED"
+)
+
+var (
+	ErrPaymentNotificationProcessed = errors.New("payment notification is already processed")
+	ErrPaymentAmountMismatch        = errors.New("payment amount does not match the invoice")
+	ErrPaymentNotRefundable         = errors.New("payment can not be refunded in its status")
+	ErrRefundExceedsPayment         = errors.New("refund amount exceeds the payment")
+	ErrAcquirerRefusedRefund        = errors.New("acquirer refused the refund")
+	ErrRefundPending                = errors.New("a refund of the payment is in progress")
+)
+
+// paymentTransition is what an acquirer status does to a payment: the new
+// local status and the kopecks credited to and taken back from the wallet.
+// An empty Status means the acquirer status does not fit and is ignored.
+type paymentTransition struct {
+	Status string
+	Credit int64
+	Refund int64
+}
+
+// planPaymentTransition decides how the reported status and amount apply to
+// the payment. For refunds amount is the sum the acquirer still holds after
+// the refund, like PaymentState.Amount, so each partial refund of a payment
+// has its own amount and its own notification key.
+func planPaymentTransition(payment models.Payments, status string, amount uint64) (paymentTransition, error) {
+	pending := payment.Status == PaymentStatusNew || payment.Status == PaymentStatusAuthorized
+	credited := payment.Status == PaymentStatusApplied || payment.Status == PaymentStatusPartialRefunded
+	remaining := int64(payment.Amount) - int64(payment.RefundedAmount)
+
+	switch {
+	case status == AcquirerStatusAuthorized && payment.Status == PaymentStatusNew:
+		return paymentTransition{Status: PaymentStatusAuthorized}, nil
+
+	case status == AcquirerStatusConfirmed && pending:
+		if amount != uint64(payment.Amount) {
+			return paymentTransition{}, ErrPaymentAmountMismatch
+		}
+		return paymentTransition{Status: PaymentStatusApplied, Credit: int64(amount)}, nil
+
+	case (status == AcquirerStatusRejected || status == AcquirerStatusCanceled || status == AcquirerStatusDeadlineExpired) && pending:
+		return paymentTransition{Status: status}, nil
+
+	case status == AcquirerStatusPartialRefunded && pending:
+		// Confirmed and partly refunded before it was heard of: the paid sum
+		// is credited first, then the refund is taken back.
+		if amount == 0 || amount >= uint64(payment.Amount) {
+			return paymentTransition{}, ErrPaymentAmountMismatch
+		}
+		return paymentTransition{Status: PaymentStatusPartialRefunded, Credit: int64(payment.Amount), Refund: int64(payment.Amount) - int64(amount)}, nil
+
+	case status == AcquirerStatusRefunded && pending:
+		return paymentTransition{Status: PaymentStatusRefunded, Credit: int64(payment.Amount), Refund: int64(payment.Amount)}, nil
+
+	case status == AcquirerStatusPartialRefunded && credited:
+		// A partial refund leaves something held; a held sum that is not
+		// below the remaining one is a stale or repeated notification.
+		if amount == 0 || int64(amount) >= remaining {
+			return paymentTransition{}, nil
+		}
+		return paymentTransition{Status: PaymentStatusPartialRefunded, Refund: remaining - int64(amount)}, nil
+
+	case (status == AcquirerStatusRefunded || status == AcquirerStatusCanceled) && credited:
+		return paymentTransition{Status: status, Refund: remaining}, nil
+	}
+	return paymentTransition{}, nil
+}
+
+// ApplyPaymentStatus moves the payment with the given provider and PaymentId
+// to the reported status and posts the matching ledger entries. It must be
+// called inside a DB transaction. amount is the notification amount in
+// kopecks: the paid sum for CONFIRMED and the sum still held after the
+// refund for PARTIAL_REFUNDED. Every (provider, PaymentId, status, amount)
+// is applied only once, repeats return ErrPaymentNotificationProcessed.
+// Transitions that do not fit the current payment status are recorded and
+// otherwise ignored.
+func ApplyPaymentStatus(tx *gorm.DB, provider string, paymentID string, status string, amount uint64) (models.Payments, bool, error) {
+	var payment models.Payments
+
+	notification := models.PaymentNotification{Provider: provider, PaymentId: paymentID, Status: status, Amount: amount}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification)
+	if result.Error != nil {
+		return payment, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return payment, false, ErrPaymentNotificationProcessed
+	}
+
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("provider = ? AND payment_id = ?", provider, paymentID).
+		First(&payment).Error; err != nil {
+		return payment, false, err
+	}
+
+	transition, err := planPaymentTransition(payment, status, amount)
+	if err != nil || transition.Status == "" {
+		return payment, false, err
+	}
+
+	if transition.Credit > 0 {
+		if err := CheckWalletCurrency(tx, payment.UserID, payment.Currency); err != nil {
+			return payment, false, err
+		}
+		entry := models.LedgerEntry{
+			Kind:        LedgerKindTopUp,
+			Description: `Пополнение баланса c карты банка`,
+			Module:      `Payment`,
+			ElementId:   payment.ID,
+			Reference:   LedgerReference("payment:%s:%s", payment.Provider, payment.PaymentId),
+		}
+		if err := CreditUserWallet(tx, payment.UserID, LedgerAccountAcquirer, transition.Credit, &entry); err != nil {
+			return payment, false, err
+		}
+		if _, err := IssueReceipt(tx, DocumentPurposeTopUp, entry, payment.UserID, nil, transition.Credit); err != nil {
+			return payment, false, err
+		}
+	}
+	if transition.Refund > 0 {
+		if err := refundPayment(tx, &payment, transition.Refund, notification.ID); err != nil {
+			return payment, false, err
+		}
+	}
+
+	payment.Status = transition.Status
+	balanceChanged := transition.Credit > 0 || transition.Refund > 0
+	return payment, balanceChanged, tx.Save(&payment).Error
+}
+
+// refundPayment takes amount kopecks of a top-up back from the user's
+// wallet. The part already taken by a pending refund is not posted again.
+// The ledger reference carries the notification, so every refund of the
+// payment is posted once.
+func refundPayment(tx *gorm.DB, payment *models.Payments, amount int64, notificationID uint64) error {
+	covered := pendingRefundCover(*payment, amount)
+	if covered > 0 {
+		payment.PendingRefundAmount -= float64(covered)
+		if payment.PendingRefundAmount == 0 {
+			payment.PendingRefundEntryID = nil
+			payment.RefundRequestedAt = nil
+		}
+	}
+
+	if amount > covered {
+		topUp, err := topUpEntry(tx, *payment)
+		if err != nil {
+			return err
+		}
+		_, err = RefundLedgerEntry(tx, topUp.ID, amount-covered,
+			`Возврат платежа на карту банка`,
+			LedgerReference("payment:%s:%s:refund:%d", payment.Provider, payment.PaymentId, notificationID))
+		if err != nil {
+			return err
+		}
+	}
+
+	payment.RefundedAmount += float64(amount)
+	return nil
+}
+
+// pendingRefundCover is the part of a refund of amount kopecks that the
+// pending refund of the payment has already taken from the wallet.
+func pendingRefundCover(payment models.Payments, amount int64) int64 {
+	pending := int64(payment.PendingRefundAmount)
+	if pending > amount {
+		return amount
+	}
+	return pending
+}
+
+func topUpEntry(tx *gorm.DB, payment models.Payments) (models.LedgerEntry, error) {
+	var topUp models.LedgerEntry
+	err := tx.Where("kind = ? AND module = ? AND element_id = ? AND reversal_of_id IS NULL", LedgerKindTopUp, `Payment`, payment.ID).
+		First(&topUp).Error
+	return topUp, err
+}
+
+// RefundTopUp returns amount kopecks of a confirmed top-up to the card, all
+// that is left when amount is zero, and takes them back from the wallet.
+// No lock is held while the acquirer answers: the sum is first taken from
+// the wallet and recorded as the pending refund of the payment, so it can
+// not be spent or refunded twice, then the acquirer is asked and its answer
+// applied. A refused refund gives the sum back to the wallet; should the
+// acquirer have refunded anyway, ReconcilePayments takes it again. Refunds
+// left pending by a crash are settled by ReconcilePayments too.
+func RefundTopUp(provider PaymentProvider, paymentID string, amount uint64) (models.Payments, error) {
+	var payment models.Payments
+	var remaining uint64
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var requestErr error
+		payment, remaining, amount, requestErr = requestRefund(tx, provider.Name(), paymentID, amount)
+		return requestErr
+	})
+	if err != nil {
+		return payment, err
+	}
+
+	if _, refundErr := provider.Refund(paymentID, amount); refundErr != nil {
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			var releaseErr error
+			payment, releaseErr = releasePendingRefund(tx, provider.Name(), paymentID)
+			return releaseErr
+		})
+		if err != nil {
+			log.Println("Could not release pending refund", paymentID, err)
+		}
+		return payment, fmt.Errorf("%w: %v", ErrAcquirerRefusedRefund, refundErr)
+	}
+
+	status := AcquirerStatusPartialRefunded
+	if amount == remaining {
+		status = AcquirerStatusRefunded
+	}
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var applyErr error
+		payment, _, applyErr = ApplyPaymentStatus(tx, provider.Name(), paymentID, status, remaining-amount)
+		return applyErr
+	})
+	if errors.Is(err, ErrPaymentNotificationProcessed) {
+		// The acquirer notification of this refund came first
+		err = initializers.DB.Where("provider = ? AND payment_id = ?", provider.Name(), paymentID).First(&payment).Error
+	}
+	return payment, err
+}
+
+// requestRefund takes amount kopecks, or all that is left when amount is
+// zero, from the wallet and records them as the pending refund of the
+// payment. It returns the payment, the sum refundable before this refund
+// and the amount to refund.
+func requestRefund(tx *gorm.DB, provider string, paymentID string, amount uint64) (models.Payments, uint64, uint64, error) {
+	var payment models.Payments
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("provider = ? AND payment_id = ?", provider, paymentID).
+		First(&payment).Error; err != nil {
+		return payment, 0, 0, err
+	}
+	if payment.Status != PaymentStatusApplied && payment.Status != PaymentStatusPartialRefunded {
+		return payment, 0, 0, ErrPaymentNotRefundable
+	}
+	if payment.PendingRefundAmount > 0 {
+		return payment, 0, 0, ErrRefundPending
+	}
+
+	remaining := uint64(payment.Amount - payment.RefundedAmount)
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount > remaining {
+		return payment, 0, 0, ErrRefundExceedsPayment
+	}
+
+	wallet, err := UserWalletAccount(tx, payment.UserID)
+	if err != nil {
+		return payment, 0, 0, err
+	}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, wallet.ID).Error; err != nil {
+		return payment, 0, 0, err
+	}
+	if wallet.Balance < int64(amount) {
+		return payment, 0, 0, ErrInsufficientBalance
+	}
+
+	topUp, err := topUpEntry(tx, payment)
+	if err != nil {
+		return payment, 0, 0, err
+	}
+	requestedAt := time.Now()
+	entry, err := RefundLedgerEntry(tx, topUp.ID, int64(amount),
+		`Возврат платежа на карту банка`,
+		LedgerReference("payment:%s:%s:refund-request:%d", payment.Provider, payment.PaymentId, requestedAt.UnixNano()))
+	if err != nil {
+		return payment, 0, 0, err
+	}
+
+	payment.PendingRefundAmount = float64(amount)
+	payment.PendingRefundEntryID = &entry.ID
+	payment.RefundRequestedAt = &requestedAt
+	return payment, remaining, amount, tx.Save(&payment).Error
+}
+
+// releasePendingRefund gives the pending refund of the payment back to the
+// wallet when the acquirer did not refund it.
+func releasePendingRefund(tx *gorm.DB, provider string, paymentID string) (models.Payments, error) {
+	var payment models.Payments
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("provider = ? AND payment_id = ?", provider, paymentID).
+		First(&payment).Error; err != nil {
+		return payment, err
+	}
+	if payment.PendingRefundAmount == 0 || payment.PendingRefundEntryID == nil {
+		return payment, nil
+	}
+
+	_, err := ReverseLedgerEntry(tx, *payment.PendingRefundEntryID,
+		`Отмена возврата платежа на карту банка`,
+		LedgerReference("payment:%s:%s:refund-release:%d", payment.Provider, payment.PaymentId, *payment.PendingRefundEntryID))
+	if err != nil {
+		return payment, err
+	}
+
+	payment.PendingRefundAmount = 0
+	payment.PendingRefundEntryID = nil
+	payment.RefundRequestedAt = nil
+	return payment, tx.Save(&payment).Error
+}
+
+// CheckWalletCurrency returns ErrWalletCurrencyMismatch unless payments in
+// currency can be credited to the user's wallet as is.
+func CheckWalletCurrency(tx *gorm.DB, userID uuid.UUID, currency string) error {
+	wallet, err := UserWalletAccount(tx, userID)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(wallet.Currency, currency) {
+		return ErrWalletCurrencyMismatch
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"testing"
+	"time"
+)
+
+func TestPlanPaymentTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		payment models.Payments
+		status  string
+		amount  uint64
+		want    paymentTransition
+		wantErr error
+	}{
+		{
+			name:    "confirmed credits the paid sum",
+			payment: models.Payments{Status: PaymentStatusNew, Amount: 10000},
+			status:  AcquirerStatusConfirmed,
+			amount:  10000,
+			want:    paymentTransition{Status: PaymentStatusApplied, Credit: 10000},
+		},
+		{
+			name:    "confirmed with another sum is refused",
+			payment: models.Payments{Status: PaymentStatusAuthorized, Amount: 10000},
+			status:  AcquirerStatusConfirmed,
+			amount:  9000,
+			wantErr: ErrPaymentAmountMismatch,
+		},
+		{
+			name:    "confirmed twice is ignored",
+			payment: models.Payments{Status: PaymentStatusApplied, Amount: 10000},
+			status:  AcquirerStatusConfirmed,
+			amount:  10000,
+		},
+		{
+			name:    "partial refund takes back the difference to the held sum",
+			payment: models.Payments{Status: PaymentStatusApplied, Amount: 10000},
+			status:  AcquirerStatusPartialRefunded,
+			amount:  7000,
+			want:    paymentTransition{Status: PaymentStatusPartialRefunded, Refund: 3000},
+		},
+		{
+			name:    "second equal partial refund",
+			payment: models.Payments{Status: PaymentStatusPartialRefunded, Amount: 10000, RefundedAmount: 3000},
+			status:  AcquirerStatusPartialRefunded,
+			amount:  4000,
+			want:    paymentTransition{Status: PaymentStatusPartialRefunded, Refund: 3000},
+		},
+		{
+			name:    "stale partial refund is ignored",
+			payment: models.Payments{Status: PaymentStatusPartialRefunded, Amount: 10000, RefundedAmount: 3000},
+			status:  AcquirerStatusPartialRefunded,
+			amount:  7000,
+		},
+		{
+			name:    "partial refund without the held sum is ignored",
+			payment: models.Payments{Status: PaymentStatusApplied, Amount: 10000},
+			status:  AcquirerStatusPartialRefunded,
+			amount:  0,
+		},
+		{
+			name:    "full refund takes back the rest",
+			payment: models.Payments{Status: PaymentStatusPartialRefunded, Amount: 10000, RefundedAmount: 3000},
+			status:  AcquirerStatusRefunded,
+			amount:  0,
+			want:    paymentTransition{Status: PaymentStatusRefunded, Refund: 7000},
+		},
+		{
+			name:    "canceled before confirmation moves no money",
+			payment: models.Payments{Status: PaymentStatusNew, Amount: 10000},
+			status:  AcquirerStatusCanceled,
+			want:    paymentTransition{Status: PaymentStatusCanceled},
+		},
+		{
+			name:    "canceled after confirmation takes back the rest",
+			payment: models.Payments{Status: PaymentStatusApplied, Amount: 10000},
+			status:  AcquirerStatusCanceled,
+			want:    paymentTransition{Status: PaymentStatusCanceled, Refund: 10000},
+		},
+		{
+			name:    "partial refund of an unconfirmed payment credits it first",
+			payment: models.Payments{Status: PaymentStatusNew, Amount: 10000},
+			status:  AcquirerStatusPartialRefunded,
+			amount:  6000,
+			want:    paymentTransition{Status: PaymentStatusPartialRefunded, Credit: 10000, Refund: 4000},
+		},
+		{
+			name:    "partial refund of an unconfirmed payment needs the held sum",
+			payment: models.Payments{Status: PaymentStatusAuthorized, Amount: 10000},
+			status:  AcquirerStatusPartialRefunded,
+			amount:  0,
+			wantErr: ErrPaymentAmountMismatch,
+		},
+		{
+			name:    "full refund of an unconfirmed payment credits and takes back all",
+			payment: models.Payments{Status: PaymentStatusNew, Amount: 10000},
+			status:  AcquirerStatusRefunded,
+			want:    paymentTransition{Status: PaymentStatusRefunded, Credit: 10000, Refund: 10000},
+		},
+		{
+			name:    "refund of a refunded payment is ignored",
+			payment: models.Payments{Status: PaymentStatusRefunded, Amount: 10000, RefundedAmount: 10000},
+			status:  AcquirerStatusRefunded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planPaymentTransition(tt.payment, tt.status, tt.amount)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("transition = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Equal partial refunds leave different sums held, so their notifications
+// do not collide and both are applied.
+func TestFakeProviderPartialRefundsHaveDistinctAmounts(t *testing.T) {
+	provider := NewFakePaymentProvider()
+	provider.SetState("fake-1", AcquirerStatusConfirmed, 10000)
+
+	payment := models.Payments{Status: PaymentStatusApplied, Amount: 10000}
+	seen := map[uint64]bool{}
+	for i := 0; i < 2; i++ {
+		state, err := provider.Refund("fake-1", 3000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[state.Amount] {
+			t.Fatalf("refund %d repeats the notification amount %d", i+1, state.Amount)
+		}
+		seen[state.Amount] = true
+
+		transition, err := planPaymentTransition(payment, state.Status, state.Amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transition.Refund != 3000 {
+			t.Fatalf("refund %d takes back %d, want 3000", i+1, transition.Refund)
+		}
+		payment.Status = transition.Status
+		payment.RefundedAmount += float64(transition.Refund)
+	}
+	if payment.RefundedAmount != 6000 {
+		t.Fatalf("refunded %v, want 6000", payment.RefundedAmount)
+	}
+}
+
+func TestReconciledAmount(t *testing.T) {
+	payment := models.Payments{Status: PaymentStatusNew, Amount: 10000}
+
+	if got := reconciledAmount(payment, PaymentState{Status: AcquirerStatusConfirmed}); got != 10000 {
+		t.Fatalf("confirmed without amount = %d, want the paid 10000", got)
+	}
+
+	state := PaymentState{Status: AcquirerStatusPartialRefunded, Amount: 6000}
+	transition, err := planPaymentTransition(payment, state.Status, reconciledAmount(payment, state))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance := transition.Credit - transition.Refund; balance != 6000 {
+		t.Fatalf("wallet gets %d, want the 6000 still held", balance)
+	}
+}
+
+func TestPendingRefundCover(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending float64
+		amount  int64
+		want    int64
+	}{
+		{name: "no pending refund", pending: 0, amount: 4000, want: 0},
+		{name: "notification of the pending refund", pending: 4000, amount: 4000, want: 4000},
+		{name: "larger refund posts the difference", pending: 4000, amount: 10000, want: 4000},
+		{name: "smaller refund leaves the rest pending", pending: 4000, amount: 1000, want: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := models.Payments{Status: PaymentStatusApplied, Amount: 10000, PendingRefundAmount: tt.pending}
+			if got := pendingRefundCover(payment, tt.amount); got != tt.want {
+				t.Fatalf("pendingRefundCover() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefundStale(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Minute)
+	old := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name    string
+		payment models.Payments
+		want    bool
+	}{
+		{name: "no pending refund", payment: models.Payments{RefundRequestedAt: &old}},
+		{name: "refund in flight", payment: models.Payments{PendingRefundAmount: 4000, RefundRequestedAt: &recent}},
+		{name: "refund never answered", payment: models.Payments{PendingRefundAmount: 4000, RefundRequestedAt: &old}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refundStale(tt.payment, now, time.Hour); got != tt.want {
+				t.Fatalf("refundStale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hyperpage/initializers"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const defaultPdfRenderer = "chromium-browser"
+
+// RenderPDF prints an HTML page to PDF with headless Chromium. The binary
+// is taken from PDF_RENDERER_BIN. Chromium keeps its sandbox unless
+// PDF_RENDERER_NO_SANDBOX is set, for local containers running as root.
+func RenderPDF(html string) ([]byte, error) {
+	config, _ := initializers.LoadConfig(".")
+	renderer := config.PdfRendererBin
+	if renderer == "" {
+		renderer = defaultPdfRenderer
+	}
+
+	dir, err := os.MkdirTemp("", "document-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "document.html")
+	output := filepath.Join(dir, "document.pdf")
+	if err := os.WriteFile(input, []byte(html), 0o600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	args := []string{"--headless", "--disable-gpu"}
+	if config.PdfRendererNoSandbox {
+		args = append(args, "--no-sandbox")
+	}
+	args = append(args,
+		"--no-pdf-header-footer",
+		"--print-to-pdf-no-header",
+		"--print-to-pdf="+output,
+		"file://"+input,
+	)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, renderer, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.New("pdf rendering failed: " + err.Error() + ": " + stderr.String())
+	}
+
+	return os.ReadFile(output)
+}
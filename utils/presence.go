@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"hyperpage/initializers"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Presence states of a user.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+const (
+	presenceSeenTTL  = 30 * 24 * time.Hour
+	presenceUsersKey = "presence:users" // users with sessions, for the sweeper
+)
+
+// A user is present while any of their sessions sends heartbeats. Sessions
+// are kept in a sorted set by the time of the last heartbeat, so sessions of
+// a crashed node fall out after wsSessionTTL. Sessions the client reported
+// as idle are also in the away set.
+func presenceKey(userID string) string     { return "presence:" + userID }
+func presenceAwayKey(userID string) string { return "presence:away:" + userID }
+func presenceSeenKey(userID string) string { return "presence:seen:" + userID }
+
+// presenceScript applies one change to the sessions of a user and returns
+// the state before and after it, so concurrent changes from several devices
+// and nodes see consistent transitions.
+var presenceScript = redis.NewScript(`
+local sessions, away, seen, users = KEYS[1], KEYS[2], KEYS[3], KEYS[4]
+local op, session, now, cutoff, ttl, seenTTL, user = ARGV[1], ARGV[2], ARGV[3], ARGV[4], ARGV[5], ARGV[6], ARGV[7]
+
+local function state()
+	redis.call("ZREMRANGEBYSCORE", sessions, "-inf", "(" .. cutoff)
+	local live = redis.call("ZRANGE", sessions, 0, -1)
+	if #live == 0 then
+		return "offline"
+	end
+	for _, id in ipairs(live) do
+		if redis.call("SISMEMBER", away, id) == 0 then
+			return "online"
+		end
+	end
+	return "away"
+end
+
+local before = state()
+if op == "connect" then
+	redis.call("SREM", away, session)
+	redis.call("ZADD", sessions, now, session)
+	redis.call("SET", seen, now, "EX", seenTTL)
+	redis.call("SADD", users, user)
+elseif op == "disconnect" then
+	redis.call("ZREM", sessions, session)
+	redis.call("SREM", away, session)
+elseif op == "away" then
+	if redis.call("ZSCORE", sessions, session) then
+		redis.call("SADD", away, session)
+	end
+elseif op == "active" then
+	redis.call("SREM", away, session)
+end
+if op ~= "state" then
+	redis.call("EXPIRE", sessions, ttl)
+	redis.call("EXPIRE", away, ttl)
+end
+
+local after = state()
+if after == "offline" then
+	redis.call("SREM", users, user)
+end
+return {before, after}`)
+
+func presenceHeartbeat(ctx context.Context, pipe redis.Pipeliner, userID string, sessionID string, now time.Time) {
+	pipe.ZAdd(ctx, presenceKey(userID), redis.Z{Score: float64(now.Unix()), Member: sessionID})
+	pipe.Expire(ctx, presenceKey(userID), wsSessionTTL)
+	pipe.Expire(ctx, presenceAwayKey(userID), wsSessionTTL)
+	pipe.Set(ctx, presenceSeenKey(userID), now.Unix(), presenceSeenTTL)
+	pipe.SAdd(ctx, presenceUsersKey, userID)
+}
+
+// presenceChange runs presenceScript and returns the state of the user
+// before and after the change.
+func presenceChange(op string, userID string, sessionID string) (string, string, error) {
+	now := time.Now()
+	result, err := presenceScript.Run(context.Background(), initializers.RedisClient,
+		[]string{presenceKey(userID), presenceAwayKey(userID), presenceSeenKey(userID), presenceUsersKey},
+		op, sessionID, now.Unix(), now.Add(-wsSessionTTL).Unix(),
+		int64(wsSessionTTL/time.Second), int64(presenceSeenTTL/time.Second), userID,
+	).StringSlice()
+	if err != nil {
+		return "", "", err
+	}
+	return result[0], result[1], nil
+}
+
+// PresenceConnect adds the session to the user. It returns the state of the
+// user before and after.
+func PresenceConnect(userID string, sessionID string) (string, string, error) {
+	return presenceChange("connect", userID, sessionID)
+}
+
+// PresenceSetAway marks the session idle or active again. It returns the
+// state of the user before and after.
+func PresenceSetAway(userID string, sessionID string, away bool) (string, string, error) {
+	if away {
+		return presenceChange("away", userID, sessionID)
+	}
+	return presenceChange("active", userID, sessionID)
+}
+
+// PresenceDisconnect removes the session from the user. It returns the
+// state of the user before and the state left by the other sessions.
+func PresenceDisconnect(userID string, sessionID string) (string, string, error) {
+	return presenceChange("disconnect", userID, sessionID)
+}
+
+// PresenceState is online while any live session is active, away while all
+// of them are idle and offline without sessions.
+func PresenceState(userID string) (string, error) {
+	_, state, err := presenceChange("state", userID, "")
+	if err != nil {
+		return PresenceOffline, err
+	}
+	return state, nil
+}
+
+// PresenceUsers lists the users that had live sessions at the last change.
+// Users whose sessions expired since are dropped by the next PresenceState.
+func PresenceUsers() ([]string, error) {
+	return initializers.RedisClient.SMembers(context.Background(), presenceUsersKey).Result()
+}
+
+// PresenceLastHeartbeat returns the time of the last heartbeat of the user,
+// the moment a user who vanished without disconnecting was last seen.
+func PresenceLastHeartbeat(userID string) (time.Time, bool) {
+	seen, err := initializers.RedisClient.Get(context.Background(), presenceSeenKey(userID)).Int64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seen, 0), true
+}
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hyperpage/initializers"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakePresenceRedis answers EVAL with the given states and records the
+// scripts it ran.
+type fakePresenceRedis struct {
+	mu     sync.Mutex
+	states []string
+	err    string
+	evals  [][]string
+}
+
+func (f *fakePresenceRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		command, err := readRESPCommand(reader)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch strings.ToUpper(command[0]) {
+		case "EVALSHA":
+			reply = "-NOSCRIPT No matching script\r\n"
+		case "EVAL":
+			f.mu.Lock()
+			f.evals = append(f.evals, command)
+			f.mu.Unlock()
+			if f.err != "" {
+				reply = "-" + f.err + "\r\n"
+				break
+			}
+			reply = fmt.Sprintf("*%d\r\n", len(f.states))
+			for _, state := range f.states {
+				reply += fmt.Sprintf("$%d\r\n%s\r\n", len(state), state)
+			}
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func readRESPCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	command := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		command = append(command, string(value[:size]))
+	}
+	return command, nil
+}
+
+func useFakePresenceRedis(t *testing.T, fake *fakePresenceRedis) {
+	previous := initializers.RedisClient
+	initializers.RedisClient = redis.NewClient(&redis.Options{
+		MaxRetries: -1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go fake.serve(server)
+			return client, nil
+		},
+	})
+	t.Cleanup(func() {
+		initializers.RedisClient.Close()
+		initializers.RedisClient = previous
+	})
+}
+
+func TestPresenceKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "sessions", got: presenceKey("u1"), want: "presence:u1"},
+		{name: "away", got: presenceAwayKey("u1"), want: "presence:away:u1"},
+		{name: "seen", got: presenceSeenKey("u1"), want: "presence:seen:u1"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Fatalf("%s key = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestPresenceChanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     func() (string, string, error)
+		states     []string
+		wantOp     string
+		wantBefore string
+		wantAfter  string
+	}{
+		{
+			name:   "first session comes online",
+			change: func() (string, string, error) { return PresenceConnect("u1", "s1") },
+			states: []string{PresenceOffline, PresenceOnline}, wantOp: "connect",
+			wantBefore: PresenceOffline, wantAfter: PresenceOnline,
+		},
+		{
+			name:   "idle session goes away",
+			change: func() (string, string, error) { return PresenceSetAway("u1", "s1", true) },
+			states: []string{PresenceOnline, PresenceAway}, wantOp: "away",
+			wantBefore: PresenceOnline, wantAfter: PresenceAway,
+		},
+		{
+			name:   "session is active again",
+			change: func() (string, string, error) { return PresenceSetAway("u1", "s1", false) },
+			states: []string{PresenceAway, PresenceOnline}, wantOp: "active",
+			wantBefore: PresenceAway, wantAfter: PresenceOnline,
+		},
+		{
+			name:   "last session leaves",
+			change: func() (string, string, error) { return PresenceDisconnect("u1", "s1") },
+			states: []string{PresenceOnline, PresenceOffline}, wantOp: "disconnect",
+			wantBefore: PresenceOnline, wantAfter: PresenceOffline,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakePresenceRedis{states: tt.states}
+			useFakePresenceRedis(t, fake)
+
+			before, after, err := tt.change()
+			if err != nil {
+				t.Fatalf("presence change failed: %v", err)
+			}
+			if before != tt.wantBefore || after != tt.wantAfter {
+				t.Fatalf("presence change = %s -> %s, want %s -> %s", before, after, tt.wantBefore, tt.wantAfter)
+			}
+
+			if len(fake.evals) != 1 {
+				t.Fatalf("ran %d scripts, want 1", len(fake.evals))
+			}
+			// EVAL script numkeys keys... op session now cutoff ttl seenTTL user
+			eval := fake.evals[0]
+			wantKeys := []string{presenceKey("u1"), presenceAwayKey("u1"), presenceSeenKey("u1"), presenceUsersKey}
+			if eval[2] != "4" || strings.Join(eval[3:7], " ") != strings.Join(wantKeys, " ") {
+				t.Fatalf("script keys = %v, want %v", eval[2:7], wantKeys)
+			}
+			args := eval[7:]
+			if args[0] != tt.wantOp || args[1] != "s1" || args[6] != "u1" {
+				t.Fatalf("script args = %v, want op %s for session s1 of u1", args, tt.wantOp)
+			}
+			now, _ := strconv.ParseInt(args[2], 10, 64)
+			cutoff, _ := strconv.ParseInt(args[3], 10, 64)
+			if now-cutoff != int64(wsSessionTTL/time.Second) {
+				t.Fatalf("sessions fall out after %ds, want %ds", now-cutoff, int64(wsSessionTTL/time.Second))
+			}
+			if args[4] != strconv.FormatInt(int64(wsSessionTTL/time.Second), 10) || args[5] != strconv.FormatInt(int64(presenceSeenTTL/time.Second), 10) {
+				t.Fatalf("script ttls = %s, %s", args[4], args[5])
+			}
+		})
+	}
+}
+
+func TestPresenceState(t *testing.T) {
+	fake := &fakePresenceRedis{states: []string{PresenceAway, PresenceAway}}
+	useFakePresenceRedis(t, fake)
+	state, err := PresenceState("u1")
+	if err != nil || state != PresenceAway {
+		t.Fatalf("PresenceState() = %s, %v, want %s", state, err, PresenceAway)
+	}
+	if op := fake.evals[0][7]; op != "state" {
+		t.Fatalf("PresenceState() ran op %q, want state", op)
+	}
+}
+
+func TestPresenceStateOnError(t *testing.T) {
+	useFakePresenceRedis(t, &fakePresenceRedis{err: "ERR script failed"})
+	state, err := PresenceState("u1")
+	if err == nil || state != PresenceOffline {
+		t.Fatalf("PresenceState() on a Redis error = %s, %v, want %s and the error", state, err, PresenceOffline)
+	}
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"hyperpage/models"
+	"sort"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidCartItem    = errors.New("invalid cart item")
+	ErrProductUnavailable = errors.New("product is not available")
+	ErrProductOutOfStock  = errors.New("product is out of stock")
+)
+
+// CartItem is a variant and quantity the buyer wants to order.
+type CartItem struct {
+	VariantID uint64 `json:"variantId"`
+	Quantity  int    `json:"quantity"`
+}
+
+// ReservedItem is a cart item with the price and seller resolved from the
+// catalog.
+type ReservedItem struct {
+	Product  models.Product
+	Variant  models.ProductVariant
+	Quantity int
+}
+
+// Amount returns the price of the item in minor units.
+func (i ReservedItem) Amount() int64 {
+	return i.Variant.Price * int64(i.Quantity)
+}
+
+// ReserveCartItems takes the items off stock. Variants are locked in ID
+// order, so concurrent carts can neither oversell nor deadlock. Errors wrap
+// ErrProductUnavailable or ErrProductOutOfStock with the SKU at fault. It
+// must be called inside a DB transaction.
+func ReserveCartItems(tx *gorm.DB, items []CartItem) ([]ReservedItem, error) {
+	ids, quantities, err := mergeCartItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := make([]ReservedItem, 0, len(ids))
+	for _, id := range ids {
+		var variant models.ProductVariant
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&variant, id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: variant %d", ErrProductUnavailable, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var product models.Product
+		if err := tx.First(&product, variant.ProductID).Error; err != nil {
+			return nil, err
+		}
+		if !variant.Active || !product.Active {
+			return nil, fmt.Errorf("%w: %s", ErrProductUnavailable, variant.SKU)
+		}
+
+		quantity := quantities[id]
+		if variant.Stock < quantity {
+			return nil, fmt.Errorf("%w: %s", ErrProductOutOfStock, variant.SKU)
+		}
+		if err := tx.Model(&variant).Update("stock", gorm.Expr("stock - ?", quantity)).Error; err != nil {
+			return nil, err
+		}
+		variant.Stock -= quantity
+
+		reserved = append(reserved, ReservedItem{Product: product, Variant: variant, Quantity: quantity})
+	}
+	return reserved, nil
+}
+
+// mergeCartItems sums the quantities of every variant and returns the
+// variant IDs in the order they are locked in.
+func mergeCartItems(items []CartItem) ([]uint64, map[uint64]int, error) {
+	if len(items) == 0 {
+		return nil, nil, ErrInvalidCartItem
+	}
+
+	quantities := map[uint64]int{}
+	var ids []uint64
+	for _, item := range items {
+		if item.VariantID == 0 || item.Quantity <= 0 {
+			return nil, nil, ErrInvalidCartItem
+		}
+		if _, ok := quantities[item.VariantID]; !ok {
+			ids = append(ids, item.VariantID)
+		}
+		quantities[item.VariantID] += item.Quantity
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, quantities, nil
+}
+
+// RestockOrderItems puts the catalog items of a canceled order back on
+// stock.
+func RestockOrderItems(tx *gorm.DB, orderID uuid.UUID) error {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ? AND variant_id IS NOT NULL", orderID).Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := tx.Model(&models.ProductVariant{}).
+			Where("id = ?", *item.VariantID).
+			Update("stock", gorm.Expr("stock + ?", item.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"testing"
+)
+
+func TestMergeCartItems(t *testing.T) {
+	ids, quantities, err := mergeCartItems([]CartItem{
+		{VariantID: 7, Quantity: 1},
+		{VariantID: 3, Quantity: 2},
+		{VariantID: 7, Quantity: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != 3 || ids[1] != 7 {
+		t.Fatalf("ids = %v, want [3 7] in lock order", ids)
+	}
+	if quantities[3] != 2 || quantities[7] != 5 {
+		t.Fatalf("quantities = %v, want 3:2 and 7:5", quantities)
+	}
+}
+
+func TestMergeCartItemsRefusesInvalidItems(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []CartItem
+	}{
+		{name: "empty cart"},
+		{name: "no variant", items: []CartItem{{Quantity: 1}}},
+		{name: "zero quantity", items: []CartItem{{VariantID: 1}}},
+		{name: "negative quantity", items: []CartItem{{VariantID: 1, Quantity: 2}, {VariantID: 1, Quantity: -1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := mergeCartItems(tt.items); !errors.Is(err, ErrInvalidCartItem) {
+				t.Fatalf("mergeCartItems() error = %v, want %v", err, ErrInvalidCartItem)
+			}
+		})
+	}
+}
+
+func TestReservedItemAmount(t *testing.T) {
+	item := ReservedItem{Variant: models.ProductVariant{Price: 1250}, Quantity: 3}
+	if got := item.Amount(); got != 3750 {
+		t.Fatalf("Amount() = %d, want 3750", got)
+	}
+}
@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hyperpage/models"
+	"math/big"
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of promo campaigns. Codes without a campaign grant their Balance.
+const (
+	PromoKindBalance     = "balance"
+	PromoKindPlanDays    = "plan_days"
+	PromoKindFeeDiscount = "fee_discount"
+)
+
+var (
+	ErrPromoCodeNotFound     = errors.New("promo code not found")
+	ErrPromoCodeUsed         = errors.New("promo code is already used")
+	ErrPromoCampaignInactive = errors.New("promo campaign is not active")
+	ErrPromoUserLimit        = errors.New("promo code limit per user is reached")
+)
+
+const promoCodeCharset = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// ValidatePromoCampaign checks that the campaign grants exactly what its
+// kind says.
+func ValidatePromoCampaign(campaign models.PromoCampaign) error {
+	switch campaign.Kind {
+	case PromoKindBalance:
+		if campaign.Balance <= 0 {
+			return errors.New("balance must be positive")
+		}
+	case PromoKindPlanDays:
+		if campaign.PlanDays <= 0 || campaign.PlanCode == "" {
+			return errors.New("plan code and plan days are required")
+		}
+	case PromoKindFeeDiscount:
+		if campaign.DiscountPercent <= 0 || campaign.DiscountPercent > 100 {
+			return errors.New("discount percent must be between 1 and 100")
+		}
+	default:
+		return errors.New("unknown campaign kind")
+	}
+	if campaign.StartsAt != nil && campaign.EndsAt != nil && campaign.EndsAt.Before(*campaign.StartsAt) {
+		return errors.New("campaign ends before it starts")
+	}
+	return nil
+}
+
+// GeneratePromoCodes creates count codes copied from template, each with a
+// new random code. A code another campaign already issued is skipped by the
+// unique index and drawn again.
+func GeneratePromoCodes(tx *gorm.DB, template models.Codes, count int) ([]models.Codes, error) {
+	codes := make([]models.Codes, 0, count)
+	for len(codes) < count {
+		value, err := randomPromoCode(10)
+		if err != nil {
+			return nil, err
+		}
+
+		code := template
+		code.Code = value
+		result := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "code"}}, DoNothing: true}).Create(&code)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func randomPromoCode(length int) (string, error) {
+	result := make([]byte, length)
+	max := big.NewInt(int64(len(promoCodeCharset)))
+	for i := range result {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		result[i] = promoCodeCharset[n.Int64()]
+	}
+	return string(result), nil
+}
+
+// RedeemPromoCode applies the code for the user. It must be called inside a
+// DB transaction. The code and its campaign rows are locked, so concurrent
+// redemptions can not exceed any of the limits.
+func RedeemPromoCode(tx *gorm.DB, userID uuid.UUID, value string) (models.PromoRedemption, models.Codes, error) {
+	var redemption models.PromoRedemption
+	var code models.Codes
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", value).First(&code).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return redemption, code, ErrPromoCodeNotFound
+	}
+	if err != nil {
+		return redemption, code, err
+	}
+	if err := checkPromoCode(code); err != nil {
+		return redemption, code, err
+	}
+
+	var used int64
+	if err := tx.Model(&models.PromoRedemption{}).Where("code_id = ? AND user_id = ?", code.ID, userID).Count(&used).Error; err != nil {
+		return redemption, code, err
+	}
+	if used > 0 {
+		return redemption, code, ErrPromoUserLimit
+	}
+
+	now := time.Now()
+	campaign := models.PromoCampaign{Kind: PromoKindBalance}
+	if code.CampaignID != nil {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&campaign, *code.CampaignID).Error; err != nil {
+			return redemption, code, err
+		}
+		if err := checkPromoCampaign(campaign, now); err != nil {
+			return redemption, code, err
+		}
+		if campaign.PerUserLimit > 0 {
+			var count int64
+			if err := tx.Model(&models.PromoRedemption{}).
+				Where("campaign_id = ? AND user_id = ?", campaign.ID, userID).
+				Count(&count).Error; err != nil {
+				return redemption, code, err
+			}
+			if err := checkPromoUserLimit(campaign, count); err != nil {
+				return redemption, code, err
+			}
+		}
+		if err := tx.Model(&campaign).Update("redemptions", gorm.Expr("redemptions + 1")).Error; err != nil {
+			return redemption, code, err
+		}
+	}
+
+	code.Redemptions++
+	code.Activated = code.MaxRedemptions > 0 && code.Redemptions >= code.MaxRedemptions
+	code.UserId = userID
+	code.Used = uint64(now.Unix())
+	if err := tx.Model(&code).Updates(map[string]interface{}{
+		"redemptions": code.Redemptions,
+		"activated":   code.Activated,
+		"user_id":     code.UserId,
+		"used":        code.Used,
+	}).Error; err != nil {
+		return redemption, code, err
+	}
+
+	redemption = models.PromoRedemption{
+		CodeID:     code.ID,
+		UserID:     userID,
+		CampaignID: code.CampaignID,
+		Kind:       campaign.Kind,
+	}
+
+	switch campaign.Kind {
+	case PromoKindBalance:
+		amount := campaign.Balance
+		if code.CampaignID == nil {
+			balance, err := strconv.ParseFloat(code.Balance, 64)
+			if err != nil {
+				return redemption, code, err
+			}
+			amount = MoneyToMinor(balance)
+		}
+		entry := models.LedgerEntry{
+			Kind:        LedgerKindPromoCode,
+			Description: `Пополнение баланса`,
+			Module:      `CodeUsed`,
+			ElementId:   uint64(code.ID),
+		}
+		if err := CreditUserWallet(tx, userID, LedgerAccountPromo, amount, &entry); err != nil {
+			return redemption, code, err
+		}
+		redemption.ConsumedAt = &now
+
+	case PromoKindPlanDays:
+		plan, err := FindSubscriptionPlan(tx, campaign.PlanCode)
+		if err != nil {
+			return redemption, code, err
+		}
+		if _, err := GrantSubscriptionDays(tx, userID, plan, campaign.PlanDays); err != nil {
+			return redemption, code, err
+		}
+		redemption.ConsumedAt = &now
+
+	case PromoKindFeeDiscount:
+		redemption.DiscountPercent = campaign.DiscountPercent
+	}
+
+	return redemption, code, tx.Create(&redemption).Error
+}
+
+// checkPromoCode refuses a code that is used up.
+func checkPromoCode(code models.Codes) error {
+	if code.Activated || (code.MaxRedemptions > 0 && code.Redemptions >= code.MaxRedemptions) {
+		return ErrPromoCodeUsed
+	}
+	return nil
+}
+
+// checkPromoCampaign refuses a campaign outside of its period or with all
+// its redemptions used.
+func checkPromoCampaign(campaign models.PromoCampaign, now time.Time) error {
+	if (campaign.StartsAt != nil && now.Before(*campaign.StartsAt)) || (campaign.EndsAt != nil && now.After(*campaign.EndsAt)) {
+		return ErrPromoCampaignInactive
+	}
+	if campaign.MaxRedemptions > 0 && campaign.Redemptions >= campaign.MaxRedemptions {
+		return ErrPromoCodeUsed
+	}
+	return nil
+}
+
+// checkPromoUserLimit refuses a user who already redeemed the campaign used
+// times.
+func checkPromoUserLimit(campaign models.PromoCampaign, used int64) error {
+	if campaign.PerUserLimit > 0 && used >= int64(campaign.PerUserLimit) {
+		return ErrPromoUserLimit
+	}
+	return nil
+}
+
+// discountedFee is what is left of a fee of amount kopecks after a discount
+// of percent, rounded down.
+func discountedFee(amount int64, percent int) int64 {
+	return amount * int64(100-percent) / 100
+}
+
+// ChargePublicationFee charges a publication fee from the user's wallet,
+// using up the oldest unused fee discount of the user. It must be called
+// inside a DB transaction.
+func ChargePublicationFee(tx *gorm.DB, userID uuid.UUID, amount int64, entry *models.LedgerEntry) error {
+	var discount models.PromoRedemption
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND kind = ? AND consumed_at IS NULL", userID, PromoKindFeeDiscount).
+		Order("id").
+		First(&discount).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil {
+		amount = discountedFee(amount, discount.DiscountPercent)
+		entry.Description = fmt.Sprintf("%s (скидка %d%%)", entry.Description, discount.DiscountPercent)
+		if err := tx.Model(&discount).Update("consumed_at", time.Now()).Error; err != nil {
+			return err
+		}
+	}
+
+	if amount == 0 {
+		return nil
+	}
+	return ChargeUserWallet(tx, userID, amount, entry)
+}
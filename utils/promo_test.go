@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePromoCampaign(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		campaign models.PromoCampaign
+		wantErr  bool
+	}{
+		{name: "balance", campaign: models.PromoCampaign{Kind: PromoKindBalance, Balance: 10000}},
+		{name: "balance without amount", campaign: models.PromoCampaign{Kind: PromoKindBalance}, wantErr: true},
+		{name: "plan days", campaign: models.PromoCampaign{Kind: PromoKindPlanDays, PlanCode: "business", PlanDays: 30}},
+		{name: "plan days without plan", campaign: models.PromoCampaign{Kind: PromoKindPlanDays, PlanDays: 30}, wantErr: true},
+		{name: "plan days without days", campaign: models.PromoCampaign{Kind: PromoKindPlanDays, PlanCode: "business"}, wantErr: true},
+		{name: "fee discount", campaign: models.PromoCampaign{Kind: PromoKindFeeDiscount, DiscountPercent: 100}},
+		{name: "fee discount over 100 percent", campaign: models.PromoCampaign{Kind: PromoKindFeeDiscount, DiscountPercent: 101}, wantErr: true},
+		{name: "fee discount of nothing", campaign: models.PromoCampaign{Kind: PromoKindFeeDiscount}, wantErr: true},
+		{name: "unknown kind", campaign: models.PromoCampaign{Kind: "gift"}, wantErr: true},
+		{
+			name:     "ends before it starts",
+			campaign: models.PromoCampaign{Kind: PromoKindBalance, Balance: 100, StartsAt: &now, EndsAt: &earlier},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePromoCampaign(tt.campaign); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidatePromoCampaign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRandomPromoCode(t *testing.T) {
+	code, err := randomPromoCode(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 10 {
+		t.Fatalf("len(%q) = %d, want 10", code, len(code))
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(promoCodeCharset, r) {
+			t.Fatalf("code %q has %q outside of the charset", code, r)
+		}
+	}
+}
+
+func TestCheckPromoCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    models.Codes
+		wantErr error
+	}{
+		{name: "fresh single use code", code: models.Codes{MaxRedemptions: 1}},
+		{name: "single use code used", code: models.Codes{MaxRedemptions: 1, Redemptions: 1}, wantErr: ErrPromoCodeUsed},
+		{name: "legacy activated code", code: models.Codes{Activated: true}, wantErr: ErrPromoCodeUsed},
+		{name: "unlimited code", code: models.Codes{Redemptions: 500}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPromoCode(tt.code); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkPromoCode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPromoCampaign(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		campaign models.PromoCampaign
+		wantErr  error
+	}{
+		{name: "open campaign", campaign: models.PromoCampaign{}},
+		{name: "running campaign", campaign: models.PromoCampaign{StartsAt: &past, EndsAt: &future}},
+		{name: "not started", campaign: models.PromoCampaign{StartsAt: &future}, wantErr: ErrPromoCampaignInactive},
+		{name: "ended", campaign: models.PromoCampaign{EndsAt: &past}, wantErr: ErrPromoCampaignInactive},
+		{name: "redemptions left", campaign: models.PromoCampaign{MaxRedemptions: 10, Redemptions: 9}},
+		{name: "all redeemed", campaign: models.PromoCampaign{MaxRedemptions: 10, Redemptions: 10}, wantErr: ErrPromoCodeUsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPromoCampaign(tt.campaign, now); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkPromoCampaign() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPromoUserLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		used    int64
+		wantErr error
+	}{
+		{name: "first redemption", limit: 1, used: 0},
+		{name: "second redemption of a once per user campaign", limit: 1, used: 1, wantErr: ErrPromoUserLimit},
+		{name: "within the limit", limit: 3, used: 2},
+		{name: "unlimited", limit: 0, used: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPromoUserLimit(models.PromoCampaign{PerUserLimit: tt.limit}, tt.used)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkPromoUserLimit() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiscountedFee(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		percent int
+		want    int64
+	}{
+		{amount: 10000, percent: 25, want: 7500},
+		{amount: 10000, percent: 100, want: 0},
+		{amount: 999, percent: 50, want: 499},
+	}
+
+	for _, tt := range tests {
+		if got := discountedFee(tt.amount, tt.percent); got != tt.want {
+			t.Fatalf("discountedFee(%d, %d) = %d, want %d", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
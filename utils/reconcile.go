@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"log"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// finalAcquirerStatuses are the acquirer statuses a stale payment is
+// reconciled to, intermediate ones like FORMSHOWED are left alone.
+var finalAcquirerStatuses = map[string]bool{
+	AcquirerStatusConfirmed:       true,
+	AcquirerStatusRejected:        true,
+	AcquirerStatusCanceled:        true,
+	AcquirerStatusDeadlineExpired: true,
+	AcquirerStatusPartialRefunded: true,
+	AcquirerStatusRefunded:        true,
+}
+
+// recentPaymentWindow is how long after its last change a credited payment
+// is still checked for refunds the acquirer made without telling us.
+const recentPaymentWindow = 7 * 24 * time.Hour
+
+// ReconcilePayments asks the provider for the state of every payment that
+// stays NEW or AUTHORIZED longer than staleAfter, has a pending refund or
+// was credited recently, and applies it. A pending refund the acquirer has
+// not made within staleAfter is given back to the wallet. Then cached wallet
+// balances that drifted from their postings are repaired. Every difference
+// found is stored as a models.PaymentDiscrepancy.
+func ReconcilePayments(config *initializers.Config, staleAfter time.Duration) ([]models.PaymentDiscrepancy, error) {
+	var discrepancies []models.PaymentDiscrepancy
+
+	now := time.Now()
+	var payments []models.Payments
+	if err := initializers.DB.
+		Where("status IN ? AND created_at < ?", []string{PaymentStatusNew, PaymentStatusAuthorized}, now.Add(-staleAfter)).
+		Or("pending_refund_amount > 0").
+		Or("status IN ? AND updated_at > ?", []string{PaymentStatusApplied, PaymentStatusPartialRefunded}, now.Add(-recentPaymentWindow)).
+		Find(&payments).Error; err != nil {
+		return nil, err
+	}
+
+	for _, payment := range payments {
+		provider, err := PaymentProviderByName(config, payment.Provider)
+		if err != nil {
+			log.Println("Could not reconcile payment", payment.PaymentId, err)
+			continue
+		}
+
+		state, err := provider.GetState(payment.PaymentId)
+		if err != nil {
+			log.Println("Could not get payment state", payment.PaymentId, err)
+			continue
+		}
+		if !finalAcquirerStatuses[state.Status] {
+			continue
+		}
+		transition, planErr := planPaymentTransition(payment, state.Status, reconciledAmount(payment, state))
+		if planErr == nil && transition.Status == "" {
+			// The acquirer agrees with us, apart from a refund it never made
+			if refundStale(payment, now, staleAfter) {
+				discrepancies = append(discrepancies, releaseStaleRefund(payment, state))
+			}
+			continue
+		}
+
+		userID := payment.UserID
+		discrepancy := models.PaymentDiscrepancy{
+			Kind:         "status",
+			PaymentId:    payment.PaymentId,
+			UserID:       &userID,
+			LocalStatus:  payment.Status,
+			RemoteStatus: state.Status,
+			LocalAmount:  int64(payment.Amount),
+			RemoteAmount: int64(state.Amount),
+		}
+
+		err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+			_, _, applyErr := ApplyPaymentStatus(tx, payment.Provider, payment.PaymentId, state.Status, reconciledAmount(payment, state))
+			return applyErr
+		})
+		if errors.Is(err, ErrPaymentNotificationProcessed) {
+			// Already reported by an earlier run
+			continue
+		}
+		if err != nil {
+			log.Println("Could not apply payment state", payment.PaymentId, err)
+		}
+		discrepancy.Resolved = err == nil
+
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	drifted, err := repairWalletBalances()
+	if err != nil {
+		return nil, err
+	}
+	discrepancies = append(discrepancies, drifted...)
+
+	if len(discrepancies) > 0 {
+		if err := initializers.DB.Create(&discrepancies).Error; err != nil {
+			return nil, err
+		}
+	}
+	return discrepancies, nil
+}
+
+// refundStale reports whether the payment has a refund that has been
+// pending for longer than staleAfter.
+func refundStale(payment models.Payments, now time.Time, staleAfter time.Duration) bool {
+	return payment.PendingRefundAmount > 0 &&
+		payment.RefundRequestedAt != nil &&
+		payment.RefundRequestedAt.Before(now.Add(-staleAfter))
+}
+
+// releaseStaleRefund gives a pending refund the acquirer did not make back
+// to the wallet.
+func releaseStaleRefund(payment models.Payments, state PaymentState) models.PaymentDiscrepancy {
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		_, releaseErr := releasePendingRefund(tx, payment.Provider, payment.PaymentId)
+		return releaseErr
+	})
+	if err != nil {
+		log.Println("Could not release pending refund", payment.PaymentId, err)
+	}
+
+	userID := payment.UserID
+	return models.PaymentDiscrepancy{
+		Kind:         "refund",
+		PaymentId:    payment.PaymentId,
+		UserID:       &userID,
+		LocalStatus:  payment.Status,
+		RemoteStatus: state.Status,
+		LocalAmount:  int64(payment.PendingRefundAmount),
+		RemoteAmount: int64(state.Amount),
+		Resolved:     err == nil,
+	}
+}
+
+// reconciledAmount is the notification amount for the state reported by
+// the acquirer: the paid sum, or for refunds the sum still held.
+func reconciledAmount(payment models.Payments, state PaymentState) uint64 {
+	if state.Status == AcquirerStatusConfirmed && state.Amount == 0 {
+		return uint64(payment.Amount)
+	}
+	return state.Amount
+}
+
+type walletDrift struct {
+	AccountID      uint64
+	UserID         uuid.UUID
+	Balance        int64
+	Posted         int64
+	BillingBalance int64
+}
+
+// repairWalletBalances resets every wallet balance and Billing row that does
+// not match the sum of the wallet postings.
+func repairWalletBalances() ([]models.PaymentDiscrepancy, error) {
+	var drifts []walletDrift
+	if err := initializers.DB.Raw(`
+		SELECT a.id AS account_id, a.user_id, a.balance,
+			COALESCE((SELECT SUM(p.amount) FROM ledger_postings p WHERE p.account_id = a.id), 0) AS posted,
+			COALESCE(b.balance, 0) AS billing_balance
+		FROM ledger_accounts a
+		LEFT JOIN billings b ON b.user_id = a.user_id AND b.deleted_at IS NULL
+		WHERE a.user_id IS NOT NULL`).
+		Scan(&drifts).Error; err != nil {
+		return nil, err
+	}
+
+	var discrepancies []models.PaymentDiscrepancy
+	for _, drift := range drifts {
+		if drift.Balance == drift.Posted && drift.BillingBalance == drift.Posted {
+			continue
+		}
+
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			var account models.LedgerAccount
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, drift.AccountID).Error; err != nil {
+				return err
+			}
+
+			var posted int64
+			if err := tx.Model(&models.LedgerPosting{}).
+				Where("account_id = ?", account.ID).
+				Select("COALESCE(SUM(amount), 0)").
+				Scan(&posted).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(&account).Update("balance", posted).Error; err != nil {
+				return err
+			}
+			return syncBillingBalance(tx, drift.UserID, posted)
+		})
+
+		userID := drift.UserID
+		discrepancies = append(discrepancies, models.PaymentDiscrepancy{
+			Kind:         "balance",
+			UserID:       &userID,
+			LocalAmount:  drift.BillingBalance,
+			RemoteAmount: drift.Posted,
+			Resolved:     err == nil,
+		})
+		if err != nil {
+			log.Println("Could not repair wallet balance", drift.UserID, err)
+		}
+	}
+	return discrepancies, nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"context"
+	"hyperpage/initializers"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	uuid "github.com/satori/go.uuid"
+)
+
+// redisUnlockScript releases a lock only if it is still held by the token.
+var redisUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// WithRedisLock runs fn while holding the named lock, so a periodic job runs
+// on one node at a time. It returns false without running fn when another
+// node holds the lock. ttl must be longer than fn takes.
+func WithRedisLock(name string, ttl time.Duration, fn func() error) (bool, error) {
+	ctx := context.Background()
+	key := "lock:" + name
+	token := uuid.NewV4().String()
+	locked, err := initializers.RedisClient.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !locked {
+		return false, err
+	}
+	defer redisUnlockScript.Run(ctx, initializers.RedisClient, []string{key}, token)
+	return true, fn()
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Statuses of models.Review. Hidden reviews are not shown and do not count
+// in ratings.
+const (
+	ReviewStatusPublished = "published"
+	ReviewStatusHidden    = "hidden"
+)
+
+var (
+	ErrReviewOrderNotCompleted = errors.New("order is not completed")
+	ErrReviewExists            = errors.New("order already has a review")
+	ErrReviewProductNotInOrder = errors.New("product is not in the order")
+)
+
+// ReviewTarget returns the catalog product and listing a review of the order
+// is about. productID must be one of the order's products; when it is nil the
+// first catalog product of the order is used. Orders without catalog items
+// are reviewed for the seller only.
+func ReviewTarget(tx *gorm.DB, orderID uuid.UUID, productID *uint64) (*uint64, *uint64, error) {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ? AND product_id IS NOT NULL", orderID).Order("created_at").Find(&items).Error; err != nil {
+		return nil, nil, err
+	}
+
+	target, err := reviewTargetProduct(items, productID)
+	if err != nil || target == nil {
+		return nil, nil, err
+	}
+
+	var product models.Product
+	if err := tx.Select("id", "blog_id").First(&product, *target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return target, nil, nil
+		}
+		return nil, nil, err
+	}
+	return target, product.BlogID, nil
+}
+
+// reviewTargetProduct picks the reviewed product among the catalog items of
+// the order.
+func reviewTargetProduct(items []models.OrderItem, productID *uint64) (*uint64, error) {
+	for _, item := range items {
+		if productID == nil || *item.ProductID == *productID {
+			return item.ProductID, nil
+		}
+	}
+	if productID != nil {
+		return nil, ErrReviewProductNotInOrder
+	}
+	return nil, nil
+}
+
+// CreateReview stores the buyer's review of a completed order and updates
+// the ratings. It must be called inside a DB transaction with the order row
+// locked.
+func CreateReview(tx *gorm.DB, order models.Order, review *models.Review) error {
+	if order.Status != OrderStatusCompleted {
+		return ErrReviewOrderNotCompleted
+	}
+
+	var count int64
+	if err := tx.Model(&models.Review{}).Where("order_id = ?", order.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrReviewExists
+	}
+
+	productID, blogID, err := ReviewTarget(tx, order.ID, review.ProductID)
+	if err != nil {
+		return err
+	}
+
+	review.OrderID = order.ID
+	review.UserID = order.UserID
+	review.SellerID = order.SellerID
+	review.ProductID = productID
+	review.BlogID = blogID
+	review.Status = ReviewStatusPublished
+	if err := tx.Create(review).Error; err != nil {
+		return err
+	}
+	return UpdateReviewRatings(tx, *review)
+}
+
+// UpdateReviewRatings recalculates the rating of the seller and of the
+// listing of the review from their published reviews.
+func UpdateReviewRatings(tx *gorm.DB, review models.Review) error {
+	aggregates := func(column string, value interface{}) map[string]interface{} {
+		published := func() *gorm.DB {
+			return tx.Model(&models.Review{}).Where(column+" = ? AND status = ?", value, ReviewStatusPublished)
+		}
+		return map[string]interface{}{
+			"rating":        gorm.Expr("COALESCE((?), 0)", published().Select("ROUND(AVG(stars), 2)")),
+			"reviews_count": gorm.Expr("(?)", published().Select("COUNT(*)")),
+		}
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ?", review.SellerID).UpdateColumns(aggregates("seller_id", review.SellerID)).Error; err != nil {
+		return err
+	}
+	if review.BlogID == nil {
+		return nil
+	}
+	return tx.Model(&models.Blog{}).Where("id = ?", *review.BlogID).UpdateColumns(aggregates("blog_id", *review.BlogID)).Error
+}
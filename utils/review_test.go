@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"testing"
+)
+
+func TestReviewTargetProduct(t *testing.T) {
+	first, second, other := uint64(11), uint64(12), uint64(99)
+	items := []models.OrderItem{{ProductID: &first}, {ProductID: &second}}
+
+	tests := []struct {
+		name      string
+		items     []models.OrderItem
+		productID *uint64
+		want      *uint64
+		wantErr   error
+	}{
+		{name: "first product by default", items: items, want: &first},
+		{name: "chosen product", items: items, productID: &second, want: &second},
+		{name: "product of another order", items: items, productID: &other, wantErr: ErrReviewProductNotInOrder},
+		{name: "order without catalog items reviews the seller only"},
+		{name: "product chosen for an order without catalog items", productID: &first, wantErr: ErrReviewProductNotInOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reviewTargetProduct(tt.items, tt.productID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reviewTargetProduct() error = %v, want %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("reviewTargetProduct() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateReviewNeedsCompletedOrder(t *testing.T) {
+	// Refused before the database is touched
+	for _, status := range []string{OrderStatusPaid, OrderStatusShipped, OrderStatusDelivered, OrderStatusRefunded} {
+		err := CreateReview(nil, models.Order{Status: status}, &models.Review{Stars: 5})
+		if !errors.Is(err, ErrReviewOrderNotCompleted) {
+			t.Fatalf("review of a %s order: error = %v, want %v", status, err, ErrReviewOrderNotCompleted)
+		}
+	}
+}
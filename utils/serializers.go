@@ -49,12 +49,24 @@ func SerializeChatRoomMember(member models.ChatRoomMember) map[string]interface{
 		"is_subscribed": member.IsSubscribed,
 		"is_new":        member.IsNew,
 		"joined_at":     member.JoinedAt,
+		"role":          member.Role,
+		"muted_until":   member.MutedUntil,
+		"unread_count":  member.UnreadCount,
+		// receipts watermarks
+		"last_read_message_id":      member.LastReadMessageID,
+		"last_delivered_message_id": member.LastDeliveredMessageID,
 	}
 }
 
 func SerializeChatRoom(roomID uint64) map[string]interface{} {
+	return SerializeChatRoomTx(initializers.DB, roomID)
+}
+
+// SerializeChatRoomTx is SerializeChatRoom with the room read through tx, so
+// a room created in the transaction can be serialized before the commit.
+func SerializeChatRoomTx(tx *gorm.DB, roomID uint64) map[string]interface{} {
 	var room models.ChatRoom
-	err := initializers.DB.Preload("Members.User").Preload("LastMessage").First(&room, roomID).Error
+	err := tx.Preload("Members.User").Preload("LastMessage").Preload("LastMessage.Attachments", OrderChatAttachments).First(&room, roomID).Error
 	if err != nil {
 		return nil
 	}
@@ -66,6 +78,12 @@ func SerializeChatRoom(roomID uint64) map[string]interface{} {
 		"created_at":   room.CreatedAt,
 		"bumped_at":    room.BumpedAt,
 		"member_count": len(room.Members),
+		"is_group":     room.IsGroup,
+		"title":        room.Title,
+		"avatar":       room.Avatar,
+		"owner_id":     room.OwnerID,
+		"member_limit": room.MemberLimit,
+		"retention":    room.Retention,
 	}
 
 	if room.LastMessage != nil {
@@ -111,7 +129,49 @@ func SerializeChatMessage(message models.ChatMessage) map[string]interface{} {
 		"jsonData":      message.JsonData,
 		"msgType":       message.MsgType,
 		"parentMsg":     SerializeParentMessage(parentMessage),
+		"attachments":   serializeChatMessageAttachments(message),
+		"reactions":     ChatMessageReactions(initializers.DB, message.ID),
+		"pinned_at":     message.PinnedAt,
+		"pinned_by_id":  message.PinnedByID,
+		"forward_from":  serializeForwardedFrom(message),
+	}
+}
+
+// serializeForwardedFrom attributes a forwarded message to the author of the
+// original one.
+func serializeForwardedFrom(message models.ChatMessage) map[string]interface{} {
+	if message.ForwardedFromUserID == nil {
+		return nil
+	}
+	user, err := FetchUserByID(*message.ForwardedFromUserID)
+	if err != nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"message_id": message.ForwardedFromID,
+		"user_id":    message.ForwardedFromUserID.String(),
+		"user":       SerializeUser(user),
+	}
+}
+
+// serializeChatMessageAttachments uses the loaded attachments, so messages
+// serialized inside a transaction see attachments bound in it. Lists of
+// messages preload them with OrderChatAttachments, only single messages
+// loaded without them are queried here. Attachments of deleted messages are
+// hidden.
+func serializeChatMessageAttachments(message models.ChatMessage) []map[string]interface{} {
+	serialized := []map[string]interface{}{}
+	if message.IsDeleted {
+		return serialized
+	}
+	attachments := message.Attachments
+	if attachments == nil {
+		initializers.DB.Where("message_id = ?", message.ID).Order("id").Find(&attachments)
+	}
+	for _, attachment := range attachments {
+		serialized = append(serialized, SerializeChatAttachment(attachment))
 	}
+	return serialized
 }
 
 func SerializeParentMessage(message models.ChatMessage) map[string]interface{} {
@@ -260,6 +320,8 @@ func SerializeUser(user models.User) map[string]interface{} {
 		"totalblogs":     user.TotalBlogs,
 		"totalrestblog":  user.TotalRestBlogs,
 		"totalfollowers": user.TotalFollowers,
+		"rating":         user.Rating,
+		"reviewsCount":   user.ReviewsCount,
 	}
 }
 
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/models"
+	"sort"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Rate types of models.ShippingMethod.
+const (
+	ShippingRateFlat   = "flat"   // Price per order
+	ShippingRateWeight = "weight" // Price plus PricePerKg for every started kilogram
+)
+
+var (
+	ErrShippingUnavailable    = errors.New("seller does not ship to the address")
+	ErrShippingMethodNotFound = errors.New("shipping method is not available for the address")
+)
+
+// ShippingOption is a shipping method available for an address with its
+// cost for the given items.
+type ShippingOption struct {
+	MethodID      uint64 `json:"methodId"`
+	ZoneID        uint64 `json:"zoneId"`
+	Name          string `json:"name"`
+	Cost          int64  `json:"cost"` // minor units
+	EstimatedDays int    `json:"estimatedDays"`
+}
+
+// SellerShippingQuote lists the shipping options for the part of a cart
+// sold by one seller.
+type SellerShippingQuote struct {
+	SellerID    uuid.UUID        `json:"sellerId"`
+	ItemsAmount int64            `json:"itemsAmount"` // minor units
+	Weight      int              `json:"weight"`      // grams
+	Ships       bool             `json:"ships"`       // false when the seller has no shipping zones
+	Options     []ShippingOption `json:"options"`
+}
+
+// IsShippingRateType reports whether rateType is a known rate type.
+func IsShippingRateType(rateType string) bool {
+	return rateType == ShippingRateFlat || rateType == ShippingRateWeight
+}
+
+// ShippingCost returns the cost of shipping items worth itemsAmount (minor
+// units) and weighing weight grams with the method.
+func ShippingCost(method models.ShippingMethod, itemsAmount int64, weight int) int64 {
+	if method.FreeFrom != nil && itemsAmount >= *method.FreeFrom {
+		return 0
+	}
+	cost := method.Price
+	if method.RateType == ShippingRateWeight {
+		kilograms := int64((weight + 999) / 1000)
+		cost += method.PricePerKg * kilograms
+	}
+	return cost
+}
+
+// SellerShips reports whether the seller has set up shipping zones. Sellers
+// without zones ship on their own terms and their orders carry no shipping
+// cost.
+func SellerShips(tx *gorm.DB, sellerID uuid.UUID) (bool, error) {
+	var count int64
+	err := tx.Model(&models.ShippingZone{}).Where("seller_id = ?", sellerID).Count(&count).Error
+	return count > 0, err
+}
+
+// ShippingOptions returns the active methods of the seller that ship to the
+// address, cheapest first. Only the zones matching most precisely are
+// used: by city before by region before by country.
+func ShippingOptions(tx *gorm.DB, sellerID uuid.UUID, address models.DeliveryAddress, itemsAmount int64, weight int) ([]ShippingOption, error) {
+	var zones []models.ShippingZone
+	if err := tx.
+		Preload("Locations").
+		Preload("Methods", "active = ?", true).
+		Where("seller_id = ?", sellerID).
+		Find(&zones).Error; err != nil {
+		return nil, err
+	}
+
+	best := 0
+	var matched []models.ShippingZone
+	for _, zone := range zones {
+		match := shippingZoneMatch(zone, address)
+		if match == 0 || match < best {
+			continue
+		}
+		if match > best {
+			best = match
+			matched = nil
+		}
+		matched = append(matched, zone)
+	}
+
+	options := []ShippingOption{}
+	for _, zone := range matched {
+		for _, method := range zone.Methods {
+			options = append(options, ShippingOption{
+				MethodID:      method.ID,
+				ZoneID:        zone.ID,
+				Name:          method.Name,
+				Cost:          ShippingCost(method, itemsAmount, weight),
+				EstimatedDays: method.EstimatedDays,
+			})
+		}
+	}
+	sort.SliceStable(options, func(i, j int) bool { return options[i].Cost < options[j].Cost })
+	return options, nil
+}
+
+// shippingZoneMatch returns 3 when the zone lists the city of the address,
+// 2 when it lists its region, 1 when it covers the whole country and 0 when
+// it does not ship there.
+func shippingZoneMatch(zone models.ShippingZone, address models.DeliveryAddress) int {
+	match := 0
+	for _, location := range zone.Locations {
+		if !strings.EqualFold(location.CountryCode, address.CountryCode) {
+			continue
+		}
+		level := 0
+		switch {
+		case location.CityID != nil:
+			if address.CityID != nil && *location.CityID == *address.CityID {
+				level = 3
+			}
+		case location.Region != "":
+			if strings.EqualFold(strings.TrimSpace(location.Region), strings.TrimSpace(address.Region)) {
+				level = 2
+			}
+		default:
+			level = 1
+		}
+		if level > match {
+			match = level
+		}
+	}
+	return match
+}
+
+// ChooseShipping returns the shipping option for a seller order: methodID
+// when given, otherwise the cheapest one. It returns nil for sellers without
+// shipping zones.
+func ChooseShipping(tx *gorm.DB, sellerID uuid.UUID, address models.DeliveryAddress, itemsAmount int64, weight int, methodID uint64) (*ShippingOption, error) {
+	ships, err := SellerShips(tx, sellerID)
+	if err != nil || !ships {
+		return nil, err
+	}
+
+	options, err := ShippingOptions(tx, sellerID, address, itemsAmount, weight)
+	if err != nil {
+		return nil, err
+	}
+	if len(options) == 0 {
+		return nil, ErrShippingUnavailable
+	}
+	if methodID == 0 {
+		return &options[0], nil
+	}
+	for _, option := range options {
+		if option.MethodID == methodID {
+			return &option, nil
+		}
+	}
+	return nil, ErrShippingMethodNotFound
+}
+
+// QuoteCartShipping returns the shipping options of every seller in the
+// cart for the address. Lines that can not be ordered are left out.
+func QuoteCartShipping(tx *gorm.DB, view CartView, address models.DeliveryAddress) ([]SellerShippingQuote, error) {
+	quotes := []SellerShippingQuote{}
+	index := map[uuid.UUID]int{}
+	for _, line := range view.Lines {
+		if line.Status != CartLineOK {
+			continue
+		}
+		i, ok := index[line.SellerID]
+		if !ok {
+			i = len(quotes)
+			index[line.SellerID] = i
+			quotes = append(quotes, SellerShippingQuote{SellerID: line.SellerID, Options: []ShippingOption{}})
+		}
+		quotes[i].ItemsAmount += line.Price * int64(line.Quantity)
+		quotes[i].Weight += line.Weight * line.Quantity
+	}
+
+	for i := range quotes {
+		ships, err := SellerShips(tx, quotes[i].SellerID)
+		if err != nil {
+			return nil, err
+		}
+		quotes[i].Ships = ships
+		if !ships {
+			continue
+		}
+		if quotes[i].Options, err = ShippingOptions(tx, quotes[i].SellerID, address, quotes[i].ItemsAmount, quotes[i].Weight); err != nil {
+			return nil, err
+		}
+	}
+	return quotes, nil
+}
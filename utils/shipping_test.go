@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"testing"
+)
+
+func TestShippingZoneMatch(t *testing.T) {
+	moscow := uint(1)
+	kazan := uint(2)
+	address := models.DeliveryAddress{CountryCode: "RU", Region: "Московская область", CityID: &moscow}
+
+	cases := []struct {
+		name      string
+		locations []models.ShippingZoneLocation
+		want      int
+	}{
+		{"city", []models.ShippingZoneLocation{{CountryCode: "RU", CityID: &moscow}}, 3},
+		{"region", []models.ShippingZoneLocation{{CountryCode: "RU", Region: "московская область"}}, 2},
+		{"country", []models.ShippingZoneLocation{{CountryCode: "RU"}}, 1},
+		{"most precise location wins", []models.ShippingZoneLocation{{CountryCode: "RU"}, {CountryCode: "RU", Region: "Московская область"}}, 2},
+		{"other city", []models.ShippingZoneLocation{{CountryCode: "RU", CityID: &kazan}}, 0},
+		{"other region", []models.ShippingZoneLocation{{CountryCode: "RU", Region: "Татарстан"}}, 0},
+		{"other country", []models.ShippingZoneLocation{{CountryCode: "KZ"}}, 0},
+	}
+	for _, tc := range cases {
+		if got := shippingZoneMatch(models.ShippingZone{Locations: tc.locations}, address); got != tc.want {
+			t.Errorf("%s: shippingZoneMatch = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
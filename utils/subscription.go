@@ -0,0 +1,366 @@
+package utils
+
+import (
+	"errors"
+	"hyperpage/initializers"
+	"hyperpage/models"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of models.Subscription.
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusGrace    = "grace"
+	SubscriptionStatusReplaced = "replaced"
+	SubscriptionStatusExpired  = "expired"
+	SubscriptionStatusCanceled = "canceled"
+)
+
+// Kinds of models.Subscription.
+const (
+	SubscriptionKindPurchase    = "purchase"
+	SubscriptionKindRenewal     = "renewal"
+	SubscriptionKindUpgrade     = "upgrade"
+	SubscriptionKindAutoRenewal = "auto_renewal"
+	SubscriptionKindPromo       = "promo"
+)
+
+// Plan and storage limit of users without a subscription.
+const (
+	DefaultPlanName     = "standart"
+	DefaultLimitStorage = 20
+)
+
+var (
+	ErrPlanNotFound           = errors.New("plan not found")
+	ErrPlanPriceNotFound      = errors.New("plan has no price in this currency")
+	ErrWalletCurrencyMismatch = errors.New("wallet currency does not match")
+)
+
+// FindSubscriptionPlan returns the active plan with the given code or
+// localized name, with its prices.
+func FindSubscriptionPlan(tx *gorm.DB, name string) (models.SubscriptionPlan, error) {
+	var plan models.SubscriptionPlan
+	err := tx.Preload("Prices").
+		Where("active = ?", true).
+		Where("code = ? OR name_ru = ? OR name_en = ? OR name_ka = ? OR name_es = ?", name, name, name, name, name).
+		First(&plan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return plan, ErrPlanNotFound
+	}
+	return plan, err
+}
+
+// SubscriptionPlanPrice returns the period price of the plan in minor units.
+func SubscriptionPlanPrice(plan models.SubscriptionPlan, currency string) (int64, error) {
+	for _, price := range plan.Prices {
+		if strings.EqualFold(price.Currency, currency) {
+			return price.Amount, nil
+		}
+	}
+	return 0, ErrPlanPriceNotFound
+}
+
+// CurrentSubscription returns the active or grace subscription of the user
+// locked for update, gorm.ErrRecordNotFound when there is none.
+func CurrentSubscription(tx *gorm.DB, userID uuid.UUID) (models.Subscription, error) {
+	var subscription models.Subscription
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Preload("Plan").
+		Where("user_id = ? AND status IN ?", userID, []string{SubscriptionStatusActive, SubscriptionStatusGrace}).
+		Order("expires_at DESC").
+		First(&subscription).Error
+	return subscription, err
+}
+
+// PurchaseSubscription charges the user's wallet for a period of the plan
+// and makes it the user's current subscription. Buying the current plan
+// again extends it, switching plans credits the unused part of the current
+// period against the new price. A credit larger than the price is not paid
+// out. It must be called inside a DB transaction.
+func PurchaseSubscription(tx *gorm.DB, userID uuid.UUID, plan models.SubscriptionPlan, currency string, autoRenew bool) (models.Subscription, error) {
+	return subscribe(tx, userID, plan, currency, autoRenew, false)
+}
+
+func subscribe(tx *gorm.DB, userID uuid.UUID, plan models.SubscriptionPlan, currency string, autoRenew bool, auto bool) (models.Subscription, error) {
+	currency = strings.ToUpper(currency)
+	price, err := SubscriptionPlanPrice(plan, currency)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	wallet, err := UserWalletAccount(tx, userID)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+	if wallet.Currency != currency {
+		return models.Subscription{}, ErrWalletCurrencyMismatch
+	}
+
+	now := time.Now()
+	subscription := models.Subscription{
+		UserID:    userID,
+		PlanID:    plan.ID,
+		Kind:      SubscriptionKindPurchase,
+		Status:    SubscriptionStatusActive,
+		Currency:  currency,
+		Price:     price,
+		Charged:   price,
+		AutoRenew: autoRenew,
+		StartedAt: now,
+		ExpiresAt: now.AddDate(0, 0, plan.PeriodDays),
+	}
+
+	current, err := CurrentSubscription(tx, userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Subscription{}, err
+	}
+	if err == nil {
+		subscription.ReplacesID = &current.ID
+
+		if current.PlanID == plan.ID {
+			subscription.Kind = SubscriptionKindRenewal
+			if current.Status == SubscriptionStatusActive && current.ExpiresAt.After(now) {
+				subscription.ExpiresAt = current.ExpiresAt.AddDate(0, 0, plan.PeriodDays)
+			}
+		} else {
+			subscription.Kind = SubscriptionKindUpgrade
+			subscription.Charged = price - unusedSubscriptionValue(current, currency, now)
+			if subscription.Charged < 0 {
+				subscription.Charged = 0
+			}
+		}
+
+		if err := tx.Model(&current).Update("status", SubscriptionStatusReplaced).Error; err != nil {
+			return models.Subscription{}, err
+		}
+	}
+	if auto {
+		subscription.Kind = SubscriptionKindAutoRenewal
+	}
+
+	if err := tx.Create(&subscription).Error; err != nil {
+		return models.Subscription{}, err
+	}
+
+	if subscription.Charged > 0 {
+		entry := models.LedgerEntry{
+			Kind:        LedgerKindPlanPurchase,
+			Description: "Оплата за тариф " + plan.Name.Ru,
+			Module:      "plan",
+			ElementId:   subscription.ID,
+		}
+		if err := ChargeUserWallet(tx, userID, subscription.Charged, &entry); err != nil {
+			return models.Subscription{}, err
+		}
+		if _, err := IssueReceipt(tx, DocumentPurposePlanPurchase, entry, userID, nil, subscription.Charged); err != nil {
+			return models.Subscription{}, err
+		}
+		subscription.LedgerEntryID = &entry.ID
+		if err := tx.Model(&subscription).Update("ledger_entry_id", entry.ID).Error; err != nil {
+			return models.Subscription{}, err
+		}
+	}
+
+	subscription.Plan = plan
+	return subscription, applyPlanToUser(tx, userID, plan, subscription.ExpiresAt)
+}
+
+// GrantSubscriptionDays adds free days to the user's current subscription,
+// or starts the plan for that many days when there is none. The granted
+// record carries the unused value of the subscription it replaces, so
+// switching plans later still credits what was paid but nothing for the
+// free days. It must be called inside a DB transaction.
+func GrantSubscriptionDays(tx *gorm.DB, userID uuid.UUID, plan models.SubscriptionPlan, days int) (models.Subscription, error) {
+	now := time.Now()
+	subscription := models.Subscription{
+		UserID:    userID,
+		PlanID:    plan.ID,
+		Kind:      SubscriptionKindPromo,
+		Status:    SubscriptionStatusActive,
+		Currency:  "RUB",
+		StartedAt: now,
+		ExpiresAt: now.AddDate(0, 0, days),
+	}
+
+	current, err := CurrentSubscription(tx, userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.Subscription{}, err
+	}
+	if err == nil {
+		plan = current.Plan
+		subscription.PlanID = current.PlanID
+		subscription.Currency = current.Currency
+		subscription.AutoRenew = current.AutoRenew
+		subscription.ReplacesID = &current.ID
+		if current.Status == SubscriptionStatusActive && current.ExpiresAt.After(now) {
+			subscription.ExpiresAt = current.ExpiresAt.AddDate(0, 0, days)
+		}
+		subscription.Price = grantedSubscriptionPrice(current, subscription.ExpiresAt, now)
+
+		if err := tx.Model(&current).Update("status", SubscriptionStatusReplaced).Error; err != nil {
+			return models.Subscription{}, err
+		}
+	}
+
+	if err := tx.Create(&subscription).Error; err != nil {
+		return models.Subscription{}, err
+	}
+
+	subscription.Plan = plan
+	return subscription, applyPlanToUser(tx, userID, plan, subscription.ExpiresAt)
+}
+
+// unusedSubscriptionValue is the part of the subscription price that covers
+// the time left until it expires.
+func unusedSubscriptionValue(subscription models.Subscription, currency string, now time.Time) int64 {
+	if subscription.Status != SubscriptionStatusActive || subscription.Currency != currency || !subscription.ExpiresAt.After(now) {
+		return 0
+	}
+	period := time.Duration(subscription.Plan.PeriodDays) * 24 * time.Hour
+	if period <= 0 {
+		return 0
+	}
+	left := subscription.ExpiresAt.Sub(now)
+	return int64(float64(subscription.Price) * float64(left) / float64(period))
+}
+
+// grantedSubscriptionPrice is the period price that gives a granted record
+// expiring at expiresAt the unused value of the replaced subscription. The
+// value is spread over the paid and the free days that are left.
+func grantedSubscriptionPrice(replaced models.Subscription, expiresAt time.Time, now time.Time) int64 {
+	value := unusedSubscriptionValue(replaced, replaced.Currency, now)
+	period := time.Duration(replaced.Plan.PeriodDays) * 24 * time.Hour
+	left := expiresAt.Sub(now)
+	if value <= 0 || period <= 0 || left <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(value) * float64(period) / float64(left)))
+}
+
+func applyPlanToUser(tx *gorm.DB, userID uuid.UUID, plan models.SubscriptionPlan, expiresAt time.Time) error {
+	return tx.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"plan":            plan.Name.Ru,
+			"signed":          true,
+			"limit_storage":   plan.LimitStorage,
+			"expired_plan_at": expiresAt,
+		}).Error
+}
+
+func downgradeUser(tx *gorm.DB, userID uuid.UUID) error {
+	return tx.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"plan":            DefaultPlanName,
+			"signed":          false,
+			"expired_plan_at": nil,
+			"limit_storage":   DefaultLimitStorage,
+		}).Error
+}
+
+// SetSubscriptionAutoRenew switches auto-renewal of the user's current
+// subscription.
+func SetSubscriptionAutoRenew(tx *gorm.DB, userID uuid.UUID, autoRenew bool) (models.Subscription, error) {
+	subscription, err := CurrentSubscription(tx, userID)
+	if err != nil {
+		return subscription, err
+	}
+	subscription.AutoRenew = autoRenew
+	return subscription, tx.Model(&subscription).Update("auto_renew", autoRenew).Error
+}
+
+// ExpireSubscriptions handles subscriptions whose period or grace period is
+// over. Auto-renewing ones are renewed from the wallet, the others and
+// those the wallet can not pay for enter the plan's grace period, and the
+// user is downgraded when it ends. Users with a plan but no subscription
+// record are downgraded by expired_plan_at.
+func ExpireSubscriptions() error {
+	now := time.Now()
+
+	var due []models.Subscription
+	if err := initializers.DB.
+		Where("(status = ? AND expires_at < ?) OR (status = ? AND (auto_renew = ? OR grace_until < ?))",
+			SubscriptionStatusActive, now, SubscriptionStatusGrace, true, now).
+		Find(&due).Error; err != nil {
+		return err
+	}
+
+	for _, subscription := range due {
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			return expireSubscription(tx, subscription.UserID, now)
+		})
+		if err != nil {
+			log.Println("Could not expire subscription", subscription.ID, err)
+		}
+	}
+
+	return initializers.DB.Model(&models.User{}).
+		Where("expired_plan_at < ?", now).
+		Where("NOT EXISTS (SELECT 1 FROM subscriptions s WHERE s.user_id = users.id AND s.status IN ?)",
+			[]string{SubscriptionStatusActive, SubscriptionStatusGrace}).
+		Updates(map[string]interface{}{
+			"plan":            DefaultPlanName,
+			"signed":          false,
+			"expired_plan_at": nil,
+			"limit_storage":   DefaultLimitStorage,
+		}).Error
+}
+
+func expireSubscription(tx *gorm.DB, userID uuid.UUID, now time.Time) error {
+	current, err := CurrentSubscription(tx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Status == SubscriptionStatusActive && current.ExpiresAt.After(now) {
+		return nil
+	}
+
+	if current.AutoRenew {
+		if err := tx.Preload("Prices").First(&current.Plan, current.PlanID).Error; err != nil {
+			return err
+		}
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			_, err := subscribe(tx, userID, current.Plan, current.Currency, true, true)
+			return err
+		})
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrInsufficientBalance) {
+			return err
+		}
+	}
+
+	if current.Status == SubscriptionStatusActive && current.Plan.GraceDays > 0 {
+		graceUntil := current.ExpiresAt.AddDate(0, 0, current.Plan.GraceDays)
+		if graceUntil.After(now) {
+			if err := tx.Model(&current).Updates(map[string]interface{}{
+				"status":      SubscriptionStatusGrace,
+				"grace_until": graceUntil,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&models.User{}).Where("id = ?", userID).Update("expired_plan_at", graceUntil).Error
+		}
+	}
+	if current.Status == SubscriptionStatusGrace && current.GraceUntil != nil && current.GraceUntil.After(now) {
+		return nil
+	}
+
+	if err := tx.Model(&current).Update("status", SubscriptionStatusExpired).Error; err != nil {
+		return err
+	}
+	return downgradeUser(tx, userID)
+}
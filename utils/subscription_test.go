@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"hyperpage/models"
+	"testing"
+	"time"
+)
+
+func TestUnusedSubscriptionValue(t *testing.T) {
+	now := time.Now()
+	plan := models.SubscriptionPlan{PeriodDays: 30}
+
+	tests := []struct {
+		name         string
+		subscription models.Subscription
+		currency     string
+		want         int64
+	}{
+		{
+			name:         "half of the period is left",
+			subscription: models.Subscription{Status: SubscriptionStatusActive, Currency: "RUB", Price: 30000, Plan: plan, ExpiresAt: now.AddDate(0, 0, 15)},
+			currency:     "RUB",
+			want:         15000,
+		},
+		{
+			name:         "expired",
+			subscription: models.Subscription{Status: SubscriptionStatusActive, Currency: "RUB", Price: 30000, Plan: plan, ExpiresAt: now.Add(-time.Hour)},
+			currency:     "RUB",
+		},
+		{
+			name:         "in grace",
+			subscription: models.Subscription{Status: SubscriptionStatusGrace, Currency: "RUB", Price: 30000, Plan: plan, ExpiresAt: now.AddDate(0, 0, 15)},
+			currency:     "RUB",
+		},
+		{
+			name:         "another currency",
+			subscription: models.Subscription{Status: SubscriptionStatusActive, Currency: "RUB", Price: 30000, Plan: plan, ExpiresAt: now.AddDate(0, 0, 15)},
+			currency:     "EUR",
+		},
+		{
+			name:         "free days only",
+			subscription: models.Subscription{Status: SubscriptionStatusActive, Currency: "RUB", Plan: plan, ExpiresAt: now.AddDate(0, 0, 15)},
+			currency:     "RUB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unusedSubscriptionValue(tt.subscription, tt.currency, now); got != tt.want {
+				t.Fatalf("unusedSubscriptionValue() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantKeepsPaidValueForUpgrade(t *testing.T) {
+	now := time.Now()
+	plan := models.SubscriptionPlan{PeriodDays: 30}
+
+	// Paid 300.00 for 30 days, 15 days are left
+	paid := models.Subscription{
+		Status:    SubscriptionStatusActive,
+		Currency:  "RUB",
+		Price:     30000,
+		Charged:   30000,
+		Plan:      plan,
+		ExpiresAt: now.AddDate(0, 0, 15),
+	}
+	value := unusedSubscriptionValue(paid, "RUB", now)
+
+	// 15 free days are granted on top
+	grant := models.Subscription{
+		Kind:      SubscriptionKindPromo,
+		Status:    SubscriptionStatusActive,
+		Currency:  paid.Currency,
+		Plan:      plan,
+		ExpiresAt: paid.ExpiresAt.AddDate(0, 0, 15),
+	}
+	grant.Price = grantedSubscriptionPrice(paid, grant.ExpiresAt, now)
+
+	// Upgrading right away credits what was paid for, not the free days
+	credit := unusedSubscriptionValue(grant, "RUB", now)
+	if credit < value || credit > value+1 {
+		t.Fatalf("upgrade after a grant credits %d, want the unused %d", credit, value)
+	}
+
+	// Later on the credit shrinks and is gone when the grant expires
+	if later := unusedSubscriptionValue(grant, "RUB", now.AddDate(0, 0, 15)); later >= credit {
+		t.Fatalf("credit after 15 days = %d, want less than %d", later, credit)
+	}
+	if expired := unusedSubscriptionValue(grant, "RUB", grant.ExpiresAt); expired != 0 {
+		t.Fatalf("credit after the grant = %d, want 0", expired)
+	}
+}
+
+func TestGrantOverFreeDaysCarriesNothing(t *testing.T) {
+	now := time.Now()
+	promo := models.Subscription{
+		Kind:      SubscriptionKindPromo,
+		Status:    SubscriptionStatusActive,
+		Currency:  "RUB",
+		Plan:      models.SubscriptionPlan{PeriodDays: 30},
+		ExpiresAt: now.AddDate(0, 0, 10),
+	}
+	if price := grantedSubscriptionPrice(promo, promo.ExpiresAt.AddDate(0, 0, 10), now); price != 0 {
+		t.Fatalf("grantedSubscriptionPrice() = %d, want 0", price)
+	}
+}
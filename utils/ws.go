@@ -1,24 +1,16 @@
 package utils
 
 import (
-	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"strconv"
 
 	"hyperpage/initializers"
 	"hyperpage/models"
 
-	"github.com/gofiber/contrib/websocket"
 	uuid "github.com/satori/go.uuid"
 )
 
-// Тип для клиентов
-type Clients map[string]*websocket.Conn
-
-var ClientsInstance = Clients{}
-
 type UserActivityMessage struct {
 	Command    string `json:"command"`
 	UserID     string `json:"userID"`
@@ -26,41 +18,26 @@ type UserActivityMessage struct {
 }
 
 func UserActivity(command string, userID string, additional string) error {
-	var err error
-	for _, c := range ClientsInstance {
-		userActivityMessage := UserActivityMessage{
-			Command:    command,
-			UserID:     userID,
-			Additional: additional,
-		}
-		jsonData, e := json.Marshal(userActivityMessage)
-		if e != nil {
-			err = fmt.Errorf("failed to marshal JSON: %v", e)
-			continue
-		}
-		if e := c.WriteMessage(websocket.TextMessage, jsonData); e != nil {
-			err = fmt.Errorf("failed to send message to client: %v", e)
-		}
+	userActivityMessage := UserActivityMessage{
+		Command:    command,
+		UserID:     userID,
+		Additional: additional,
 	}
-	return err
+	jsonData, err := json.Marshal(userActivityMessage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %v", err)
+	}
+	if err := WSHub.Broadcast(jsonData); err != nil {
+		return fmt.Errorf("failed to send message to clients: %v", err)
+	}
+	return nil
 }
 
 func SendBlogMessageToClients(message string, userName string) error {
-	fmt.Println("Sending message to clients:", message)
-
-	fmt.Println("List of clients:")
-	for _, c := range ClientsInstance {
-		fmt.Println(c)
-	}
-
 	if message == "newblog" {
-		var err error
-		for _, c := range ClientsInstance {
-			if e := c.WriteMessage(websocket.TextMessage, []byte(message)); e != nil {
-				err = fmt.Errorf("failed to send message to client: %v", e)
-			}
+		if err := WSHub.Broadcast([]byte(message)); err != nil {
+			return fmt.Errorf("failed to send message to clients: %v", err)
 		}
-		return err
 	}
 
 	return nil
@@ -77,14 +54,27 @@ type ClientMessage struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-func SendPersonalMessageToClientWithData(clientID string, command string, additionalData []AdditionalData) error {
+// SendPersonalMessageToUserWithData sends the command with data to every
+// session of the user.
+func SendPersonalMessageToUserWithData(userID string, command string, additionalData []AdditionalData) error {
 	message := ClientMessage{
 		Command: command,
 		Data:    additionalData,
 	}
-	return sendMessage(clientID, message)
+	return sendMessageToUser(userID, message)
+}
+
+// SendPersonalMessageToUser sends the command to every session of the user,
+// on all devices and nodes.
+func SendPersonalMessageToUser(userID string, command string) error {
+	message := ClientMessage{
+		Command: command,
+	}
+	return sendMessageToUser(userID, message)
 }
 
+// SendPersonalMessageToClient answers the socket of the session that made
+// the request. Messages for the user go through SendPersonalMessageToUser.
 func SendPersonalMessageToClient(clientID, command string) error {
 	message := ClientMessage{
 		Command: command,
@@ -93,92 +83,58 @@ func SendPersonalMessageToClient(clientID, command string) error {
 }
 
 func sendMessage(clientID string, message ClientMessage) error {
-	jsonData, err := json.Marshal(message)
+	jsonData, err := clientMessageData(message)
 	if err != nil {
-		return fmt.Errorf("error marshalling message: %v", err)
+		return err
 	}
 
-	// Получить соединение клиента из Redis
-	conn, err := GetClientConnFromRedis(clientID)
-	if err != nil {
-		return fmt.Errorf("error getting client connection from Redis: %v", err)
+	// Сессия может быть подключена к любому узлу, хаб доставит сообщение
+	if err := WSHub.SendToSession(clientID, jsonData); err != nil {
+		return fmt.Errorf("error writing message to client: %v", err)
 	}
+	return nil
+}
 
-	// Проверить, что переменная conn не равна nil
-	if conn == nil {
-		return errors.New("connection is nil")
+func sendMessageToUser(userID string, message ClientMessage) error {
+	jsonData, err := clientMessageData(message)
+	if err != nil {
+		return err
 	}
 
-	switch message.Command {
-	case "Activated", "BalanceAdded", "newDonat":
-		if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			return fmt.Errorf("error writing message to client: %v", err)
-		}
-
-	case "newblog":
-		// Получить общее количество записей в таблице "blog"
-		var count int64
-		if err := initializers.DB.Table("blogs").Count(&count).Error; err != nil {
-			return fmt.Errorf("error getting blog count: %v", err)
-		}
-
-		if err := conn.WriteMessage(websocket.TextMessage, []byte(strconv.FormatInt(count, 10))); err != nil {
-			return fmt.Errorf("error writing blog count to client: %v", err)
-		}
-
-	default:
-		if err := conn.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			return fmt.Errorf("error writing default message to client: %v", err)
-		}
+	// Хаб доставит сообщение каждой сессии пользователя на любом узле
+	if err := WSHub.SendToUser(userID, jsonData); err != nil {
+		return fmt.Errorf("error writing message to user: %v", err)
 	}
-
 	return nil
 }
 
-func GetClientConnFromRedis(clientID string) (*websocket.Conn, error) {
-	if conn, ok := ClientsInstance[clientID]; ok {
-		// Соединение клиента найдено в map
-		return conn, nil
-	}
-
-	// Инициализировать Redis клиент
-	configPath := "./app.env"
-	config, err := initializers.LoadConfig(configPath)
+func clientMessageData(message ClientMessage) ([]byte, error) {
+	jsonData, err := json.Marshal(message)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error marshalling message: %v", err)
 	}
 
-	redisClient := initializers.ConnectRedis(&config)
-
-	// Получить байтовый срез, представляющий объект соединения, из Redis
-	var connBytes []byte
-	connBytes, err = redisClient.HGet(context.Background(), "connected_clients", clientID).Bytes()
-	if err != nil {
-		fmt.Printf("Error retrieving value from Redis for key %s: %v\n", clientID, err)
-		return nil, err
+	if message.Command == "newblog" {
+		// Получить общее количество записей в таблице "blog"
+		var count int64
+		if err := initializers.DB.Table("blogs").Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("error getting blog count: %v", err)
+		}
+		jsonData = []byte(strconv.FormatInt(count, 10))
 	}
+	return jsonData, nil
+}
 
-	// Десериализовать байтовый срез обратно в объект *websocket.Conn
-	var conn *websocket.Conn
-
-	fmt.Println(conn)
-
-	err = json.Unmarshal(connBytes, &conn)
+// broadcastClientMessage sends the message to every connected client.
+func broadcastClientMessage(message ClientMessage) {
+	jsonData, err := json.Marshal(message)
 	if err != nil {
-		fmt.Printf("Error deserializing byte slice to websocket conn object: %v\n", err)
-		return nil, err
+		fmt.Printf("Failed to marshal %s data: %v\n", message.Command, err)
+		return
 	}
-
-	ClientsInstance[clientID] = conn
-
-	// Проверить, что переменная conn не равна nil
-	if conn == nil {
-		return nil, errors.New("deserialized websocket conn object is nil")
+	if err := WSHub.Broadcast(jsonData); err != nil {
+		fmt.Printf("Failed to send %s message to clients: %v\n", message.Command, err)
 	}
-
-	// fmt.Println(conn)
-
-	return conn, nil
 }
 
 func NotifyClientsAboutNewComment(comment models.CommentPost) {
@@ -190,18 +146,7 @@ func NotifyClientsAboutNewComment(comment models.CommentPost) {
 		},
 	}
 
-	// Отправляем сообщение всем клиентам
-	for _, client := range ClientsInstance {
-		jsonData, err := json.Marshal(message)
-		if err != nil {
-			fmt.Printf("Failed to marshal comment data: %v\n", err)
-			continue
-		}
-
-		if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			fmt.Printf("Failed to send message to client: %v\n", err)
-		}
-	}
+	broadcastClientMessage(message)
 }
 
 func NotifyClientsAboutLike(like models.LikePost, isLiked bool) {
@@ -214,18 +159,7 @@ func NotifyClientsAboutLike(like models.LikePost, isLiked bool) {
 		},
 	}
 
-	// Отправляем сообщение всем клиентам
-	for _, client := range ClientsInstance {
-		jsonData, err := json.Marshal(message)
-		if err != nil {
-			fmt.Printf("Failed to marshal like data: %v\n", err)
-			continue
-		}
-
-		if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			fmt.Printf("Failed to send message to client: %v\n", err)
-		}
-	}
+	broadcastClientMessage(message)
 }
 
 func NotifyClientsAboutNewPost(post models.Post) {
@@ -236,18 +170,7 @@ func NotifyClientsAboutNewPost(post models.Post) {
 		},
 	}
 
-	// Отправляем сообщение всем клиентам
-	for _, client := range ClientsInstance {
-		jsonData, err := json.Marshal(message)
-		if err != nil {
-			fmt.Printf("Failed to marshal post data: %v\n", err)
-			continue
-		}
-
-		if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			fmt.Printf("Failed to send message to client: %v\n", err)
-		}
-	}
+	broadcastClientMessage(message)
 }
 
 func NotifyClientsAboutDeletedPost(postID uuid.UUID) {
@@ -258,18 +181,7 @@ func NotifyClientsAboutDeletedPost(postID uuid.UUID) {
 		},
 	}
 
-	// Отправляем сообщение всем клиентам
-	for _, client := range ClientsInstance {
-		jsonData, err := json.Marshal(message)
-		if err != nil {
-			fmt.Printf("Failed to marshal delete post data: %v\n", err)
-			continue
-		}
-
-		if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			fmt.Printf("Failed to send delete post message to client: %v\n", err)
-		}
-	}
+	broadcastClientMessage(message)
 }
 
 func NotifyClientsAboutDeletedComment(postID, commentID string) {
@@ -281,18 +193,7 @@ func NotifyClientsAboutDeletedComment(postID, commentID string) {
 		},
 	}
 
-	// Отправляем сообщение всем клиентам
-	for _, client := range ClientsInstance {
-		jsonData, err := json.Marshal(message)
-		if err != nil {
-			fmt.Printf("Failed to marshal delete comment data: %v\n", err)
-			continue
-		}
-
-		if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			fmt.Printf("Failed to send delete comment message to client: %v\n", err)
-		}
-	}
+	broadcastClientMessage(message)
 }
 
 func NotifyClientsAboutUpdatedPost(post models.Post) {
@@ -304,16 +205,5 @@ func NotifyClientsAboutUpdatedPost(post models.Post) {
 		},
 	}
 
-	// Отправляем сообщение всем клиентам
-	for _, client := range ClientsInstance {
-		jsonData, err := json.Marshal(message)
-		if err != nil {
-			fmt.Printf("Failed to marshal update post data: %v\n", err)
-			continue
-		}
-
-		if err := client.WriteMessage(websocket.TextMessage, jsonData); err != nil {
-			fmt.Printf("Failed to send update post message to client: %v\n", err)
-		}
-	}
+	broadcastClientMessage(message)
 }
@@ -0,0 +1,408 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/redis/go-redis/v9"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	wsSessionTTL     = time.Minute      // a session key lives this long without a heartbeat
+	wsHeartbeat      = 20 * time.Second // refresh of the session keys and pings
+	wsWriteTimeout   = 10 * time.Second
+	wsSendQueueSize  = 64
+	wsBroadcastTopic = "ws:broadcast"
+)
+
+var (
+	ErrSessionNotFound = errors.New("websocket session not found")
+	ErrSessionBound    = errors.New("websocket session belongs to another user")
+)
+
+// wsUnbindScript deletes the owner of a session only if it is the given user.
+var wsUnbindScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// WSHub is the hub of the /socket.io connections of this node.
+var WSHub *Hub
+
+// Hub delivers messages to websocket sessions connected to any node. Every
+// session is registered in Redis under its ID with the node that holds the
+// connection, and under the user once the user is known. The owner key of a
+// session keeps it from being bound to a second user. Messages for a
+// session on another node go through the pub/sub topic of that node,
+// broadcasts through a topic every node listens to.
+type Hub struct {
+	nodeID string
+	redis  *redis.Client
+
+	mu       sync.RWMutex
+	sessions map[string]*WSSession
+}
+
+// WSSession is a connection of this node. Writes go through its send queue,
+// one goroutine writes to the connection.
+type WSSession struct {
+	ID     string
+	userID string // guarded by Hub.mu, set once the user signs in
+
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// hubEnvelope is a message published to the topic of a node. With Bind set
+// it binds the session to the user instead, an empty Bind unbinds it.
+type hubEnvelope struct {
+	SessionID string  `json:"session"`
+	Data      []byte  `json:"data,omitempty"`
+	Bind      *string `json:"bind,omitempty"`
+}
+
+// NewHub creates the hub of this node. Run must be started for the hub to
+// receive messages from other nodes.
+func NewHub(client *redis.Client) *Hub {
+	return &Hub{
+		nodeID:   uuid.NewV4().String(),
+		redis:    client,
+		sessions: map[string]*WSSession{},
+	}
+}
+
+func wsSessionKey(sessionID string) string { return "ws:session:" + sessionID }
+func wsUserKey(userID string) string       { return "ws:user:" + userID }
+func wsOwnerKey(sessionID string) string   { return "ws:owner:" + sessionID }
+func wsNodeTopic(nodeID string) string     { return "ws:node:" + nodeID }
+
+// Run delivers the messages published for this node and keeps the session
+// keys of its connections alive until ctx is done.
+func (h *Hub) Run(ctx context.Context) {
+	pubsub := h.redis.Subscribe(ctx, wsNodeTopic(h.nodeID), wsBroadcastTopic)
+	defer pubsub.Close()
+
+	heartbeat := time.NewTicker(wsHeartbeat)
+	defer heartbeat.Stop()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			h.refreshSessions(ctx)
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			if msg.Channel == wsBroadcastTopic {
+				h.sendLocalAll([]byte(msg.Payload))
+				continue
+			}
+			var envelope hubEnvelope
+			if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
+				log.Println("Invalid websocket hub message:", err)
+				continue
+			}
+			session := h.localSession(envelope.SessionID)
+			if session == nil {
+				continue
+			}
+			if envelope.Bind != nil {
+				h.setSessionUser(session, *envelope.Bind)
+			} else {
+				session.Send(envelope.Data)
+			}
+		}
+	}
+}
+
+// Register adds the connection of this node under the session ID and starts
+// its writer.
+func (h *Hub) Register(sessionID string, conn *websocket.Conn) *WSSession {
+	session := &WSSession{
+		ID:   sessionID,
+		conn: conn,
+		send: make(chan []byte, wsSendQueueSize),
+		done: make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.sessions[sessionID] = session
+	h.mu.Unlock()
+
+	if err := h.redis.Set(context.Background(), wsSessionKey(sessionID), h.nodeID, wsSessionTTL).Err(); err != nil {
+		log.Println("Failed to register websocket session:", err)
+	}
+
+	go session.writePump()
+	return session
+}
+
+// BindUser registers the session under the user, so SendToUser reaches it
+// and the heartbeats of the session keep the user present. The session may
+// be connected to another node. A session bound to another user is refused
+// with ErrSessionBound.
+func (h *Hub) BindUser(sessionID string, userID string) error {
+	ctx := context.Background()
+	claimed, err := h.redis.SetNX(ctx, wsOwnerKey(sessionID), userID, wsSessionTTL).Result()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		owner, err := h.redis.Get(ctx, wsOwnerKey(sessionID)).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if owner != userID {
+			return ErrSessionBound
+		}
+	}
+
+	if err := h.bindSession(sessionID, userID); err != nil {
+		if claimed {
+			wsUnbindScript.Run(ctx, h.redis, []string{wsOwnerKey(sessionID)}, userID)
+		}
+		return err
+	}
+	pipe := h.redis.TxPipeline()
+	pipe.SAdd(ctx, wsUserKey(userID), sessionID)
+	pipe.Expire(ctx, wsUserKey(userID), wsSessionTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// UnbindUser removes the session from the user after signing out, the
+// connection stays open. Sessions of other users are refused with
+// ErrSessionBound.
+func (h *Hub) UnbindUser(sessionID string, userID string) error {
+	ctx := context.Background()
+	owner, err := h.redis.Get(ctx, wsOwnerKey(sessionID)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if owner != "" && owner != userID {
+		return ErrSessionBound
+	}
+	if err := h.bindSession(sessionID, ""); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	if err := wsUnbindScript.Run(ctx, h.redis, []string{wsOwnerKey(sessionID)}, userID).Err(); err != nil {
+		return err
+	}
+	return h.redis.SRem(ctx, wsUserKey(userID), sessionID).Err()
+}
+
+// IsUserSession reports whether the session is bound to the user.
+func (h *Hub) IsUserSession(sessionID string, userID string) (bool, error) {
+	owner, err := h.redis.Get(context.Background(), wsOwnerKey(sessionID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return owner == userID, err
+}
+
+func (h *Hub) bindSession(sessionID string, userID string) error {
+	if session := h.localSession(sessionID); session != nil {
+		h.setSessionUser(session, userID)
+		return nil
+	}
+	return h.publishToSession(sessionID, hubEnvelope{SessionID: sessionID, Bind: &userID})
+}
+
+func (h *Hub) setSessionUser(session *WSSession, userID string) {
+	h.mu.Lock()
+	previous := session.userID
+	session.userID = userID
+	h.mu.Unlock()
+	if previous != "" && previous != userID {
+		h.redis.SRem(context.Background(), wsUserKey(previous), session.ID)
+	}
+}
+
+// SessionUser returns the user bound to a session of this node.
+func (h *Hub) SessionUser(session *WSSession) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return session.userID
+}
+
+// Unregister removes the session and stops its writer. The connection is
+// closed by the caller. It returns the user the session was bound to.
+func (h *Hub) Unregister(session *WSSession) string {
+	h.mu.Lock()
+	if h.sessions[session.ID] == session {
+		delete(h.sessions, session.ID)
+	}
+	userID := session.userID
+	h.mu.Unlock()
+	session.close()
+
+	ctx := context.Background()
+	pipe := h.redis.TxPipeline()
+	pipe.Del(ctx, wsSessionKey(session.ID))
+	pipe.Del(ctx, wsOwnerKey(session.ID))
+	if userID != "" {
+		pipe.SRem(ctx, wsUserKey(userID), session.ID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println("Failed to unregister websocket session:", err)
+	}
+	return userID
+}
+
+// SendToSession delivers data to the session on whichever node holds it.
+func (h *Hub) SendToSession(sessionID string, data []byte) error {
+	if session := h.localSession(sessionID); session != nil {
+		session.Send(data)
+		return nil
+	}
+	return h.publishToSession(sessionID, hubEnvelope{SessionID: sessionID, Data: data})
+}
+
+// publishToSession sends the envelope to the node holding the session.
+func (h *Hub) publishToSession(sessionID string, envelope hubEnvelope) error {
+	ctx := context.Background()
+	nodeID, err := h.redis.Get(ctx, wsSessionKey(sessionID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return h.redis.Publish(ctx, wsNodeTopic(nodeID), payload).Err()
+}
+
+// SendToUser delivers data to every session of the user. Sessions that
+// expired without unregistering are dropped from the user.
+func (h *Hub) SendToUser(userID string, data []byte) error {
+	ctx := context.Background()
+	sessionIDs, err := h.redis.SMembers(ctx, wsUserKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		err := h.SendToSession(sessionID, data)
+		if errors.Is(err, ErrSessionNotFound) {
+			h.redis.SRem(ctx, wsUserKey(userID), sessionID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Broadcast delivers data to every session of every node.
+func (h *Hub) Broadcast(data []byte) error {
+	return h.redis.Publish(context.Background(), wsBroadcastTopic, data).Err()
+}
+
+// LocalSessionIDs lists the sessions connected to this node.
+func (h *Hub) LocalSessionIDs() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]string, 0, len(h.sessions))
+	for id := range h.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (h *Hub) localSession(sessionID string) *WSSession {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.sessions[sessionID]
+}
+
+func (h *Hub) sendLocalAll(data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, session := range h.sessions {
+		session.Send(data)
+	}
+}
+
+func (h *Hub) refreshSessions(ctx context.Context) {
+	now := time.Now()
+	h.mu.RLock()
+	pipe := h.redis.Pipeline()
+	for id, session := range h.sessions {
+		pipe.Set(ctx, wsSessionKey(id), h.nodeID, wsSessionTTL)
+		if session.userID != "" {
+			pipe.Expire(ctx, wsUserKey(session.userID), wsSessionTTL)
+			pipe.Expire(ctx, wsOwnerKey(id), wsSessionTTL)
+			presenceHeartbeat(ctx, pipe, session.userID, id, now)
+		}
+	}
+	h.mu.RUnlock()
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		log.Println("Failed to refresh websocket sessions:", err)
+	}
+}
+
+// Send queues data for the connection. A connection that does not keep up
+// with its queue is closed, the client reconnects.
+func (s *WSSession) Send(data []byte) {
+	if !s.queue(data) {
+		log.Printf("Websocket session %s is too slow, closing", s.ID)
+		s.close()
+		s.conn.Close()
+	}
+}
+
+// queue puts data in the send queue, data for a closed session is dropped.
+// It reports false when the queue is full.
+func (s *WSSession) queue(data []byte) bool {
+	select {
+	case <-s.done:
+		return true
+	case s.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *WSSession) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// writePump is the only writer of data frames and pings to the connection.
+func (s *WSSession) writePump() {
+	ping := time.NewTicker(wsHeartbeat)
+	defer ping.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("Failed to write to websocket session %s: %v", s.ID, err)
+				s.close()
+				return
+			}
+		case <-ping.C:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := s.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				s.close()
+				return
+			}
+		}
+	}
+}
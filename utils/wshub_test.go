@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"sort"
+	"testing"
+)
+
+func testWSSession(id string) *WSSession {
+	return &WSSession{ID: id, send: make(chan []byte, wsSendQueueSize), done: make(chan struct{})}
+}
+
+func TestWSKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "session", got: wsSessionKey("s1"), want: "ws:session:s1"},
+		{name: "user", got: wsUserKey("u1"), want: "ws:user:u1"},
+		{name: "owner", got: wsOwnerKey("s1"), want: "ws:owner:s1"},
+		{name: "node topic", got: wsNodeTopic("n1"), want: "ws:node:n1"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Fatalf("%s key = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestWSSessionQueue(t *testing.T) {
+	session := testWSSession("s1")
+	for i := 0; i < wsSendQueueSize; i++ {
+		if !session.queue([]byte{byte(i)}) {
+			t.Fatalf("queue() = false after %d messages, want true until %d", i, wsSendQueueSize)
+		}
+	}
+	if session.queue([]byte("overflow")) {
+		t.Fatalf("queue() on a full queue = true, want false")
+	}
+	if first := <-session.send; len(first) != 1 || first[0] != 0 {
+		t.Fatalf("first queued message = %v, want [0]", first)
+	}
+	if !session.queue([]byte("next")) {
+		t.Fatalf("queue() after the writer took a message = false, want true")
+	}
+}
+
+func TestWSSessionQueueAfterClose(t *testing.T) {
+	session := testWSSession("s1")
+	for i := 0; i < wsSendQueueSize; i++ {
+		session.queue([]byte{byte(i)})
+	}
+	session.close()
+	session.close()
+
+	if !session.queue([]byte("late")) {
+		t.Fatalf("queue() on a closed session = false, want the data dropped")
+	}
+	// A closed session is not closed again on a full queue
+	session.Send([]byte("late"))
+}
+
+func TestHubLocalSessions(t *testing.T) {
+	hub := &Hub{sessions: map[string]*WSSession{}}
+	for _, id := range []string{"s2", "s1"} {
+		hub.sessions[id] = testWSSession(id)
+	}
+
+	ids := hub.LocalSessionIDs()
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "s1" || ids[1] != "s2" {
+		t.Fatalf("LocalSessionIDs() = %v, want [s1 s2]", ids)
+	}
+	if hub.localSession("s1") != hub.sessions["s1"] {
+		t.Fatalf("localSession(s1) did not return the registered session")
+	}
+	if hub.localSession("missing") != nil {
+		t.Fatalf("localSession(missing) returned a session")
+	}
+
+	hub.sendLocalAll([]byte("hello"))
+	for _, id := range ids {
+		select {
+		case data := <-hub.sessions[id].send:
+			if string(data) != "hello" {
+				t.Fatalf("session %s got %q, want %q", id, data, "hello")
+			}
+		default:
+			t.Fatalf("session %s got nothing from sendLocalAll", id)
+		}
+	}
+}
+
+func TestHubSessionUser(t *testing.T) {
+	hub := &Hub{sessions: map[string]*WSSession{}}
+	session := testWSSession("s1")
+	if got := hub.SessionUser(session); got != "" {
+		t.Fatalf("SessionUser() of a new session = %q, want empty", got)
+	}
+	hub.setSessionUser(session, "u1")
+	if got := hub.SessionUser(session); got != "u1" {
+		t.Fatalf("SessionUser() = %q, want %q", got, "u1")
+	}
+}
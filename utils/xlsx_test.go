@@ -0,0 +1,22 @@
+package utils
+
+import "testing"
+
+func TestSpreadsheetCell(t *testing.T) {
+	cases := map[string]string{
+		"=HYPERLINK(\"x\")": "'=HYPERLINK(\"x\")",
+		"+79991234567":      "'+79991234567",
+		"-1+1":              "'-1+1",
+		"@SUM(A1)":          "'@SUM(A1)",
+		"\t=1":              "'\t=1",
+		"\r=1":              "'\r=1",
+		"Москва":            "Москва",
+		"1500.00":           "1500.00",
+		"":                  "",
+	}
+	for value, want := range cases {
+		if got := SpreadsheetCell(value); got != want {
+			t.Errorf("SpreadsheetCell(%q) = %q, want %q", value, got, want)
+		}
+	}
+}